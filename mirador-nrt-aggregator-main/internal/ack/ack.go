@@ -0,0 +1,164 @@
+package ack
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// Helpers for threading model.Acker through the pipeline. All functions are
+// nil-safe so components never need to check whether a receiver asked for acks.
+
+// Done acks a if it is set.
+func Done(a model.Acker) {
+	if a != nil {
+		a.Ack()
+	}
+}
+
+// Fail nacks a if it is set.
+func Fail(a model.Acker, err error) {
+	if a != nil {
+		a.Nack(err)
+	}
+}
+
+// Fanout splits a into n child ackers. The parent resolves once every child has
+// resolved: Ack if all children acked, otherwise Nack with the first error.
+// With n <= 0 nothing downstream will ever resolve it, so the parent is acked
+// immediately. Returned children are nil when a is nil.
+func Fanout(a model.Acker, n int) []model.Acker {
+	if n <= 0 {
+		Done(a)
+		return nil
+	}
+	out := make([]model.Acker, n)
+	if a == nil {
+		return out
+	}
+	if n == 1 {
+		out[0] = a
+		return out
+	}
+	g := &group{parent: a, pending: n}
+	for i := range out {
+		out[i] = &child{g: g}
+	}
+	return out
+}
+
+// Merge returns one acker that resolves every acker in as. Nil entries are
+// skipped; nil is returned when there is nothing to resolve.
+func Merge(as []model.Acker) model.Acker {
+	live := make([]model.Acker, 0, len(as))
+	for _, a := range as {
+		if a != nil {
+			live = append(live, a)
+		}
+	}
+	switch len(live) {
+	case 0:
+		return nil
+	case 1:
+		return live[0]
+	default:
+		return multi(live)
+	}
+}
+
+// MalformedError marks a payload that can never be processed, e.g. one that
+// fails to decode. Receivers use it to tell poison messages (skip or
+// dead-letter them) apart from export failures (redeliver them).
+type MalformedError struct {
+	Err error
+}
+
+func (e *MalformedError) Error() string { return "malformed payload: " + e.Err.Error() }
+func (e *MalformedError) Unwrap() error { return e.Err }
+
+// Malformed wraps err as a MalformedError.
+func Malformed(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &MalformedError{Err: err}
+}
+
+// IsMalformed reports whether err (or anything it wraps) is a MalformedError.
+func IsMalformed(err error) bool {
+	var me *MalformedError
+	return errors.As(err, &me)
+}
+
+// Batch accumulates the ackers of envelopes folded into a window so they can
+// be handed to the aggregates emitted at flush time. Not safe for concurrent use.
+type Batch struct {
+	acks []model.Acker
+}
+
+// Add records a (nil is ignored).
+func (b *Batch) Add(a model.Acker) {
+	if a != nil {
+		b.acks = append(b.acks, a)
+	}
+}
+
+// Release resets the batch and returns n ackers that together resolve every
+// recorded acker (see Fanout). With n == 0 everything is acked immediately.
+func (b *Batch) Release(n int) []model.Acker {
+	merged := Merge(b.acks)
+	b.acks = nil
+	return Fanout(merged, n)
+}
+
+// ---------------- implementations ----------------
+
+type group struct {
+	mu      sync.Mutex
+	parent  model.Acker
+	pending int
+	err     error
+}
+
+func (g *group) resolve(err error) {
+	g.mu.Lock()
+	if err != nil && g.err == nil {
+		g.err = err
+	}
+	g.pending--
+	done := g.pending == 0
+	ferr := g.err
+	g.mu.Unlock()
+
+	if !done {
+		return
+	}
+	if ferr != nil {
+		g.parent.Nack(ferr)
+	} else {
+		g.parent.Ack()
+	}
+}
+
+type child struct {
+	g    *group
+	once sync.Once
+}
+
+func (c *child) Ack()           { c.once.Do(func() { c.g.resolve(nil) }) }
+func (c *child) Nack(err error) { c.once.Do(func() { c.g.resolve(err) }) }
+
+type multi []model.Acker
+
+func (m multi) Ack() {
+	for _, a := range m {
+		a.Ack()
+	}
+}
+
+func (m multi) Nack(err error) {
+	for _, a := range m {
+		a.Nack(err)
+	}
+}
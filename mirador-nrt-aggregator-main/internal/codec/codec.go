@@ -0,0 +1,301 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	colllog "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collmet "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// Supported message encodings. They match the OpenTelemetry Collector Kafka
+// exporter's `encoding` values so topics written by it can be consumed as-is.
+const (
+	EncodingRaw         = "raw"          // payload already is the canonical bytes for Kind
+	EncodingOTLPProto   = "otlp_proto"   // OTLP Export*ServiceRequest protobuf
+	EncodingOTLPJSON    = "otlp_json"    // OTLP/JSON (hex trace/span IDs)
+	EncodingJaegerProto = "jaeger_proto" // one Jaeger model.Span protobuf per message
+	EncodingZipkinJSON  = "zipkin_json"  // Zipkin v2 JSON span list
+	EncodingZipkinProto = "zipkin_proto" // Zipkin v2 protobuf ListOfSpans
+)
+
+// KindAuto selects the envelope kind per message from a header/property.
+const KindAuto = "auto"
+
+// Payload is one decoded envelope body.
+type Payload struct {
+	Kind  string
+	Bytes []byte
+}
+
+// Options controls how broker messages are turned into envelopes.
+//
+// Read from receiver extras (see FromExtra):
+//
+//	encoding: raw | otlp_proto | otlp_json | jaeger_proto | zipkin_json | zipkin_proto   (default raw)
+//	kind: metrics | traces | prom_rw | json_logs | auto                  (receiver kind)
+//	kind_header: string        # header/property naming the kind when kind=auto (default "signal")
+//	default_kind: string       # kind=auto fallback when the header is absent (default metrics)
+//	ndjson: bool               # raw json_logs only: one envelope per line
+//	max_bytes: int             # ndjson: longest accepted line (default 10 MiB)
+type Options struct {
+	Encoding    string
+	Kind        string
+	KindHeader  string
+	DefaultKind string
+	NDJSON      bool
+	MaxLine     int
+}
+
+// FromExtra builds Options from receiver extras; kind is the receiver's configured kind.
+func FromExtra(extra map[string]any, kind string) Options {
+	o := Options{
+		Encoding:    EncodingRaw,
+		Kind:        NormalizeKind(kind),
+		KindHeader:  "signal",
+		DefaultKind: model.KindMetrics,
+		MaxLine:     10 * 1024 * 1024,
+	}
+	if s, ok := extra["encoding"].(string); ok && s != "" {
+		o.Encoding = normalizeEncoding(s)
+	}
+	if s, ok := extra["kind_header"].(string); ok && s != "" {
+		o.KindHeader = s
+	}
+	if s, ok := extra["default_kind"].(string); ok && s != "" {
+		o.DefaultKind = NormalizeKind(s)
+	}
+	if b, ok := extra["ndjson"].(bool); ok {
+		o.NDJSON = b
+	}
+	if v, ok := extra["max_bytes"].(int); ok && v > 0 {
+		o.MaxLine = v
+	}
+	// Trace-only encodings imply the kind.
+	if o.Encoding == EncodingJaegerProto || o.Encoding == EncodingZipkinJSON || o.Encoding == EncodingZipkinProto {
+		o.Kind = model.KindTraces
+	}
+	return o
+}
+
+// Decode turns one message into zero or more envelope payloads.
+// hdrs are the message headers (Kafka) or properties (Pulsar).
+func (o Options) Decode(payload []byte, hdrs map[string]string) ([]Payload, error) {
+	kind := o.Kind
+	if kind == KindAuto {
+		kind = o.detectKind(payload, hdrs)
+	}
+
+	switch o.Encoding {
+	case EncodingOTLPProto:
+		return []Payload{{Kind: kind, Bytes: payload}}, nil
+
+	case EncodingOTLPJSON:
+		b, err := otlpJSONToProto(kind, payload)
+		if err != nil {
+			return nil, err
+		}
+		return []Payload{{Kind: kind, Bytes: b}}, nil
+
+	case EncodingJaegerProto:
+		req, err := JaegerProtoToOTLP(payload)
+		if err != nil {
+			return nil, err
+		}
+		return marshalTraces(req)
+
+	case EncodingZipkinJSON:
+		req, err := ZipkinJSONToOTLP(payload)
+		if err != nil {
+			return nil, err
+		}
+		return marshalTraces(req)
+
+	case EncodingZipkinProto:
+		req, err := ZipkinProtoToOTLP(payload)
+		if err != nil {
+			return nil, err
+		}
+		return marshalTraces(req)
+
+	default: // raw
+		if kind == model.KindJSONLogs && o.NDJSON {
+			lines, err := splitNDJSON(payload, o.MaxLine)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]Payload, 0, len(lines))
+			for _, l := range lines {
+				out = append(out, Payload{Kind: kind, Bytes: l})
+			}
+			return out, nil
+		}
+		return []Payload{{Kind: kind, Bytes: payload}}, nil
+	}
+}
+
+// detectKind resolves kind=auto from the configured header, then (for
+// otlp_json) from the top-level JSON key, then the configured default.
+func (o Options) detectKind(payload []byte, hdrs map[string]string) string {
+	for k, v := range hdrs {
+		if strings.EqualFold(k, o.KindHeader) && v != "" {
+			if kind := NormalizeKind(v); kind != KindAuto {
+				return kind
+			}
+		}
+	}
+	if o.Encoding == EncodingOTLPJSON {
+		head := payload
+		if len(head) > 64 {
+			head = head[:64]
+		}
+		switch {
+		case bytes.Contains(head, []byte(`"resourceSpans"`)):
+			return model.KindTraces
+		case bytes.Contains(head, []byte(`"resourceMetrics"`)):
+			return model.KindMetrics
+		case bytes.Contains(head, []byte(`"resourceLogs"`)):
+			return model.KindJSONLogs
+		}
+	}
+	return o.DefaultKind
+}
+
+// NormalizeKind maps kind aliases onto model.Kind* constants (or KindAuto).
+// Unknown values fall back to metrics, matching the receivers' historic behaviour.
+func NormalizeKind(k string) string {
+	k = strings.ToLower(strings.TrimSpace(k))
+	switch k {
+	case model.KindMetrics, model.KindTraces, model.KindPromRW, model.KindJSONLogs, KindAuto:
+		return k
+	case "metric", "otlp_metrics":
+		return model.KindMetrics
+	case "trace", "spans", "otlp_traces":
+		return model.KindTraces
+	case "promremotewrite", "prometheusremotewrite", "prom-remote-write":
+		return model.KindPromRW
+	case "json", "jsonlogs", "logs", "log", "otlp_logs":
+		return model.KindJSONLogs
+	default:
+		return model.KindMetrics
+	}
+}
+
+func normalizeEncoding(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case EncodingOTLPProto, EncodingOTLPJSON, EncodingJaegerProto, EncodingZipkinJSON, EncodingZipkinProto, EncodingRaw:
+		return s
+	case "otlp", "protobuf", "proto":
+		return EncodingOTLPProto
+	case "json":
+		return EncodingOTLPJSON
+	case "zipkin":
+		return EncodingZipkinJSON
+	case "jaeger":
+		return EncodingJaegerProto
+	default:
+		log.Printf("[codec] unknown encoding %q; using raw", s)
+		return EncodingRaw
+	}
+}
+
+// ---------------- OTLP/JSON ----------------
+
+// otlpJSONToProto converts an OTLP/JSON request into the protobuf bytes the
+// processors decode. OTLP/JSON encodes trace/span IDs as hex while protojson
+// expects base64, so IDs are rewritten first.
+func otlpJSONToProto(kind string, payload []byte) ([]byte, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("otlp_json: %w", err)
+	}
+	hexIDsToBase64(doc)
+	fixed, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg proto.Message
+	switch kind {
+	case model.KindTraces:
+		msg = &colltr.ExportTraceServiceRequest{}
+	case model.KindJSONLogs:
+		msg = &colllog.ExportLogsServiceRequest{}
+	case model.KindMetrics:
+		msg = &collmet.ExportMetricsServiceRequest{}
+	default:
+		return nil, fmt.Errorf("otlp_json: unsupported kind %q", kind)
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(fixed, msg); err != nil {
+		return nil, fmt.Errorf("otlp_json: %w", err)
+	}
+	return proto.Marshal(msg)
+}
+
+func hexIDsToBase64(v any) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			switch k {
+			case "traceId", "spanId", "parentSpanId", "trace_id", "span_id", "parent_span_id":
+				if s, ok := val.(string); ok && s != "" {
+					if b, err := hex.DecodeString(s); err == nil {
+						t[k] = base64.StdEncoding.EncodeToString(b)
+					}
+				}
+			default:
+				hexIDsToBase64(val)
+			}
+		}
+	case []any:
+		for _, it := range t {
+			hexIDsToBase64(it)
+		}
+	}
+}
+
+func marshalTraces(req *colltr.ExportTraceServiceRequest) ([]Payload, error) {
+	if len(req.ResourceSpans) == 0 {
+		return nil, nil
+	}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return []Payload{{Kind: model.KindTraces, Bytes: b}}, nil
+}
+
+// splitNDJSON returns the non-empty, trimmed lines of b. A line longer than
+// maxLine fails the whole message so receivers nack it as malformed.
+func splitNDJSON(b []byte, maxLine int) ([][]byte, error) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	// The initial buffer must not exceed maxLine or the limit is not enforced.
+	buf := make([]byte, 0, min(64*1024, maxLine))
+	sc.Buffer(buf, maxLine)
+	var out [][]byte
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		out = append(out, append([]byte(nil), line...))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("ndjson: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	colllog "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+const otlpJSONTraces = `{"resourceSpans":[{"scopeSpans":[{"spans":[{
+	"traceId":"5b8efff798038103d269b633813fc60c","spanId":"eee19b7ec3c1b174",
+	"parentSpanId":"eee19b7ec3c1b173","name":"GET /","kind":2}]}]}]}`
+
+func TestDecodeOTLPJSONHexIDs(t *testing.T) {
+	o := FromExtra(map[string]any{"encoding": "otlp_json"}, model.KindTraces)
+	got, err := o.Decode([]byte(otlpJSONTraces), nil)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(got) != 1 || got[0].Kind != model.KindTraces {
+		t.Fatalf("payloads = %+v", got)
+	}
+	req := &colltr.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(got[0].Bytes, req); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	sp := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if !bytes.Equal(sp.TraceId, mustHex("5b8efff798038103d269b633813fc60c")) ||
+		!bytes.Equal(sp.SpanId, mustHex("eee19b7ec3c1b174")) ||
+		!bytes.Equal(sp.ParentSpanId, mustHex("eee19b7ec3c1b173")) {
+		t.Fatalf("ids = %x %x %x", sp.TraceId, sp.SpanId, sp.ParentSpanId)
+	}
+	if sp.Name != "GET /" {
+		t.Fatalf("name = %q", sp.Name)
+	}
+}
+
+func TestDecodeOTLPJSONErrors(t *testing.T) {
+	o := FromExtra(map[string]any{"encoding": "otlp_json"}, model.KindTraces)
+	for _, in := range []string{`{not json`, `{"resourceSpans":"nope"}`} {
+		if _, err := o.Decode([]byte(in), nil); err == nil {
+			t.Errorf("Decode(%s) succeeded, want error", in)
+		}
+	}
+}
+
+func TestDecodeKindAuto(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		payload  string
+		hdrs     map[string]string
+		want     string
+	}{
+		{name: "header", encoding: "raw", payload: `x`, hdrs: map[string]string{"Signal": "logs"}, want: model.KindJSONLogs},
+		{name: "header wins over body", encoding: "otlp_json", payload: `{"resourceLogs":[]}`, hdrs: map[string]string{"signal": "traces"}, want: model.KindTraces},
+		{name: "header auto ignored", encoding: "raw", payload: `x`, hdrs: map[string]string{"signal": "auto"}, want: model.KindPromRW},
+		{name: "top-level spans", encoding: "otlp_json", payload: otlpJSONTraces, want: model.KindTraces},
+		{name: "top-level metrics", encoding: "otlp_json", payload: `{"resourceMetrics":[]}`, want: model.KindMetrics},
+		{name: "top-level logs", encoding: "otlp_json", payload: `{"resourceLogs":[]}`, want: model.KindJSONLogs},
+		{name: "raw falls back to default", encoding: "raw", payload: `{"resourceLogs":[]}`, want: model.KindPromRW},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := FromExtra(map[string]any{"encoding": tt.encoding, "default_kind": "prom_rw"}, KindAuto)
+			got, err := o.Decode([]byte(tt.payload), tt.hdrs)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if len(got) != 1 || got[0].Kind != tt.want {
+				t.Fatalf("payloads = %+v, want kind %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeOTLPJSONLogs(t *testing.T) {
+	o := FromExtra(map[string]any{"encoding": "otlp_json"}, KindAuto)
+	in := `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"traceId":"5b8efff798038103d269b633813fc60c","body":{"stringValue":"hi"}}]}]}]}`
+	got, err := o.Decode([]byte(in), nil)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	req := &colllog.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(got[0].Bytes, req); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	lr := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if !bytes.Equal(lr.TraceId, mustHex("5b8efff798038103d269b633813fc60c")) || lr.Body.GetStringValue() != "hi" {
+		t.Fatalf("record = %v", lr)
+	}
+}
+
+func TestDecodeNDJSON(t *testing.T) {
+	o := FromExtra(map[string]any{"ndjson": true}, "json_logs")
+	got, err := o.Decode([]byte("{\"a\":1}\n\n  {\"b\":2}  \r\n{\"c\":3}"), nil)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}
+	if len(got) != len(want) {
+		t.Fatalf("got %d payloads, want %d", len(got), len(want))
+	}
+	for i, p := range got {
+		if p.Kind != model.KindJSONLogs || string(p.Bytes) != want[i] {
+			t.Errorf("payload %d = %s %q, want %q", i, p.Kind, p.Bytes, want[i])
+		}
+	}
+
+	// Without ndjson the message is passed through whole.
+	whole := FromExtra(nil, "json_logs")
+	if got, _ := whole.Decode([]byte("{\"a\":1}\n{\"b\":2}"), nil); len(got) != 1 {
+		t.Fatalf("non-ndjson payloads = %d, want 1", len(got))
+	}
+}
+
+func TestDecodeNDJSONLineTooLong(t *testing.T) {
+	o := FromExtra(map[string]any{"ndjson": true, "max_bytes": 16}, "json_logs")
+	_, err := o.Decode([]byte("{\"a\":1}\n{\"msg\":\""+strings.Repeat("x", 64)+"\"}\n"), nil)
+	if err == nil {
+		t.Fatal("Decode succeeded, want line-too-long error")
+	}
+}
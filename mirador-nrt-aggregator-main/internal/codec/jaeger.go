@@ -0,0 +1,309 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Jaeger api_v2 model.proto field numbers. The Jaeger model is gogo-generated,
+// so it is decoded by hand with protowire instead of pulling in jaeger-idl.
+const (
+	jSpanTraceID    = 1
+	jSpanSpanID     = 2
+	jSpanOperation  = 3
+	jSpanReferences = 4
+	jSpanStartTime  = 6
+	jSpanDuration   = 7
+	jSpanTags       = 8
+	jSpanLogs       = 9
+	jSpanProcess    = 10
+
+	jRefChildOf     = 0
+	jRefFollowsFrom = 1
+)
+
+// jaegerSpan is the wire-independent form shared by the proto and thrift decoders.
+type jaegerSpan struct {
+	traceID   []byte
+	spanID    []byte
+	operation string
+	refs      []jaegerRef
+	startNs   uint64
+	durNs     uint64
+	tags      []*com.KeyValue
+	logs      []jaegerLog
+	service   string
+	procTags  []*com.KeyValue
+}
+
+type jaegerRef struct {
+	traceID []byte
+	spanID  []byte
+	refType int
+}
+
+type jaegerLog struct {
+	tsNs   uint64
+	fields []*com.KeyValue
+}
+
+// JaegerProtoToOTLP decodes one Jaeger model.Span (the OTel Kafka exporter's
+// jaeger_proto encoding: one span per message, process embedded) into OTLP.
+func JaegerProtoToOTLP(b []byte) (*colltr.ExportTraceServiceRequest, error) {
+	js, err := decodeJaegerSpan(b)
+	if err != nil {
+		return nil, fmt.Errorf("jaeger_proto: %w", err)
+	}
+	tb := newTraceBuilder()
+	tb.add(js.service, js.procTags, js.toOTLP())
+	return tb.request(), nil
+}
+
+func (js *jaegerSpan) toOTLP() *tr.Span {
+	sp := &tr.Span{
+		TraceId:           js.traceID,
+		SpanId:            js.spanID,
+		Name:              js.operation,
+		StartTimeUnixNano: js.startNs,
+		EndTimeUnixNano:   js.startNs + js.durNs,
+	}
+	for _, r := range js.refs {
+		// The first CHILD_OF reference within the same trace is the parent;
+		// everything else becomes a link.
+		if sp.ParentSpanId == nil && r.refType == jRefChildOf && string(r.traceID) == string(js.traceID) {
+			sp.ParentSpanId = r.spanID
+			continue
+		}
+		sp.Links = append(sp.Links, &tr.Span_Link{TraceId: r.traceID, SpanId: r.spanID})
+	}
+	for _, l := range js.logs {
+		ev := &tr.Span_Event{TimeUnixNano: l.tsNs, Name: "event"}
+		for _, f := range l.fields {
+			if f.Key == "event" {
+				ev.Name = anyString(f.Value)
+				continue
+			}
+			ev.Attributes = append(ev.Attributes, f)
+		}
+		sp.Events = append(sp.Events, ev)
+	}
+	applySpanTags(sp, js.tags)
+	return sp
+}
+
+func decodeJaegerSpan(b []byte) (*jaegerSpan, error) {
+	js := &jaegerSpan{}
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case jSpanTraceID:
+			js.traceID = append([]byte(nil), v...)
+		case jSpanSpanID:
+			js.spanID = append([]byte(nil), v...)
+		case jSpanOperation:
+			js.operation = string(v)
+		case jSpanReferences:
+			r, err := decodeJaegerRef(v)
+			if err != nil {
+				return err
+			}
+			js.refs = append(js.refs, r)
+		case jSpanStartTime:
+			ns, err := decodeTimestamp(v)
+			if err != nil {
+				return err
+			}
+			js.startNs = ns
+		case jSpanDuration:
+			ns, err := decodeTimestamp(v)
+			if err != nil {
+				return err
+			}
+			js.durNs = ns
+		case jSpanTags:
+			kv, err := decodeJaegerKV(v)
+			if err != nil {
+				return err
+			}
+			js.tags = append(js.tags, kv)
+		case jSpanLogs:
+			l, err := decodeJaegerLog(v)
+			if err != nil {
+				return err
+			}
+			js.logs = append(js.logs, l)
+		case jSpanProcess:
+			return walk(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+				switch num {
+				case 1:
+					js.service = string(v)
+				case 2:
+					kv, err := decodeJaegerKV(v)
+					if err != nil {
+						return err
+					}
+					js.procTags = append(js.procTags, kv)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(js.traceID) != 16 || len(js.spanID) != 8 {
+		return nil, errors.New("missing or malformed trace/span id")
+	}
+	return js, nil
+}
+
+func decodeJaegerRef(b []byte) (jaegerRef, error) {
+	var r jaegerRef
+	err := walk(b, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			r.traceID = append([]byte(nil), v...)
+		case 2:
+			r.spanID = append([]byte(nil), v...)
+		case 3:
+			r.refType = int(n)
+		}
+		return nil
+	})
+	return r, err
+}
+
+func decodeJaegerLog(b []byte) (jaegerLog, error) {
+	var l jaegerLog
+	err := walk(b, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			ns, err := decodeTimestamp(v)
+			if err != nil {
+				return err
+			}
+			l.tsNs = ns
+		case 2:
+			kv, err := decodeJaegerKV(v)
+			if err != nil {
+				return err
+			}
+			l.fields = append(l.fields, kv)
+		}
+		return nil
+	})
+	return l, err
+}
+
+// decodeJaegerKV decodes model.KeyValue{key, v_type, v_str, v_bool, v_int64, v_float64, v_binary}.
+func decodeJaegerKV(b []byte) (*com.KeyValue, error) {
+	var (
+		key   string
+		vtype uint64
+		vstr  string
+		vbool bool
+		vint  int64
+		vflt  float64
+		vbin  []byte
+	)
+	err := walk(b, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			vtype = n
+		case 3:
+			vstr = string(v)
+		case 4:
+			vbool = n != 0
+		case 5:
+			vint = int64(n)
+		case 6:
+			vflt = math.Float64frombits(n)
+		case 7:
+			vbin = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch vtype {
+	case 1:
+		return boolKV(key, vbool), nil
+	case 2:
+		return intKV(key, vint), nil
+	case 3:
+		return doubleKV(key, vflt), nil
+	case 4:
+		return bytesKV(key, vbin), nil
+	default:
+		return strKV(key, vstr), nil
+	}
+}
+
+// decodeTimestamp decodes google.protobuf.Timestamp / Duration into nanoseconds.
+func decodeTimestamp(b []byte) (uint64, error) {
+	var sec, nanos int64
+	err := walk(b, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) error {
+		switch num {
+		case 1:
+			sec = int64(n)
+		case 2:
+			nanos = int64(int32(n))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	ns := sec*1e9 + nanos
+	if ns < 0 {
+		ns = 0
+	}
+	return uint64(ns), nil
+}
+
+// walk iterates the fields of a protobuf message. For length-delimited fields v
+// holds the bytes; for varint/fixed fields n holds the raw value.
+func walk(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		var (
+			v []byte
+			n uint64
+		)
+		switch typ {
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			n, l = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var n32 uint32
+			n32, l = protowire.ConsumeFixed32(b)
+			n = uint64(n32)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		if err := fn(num, typ, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// Thrift binary protocol type ids.
+const (
+	tStop   = 0
+	tBool   = 2
+	tByte   = 3
+	tDouble = 4
+	tI16    = 6
+	tI32    = 8
+	tI64    = 10
+	tString = 11
+	tStruct = 12
+	tMap    = 13
+	tSet    = 14
+	tList   = 15
+)
+
+// Jaeger thrift TagType values (they differ from the proto ValueType order).
+const (
+	jtTagString = 0
+	jtTagDouble = 1
+	jtTagBool   = 2
+	jtTagLong   = 3
+	jtTagBinary = 4
+)
+
+const thriftMaxDepth = 32
+
+var errThriftShort = errors.New("thrift: unexpected end of input")
+
+// JaegerThriftToOTLP decodes a jaeger.thrift Batch in the Thrift binary
+// protocol (the body jaeger-client / OpenTracing tracers POST to the
+// collector's /api/traces) into OTLP.
+func JaegerThriftToOTLP(b []byte) (*colltr.ExportTraceServiceRequest, error) {
+	rd := &thriftReader{b: b}
+	var (
+		service  string
+		procTags []*com.KeyValue
+		spans    []*jaegerSpan
+	)
+	err := rd.readStruct(func(id int16, typ byte) error {
+		switch {
+		case id == 1 && typ == tStruct: // Process
+			return rd.readStruct(func(id int16, typ byte) error {
+				switch {
+				case id == 1 && typ == tString:
+					s, err := rd.str()
+					service = s
+					return err
+				case id == 2 && typ == tList:
+					tags, err := rd.tagList()
+					procTags = tags
+					return err
+				}
+				return rd.skip(typ)
+			})
+		case id == 2 && typ == tList: // spans
+			n, err := rd.listHeader(tStruct)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				js, err := rd.span()
+				if err != nil {
+					return err
+				}
+				spans = append(spans, js)
+			}
+			return nil
+		}
+		return rd.skip(typ)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("jaeger_thrift: %w", err)
+	}
+	tb := newTraceBuilder()
+	for _, js := range spans {
+		tb.add(service, procTags, js.toOTLP())
+	}
+	return tb.request(), nil
+}
+
+type thriftReader struct {
+	b     []byte
+	depth int
+}
+
+func (r *thriftReader) span() (*jaegerSpan, error) {
+	var (
+		js                 = &jaegerSpan{}
+		traceLow, traceHi  uint64
+		spanID, parentSpan uint64
+	)
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == tI64:
+			traceLow, err = r.u64()
+		case id == 2 && typ == tI64:
+			traceHi, err = r.u64()
+		case id == 3 && typ == tI64:
+			spanID, err = r.u64()
+		case id == 4 && typ == tI64:
+			parentSpan, err = r.u64()
+		case id == 5 && typ == tString:
+			js.operation, err = r.str()
+		case id == 6 && typ == tList:
+			var n int
+			if n, err = r.listHeader(tStruct); err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				ref, err := r.spanRef()
+				if err != nil {
+					return err
+				}
+				js.refs = append(js.refs, ref)
+			}
+		case id == 8 && typ == tI64:
+			var us uint64
+			us, err = r.u64()
+			js.startNs = us * 1000
+		case id == 9 && typ == tI64:
+			var us uint64
+			us, err = r.u64()
+			js.durNs = us * 1000
+		case id == 10 && typ == tList:
+			js.tags, err = r.tagList()
+		case id == 11 && typ == tList:
+			var n int
+			if n, err = r.listHeader(tStruct); err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				l, err := r.log()
+				if err != nil {
+					return err
+				}
+				js.logs = append(js.logs, l)
+			}
+		default:
+			err = r.skip(typ)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	js.traceID = traceIDBytes(traceHi, traceLow)
+	js.spanID = binary.BigEndian.AppendUint64(nil, spanID)
+	// parentSpanId is the legacy parent pointer; references take precedence.
+	if parentSpan != 0 && len(js.refs) == 0 {
+		js.refs = append(js.refs, jaegerRef{
+			traceID: js.traceID,
+			spanID:  binary.BigEndian.AppendUint64(nil, parentSpan),
+			refType: jRefChildOf,
+		})
+	}
+	return js, nil
+}
+
+func (r *thriftReader) spanRef() (jaegerRef, error) {
+	var (
+		ref       jaegerRef
+		low, high uint64
+		spanID    uint64
+	)
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == tI32:
+			var v int32
+			v, err = r.i32()
+			ref.refType = int(v)
+		case id == 2 && typ == tI64:
+			low, err = r.u64()
+		case id == 3 && typ == tI64:
+			high, err = r.u64()
+		case id == 4 && typ == tI64:
+			spanID, err = r.u64()
+		default:
+			err = r.skip(typ)
+		}
+		return err
+	})
+	ref.traceID = traceIDBytes(high, low)
+	ref.spanID = binary.BigEndian.AppendUint64(nil, spanID)
+	return ref, err
+}
+
+func (r *thriftReader) log() (jaegerLog, error) {
+	var l jaegerLog
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == tI64:
+			var us uint64
+			us, err = r.u64()
+			l.tsNs = us * 1000
+		case id == 2 && typ == tList:
+			l.fields, err = r.tagList()
+		default:
+			err = r.skip(typ)
+		}
+		return err
+	})
+	return l, err
+}
+
+func (r *thriftReader) tagList() ([]*com.KeyValue, error) {
+	n, err := r.listHeader(tStruct)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*com.KeyValue, 0, min(n, 256))
+	for i := 0; i < n; i++ {
+		var (
+			key   string
+			vtype int32
+			vstr  string
+			vdbl  float64
+			vbool bool
+			vlong int64
+			vbin  []byte
+		)
+		err := r.readStruct(func(id int16, typ byte) error {
+			var err error
+			switch {
+			case id == 1 && typ == tString:
+				key, err = r.str()
+			case id == 2 && typ == tI32:
+				vtype, err = r.i32()
+			case id == 3 && typ == tString:
+				vstr, err = r.str()
+			case id == 4 && typ == tDouble:
+				var u uint64
+				u, err = r.u64()
+				vdbl = math.Float64frombits(u)
+			case id == 5 && typ == tBool:
+				var c []byte
+				c, err = r.next(1)
+				vbool = err == nil && c[0] != 0
+			case id == 6 && typ == tI64:
+				var u uint64
+				u, err = r.u64()
+				vlong = int64(u)
+			case id == 7 && typ == tString:
+				var s string
+				s, err = r.str()
+				vbin = []byte(s)
+			default:
+				err = r.skip(typ)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch vtype {
+		case jtTagDouble:
+			out = append(out, doubleKV(key, vdbl))
+		case jtTagBool:
+			out = append(out, boolKV(key, vbool))
+		case jtTagLong:
+			out = append(out, intKV(key, vlong))
+		case jtTagBinary:
+			out = append(out, bytesKV(key, vbin))
+		default:
+			out = append(out, strKV(key, vstr))
+		}
+	}
+	return out, nil
+}
+
+// readStruct iterates the fields of a struct; fn must consume the field value
+// (or skip it).
+func (r *thriftReader) readStruct(fn func(id int16, typ byte) error) error {
+	r.depth++
+	defer func() { r.depth-- }()
+	if r.depth > thriftMaxDepth {
+		return errors.New("thrift: nesting too deep")
+	}
+	for {
+		h, err := r.next(1)
+		if err != nil {
+			return err
+		}
+		typ := h[0]
+		if typ == tStop {
+			return nil
+		}
+		idb, err := r.next(2)
+		if err != nil {
+			return err
+		}
+		if err := fn(int16(binary.BigEndian.Uint16(idb)), typ); err != nil {
+			return err
+		}
+	}
+}
+
+// listHeader reads a list/set header and checks the element type.
+func (r *thriftReader) listHeader(elem byte) (int, error) {
+	h, err := r.next(5)
+	if err != nil {
+		return 0, err
+	}
+	n := int(int32(binary.BigEndian.Uint32(h[1:])))
+	if h[0] != elem {
+		return 0, fmt.Errorf("thrift: list of type %d, want %d", h[0], elem)
+	}
+	if n < 0 || n > len(r.b) {
+		return 0, errThriftShort
+	}
+	return n, nil
+}
+
+func (r *thriftReader) skip(typ byte) error {
+	switch typ {
+	case tBool, tByte:
+		_, err := r.next(1)
+		return err
+	case tI16:
+		_, err := r.next(2)
+		return err
+	case tI32:
+		_, err := r.next(4)
+		return err
+	case tI64, tDouble:
+		_, err := r.next(8)
+		return err
+	case tString:
+		_, err := r.str()
+		return err
+	case tStruct:
+		return r.readStruct(func(_ int16, typ byte) error { return r.skip(typ) })
+	case tList, tSet:
+		h, err := r.next(5)
+		if err != nil {
+			return err
+		}
+		n := int(int32(binary.BigEndian.Uint32(h[1:])))
+		if n < 0 || n > len(r.b) {
+			return errThriftShort
+		}
+		for i := 0; i < n; i++ {
+			if err := r.skip(h[0]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case tMap:
+		h, err := r.next(6)
+		if err != nil {
+			return err
+		}
+		n := int(int32(binary.BigEndian.Uint32(h[2:])))
+		if n < 0 || n > len(r.b) {
+			return errThriftShort
+		}
+		for i := 0; i < n; i++ {
+			if err := r.skip(h[0]); err != nil {
+				return err
+			}
+			if err := r.skip(h[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("thrift: unknown type %d", typ)
+}
+
+func (r *thriftReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.b) < n {
+		return nil, errThriftShort
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v, nil
+}
+
+func (r *thriftReader) i32() (int32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (r *thriftReader) u64() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (r *thriftReader) str() (string, error) {
+	n, err := r.i32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.next(int(n))
+	return string(b), err
+}
+
+func traceIDBytes(high, low uint64) []byte {
+	b := binary.BigEndian.AppendUint64(make([]byte, 0, 16), high)
+	return binary.BigEndian.AppendUint64(b, low)
+}
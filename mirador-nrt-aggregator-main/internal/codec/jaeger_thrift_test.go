@@ -0,0 +1,279 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// thriftWriter writes the Thrift binary protocol for test batches.
+type thriftWriter struct{ bytes.Buffer }
+
+func (w *thriftWriter) field(typ byte, id int16) {
+	w.WriteByte(typ)
+	_ = binary.Write(w, binary.BigEndian, id)
+}
+
+func (w *thriftWriter) stop() { w.WriteByte(tStop) }
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(tI32, id)
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *thriftWriter) i64(id int16, v uint64) {
+	w.field(tI64, id)
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(tString, id)
+	_ = binary.Write(w, binary.BigEndian, int32(len(s)))
+	w.WriteString(s)
+}
+
+func (w *thriftWriter) list(id int16, elem byte, n int) {
+	w.field(tList, id)
+	w.WriteByte(elem)
+	_ = binary.Write(w, binary.BigEndian, int32(n))
+}
+
+// tag writes one jaeger.thrift Tag struct (list element, no field header).
+func (w *thriftWriter) tag(key string, v any) {
+	w.str(1, key)
+	switch x := v.(type) {
+	case string:
+		w.i32(2, jtTagString)
+		w.str(3, x)
+	case float64:
+		w.i32(2, jtTagDouble)
+		w.field(tDouble, 4)
+		_ = binary.Write(w, binary.BigEndian, math.Float64bits(x))
+	case bool:
+		w.i32(2, jtTagBool)
+		w.field(tBool, 5)
+		if x {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case int64:
+		w.i32(2, jtTagLong)
+		w.i64(6, uint64(x))
+	case []byte:
+		w.i32(2, jtTagBinary)
+		w.str(7, string(x))
+	}
+	w.stop()
+}
+
+func (w *thriftWriter) tags(id int16, kvs ...any) {
+	w.list(id, tStruct, len(kvs)/2)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		w.tag(kvs[i].(string), kvs[i+1])
+	}
+}
+
+// batch writes a Batch with one Process and the spans written by span.
+func thriftBatch(service string, spans ...func(w *thriftWriter)) []byte {
+	var w thriftWriter
+	w.field(tStruct, 1) // process
+	w.str(1, service)
+	w.tags(2, "hostname", "h1")
+	w.stop()
+	w.list(2, tStruct, len(spans))
+	for _, s := range spans {
+		s(&w)
+		w.stop()
+	}
+	w.stop()
+	return w.Bytes()
+}
+
+func attrMap(kvs []*com.KeyValue) map[string]any {
+	out := map[string]any{}
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *com.AnyValue_StringValue:
+			out[kv.Key] = v.StringValue
+		case *com.AnyValue_DoubleValue:
+			out[kv.Key] = v.DoubleValue
+		case *com.AnyValue_BoolValue:
+			out[kv.Key] = v.BoolValue
+		case *com.AnyValue_IntValue:
+			out[kv.Key] = v.IntValue
+		case *com.AnyValue_BytesValue:
+			out[kv.Key] = v.BytesValue
+		}
+	}
+	return out
+}
+
+func id8(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func TestJaegerThriftToOTLP(t *testing.T) {
+	traceID := traceIDBytes(0x1122, 0x3344)
+	tests := []struct {
+		name  string
+		spans []func(w *thriftWriter)
+		check func(t *testing.T, sp *tr.Span)
+	}{
+		{
+			name: "span fields, tags and logs",
+			spans: []func(w *thriftWriter){func(w *thriftWriter) {
+				w.i64(1, 0x3344) // traceIdLow
+				w.i64(2, 0x1122) // traceIdHigh
+				w.i64(3, 0xaa)
+				w.i64(4, 0xbb) // parentSpanId
+				w.str(5, "GET /users")
+				w.i64(8, 1_700_000_000_000_000) // start, µs
+				w.i64(9, 1500)                  // duration, µs
+				w.tags(10, "http.method", "GET", "ratio", 0.5, "cached", false,
+					"http.status_code", int64(500), "blob", []byte{1, 2},
+					"span.kind", "server", "error", true)
+				w.list(11, tStruct, 1)
+				w.i64(1, 1_700_000_000_000_100)
+				w.tags(2, "event", "retry", "attempt", int64(2))
+				w.stop()
+			}},
+			check: func(t *testing.T, sp *tr.Span) {
+				if !bytes.Equal(sp.TraceId, traceID) || !bytes.Equal(sp.SpanId, id8(0xaa)) || !bytes.Equal(sp.ParentSpanId, id8(0xbb)) {
+					t.Fatalf("ids = %x %x %x", sp.TraceId, sp.SpanId, sp.ParentSpanId)
+				}
+				if sp.Name != "GET /users" || sp.StartTimeUnixNano != 1_700_000_000_000_000_000 || sp.EndTimeUnixNano != 1_700_000_000_001_500_000 {
+					t.Fatalf("name/times = %q %d %d", sp.Name, sp.StartTimeUnixNano, sp.EndTimeUnixNano)
+				}
+				if sp.Kind != tr.Span_SPAN_KIND_SERVER || sp.Status.GetCode() != tr.Status_STATUS_CODE_ERROR {
+					t.Fatalf("kind/status = %v %v", sp.Kind, sp.Status)
+				}
+				want := map[string]any{
+					"http.method": "GET", "ratio": 0.5, "cached": false,
+					"http.status_code": int64(500), "blob": []byte{1, 2},
+				}
+				if got := attrMap(sp.Attributes); !reflect.DeepEqual(got, want) {
+					t.Fatalf("attributes = %v, want %v", got, want)
+				}
+				if len(sp.Events) != 1 || sp.Events[0].Name != "retry" || sp.Events[0].TimeUnixNano != 1_700_000_000_000_100_000 ||
+					!reflect.DeepEqual(attrMap(sp.Events[0].Attributes), map[string]any{"attempt": int64(2)}) {
+					t.Fatalf("events = %v", sp.Events)
+				}
+			},
+		},
+		{
+			name: "references take precedence over parentSpanId",
+			spans: []func(w *thriftWriter){func(w *thriftWriter) {
+				w.i64(1, 0x3344)
+				w.i64(2, 0x1122)
+				w.i64(3, 0xaa)
+				w.i64(4, 0xbb)
+				w.list(6, tStruct, 2)
+				w.i32(1, jRefFollowsFrom)
+				w.i64(2, 0x9)
+				w.i64(3, 0)
+				w.i64(4, 0xcc)
+				w.stop()
+				w.i32(1, jRefChildOf)
+				w.i64(2, 0x3344)
+				w.i64(3, 0x1122)
+				w.i64(4, 0xdd)
+				w.stop()
+			}},
+			check: func(t *testing.T, sp *tr.Span) {
+				if !bytes.Equal(sp.ParentSpanId, id8(0xdd)) {
+					t.Fatalf("parent = %x, want dd", sp.ParentSpanId)
+				}
+				if len(sp.Links) != 1 || !bytes.Equal(sp.Links[0].SpanId, id8(0xcc)) || !bytes.Equal(sp.Links[0].TraceId, traceIDBytes(0, 9)) {
+					t.Fatalf("links = %v", sp.Links)
+				}
+			},
+		},
+		{
+			name: "unknown fields are skipped",
+			spans: []func(w *thriftWriter){func(w *thriftWriter) {
+				w.i64(1, 0x3344)
+				w.i64(2, 0x1122)
+				w.field(tMap, 90)
+				w.WriteByte(tString)
+				w.WriteByte(tI32)
+				_ = binary.Write(w, binary.BigEndian, int32(1))
+				_ = binary.Write(w, binary.BigEndian, int32(1))
+				w.WriteString("k")
+				_ = binary.Write(w, binary.BigEndian, int32(7))
+				w.field(tStruct, 91)
+				w.list(1, tI16, 2)
+				w.Write([]byte{0, 1, 0, 2})
+				w.stop()
+				w.i64(3, 0xaa)
+				w.str(5, "op")
+			}},
+			check: func(t *testing.T, sp *tr.Span) {
+				if sp.Name != "op" || !bytes.Equal(sp.SpanId, id8(0xaa)) {
+					t.Fatalf("span = %v", sp)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := JaegerThriftToOTLP(thriftBatch("users", tt.spans...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(req.ResourceSpans) != 1 {
+				t.Fatalf("resource spans = %d", len(req.ResourceSpans))
+			}
+			rs := req.ResourceSpans[0]
+			if got := attrMap(rs.Resource.Attributes); !reflect.DeepEqual(got, map[string]any{"service.name": "users", "hostname": "h1"}) {
+				t.Fatalf("resource = %v", got)
+			}
+			tt.check(t, rs.ScopeSpans[0].Spans[0])
+		})
+	}
+}
+
+func TestJaegerThriftErrors(t *testing.T) {
+	valid := thriftBatch("svc", func(w *thriftWriter) { w.i64(3, 1) })
+	deep := func() []byte {
+		var w thriftWriter
+		for i := 0; i < thriftMaxDepth+1; i++ {
+			w.field(tStruct, 1)
+		}
+		return w.Bytes()
+	}
+	wrongList := func() []byte {
+		var w thriftWriter
+		w.list(2, tI32, 1)
+		return w.Bytes()
+	}
+	hugeList := func() []byte {
+		var w thriftWriter
+		w.list(2, tStruct, 1<<30)
+		return w.Bytes()
+	}
+	tests := []struct {
+		name    string
+		in      []byte
+		wantErr string
+	}{
+		{"empty", nil, "unexpected end"},
+		{"truncated", valid[:len(valid)-3], "unexpected end"},
+		{"nesting too deep", deep(), "too deep"},
+		{"spans of the wrong type", wrongList(), "list of type"},
+		{"list longer than the input", hugeList(), "unexpected end"},
+		{"unknown type", []byte{99, 0, 1}, "unknown type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := JaegerThriftToOTLP(tt.in)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package codec
+
+import (
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	res "go.opentelemetry.io/proto/otlp/resource/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// traceBuilder groups translated spans into OTLP ResourceSpans keyed by their
+// resource (service name + resource attributes), preserving arrival order.
+type traceBuilder struct {
+	byKey map[string]*tr.ResourceSpans
+	order []*tr.ResourceSpans
+}
+
+func newTraceBuilder() *traceBuilder {
+	return &traceBuilder{byKey: map[string]*tr.ResourceSpans{}}
+}
+
+func (b *traceBuilder) add(service string, resAttrs []*com.KeyValue, sp *tr.Span) {
+	key := resourceKey(service, resAttrs)
+	rs, ok := b.byKey[key]
+	if !ok {
+		attrs := make([]*com.KeyValue, 0, len(resAttrs)+1)
+		if service != "" {
+			attrs = append(attrs, strKV("service.name", service))
+		}
+		for _, kv := range resAttrs {
+			if kv.Key != "service.name" {
+				attrs = append(attrs, kv)
+			}
+		}
+		rs = &tr.ResourceSpans{
+			Resource:   &res.Resource{Attributes: attrs},
+			ScopeSpans: []*tr.ScopeSpans{{}},
+		}
+		b.byKey[key] = rs
+		b.order = append(b.order, rs)
+	}
+	rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, sp)
+}
+
+func (b *traceBuilder) request() *colltr.ExportTraceServiceRequest {
+	return &colltr.ExportTraceServiceRequest{ResourceSpans: b.order}
+}
+
+func resourceKey(service string, attrs []*com.KeyValue) string {
+	parts := make([]string, 0, len(attrs))
+	for _, kv := range attrs {
+		parts = append(parts, kv.Key+"="+anyString(kv.Value))
+	}
+	sort.Strings(parts)
+	return service + "|" + strings.Join(parts, ",")
+}
+
+// applySpanTags moves tags with OTLP span-level meaning (kind, status) out of
+// the attribute list, following the OpenTelemetry Jaeger/Zipkin translators.
+func applySpanTags(sp *tr.Span, tags []*com.KeyValue) {
+	var (
+		status   tr.Status_StatusCode
+		desc     string
+		errorTag bool
+	)
+	attrs := tags[:0:0]
+	for _, kv := range tags {
+		v := anyString(kv.Value)
+		switch kv.Key {
+		case "span.kind":
+			sp.Kind = spanKind(v)
+		case "otel.status_code":
+			switch strings.ToUpper(v) {
+			case "ERROR":
+				status = tr.Status_STATUS_CODE_ERROR
+			case "OK":
+				status = tr.Status_STATUS_CODE_OK
+			}
+		case "otel.status_description":
+			desc = v
+		case "error":
+			// Jaeger: error=true; Zipkin: error=<message>.
+			if v != "false" {
+				errorTag = true
+				if v != "true" && desc == "" {
+					desc = v
+				}
+			}
+		default:
+			attrs = append(attrs, kv)
+		}
+	}
+	if status == tr.Status_STATUS_CODE_UNSET && errorTag {
+		status = tr.Status_STATUS_CODE_ERROR
+	}
+	if status != tr.Status_STATUS_CODE_UNSET || desc != "" {
+		sp.Status = &tr.Status{Code: status, Message: desc}
+	}
+	sp.Attributes = append(sp.Attributes, attrs...)
+}
+
+func spanKind(s string) tr.Span_SpanKind {
+	switch strings.ToLower(s) {
+	case "client":
+		return tr.Span_SPAN_KIND_CLIENT
+	case "server":
+		return tr.Span_SPAN_KIND_SERVER
+	case "producer":
+		return tr.Span_SPAN_KIND_PRODUCER
+	case "consumer":
+		return tr.Span_SPAN_KIND_CONSUMER
+	case "internal":
+		return tr.Span_SPAN_KIND_INTERNAL
+	default:
+		return tr.Span_SPAN_KIND_UNSPECIFIED
+	}
+}
+
+// hexID decodes a hex trace/span ID, left-padding to size bytes (Zipkin allows
+// 64-bit trace IDs). Invalid input yields nil.
+func hexID(s string, size int) []byte {
+	if s == "" {
+		return nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) > size {
+		return nil
+	}
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return b
+}
+
+func strKV(k, v string) *com.KeyValue {
+	return &com.KeyValue{Key: k, Value: &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: v}}}
+}
+
+func boolKV(k string, v bool) *com.KeyValue {
+	return &com.KeyValue{Key: k, Value: &com.AnyValue{Value: &com.AnyValue_BoolValue{BoolValue: v}}}
+}
+
+func intKV(k string, v int64) *com.KeyValue {
+	return &com.KeyValue{Key: k, Value: &com.AnyValue{Value: &com.AnyValue_IntValue{IntValue: v}}}
+}
+
+func doubleKV(k string, v float64) *com.KeyValue {
+	return &com.KeyValue{Key: k, Value: &com.AnyValue{Value: &com.AnyValue_DoubleValue{DoubleValue: v}}}
+}
+
+func bytesKV(k string, v []byte) *com.KeyValue {
+	return &com.KeyValue{Key: k, Value: &com.AnyValue{Value: &com.AnyValue_BytesValue{BytesValue: v}}}
+}
+
+func anyString(v *com.AnyValue) string {
+	if v == nil {
+		return ""
+	}
+	switch x := v.Value.(type) {
+	case *com.AnyValue_StringValue:
+		return x.StringValue
+	case *com.AnyValue_BoolValue:
+		return strconv.FormatBool(x.BoolValue)
+	case *com.AnyValue_IntValue:
+		return strconv.FormatInt(x.IntValue, 10)
+	case *com.AnyValue_DoubleValue:
+		return strconv.FormatFloat(x.DoubleValue, 'g', -1, 64)
+	case *com.AnyValue_BytesValue:
+		return hex.EncodeToString(x.BytesValue)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,122 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// zipkinSpan is the Zipkin v2 span model (JSON field names).
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind"`
+	Timestamp      uint64             `json:"timestamp"` // epoch microseconds
+	Duration       uint64             `json:"duration"`  // microseconds
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint"`
+	Annotations    []zipkinAnnotation `json:"annotations"`
+	Tags           map[string]string  `json:"tags"`
+	Debug          bool               `json:"debug"`
+	Shared         bool               `json:"shared"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int    `json:"port"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp uint64 `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// ZipkinJSONToOTLP decodes a Zipkin v2 JSON span list (a single span object is
+// also accepted) into OTLP, grouping spans by local service.
+func ZipkinJSONToOTLP(b []byte) (*colltr.ExportTraceServiceRequest, error) {
+	var spans []zipkinSpan
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '{' {
+		var one zipkinSpan
+		if err := json.Unmarshal(b, &one); err != nil {
+			return nil, fmt.Errorf("zipkin_json: %w", err)
+		}
+		spans = []zipkinSpan{one}
+	} else if err := json.Unmarshal(b, &spans); err != nil {
+		return nil, fmt.Errorf("zipkin_json: %w", err)
+	}
+	return zipkinToOTLP(spans), nil
+}
+
+func zipkinToOTLP(spans []zipkinSpan) *colltr.ExportTraceServiceRequest {
+	tb := newTraceBuilder()
+	for i := range spans {
+		zs := &spans[i]
+		traceID := hexID(zs.TraceID, 16)
+		spanID := hexID(zs.ID, 8)
+		if traceID == nil || spanID == nil {
+			continue
+		}
+		startNs := zs.Timestamp * 1000
+		sp := &tr.Span{
+			TraceId:           traceID,
+			SpanId:            spanID,
+			ParentSpanId:      hexID(zs.ParentID, 8),
+			Name:              zs.Name,
+			Kind:              spanKind(zs.Kind),
+			StartTimeUnixNano: startNs,
+			EndTimeUnixNano:   startNs + zs.Duration*1000,
+		}
+		for _, a := range zs.Annotations {
+			sp.Events = append(sp.Events, &tr.Span_Event{TimeUnixNano: a.Timestamp * 1000, Name: a.Value})
+		}
+
+		tags := make([]*com.KeyValue, 0, len(zs.Tags)+3)
+		for k, v := range zs.Tags {
+			tags = append(tags, strKV(k, v))
+		}
+		if ep := zs.RemoteEndpoint; ep != nil {
+			if ep.ServiceName != "" {
+				tags = append(tags, strKV("peer.service", ep.ServiceName))
+			}
+			if ip := firstNonEmpty(ep.IPv4, ep.IPv6); ip != "" {
+				tags = append(tags, strKV("net.peer.ip", ip))
+			}
+			if ep.Port > 0 {
+				tags = append(tags, intKV("net.peer.port", int64(ep.Port)))
+			}
+		}
+		applySpanTags(sp, tags)
+
+		service := ""
+		var resAttrs []*com.KeyValue
+		if ep := zs.LocalEndpoint; ep != nil {
+			service = ep.ServiceName
+			if ip := firstNonEmpty(ep.IPv4, ep.IPv6); ip != "" {
+				resAttrs = append(resAttrs, strKV("net.host.ip", ip))
+			}
+			if ep.Port > 0 {
+				resAttrs = append(resAttrs, intKV("net.host.port", int64(ep.Port)))
+			}
+		}
+		tb.add(service, resAttrs, sp)
+	}
+	return tb.request()
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// zipkin.proto (proto3) field numbers; decoded by hand like the Jaeger model.
+const (
+	zSpanTraceID     = 1
+	zSpanParentID    = 2
+	zSpanID          = 3
+	zSpanKind        = 4
+	zSpanName        = 5
+	zSpanTimestamp   = 6
+	zSpanDuration    = 7
+	zSpanLocalEP     = 8
+	zSpanRemoteEP    = 9
+	zSpanAnnotations = 10
+	zSpanTags        = 11
+	zSpanDebug       = 12
+	zSpanShared      = 13
+)
+
+// zipkin.proto Span.Kind enum values.
+var zipkinProtoKinds = map[uint64]string{1: "CLIENT", 2: "SERVER", 3: "PRODUCER", 4: "CONSUMER"}
+
+// ZipkinProtoToOTLP decodes a Zipkin v2 protobuf ListOfSpans into OTLP,
+// sharing the JSON path's mapping once the spans are in zipkinSpan form.
+func ZipkinProtoToOTLP(b []byte) (*colltr.ExportTraceServiceRequest, error) {
+	var spans []zipkinSpan
+	err := walk(b, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		zs, err := decodeZipkinSpan(v)
+		if err != nil {
+			return err
+		}
+		spans = append(spans, zs)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("zipkin_proto: %w", err)
+	}
+	return zipkinToOTLP(spans), nil
+}
+
+func decodeZipkinSpan(b []byte) (zipkinSpan, error) {
+	var zs zipkinSpan
+	err := walk(b, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case zSpanTraceID:
+			zs.TraceID = hex.EncodeToString(v)
+		case zSpanParentID:
+			zs.ParentID = hex.EncodeToString(v)
+		case zSpanID:
+			zs.ID = hex.EncodeToString(v)
+		case zSpanKind:
+			zs.Kind = zipkinProtoKinds[n]
+		case zSpanName:
+			zs.Name = string(v)
+		case zSpanTimestamp:
+			zs.Timestamp = n
+		case zSpanDuration:
+			zs.Duration = n
+		case zSpanLocalEP, zSpanRemoteEP:
+			ep, err := decodeZipkinEndpoint(v)
+			if err != nil {
+				return err
+			}
+			if num == zSpanLocalEP {
+				zs.LocalEndpoint = ep
+			} else {
+				zs.RemoteEndpoint = ep
+			}
+		case zSpanAnnotations:
+			var a zipkinAnnotation
+			if err := walk(v, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+				switch num {
+				case 1:
+					a.Timestamp = n
+				case 2:
+					a.Value = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			zs.Annotations = append(zs.Annotations, a)
+		case zSpanTags:
+			var key, val string
+			if err := walk(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+				switch num {
+				case 1:
+					key = string(v)
+				case 2:
+					val = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if zs.Tags == nil {
+				zs.Tags = map[string]string{}
+			}
+			zs.Tags[key] = val
+		case zSpanDebug:
+			zs.Debug = n != 0
+		case zSpanShared:
+			zs.Shared = n != 0
+		}
+		return nil
+	})
+	return zs, err
+}
+
+func decodeZipkinEndpoint(b []byte) (*zipkinEndpoint, error) {
+	ep := &zipkinEndpoint{}
+	err := walk(b, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			ep.ServiceName = string(v)
+		case 2:
+			if len(v) == net.IPv4len {
+				ep.IPv4 = net.IP(v).String()
+			}
+		case 3:
+			if len(v) == net.IPv6len {
+				ep.IPv6 = net.IP(v).String()
+			}
+		case 4:
+			ep.Port = int(int32(n))
+		}
+		return nil
+	})
+	return ep, err
+}
@@ -0,0 +1,229 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestZipkinJSONToOTLP(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		resources int
+		check     func(t *testing.T, req []*tr.ResourceSpans)
+	}{
+		{
+			name: "span fields, endpoints and tags",
+			in: `[{"traceId":"463ac35c9f6413ad48485a3953bb6124","id":"a2fb4a1d1a96d312","parentId":"0020000000000001",
+				"name":"get /users","kind":"SERVER","timestamp":1700000000000000,"duration":1500,
+				"localEndpoint":{"serviceName":"users","ipv4":"10.0.0.1","port":8080},
+				"remoteEndpoint":{"serviceName":"gateway","ipv6":"::1","port":443},
+				"annotations":[{"timestamp":1700000000000100,"value":"ws"}],
+				"tags":{"http.method":"GET","error":"upstream timeout"}}]`,
+			resources: 1,
+			check: func(t *testing.T, rss []*tr.ResourceSpans) {
+				res := attrMap(rss[0].Resource.Attributes)
+				if !reflect.DeepEqual(res, map[string]any{"service.name": "users", "net.host.ip": "10.0.0.1", "net.host.port": int64(8080)}) {
+					t.Fatalf("resource = %v", res)
+				}
+				sp := rss[0].ScopeSpans[0].Spans[0]
+				if !bytes.Equal(sp.TraceId, mustHex("463ac35c9f6413ad48485a3953bb6124")) ||
+					!bytes.Equal(sp.SpanId, mustHex("a2fb4a1d1a96d312")) || !bytes.Equal(sp.ParentSpanId, mustHex("0020000000000001")) {
+					t.Fatalf("ids = %x %x %x", sp.TraceId, sp.SpanId, sp.ParentSpanId)
+				}
+				if sp.Kind != tr.Span_SPAN_KIND_SERVER || sp.StartTimeUnixNano != 1_700_000_000_000_000_000 || sp.EndTimeUnixNano != 1_700_000_000_001_500_000 {
+					t.Fatalf("kind/times = %v %d %d", sp.Kind, sp.StartTimeUnixNano, sp.EndTimeUnixNano)
+				}
+				if sp.Status.GetCode() != tr.Status_STATUS_CODE_ERROR || sp.Status.GetMessage() != "upstream timeout" {
+					t.Fatalf("status = %v", sp.Status)
+				}
+				attrs := attrMap(sp.Attributes)
+				want := map[string]any{"http.method": "GET", "peer.service": "gateway", "net.peer.ip": "::1", "net.peer.port": int64(443)}
+				if !reflect.DeepEqual(attrs, want) {
+					t.Fatalf("attributes = %v, want %v", attrs, want)
+				}
+				if len(sp.Events) != 1 || sp.Events[0].Name != "ws" || sp.Events[0].TimeUnixNano != 1_700_000_000_000_100_000 {
+					t.Fatalf("events = %v", sp.Events)
+				}
+			},
+		},
+		{
+			name:      "single span object, 64-bit trace ID padded",
+			in:        `{"traceId":"48485a3953bb6124","id":"0000000000000001","name":"x"}`,
+			resources: 1,
+			check: func(t *testing.T, rss []*tr.ResourceSpans) {
+				sp := rss[0].ScopeSpans[0].Spans[0]
+				if !bytes.Equal(sp.TraceId, mustHex("000000000000000048485a3953bb6124")) {
+					t.Fatalf("trace id = %x", sp.TraceId)
+				}
+				if len(rss[0].Resource.Attributes) != 0 {
+					t.Fatalf("resource = %v", rss[0].Resource.Attributes)
+				}
+			},
+		},
+		{
+			name: "spans grouped by local service, bad IDs dropped",
+			in: `[{"traceId":"01","id":"01","localEndpoint":{"serviceName":"a"}},
+				{"traceId":"01","id":"02","localEndpoint":{"serviceName":"b"}},
+				{"traceId":"01","id":"03","localEndpoint":{"serviceName":"a"}},
+				{"traceId":"zz","id":"04","localEndpoint":{"serviceName":"a"}},
+				{"traceId":"01","id":"0102030405060708090a","localEndpoint":{"serviceName":"a"}}]`,
+			resources: 2,
+			check: func(t *testing.T, rss []*tr.ResourceSpans) {
+				if n := len(rss[0].ScopeSpans[0].Spans); n != 2 {
+					t.Fatalf("spans of a = %d, want 2", n)
+				}
+				if n := len(rss[1].ScopeSpans[0].Spans); n != 1 {
+					t.Fatalf("spans of b = %d, want 1", n)
+				}
+			},
+		},
+		{
+			name:      "error=false is not an error",
+			in:        `[{"traceId":"01","id":"01","tags":{"error":"false"}}]`,
+			resources: 1,
+			check: func(t *testing.T, rss []*tr.ResourceSpans) {
+				if sp := rss[0].ScopeSpans[0].Spans[0]; sp.Status != nil {
+					t.Fatalf("status = %v", sp.Status)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ZipkinJSONToOTLP([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(req.ResourceSpans) != tt.resources {
+				t.Fatalf("resource spans = %d, want %d", len(req.ResourceSpans), tt.resources)
+			}
+			tt.check(t, req.ResourceSpans)
+		})
+	}
+	if _, err := ZipkinJSONToOTLP([]byte(`[{"traceId":1}]`)); err == nil {
+		t.Fatal("bad JSON accepted")
+	}
+}
+
+// zipkinProtoSpan encodes a zipkin.proto Span.
+func zipkinProtoSpan(traceID, id, parent, name string, kind uint64, ts, dur uint64, local, remote []byte, tags map[string]string) []byte {
+	var b []byte
+	bytesField := func(num protowire.Number, v []byte) {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	bytesField(zSpanTraceID, mustHex(traceID))
+	if parent != "" {
+		bytesField(zSpanParentID, mustHex(parent))
+	}
+	bytesField(zSpanID, mustHex(id))
+	b = protowire.AppendTag(b, zSpanKind, protowire.VarintType)
+	b = protowire.AppendVarint(b, kind)
+	bytesField(zSpanName, []byte(name))
+	b = protowire.AppendTag(b, zSpanTimestamp, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, ts)
+	b = protowire.AppendTag(b, zSpanDuration, protowire.VarintType)
+	b = protowire.AppendVarint(b, dur)
+	if local != nil {
+		bytesField(zSpanLocalEP, local)
+	}
+	if remote != nil {
+		bytesField(zSpanRemoteEP, remote)
+	}
+	for k, v := range tags {
+		var e []byte
+		e = protowire.AppendTag(e, 1, protowire.BytesType)
+		e = protowire.AppendString(e, k)
+		e = protowire.AppendTag(e, 2, protowire.BytesType)
+		e = protowire.AppendString(e, v)
+		bytesField(zSpanTags, e)
+	}
+	return b
+}
+
+func zipkinProtoEndpoint(service string, ip net.IP, port uint64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, service)
+	if ip4 := ip.To4(); ip4 != nil {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, ip4)
+	} else {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, ip.To16())
+	}
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	return protowire.AppendVarint(b, port)
+}
+
+// The protobuf and JSON encodings of the same spans translate identically.
+func TestZipkinProtoMatchesJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		proto [][]byte
+		json  string
+	}{
+		{
+			name: "server span with endpoints and tags",
+			proto: [][]byte{zipkinProtoSpan("463ac35c9f6413ad48485a3953bb6124", "a2fb4a1d1a96d312", "0020000000000001",
+				"get /users", 2, 1700000000000000, 1500,
+				zipkinProtoEndpoint("users", net.ParseIP("10.0.0.1"), 8080),
+				zipkinProtoEndpoint("gateway", net.ParseIP("2001:db8::1"), 443),
+				map[string]string{"error": "boom"})},
+			json: `[{"traceId":"463ac35c9f6413ad48485a3953bb6124","id":"a2fb4a1d1a96d312","parentId":"0020000000000001",
+				"name":"get /users","kind":"SERVER","timestamp":1700000000000000,"duration":1500,
+				"localEndpoint":{"serviceName":"users","ipv4":"10.0.0.1","port":8080},
+				"remoteEndpoint":{"serviceName":"gateway","ipv6":"2001:db8::1","port":443},
+				"tags":{"error":"boom"}}]`,
+		},
+		{
+			name: "two services",
+			proto: [][]byte{
+				zipkinProtoSpan("01", "01", "", "a", 1, 1, 1, zipkinProtoEndpoint("a", net.ParseIP("10.0.0.1"), 1), nil, nil),
+				zipkinProtoSpan("01", "02", "01", "b", 4, 2, 1, zipkinProtoEndpoint("b", net.ParseIP("10.0.0.2"), 2), nil, nil),
+			},
+			json: `[{"traceId":"01","id":"01","name":"a","kind":"CLIENT","timestamp":1,"duration":1,"localEndpoint":{"serviceName":"a","ipv4":"10.0.0.1","port":1}},
+				{"traceId":"01","id":"02","parentId":"01","name":"b","kind":"CONSUMER","timestamp":2,"duration":1,"localEndpoint":{"serviceName":"b","ipv4":"10.0.0.2","port":2}}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list []byte
+			for _, sp := range tt.proto {
+				list = protowire.AppendTag(list, 1, protowire.BytesType)
+				list = protowire.AppendBytes(list, sp)
+			}
+			got, err := ZipkinProtoToOTLP(list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := ZipkinJSONToOTLP([]byte(tt.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(got, want) {
+				t.Fatalf("proto = %v\njson = %v", got, want)
+			}
+		})
+	}
+	if _, err := ZipkinProtoToOTLP([]byte{0x0a, 0x05, 0x01}); err == nil {
+		t.Fatal("truncated ListOfSpans accepted")
+	}
+}
@@ -0,0 +1,23 @@
+// Package common holds the small helpers shared by receivers and
+// processors: nested config lookups, TLS configs and the logfmt parser.
+package common
+
+// NestedString returns m[k1][k2] when it is a string, "" otherwise.
+func NestedString(m map[string]any, k1, k2 string) string {
+	n1, ok := m[k1].(map[string]any)
+	if !ok {
+		return ""
+	}
+	s, _ := n1[k2].(string)
+	return s
+}
+
+// NestedBool returns m[k1][k2] and whether it is set to a bool.
+func NestedBool(m map[string]any, k1, k2 string) (bool, bool) {
+	n1, ok := m[k1].(map[string]any)
+	if !ok {
+		return false, false
+	}
+	b, ok := n1[k2].(bool)
+	return b, ok
+}
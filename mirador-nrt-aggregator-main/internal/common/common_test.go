@@ -0,0 +1,34 @@
+package common
+
+import "testing"
+
+func TestNested(t *testing.T) {
+	m := map[string]any{
+		"tls":  map[string]any{"cert_file": "c.pem", "enabled": true, "port": 1},
+		"flat": "x",
+	}
+	tests := []struct {
+		k1, k2  string
+		wantS   string
+		wantB   bool
+		wantSet bool
+	}{
+		{"tls", "cert_file", "c.pem", false, false},
+		{"tls", "enabled", "", true, true},
+		{"tls", "port", "", false, false},
+		{"tls", "missing", "", false, false},
+		{"flat", "x", "", false, false},
+		{"missing", "x", "", false, false},
+	}
+	for _, tt := range tests {
+		if got := NestedString(m, tt.k1, tt.k2); got != tt.wantS {
+			t.Errorf("NestedString(%s, %s) = %q, want %q", tt.k1, tt.k2, got, tt.wantS)
+		}
+		if b, ok := NestedBool(m, tt.k1, tt.k2); b != tt.wantB || ok != tt.wantSet {
+			t.Errorf("NestedBool(%s, %s) = %v, %v, want %v, %v", tt.k1, tt.k2, b, ok, tt.wantB, tt.wantSet)
+		}
+	}
+	if NestedString(nil, "a", "b") != "" {
+		t.Error("NestedString(nil) should be empty")
+	}
+}
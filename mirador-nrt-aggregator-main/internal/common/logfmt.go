@@ -0,0 +1,89 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseLogfmt parses `key=value key2="quoted value"` lines. Quoted values may
+// carry escapes; bare keys map to "", as in Loki's logfmt parser. It returns
+// nil when the line holds no key.
+func ParseLogfmt(line string) map[string]any {
+	out, _ := parseLogfmt(line)
+	return out
+}
+
+// ParseLogfmtStrict is ParseLogfmt for lines that may just as well be free
+// text: it only accepts lines made of at least two key=value pairs, so a
+// sentence with a stray "=" stays text.
+func ParseLogfmtStrict(line string) map[string]any {
+	out, clean := parseLogfmt(line)
+	if !clean || len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+// parseLogfmt also reports whether the line was well formed: no bare words,
+// stray '=' or unterminated quotes.
+func parseLogfmt(line string) (map[string]any, bool) {
+	out := map[string]any{}
+	clean := true
+	s := line
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		end := strings.IndexAny(s, "= \t")
+		if end < 0 {
+			end = len(s)
+		}
+		key := s[:end]
+		s = s[end:]
+		if key == "" {
+			// stray '=' — skip it
+			s = s[1:]
+			clean = false
+			continue
+		}
+		if !strings.HasPrefix(s, "=") {
+			out[key] = ""
+			clean = false
+			continue
+		}
+		s = s[1:]
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				val, s = s[1:], ""
+				clean = false
+			} else {
+				if uq, err := strconv.Unquote(s[:i+1]); err == nil {
+					val = uq
+				} else {
+					val = s[1:i]
+				}
+				s = s[i+1:]
+			}
+		} else {
+			sp := strings.IndexAny(s, " \t")
+			if sp < 0 {
+				sp = len(s)
+			}
+			val, s = s[:sp], s[sp:]
+		}
+		out[key] = val
+	}
+	if len(out) == 0 {
+		return nil, false
+	}
+	return out, clean
+}
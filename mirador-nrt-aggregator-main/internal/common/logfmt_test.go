@@ -0,0 +1,70 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		name       string
+		in         string
+		want       map[string]any
+		wantStrict map[string]any // nil when the strict parser rejects the line
+	}{
+		{
+			name:       "pairs",
+			in:         `level=info msg=started port=8080`,
+			want:       map[string]any{"level": "info", "msg": "started", "port": "8080"},
+			wantStrict: map[string]any{"level": "info", "msg": "started", "port": "8080"},
+		},
+		{
+			name:       "quoted values with escapes",
+			in:         `msg="GET /api \"v1\"" path="a b"	status=200`,
+			want:       map[string]any{"msg": `GET /api "v1"`, "path": "a b", "status": "200"},
+			wantStrict: map[string]any{"msg": `GET /api "v1"`, "path": "a b", "status": "200"},
+		},
+		{
+			name:       "empty values",
+			in:         `a= b=""`,
+			want:       map[string]any{"a": "", "b": ""},
+			wantStrict: map[string]any{"a": "", "b": ""},
+		},
+		{
+			name: "bare keys",
+			in:   `debug level=warn`,
+			want: map[string]any{"debug": "", "level": "warn"},
+		},
+		{
+			name: "stray equals",
+			in:   `= a=1 b=2`,
+			want: map[string]any{"a": "1", "b": "2"},
+		},
+		{
+			name: "unterminated quote",
+			in:   `a=1 msg="oops`,
+			want: map[string]any{"a": "1", "msg": "oops"},
+		},
+		{
+			name: "single pair",
+			in:   `a=1`,
+			want: map[string]any{"a": "1"},
+		},
+		{
+			name: "free text with an equals sign",
+			in:   `retrying because x=3 failed`,
+			want: map[string]any{"retrying": "", "because": "", "x": "3", "failed": ""},
+		},
+		{name: "blank", in: " \t "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseLogfmt(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLogfmt = %v, want %v", got, tt.want)
+			}
+			if got := ParseLogfmtStrict(tt.in); !reflect.DeepEqual(got, tt.wantStrict) {
+				t.Errorf("ParseLogfmtStrict = %v, want %v", got, tt.wantStrict)
+			}
+		})
+	}
+}
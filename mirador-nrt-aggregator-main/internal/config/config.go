@@ -91,6 +91,11 @@ func Load(path string) (*Config, error) {
 		if v.Extra == nil {
 			v.Extra = map[string]any{}
 		}
+		for _, q := range v.Quantiles {
+			if q <= 0 || q >= 1 {
+				return nil, fmt.Errorf("processor %q: quantile %v must be in (0, 1)", k, q)
+			}
+		}
 		cfg.Processors[k] = v
 	}
 
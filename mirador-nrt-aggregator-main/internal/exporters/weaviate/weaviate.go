@@ -13,6 +13,7 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
 )
@@ -26,7 +27,8 @@ type Exporter struct {
 
 // New creates a new Weaviate exporter from config.
 func New(cfg config.ExporterCfg) *Exporter {
-	tmpl := "{{.Service}}:{{.WindowStart}}:{{.SummaryText}}"
+	// Grouped aggregates (see Aggregate.Group) get one object per operation.
+	tmpl := "{{.Service}}{{with .GroupKey}}:{{.}}{{end}}:{{.WindowStart}}:{{.SummaryText}}"
 	if cfg.IDTemplate != "" {
 		tmpl = cfg.IDTemplate
 	}
@@ -43,6 +45,7 @@ func New(cfg config.ExporterCfg) *Exporter {
 }
 
 // Start runs the exporter, consuming Aggregates until the input channel closes.
+// Each aggregate's Ack is resolved with the upsert outcome.
 func (e *Exporter) Start(ctx context.Context, in <-chan model.Aggregate) error {
 	for {
 		select {
@@ -54,7 +57,10 @@ func (e *Exporter) Start(ctx context.Context, in <-chan model.Aggregate) error {
 			}
 			if err := e.upsert(ctx, a); err != nil {
 				log.Printf("weaviate exporter: upsert failed: %v", err)
+				ack.Fail(a.Ack, err)
+				continue
 			}
+			ack.Done(a.Ack)
 		}
 	}
 }
@@ -71,6 +77,26 @@ func (e *Exporter) upsert(ctx context.Context, a model.Aggregate) error {
 			labelsJSON = string(lb)
 		}
 	}
+	quantilesJSON := "{}"
+	if a.Quantiles != nil {
+		if qb, err := json.Marshal(a.Quantiles); err == nil {
+			quantilesJSON = string(qb)
+		}
+	}
+	// Sketch (when the producer emits one) is kept so stored windows can still
+	// be merged into coarser ones later.
+	sketchJSON := ""
+	if a.Sketch != nil {
+		if sb, err := json.Marshal(a.Sketch); err == nil {
+			sketchJSON = string(sb)
+		}
+	}
+	groupJSON := "{}"
+	if a.Group != nil {
+		if gb, err := json.Marshal(a.Group); err == nil {
+			groupJSON = string(gb)
+		}
+	}
 
 	body := map[string]any{
 		"class":  e.class,
@@ -84,11 +110,14 @@ func (e *Exporter) upsert(ctx context.Context, a model.Aggregate) error {
 			"p50":           a.P50,
 			"p95":           a.P95,
 			"p99":           a.P99,
+			"quantiles":     quantilesJSON,
 			"rps":           a.RPS,
 			"error_rate":    a.ErrorRate,
 			"anomaly_score": a.AnomalyScore,
 			"count":         a.Count,
 			"labels":        labelsJSON,
+			"group":         groupJSON,
+			"sketch":        sketchJSON,
 			"locator":       a.Locator,
 		},
 	}
@@ -119,7 +148,7 @@ func (e *Exporter) renderID(a model.Aggregate) string {
 	var sb strings.Builder
 	if err := e.idTemplate.Execute(&sb, a); err != nil {
 		// fallback
-		return fmt.Sprintf("%s:%d", a.Service, a.WindowStart)
+		return fmt.Sprintf("%s:%d", a.SeriesKey(), a.WindowStart)
 	}
 	return sb.String()
 }
@@ -0,0 +1,140 @@
+// Package locator builds the Aggregate.Locator JSON shared by the summarizer
+// and logsum: the window, the exemplar traces behind it and query/trace links
+// for RCA and the UI.
+package locator
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+// Exemplar is one concrete trace behind a window, kept for deep-linking.
+type Exemplar struct {
+	TraceID string  `json:"trace_id"`
+	SpanID  string  `json:"span_id,omitempty"`
+	Value   float64 `json:"value,omitempty"`   // latency in seconds for histogram exemplars, quantile_field for logs
+	TSUnix  float64 `json:"ts_unix,omitempty"` // exemplar time, Unix seconds
+	Reason  string  `json:"reason,omitempty"`  // error | slow
+	Metric  string  `json:"metric,omitempty"`
+}
+
+// Config turns a window into query/trace links. Templates expand {service},
+// {start} and {end} (RFC 3339).
+type Config struct {
+	MaxExemplars int
+	LogsURL      string // VictoriaLogs base URL; "" keeps links relative
+	TracesURL    string // VictoriaTraces (Jaeger API) base URL
+	LogsQuery    string // LogsQL template
+}
+
+// NewConfig reads exemplars_per_window, logs_url, traces_url and
+// logs_query_template.
+func NewConfig(cfg config.ProcessorCfg) Config {
+	n := 5
+	if v, ok := cfg.Extra["exemplars_per_window"].(int); ok && v >= 0 {
+		n = v
+	}
+	return Config{
+		MaxExemplars: n,
+		LogsURL:      strings.TrimRight(cfg.ExtraString("logs_url", ""), "/"),
+		TracesURL:    strings.TrimRight(cfg.ExtraString("traces_url", ""), "/"),
+		LogsQuery:    cfg.ExtraString("logs_query_template", `_time:[{start}, {end}) service.name:"{service}"`),
+	}
+}
+
+// Add keeps the most useful exemplars of a window in xs: errors before
+// others, higher values before lower, one per trace.
+func (c Config) Add(xs []Exemplar, ex Exemplar) []Exemplar {
+	if c.MaxExemplars == 0 || ex.TraceID == "" {
+		return xs
+	}
+	for i, cur := range xs {
+		if cur.TraceID == ex.TraceID {
+			if Less(cur, ex) {
+				xs[i] = ex
+			}
+			return xs
+		}
+	}
+	if len(xs) < c.MaxExemplars {
+		return append(xs, ex)
+	}
+	worst := 0
+	for i := 1; i < len(xs); i++ {
+		if Less(xs[i], xs[worst]) {
+			worst = i
+		}
+	}
+	if Less(xs[worst], ex) {
+		xs[worst] = ex
+	}
+	return xs
+}
+
+// Less reports whether a is less worth keeping than b.
+func Less(a, b Exemplar) bool {
+	if (a.Reason == "error") != (b.Reason == "error") {
+		return b.Reason == "error"
+	}
+	return a.Value < b.Value
+}
+
+// locator is the JSON stored in Aggregate.Locator.
+type locator struct {
+	Service     string     `json:"service"`
+	WindowStart string     `json:"window_start"`
+	WindowEnd   string     `json:"window_end"`
+	Exemplars   []Exemplar `json:"exemplars,omitempty"`
+	LogsQuery   string     `json:"logs_query"`
+	Links       links      `json:"links"`
+}
+
+type links struct {
+	Logs        string   `json:"logs"`
+	TraceSearch string   `json:"trace_search"`
+	Traces      []string `json:"traces,omitempty"`
+}
+
+// Build returns the locator JSON of one window, exemplars most useful first.
+// exs is sorted in place.
+func (c Config) Build(service string, winStart, winEnd int64, exs []Exemplar) string {
+	sort.SliceStable(exs, func(i, j int) bool { return Less(exs[j], exs[i]) })
+	start := time.Unix(winStart, 0).UTC()
+	end := time.Unix(winEnd, 0).UTC()
+	q := strings.NewReplacer(
+		"{service}", service,
+		"{start}", start.Format(time.RFC3339),
+		"{end}", end.Format(time.RFC3339),
+	).Replace(c.LogsQuery)
+
+	search := url.Values{}
+	search.Set("service", service)
+	search.Set("start", strconv.FormatInt(start.UnixMicro(), 10))
+	search.Set("end", strconv.FormatInt(end.UnixMicro(), 10))
+
+	loc := locator{
+		Service:     service,
+		WindowStart: start.Format(time.RFC3339),
+		WindowEnd:   end.Format(time.RFC3339),
+		Exemplars:   exs,
+		LogsQuery:   q,
+		Links: links{
+			Logs:        c.LogsURL + "/select/logsql/query?query=" + url.QueryEscape(q),
+			TraceSearch: c.TracesURL + "/select/jaeger/api/traces?" + search.Encode(),
+		},
+	}
+	for _, ex := range exs {
+		loc.Links.Traces = append(loc.Links.Traces, c.TracesURL+"/select/jaeger/api/traces/"+ex.TraceID)
+	}
+	b, err := json.Marshal(loc)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,96 @@
+package locator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		in   []Exemplar
+		want []string // trace IDs kept, in insertion slots
+	}{
+		{
+			name: "disabled",
+			max:  0,
+			in:   []Exemplar{{TraceID: "a"}},
+		},
+		{
+			name: "without trace ID",
+			max:  2,
+			in:   []Exemplar{{SpanID: "s"}},
+		},
+		{
+			name: "one per trace, the better one wins",
+			max:  2,
+			in:   []Exemplar{{TraceID: "a", Value: 1}, {TraceID: "a", Value: 3}, {TraceID: "a", Value: 2}},
+			want: []string{"a"},
+		},
+		{
+			name: "full: the worst is replaced",
+			max:  2,
+			in:   []Exemplar{{TraceID: "a", Value: 5}, {TraceID: "b", Value: 1}, {TraceID: "c", Value: 2}},
+			want: []string{"a", "c"},
+		},
+		{
+			name: "full: errors beat values",
+			max:  2,
+			in:   []Exemplar{{TraceID: "a", Value: 5}, {TraceID: "b", Value: 9}, {TraceID: "c", Reason: "error"}},
+			want: []string{"c", "b"},
+		},
+		{
+			name: "full: a worse exemplar is ignored",
+			max:  1,
+			in:   []Exemplar{{TraceID: "a", Reason: "error"}, {TraceID: "b", Value: 9}},
+			want: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{MaxExemplars: tt.max}
+			var xs []Exemplar
+			for _, ex := range tt.in {
+				xs = c.Add(xs, ex)
+			}
+			var got []string
+			for _, ex := range xs {
+				got = append(got, ex.TraceID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	c := Config{
+		MaxExemplars: 5,
+		LogsURL:      "http://logs",
+		TracesURL:    "http://traces",
+		LogsQuery:    `service.name:"{service}" _time:[{start}, {end})`,
+	}
+	exs := []Exemplar{{TraceID: "slow", Value: 2}, {TraceID: "err", Reason: "error"}}
+	var got locator
+	if err := json.Unmarshal([]byte(c.Build("api", 0, 60, exs)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := locator{
+		Service:     "api",
+		WindowStart: "1970-01-01T00:00:00Z",
+		WindowEnd:   "1970-01-01T00:01:00Z",
+		Exemplars:   []Exemplar{{TraceID: "err", Reason: "error"}, {TraceID: "slow", Value: 2}},
+		LogsQuery:   `service.name:"api" _time:[1970-01-01T00:00:00Z, 1970-01-01T00:01:00Z)`,
+		Links: links{
+			Logs:        "http://logs/select/logsql/query?query=service.name%3A%22api%22+_time%3A%5B1970-01-01T00%3A00%3A00Z%2C+1970-01-01T00%3A01%3A00Z%29",
+			TraceSearch: "http://traces/select/jaeger/api/traces?end=60000000&service=api&start=0",
+			Traces:      []string{"http://traces/select/jaeger/api/traces/err", "http://traces/select/jaeger/api/traces/slow"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -1,5 +1,11 @@
 package model
 
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
 // Envelope is the generic container for any inbound signal emitted by receivers.
 // It carries the raw, undecoded payload plus minimal metadata, so downstream
 // processors (summarizer, logsum, spanmetrics, filters) can decode/inspect as needed.
@@ -25,6 +31,12 @@ type Envelope struct {
 
 	// TSUnix is the receiver-observed arrival time in Unix seconds.
 	TSUnix int64 `json:"ts_unix"`
+
+	// Ack, when set by the receiver, must be resolved exactly once after everything
+	// derived from this envelope has been exported (or deliberately dropped).
+	// Processors that transform envelopes carry it forward; windowed processors
+	// hand it to the aggregates they emit. Nil means the receiver does not care.
+	Ack Acker `json:"-"`
 }
 
 // Aggregate is the per-window summary produced by summarizers (or logsum).
@@ -37,6 +49,11 @@ type Aggregate struct {
 	Labels      map[string]string `json:"labels,omitempty"` // extra facets/top-Ks/etc.
 	Locator     string            `json:"locator"`          // optional opaque locator or deep-link JSON
 
+	// Group holds the grouping keys when the aggregate covers one operation of
+	// the service (e.g. {"http.route": "/checkout"}) rather than all of it.
+	// Values of "__other__" collect groups past the per-service cap.
+	Group map[string]string `json:"group,omitempty"`
+
 	// Counts & rates
 	Count     uint64  `json:"count"`      // number of contributing samples/points (approx)
 	RPS       float64 `json:"rps"`        // requests/events per second over the window
@@ -47,16 +64,99 @@ type Aggregate struct {
 	P95 float64 `json:"p95"`
 	P99 float64 `json:"p99"`
 
+	// Quantiles holds the processor's configured `quantiles:` set from the
+	// same digest, keyed by QuantileKey (e.g. "q0.75", "q0.999").
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+
 	// Anomaly scoring (filled by iforest processor)
 	AnomalyScore float64 `json:"anomaly_score"`
 
 	// Human-readable short summary; also used as input to embedding.
 	SummaryText string `json:"summary"`
 
+	// Sketch is the mergeable window state, set when the producing processor
+	// has `emit_sketch: true`; the rollup processor merges it across replicas
+	// and into coarser windows.
+	Sketch *Sketch `json:"sketch,omitempty"`
+
 	// Vector embedding produced by the vectorizer (not serialized to JSON).
 	Vector []float32 `json:"-"`
+
+	// Ack resolves the envelopes this aggregate was built from; exporters call it
+	// once the aggregate is stored (see Envelope.Ack).
+	Ack Acker `json:"-"`
+}
+
+// Sketch carries what is needed to merge aggregates exactly: the latency
+// t-digest (see internal/sketch), the request/ok/error totals behind RPS and
+// ErrorRate, and HyperLogLog registers for distinct-count labels.
+type Sketch struct {
+	Digest   []byte            `json:"digest,omitempty"` // t-digest, caio/go-tdigest binary encoding
+	Requests float64           `json:"requests"`         // RPS * window length
+	OK       float64           `json:"ok"`
+	Errors   float64           `json:"errors"`        // ErrorRate = Errors / (OK + Errors)
+	HLL      map[string][]byte `json:"hll,omitempty"` // Labels key → registers, e.g. "unique_users"
+}
+
+// GroupKey renders Group as "k=v,k=v" in key order; "" when ungrouped.
+func (a Aggregate) GroupKey() string {
+	if len(a.Group) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(a.Group))
+	for k := range a.Group {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + a.Group[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+// SeriesKey identifies the aggregate's series across windows: the service,
+// plus the group when there is one. Baselines are kept per SeriesKey.
+func (a Aggregate) SeriesKey() string {
+	if gk := a.GroupKey(); gk != "" {
+		return a.Service + "|" + gk
+	}
+	return a.Service
+}
+
+// QuantileKey names quantile q in Aggregate.Quantiles: "q" + q, e.g. "q0.9".
+func QuantileKey(q float64) string {
+	return "q" + strconv.FormatFloat(q, 'f', -1, 64)
 }
 
+// Quantile looks up a configured quantile by name ("q0.999"); names that
+// parse to the same value ("q0.90", "q.9") resolve to the same entry.
+func (a Aggregate) Quantile(name string) (float64, bool) {
+	s, ok := strings.CutPrefix(name, "q")
+	if !ok {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	v, ok := a.Quantiles[QuantileKey(q)]
+	return v, ok
+}
+
+// Acker carries delivery outcome back to a receiver so it can commit/ack its
+// source (e.g. Kafka offsets) only once the data is safely downstream.
+type Acker interface {
+	// Ack reports that the data was exported or intentionally dropped.
+	Ack()
+	// Nack reports that the data could not be exported.
+	Nack(err error)
+}
+
+// AttrCumulative set to "true" on a KindPromRW envelope marks its counters
+// as raw cumulative values (e.g. scraped) rather than remote-write deltas.
+const AttrCumulative = "prom.cumulative"
+
 // Known Envelope.Kind constants to help avoid typos.
 const (
 	KindMetrics  = "metrics"
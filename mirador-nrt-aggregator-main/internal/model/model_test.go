@@ -0,0 +1,40 @@
+package model
+
+import "testing"
+
+func TestSeriesAndGroupKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		a         Aggregate
+		wantGroup string
+		wantKey   string
+	}{
+		{"no group", Aggregate{Service: "api"}, "", "api"},
+		{"empty group", Aggregate{Service: "api", Group: map[string]string{}}, "", "api"},
+		{
+			name:      "one key",
+			a:         Aggregate{Service: "api", Group: map[string]string{"http.route": "/cart"}},
+			wantGroup: "http.route=/cart",
+			wantKey:   "api|http.route=/cart",
+		},
+		{
+			name:      "keys sorted",
+			a:         Aggregate{Service: "api", Group: map[string]string{"rpc.method": "Get", "http.route": "/cart", "k8s.namespace.name": "shop"}},
+			wantGroup: "http.route=/cart,k8s.namespace.name=shop,rpc.method=Get",
+			wantKey:   "api|http.route=/cart,k8s.namespace.name=shop,rpc.method=Get",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order varies; the keys must not.
+			for i := 0; i < 20; i++ {
+				if got := tt.a.GroupKey(); got != tt.wantGroup {
+					t.Fatalf("GroupKey = %q, want %q", got, tt.wantGroup)
+				}
+				if got := tt.a.SeriesKey(); got != tt.wantKey {
+					t.Fatalf("SeriesKey = %q, want %q", got, tt.wantKey)
+				}
+			}
+		})
+	}
+}
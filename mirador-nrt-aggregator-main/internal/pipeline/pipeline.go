@@ -6,25 +6,39 @@ import (
 	"log"
 	"sync"
 
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/exporters/weaviate"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
 
 	// Receivers
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/filelog"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/fluentforward"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/jaeger"
 	jl "github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/jsonlogs"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/kafka"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/loki"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/nats"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/otlpgrpc"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/otlphttp"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/promrw"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/promscrape"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/pulsar"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/statsd"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/syslog"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/zipkin"
 
 	// Processors
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/filter"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/iforest"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/logsum"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/otlplogs"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/ratelimit"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/rollup"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/servicegraph"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/spanmetrics"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/summarizer"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/transform"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/processors/vectorizer"
 )
 
@@ -85,10 +99,14 @@ func BuildAndRun(ctx context.Context, cfg *config.Config) error {
 		// Fan-out: broadcast from shared channel to all subscriber channels.
 		// Each subscriber gets a deep-copy of the Envelope bytes to prevent
 		// data races when multiple pipelines unmarshal the same protobuf slice.
+		// The envelope's Ack is split so the receiver only hears back once
+		// every subscribing pipeline has exported what it built from it.
 		go func(label string, subscribers []rxSub) {
 			for env := range shared {
+				acks := ack.Fanout(env.Ack, len(subscribers))
 				for i, sub := range subscribers {
 					e := env
+					e.Ack = acks[i]
 					if i > 0 {
 						// Copy byte slice for all subscribers after the first
 						cp := make([]byte, len(env.Bytes))
@@ -187,12 +205,16 @@ func runSinglePipeline(
 
 	// Stage N+1: Exporters (fan-out)
 	finalAgg := make(chan model.Aggregate)
-	// bridge: any -> aggregate
+	// bridge: any -> aggregate. Envelopes that reach the end of the chain
+	// produced nothing to export, so they are acked here.
 	go func() {
 		defer close(finalAgg)
 		for v := range inAny {
-			if a, ok := v.(model.Aggregate); ok {
-				finalAgg <- a
+			switch t := v.(type) {
+			case model.Aggregate:
+				finalAgg <- t
+			case model.Envelope:
+				ack.Done(t.Ack)
 			}
 		}
 	}()
@@ -200,6 +222,11 @@ func runSinglePipeline(
 	// Fan-out to all exporters
 	if len(pl.Exporters) == 0 {
 		log.Printf("[pipeline:%s] no exporters; aggregates will be dropped", name)
+		go func() {
+			for a := range finalAgg {
+				ack.Done(a.Ack)
+			}
+		}()
 	} else {
 		var expWg sync.WaitGroup
 		expInputs := make([]chan model.Aggregate, 0, len(pl.Exporters))
@@ -228,7 +255,9 @@ func runSinglePipeline(
 				}
 			}()
 			for a := range finalAgg {
-				for _, ch := range expInputs {
+				acks := ack.Fanout(a.Ack, len(expInputs))
+				for i, ch := range expInputs {
+					a.Ack = acks[i]
 					select {
 					case ch <- a:
 					case <-ctx.Done():
@@ -288,8 +317,30 @@ func buildReceivers(cfg *config.Config) (map[string]Receiver, error) {
 				kind = v
 			}
 			r = pulsar.New(rc, kind)
+		case "nats", "jetstream":
+			kind := "metrics"
+			if v, ok := rc.Extra["kind"].(string); ok && v != "" {
+				kind = v
+			}
+			r = nats.New(rc, kind)
 		case "promremotewrite", "promrw":
 			r = promrw.New(rc)
+		case "promscrape", "prometheus":
+			r = promscrape.New(rc)
+		case "syslog":
+			r = syslog.New(rc)
+		case "fluentforward", "forward":
+			r = fluentforward.New(rc)
+		case "loki":
+			r = loki.New(rc)
+		case "filelog":
+			r = filelog.New(rc)
+		case "statsd", "dogstatsd":
+			r = statsd.New(rc)
+		case "zipkin":
+			r = zipkin.New(rc)
+		case "jaeger":
+			r = jaeger.New(rc)
 		case "jsonlogs":
 			// Subtype via rc.Name: "http" or "kafka"
 			switch rc.Name {
@@ -303,6 +354,10 @@ func buildReceivers(cfg *config.Config) (map[string]Receiver, error) {
 		default:
 			return nil, fmt.Errorf("unknown receiver type %q (key=%s)", rc.Type, key)
 		}
+		// Optional inline rate limiting, applied before fan-out to pipelines.
+		if rl, ok := rc.Extra["rate_limit"].(map[string]any); ok {
+			r = ratelimit.WrapReceiver(key, r, rl)
+		}
 		rx[key] = r
 	}
 	return rx, nil
@@ -327,6 +382,14 @@ func buildProcessors(cfg *config.Config) (map[string]Processor, error) {
 			p = otlplogs.New(pc)
 		case "filter":
 			p = filter.New(pc)
+		case "ratelimit":
+			p = ratelimit.New(pc)
+		case "rollup":
+			p = rollup.New(pc)
+		case "servicegraph":
+			p = servicegraph.New(pc)
+		case "transform":
+			p = transform.New(pc)
 		default:
 			return nil, fmt.Errorf("unknown processor type %q (key=%s)", pc.Type, key)
 		}
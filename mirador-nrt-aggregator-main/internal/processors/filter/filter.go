@@ -9,6 +9,7 @@ import (
 
 	"github.com/google/cel-go/cel"
 
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
 )
@@ -87,6 +88,7 @@ func New(cfg config.ProcessorCfg) *processor {
 			cel.Variable("p50", cel.DoubleType),
 			cel.Variable("p95", cel.DoubleType),
 			cel.Variable("p99", cel.DoubleType),
+			cel.Variable("quantiles", cel.MapType(cel.StringType, cel.DoubleType)), // e.g. quantiles["q0.999"]
 			cel.Variable("rps", cel.DoubleType),
 			cel.Variable("error_rate", cel.DoubleType),
 			cel.Variable("anomaly_score", cel.DoubleType),
@@ -155,6 +157,8 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 				keep := p.evalEnvelope(t)
 				if keep || !p.dropNonMatching {
 					out <- t
+				} else {
+					ack.Done(t.Ack) // dropped on purpose → nothing left to deliver
 				}
 			case model.Aggregate:
 				if p.stage != "post" && p.on != "aggregates" {
@@ -164,6 +168,8 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 				keep := p.evalAggregate(t)
 				if keep || !p.dropNonMatching {
 					out <- t
+				} else {
+					ack.Done(t.Ack)
 				}
 			default:
 				// Unknown type -> pass through.
@@ -227,6 +233,7 @@ func (p *processor) evalAggregate(a model.Aggregate) bool {
 		"p50":           a.P50,
 		"p95":           a.P95,
 		"p99":           a.P99,
+		"quantiles":     quantilesOf(a),
 		"rps":           a.RPS,
 		"error_rate":    a.ErrorRate,
 		"anomaly_score": a.AnomalyScore,
@@ -237,6 +244,15 @@ func (p *processor) evalAggregate(a model.Aggregate) bool {
 	return p.eval(act)
 }
 
+// quantilesOf never hands CEL a nil map, so `"q0.999" in quantiles` works
+// on aggregates without configured quantiles.
+func quantilesOf(a model.Aggregate) map[string]float64 {
+	if a.Quantiles == nil {
+		return map[string]float64{}
+	}
+	return a.Quantiles
+}
+
 func (p *processor) eval(vars map[string]any) bool {
 	// Fail-open: if CEL eval errors or does not return a bool, keep the item.
 	out, _, err := p.prg.Eval(vars)
@@ -8,6 +8,7 @@ import (
 	"math"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -98,9 +99,10 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 			// Build feature vector
 			vec := p.featuresOf(a)
 
-			// Optional normalization (rolling per service)
+			// Optional normalization (rolling per service, or per operation
+			// for grouped aggregates)
 			if p.normMode == "zscore" {
-				vec = p.stats.apply(a.Service, a.WindowEnd, vec)
+				vec = p.stats.apply(a.SeriesKey(), a.WindowEnd, vec)
 			}
 
 			// Score
@@ -132,8 +134,18 @@ func (p *processor) featuresOf(a model.Aggregate) []float64 {
 		case "count":
 			x = append(x, float64(a.Count))
 		default:
-			// Unknown feature → append 0 to keep vector length stable
-			x = append(x, 0)
+			// "labels.<key>" reads a numeric label (e.g. labels.scrape_failure_ratio),
+			// "q<quantile>" a configured quantile (e.g. q0.999);
+			// anything unknown or unparsable → 0 to keep vector length stable
+			v := 0.0
+			if k, ok := strings.CutPrefix(f, "labels."); ok {
+				if parsed, err := strconv.ParseFloat(a.Labels[k], 64); err == nil {
+					v = parsed
+				}
+			} else if q, ok := a.Quantile(f); ok {
+				v = q
+			}
+			x = append(x, v)
 		}
 	}
 	return x
@@ -315,7 +327,7 @@ func newZ(win int) *zstats {
 	}
 }
 
-// apply returns z-scored features (per service) using an online Welford estimator.
+// apply returns z-scored features (per Aggregate.SeriesKey) using an online Welford estimator.
 // This simple implementation grows without bounds; in practice you might want TTL per service.
 // For now we keep it lean and effective for NRT usage.
 func (z *zstats) apply(svc string, ts int64, vec []float64) []float64 {
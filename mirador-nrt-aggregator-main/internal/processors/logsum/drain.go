@@ -0,0 +1,425 @@
+package logsum
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+// Online log template mining after Drain (He et al., ICWS 2017): messages are
+// masked, tokenized and routed through a fixed-depth tree (token count, then
+// the first tokens) to a small list of clusters; a message joins the most
+// similar cluster above the threshold, turning the tokens that differ into
+// <*>, or starts a new one. One miner per service, bounded by maxClusters
+// with LRU eviction.
+
+const wildcard = "<*>"
+
+// masks replace variable parts before tokenizing, most specific first.
+var masks = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<UUID>"},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), "<IP>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<HEX>"},
+	{hexID, "<HEX>"},
+	{regexp.MustCompile(`-?\b\d+(?:\.\d+)?(?:ms|us|µs|ns|s|m|h|kb|mb|gb|b|%)?\b`), "<NUM>"},
+}
+
+// hexID matches long hex runs (trace/span/commit IDs); mask only replaces
+// those mixing digits and letters, leaving words and plain numbers alone.
+var hexID = regexp.MustCompile(`\b[0-9a-fA-F]{8,}\b`)
+
+type templateCfg struct {
+	enabled     bool
+	msgField    string
+	depth       int     // tree depth incl. the length level (Drain's depth - 1)
+	similarity  float64 // min share of identical tokens to join a cluster
+	maxChildren int     // per tree node; further tokens route to <*>
+	maxClusters int     // per service; least recently matched are evicted
+	top         int     // templates listed per window
+	rateChange  float64 // count/baseline ratio (either way) flagged as changed
+	minCount    float64 // ignore changes below this many lines per window
+	warmup      int     // windows a template must have been tracked before changes count
+}
+
+// parseTemplateCfg reads the `templates:` block:
+//
+//	templates:
+//	  enabled: true
+//	  message_field: "message"   # falls back to msg, body, log
+//	  depth: 4
+//	  similarity: 0.5
+//	  max_children: 100
+//	  max_clusters: 1000
+//	  top: 5
+//	  rate_change: 3.0
+//	  min_count: 5
+func parseTemplateCfg(cfg config.ProcessorCfg) templateCfg {
+	tc := templateCfg{
+		msgField:    "message",
+		depth:       4,
+		similarity:  0.5,
+		maxChildren: 100,
+		maxClusters: 1000,
+		top:         5,
+		rateChange:  3.0,
+		minCount:    5,
+		warmup:      3,
+	}
+	m, ok := cfg.Extra["templates"].(map[string]any)
+	if !ok {
+		return tc
+	}
+	tc.enabled = true
+	if v, ok := m["enabled"].(bool); ok {
+		tc.enabled = v
+	}
+	if v, ok := m["message_field"].(string); ok && v != "" {
+		tc.msgField = v
+	}
+	if v, ok := m["depth"].(int); ok && v >= 3 {
+		tc.depth = v
+	}
+	if v, ok := number(m["similarity"]); ok && v > 0 && v <= 1 {
+		tc.similarity = v
+	}
+	if v, ok := m["max_children"].(int); ok && v > 0 {
+		tc.maxChildren = v
+	}
+	if v, ok := m["max_clusters"].(int); ok && v > 0 {
+		tc.maxClusters = v
+	}
+	if v, ok := m["top"].(int); ok && v > 0 {
+		tc.top = v
+	}
+	if v, ok := number(m["rate_change"]); ok && v > 1 {
+		tc.rateChange = v
+	}
+	if v, ok := number(m["min_count"]); ok && v >= 0 {
+		tc.minCount = v
+	}
+	return tc
+}
+
+func number(v any) (float64, bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+// cluster is one template and its per-window statistics.
+type cluster struct {
+	id       int
+	tokens   []string
+	leaf     *node
+	elem     *list.Element // position in the miner's LRU
+	created  int64         // window the template first appeared in
+	win      uint64        // lines this window, all groups of the service
+	baseline float64       // EWMA of lines per window
+	windows  int           // windows tracked so far
+
+	// set at flush, before per-aggregate labels are built
+	changed bool
+	ratio   float64
+}
+
+func (c *cluster) template() string { return strings.Join(c.tokens, " ") }
+
+type node struct {
+	children map[string]*node
+	clusters []*cluster
+	parent   *node  // nil for the token-count level
+	key      string // key in parent.children, or the token count at the top
+}
+
+type miner struct {
+	cfg    *templateCfg
+	root   map[int]*node // by token count
+	lru    *list.List    // front = most recently matched
+	nextID int
+}
+
+func newMiner(cfg *templateCfg) *miner {
+	return &miner{cfg: cfg, root: map[int]*node{}, lru: list.New()}
+}
+
+// mask replaces variables (UUIDs, IPs, hex IDs, numbers) with typed
+// placeholders and splits the message into tokens.
+func mask(msg string) []string {
+	for _, m := range masks {
+		if m.re == hexID {
+			msg = m.re.ReplaceAllStringFunc(msg, func(s string) string {
+				if strings.ContainsAny(s, "0123456789") && strings.ContainsAny(s, "abcdefABCDEF") {
+					return m.repl
+				}
+				return s
+			})
+			continue
+		}
+		msg = m.re.ReplaceAllString(msg, m.repl)
+	}
+	return strings.Fields(msg)
+}
+
+// add routes one message to its cluster, creating it if needed.
+func (m *miner) add(msg string, winStart int64) *cluster {
+	toks := mask(msg)
+	if len(toks) == 0 {
+		return nil
+	}
+	leaf := m.leaf(toks)
+	var best *cluster
+	bestSim, bestParams := -1.0, -1
+	for _, c := range leaf.clusters {
+		sim, params := similarity(c.tokens, toks)
+		if sim > bestSim || sim == bestSim && params > bestParams {
+			best, bestSim, bestParams = c, sim, params
+		}
+	}
+	if best != nil && bestSim >= m.cfg.similarity {
+		for i, t := range toks {
+			if best.tokens[i] != t {
+				best.tokens[i] = wildcard
+			}
+		}
+		m.lru.MoveToFront(best.elem)
+		return best
+	}
+
+	m.nextID++
+	c := &cluster{id: m.nextID, tokens: toks, leaf: leaf, created: winStart}
+	leaf.clusters = append(leaf.clusters, c)
+	c.elem = m.lru.PushFront(c)
+	for m.lru.Len() > m.cfg.maxClusters {
+		m.evict(m.lru.Back().Value.(*cluster))
+	}
+	return c
+}
+
+// leaf walks (and grows) the tree: token count, then up to depth-1 leading
+// tokens. Tokens with digits, and tokens past a full node, take the <*> branch.
+func (m *miner) leaf(toks []string) *node {
+	n, ok := m.root[len(toks)]
+	if !ok {
+		n = &node{children: map[string]*node{}, key: strconv.Itoa(len(toks))}
+		m.root[len(toks)] = n
+	}
+	for i := 0; i < m.cfg.depth-1 && i < len(toks); i++ {
+		key := toks[i]
+		if strings.ContainsAny(key, "0123456789") {
+			key = wildcard
+		}
+		child, ok := n.children[key]
+		if !ok {
+			if len(n.children) >= m.cfg.maxChildren {
+				key = wildcard
+				child = n.children[key]
+			}
+			if child == nil {
+				child = &node{children: map[string]*node{}, parent: n, key: key}
+				n.children[key] = child
+			}
+		}
+		n = child
+	}
+	return n
+}
+
+func (m *miner) evict(c *cluster) {
+	m.lru.Remove(c.elem)
+	cs := c.leaf.clusters
+	for i := range cs {
+		if cs[i] == c {
+			c.leaf.clusters = append(cs[:i], cs[i+1:]...)
+			break
+		}
+	}
+	m.prune(c.leaf)
+}
+
+// prune removes n and its ancestors once they hold neither clusters nor
+// children, so evicted templates do not leave their tree paths behind.
+func (m *miner) prune(n *node) {
+	for n != nil && len(n.clusters) == 0 && len(n.children) == 0 {
+		if n.parent == nil {
+			size, _ := strconv.Atoi(n.key)
+			delete(m.root, size)
+			return
+		}
+		delete(n.parent.children, n.key)
+		n = n.parent
+	}
+}
+
+// similarity is Drain's seqDist: the share of positions with identical
+// tokens (wildcards do not count), plus the wildcard count as tie-breaker.
+func similarity(tmpl, toks []string) (float64, int) {
+	same, params := 0, 0
+	for i, t := range tmpl {
+		switch {
+		case t == wildcard:
+			params++
+		case t == toks[i]:
+			same++
+		}
+	}
+	return float64(same) / float64(len(tmpl)), params
+}
+
+// baselineAlpha weighs the newest window in a template's rate baseline.
+const baselineAlpha = 0.3
+
+// markChanges flags templates whose count this window departs from their
+// baseline by rate_change either way, including templates that stopped
+// appearing altogether. Call before building labels.
+func (m *miner) markChanges() {
+	for e := m.lru.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*cluster)
+		c.changed, c.ratio = false, 0
+		if c.windows < m.cfg.warmup {
+			continue
+		}
+		w := float64(c.win)
+		c.ratio = (w + 1) / (c.baseline + 1)
+		if (c.ratio >= m.cfg.rateChange || c.ratio <= 1/m.cfg.rateChange) && (w >= m.cfg.minCount || c.baseline >= m.cfg.minCount) {
+			c.changed = true
+		}
+	}
+}
+
+// endWindow folds the window's counts into the baselines and resets them.
+func (m *miner) endWindow() {
+	for e := m.lru.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*cluster)
+		if c.windows == 0 {
+			c.baseline = float64(c.win)
+		} else {
+			c.baseline = baselineAlpha*float64(c.win) + (1-baselineAlpha)*c.baseline
+		}
+		c.windows++
+		c.win = 0
+	}
+}
+
+// templateEntry is one template as written into Labels.
+type templateEntry struct {
+	ID       int     `json:"id"`
+	Template string  `json:"template"`
+	Count    uint64  `json:"count"`
+	Baseline float64 `json:"baseline,omitempty"`
+	Ratio    float64 `json:"ratio,omitempty"`
+}
+
+// templateLabels writes the window's template summary for one aggregate:
+// templates_top / templates_new / templates_changed (JSON lists) and their
+// sizes plus templates_distinct (numbers, usable as iforest features). It
+// returns the lists, cut to top, and the number of new templates for the
+// summary text.
+//
+// counts holds the aggregate's lines per template. Changes are judged on the
+// whole service, so templates_changed lists the changed templates seen in
+// this aggregate plus those the service stopped logging (count 0), which no
+// aggregate saw.
+func templateLabels(m *miner, counts map[*cluster]uint64, winStart int64, top int, labels map[string]string) (tops, news, changed []templateEntry, newCount int) {
+	all := make([]templateEntry, 0, len(counts))
+	for c, n := range counts {
+		e := templateEntry{ID: c.id, Template: c.template(), Count: n}
+		all = append(all, e)
+		if c.created == winStart {
+			news = append(news, e)
+		}
+	}
+	for e := m.lru.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*cluster)
+		if !c.changed {
+			continue
+		}
+		if n, ok := counts[c]; ok || c.win == 0 {
+			changed = append(changed, templateEntry{
+				ID: c.id, Template: c.template(), Count: n, Baseline: c.baseline, Ratio: c.ratio,
+			})
+		}
+	}
+	byCount := func(xs []templateEntry) {
+		sort.Slice(xs, func(i, j int) bool {
+			if xs[i].Count != xs[j].Count {
+				return xs[i].Count > xs[j].Count
+			}
+			return xs[i].ID < xs[j].ID
+		})
+	}
+	byCount(all)
+	byCount(news)
+	sort.Slice(changed, func(i, j int) bool {
+		return changeOf(changed[i].Ratio) > changeOf(changed[j].Ratio)
+	})
+	labels["templates_distinct"] = strconv.Itoa(len(all))
+	newCount = len(news)
+	labels["templates_new_count"] = strconv.Itoa(newCount)
+	labels["templates_changed_count"] = strconv.Itoa(len(changed))
+
+	tops = all[:min(top, len(all))]
+	news = news[:min(top, len(news))]
+	changed = changed[:min(top, len(changed))]
+	for k, xs := range map[string][]templateEntry{"templates_top": tops, "templates_new": news, "templates_changed": changed} {
+		if len(xs) == 0 {
+			continue
+		}
+		// no HTML escaping: templates are full of <*> and <NUM>
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(xs); err == nil {
+			labels[k] = strings.TrimSuffix(buf.String(), "\n")
+		}
+	}
+	return tops, news, changed, newCount
+}
+
+// changeOf orders spikes and drops by magnitude.
+func changeOf(ratio float64) float64 {
+	if ratio > 0 && ratio < 1 {
+		return 1 / ratio
+	}
+	return ratio
+}
+
+// templateSummary is the short text form appended to SummaryText: the most
+// frequent template, the number of new ones and the busiest of them, and the
+// largest rate change.
+func templateSummary(tops, news, changed []templateEntry, newCount int) string {
+	var sb strings.Builder
+	if len(tops) > 0 {
+		sb.WriteString(" top_template=")
+		sb.WriteString(strconv.Quote(tops[0].Template))
+		sb.WriteString(" x")
+		sb.WriteString(strconv.FormatUint(tops[0].Count, 10))
+	}
+	if len(news) > 0 {
+		sb.WriteString(" new_templates=")
+		sb.WriteString(strconv.Itoa(newCount))
+		sb.WriteString(" new_template=")
+		sb.WriteString(strconv.Quote(news[0].Template))
+	}
+	if len(changed) > 0 {
+		sb.WriteString(" changed_template=")
+		sb.WriteString(strconv.Quote(changed[0].Template))
+		sb.WriteString(" x")
+		sb.WriteString(strconv.FormatUint(changed[0].Count, 10))
+		sb.WriteString(" ratio=")
+		sb.WriteString(strconv.FormatFloat(changed[0].Ratio, 'f', 2, 64))
+	}
+	return sb.String()
+}
@@ -0,0 +1,215 @@
+package logsum
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testTemplateCfg() *templateCfg {
+	return &templateCfg{
+		enabled: true, depth: 4, similarity: 0.5, maxChildren: 100, maxClusters: 1000,
+		top: 5, rateChange: 3, minCount: 5, warmup: 3,
+	}
+}
+
+func TestMinerTemplates(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs []string
+		want []string // templates in order of creation
+	}{
+		{
+			name: "variables are masked",
+			msgs: []string{
+				"connected to 10.0.0.1:5432 in 12ms",
+				"connected to 10.0.0.2:5432 in 7ms",
+			},
+			want: []string{"connected to <IP> in <NUM>"},
+		},
+		{
+			name: "differing tokens become wildcards",
+			msgs: []string{
+				"login failed for alice",
+				"login failed for bob",
+			},
+			want: []string{"login failed for <*>"},
+		},
+		{
+			name: "dissimilar messages start new clusters",
+			msgs: []string{
+				"cache miss for key session",
+				"request failed with timeout",
+			},
+			want: []string{"cache miss for key session", "request failed with timeout"},
+		},
+		{
+			name: "token count splits clusters",
+			msgs: []string{"job done", "job done twice"},
+			want: []string{"job done", "job done twice"},
+		},
+		{
+			name: "hex ids are masked, words kept",
+			msgs: []string{"trace 4bf92f3577b34da6a3ce929d0e0e4736 deadbeef"},
+			want: []string{"trace <HEX> deadbeef"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMiner(testTemplateCfg())
+			var order []*cluster
+			seen := map[*cluster]bool{}
+			for _, msg := range tt.msgs {
+				if c := m.add(msg, 0); c != nil && !seen[c] {
+					seen[c] = true
+					order = append(order, c)
+				}
+			}
+			got := make([]string, len(order))
+			for i, c := range order {
+				got[i] = c.template()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinerEviction(t *testing.T) {
+	cfg := testTemplateCfg()
+	cfg.maxClusters = 2
+	m := newMiner(cfg)
+	a := m.add("alpha one", 0)
+	m.add("beta two three", 0)
+	m.add("alpha one", 0) // a is now the most recently matched
+	m.add("gamma four five six", 0)
+	if m.lru.Len() != 2 {
+		t.Fatalf("clusters = %d, want 2", m.lru.Len())
+	}
+	if c := m.add("alpha one", 0); c != a {
+		t.Fatal("most recently matched cluster was evicted")
+	}
+}
+
+func TestMinerEvictionPrunesTree(t *testing.T) {
+	cfg := testTemplateCfg()
+	cfg.maxClusters = 1
+	m := newMiner(cfg)
+	for i := 0; i < 50; i++ {
+		m.add(fmt.Sprintf("job%c started on worker", 'a'+i%26)+strings.Repeat(" x", i%5), 0)
+	}
+	nodes := 0
+	var walk func(n *node)
+	walk = func(n *node) {
+		nodes++
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	for _, n := range m.root {
+		walk(n)
+	}
+	// One cluster left: one path of depth nodes (token count + depth-1 tokens).
+	if len(m.root) != 1 || nodes != cfg.depth {
+		t.Fatalf("tree has %d roots and %d nodes after eviction, want 1 and %d", len(m.root), nodes, cfg.depth)
+	}
+}
+
+func TestTemplateSummaryNewCount(t *testing.T) {
+	cfg := testTemplateCfg()
+	cfg.top = 1
+	m := newMiner(cfg)
+	counts := map[*cluster]uint64{}
+	for _, msg := range []string{"alpha one", "beta two three", "gamma four five six"} {
+		counts[m.add(msg, 7)]++
+	}
+	labels := map[string]string{}
+	text := templateSummary(templateLabels(m, counts, 7, cfg.top, labels))
+	if labels["templates_new_count"] != "3" || !strings.Contains(text, "new_templates=3 ") {
+		t.Fatalf("templates_new_count = %s, summary = %q; want 3 in both", labels["templates_new_count"], text)
+	}
+}
+
+// TestTemplateChanges runs a service through warmup windows and then checks
+// the templates_changed label of one aggregate in the last window.
+func TestTemplateChanges(t *testing.T) {
+	const steady = "GET /health answered"
+	const other = "cache warmed"
+	tests := []struct {
+		name      string
+		history   map[string]int // lines per window during warmup
+		last      map[string]int // lines in the checked window
+		want      []string       // templates_changed, most changed first
+		wantCount []uint64
+	}{
+		{
+			name:    "steady rate is not a change",
+			history: map[string]int{steady: 10, other: 10},
+			last:    map[string]int{steady: 11, other: 10},
+		},
+		{
+			name:      "spike",
+			history:   map[string]int{steady: 10, other: 10},
+			last:      map[string]int{steady: 60, other: 10},
+			want:      []string{steady},
+			wantCount: []uint64{60},
+		},
+		{
+			name:      "template that stopped appearing",
+			history:   map[string]int{steady: 10, other: 10},
+			last:      map[string]int{other: 10},
+			want:      []string{steady},
+			wantCount: []uint64{0},
+		},
+		{
+			name:    "stopped template below min_count",
+			history: map[string]int{steady: 2, other: 10},
+			last:    map[string]int{other: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testTemplateCfg()
+			m := newMiner(cfg)
+			window := func(lines map[string]int) map[*cluster]uint64 {
+				counts := map[*cluster]uint64{}
+				for msg, n := range lines {
+					for i := 0; i < n; i++ {
+						c := m.add(msg, 0)
+						c.win++
+						counts[c]++
+					}
+				}
+				return counts
+			}
+			for w := 0; w < cfg.warmup; w++ {
+				window(tt.history)
+				m.markChanges()
+				m.endWindow()
+			}
+			counts := window(tt.last)
+			m.markChanges()
+
+			labels := map[string]string{}
+			_, _, changed, _ := templateLabels(m, counts, 1, cfg.top, labels)
+			var got []string
+			var gotCount []uint64
+			for _, e := range changed {
+				got = append(got, e.Template)
+				gotCount = append(gotCount, e.Count)
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(gotCount, tt.wantCount) {
+				t.Fatalf("changed = %q %v, want %q %v", got, gotCount, tt.want, tt.wantCount)
+			}
+			if n := labels["templates_changed_count"]; n != strconv.Itoa(len(tt.want)) {
+				t.Fatalf("templates_changed_count = %s, want %d", n, len(tt.want))
+			}
+			if len(tt.want) > 0 && !strings.Contains(labels["templates_changed"], tt.want[0]) {
+				t.Fatalf("templates_changed = %s", labels["templates_changed"])
+			}
+		})
+	}
+}
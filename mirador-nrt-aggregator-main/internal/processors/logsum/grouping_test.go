@@ -0,0 +1,51 @@
+package logsum
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+func TestGroupBy(t *testing.T) {
+	p := New(config.ProcessorCfg{Extra: map[string]any{
+		"group_by":               []any{"route", "status"},
+		"max_groups_per_service": 2,
+	}})
+	lines := []string{
+		`{"service":"api","route":"/a"}`,
+		`{"service":"api","route":"/a"}`,
+		`{"service":"api","route":"/b","status":404}`,
+		`{"service":"api","route":"/c"}`, // past the cap
+		`{"service":"api","route":"/d","status":500}`,
+		`{"service":"api","message":"no group keys"}`,
+		`{"service":"web","route":"/c"}`, // caps are per service
+	}
+	for _, l := range lines {
+		if err := p.consume([]byte(l), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[string]uint64{}
+	for k, st := range p.state {
+		got[k] = st.total
+	}
+	want := map[string]uint64{
+		"api|route=/a":                         2,
+		"api|route=/b,status=404":              1,
+		"api|route=__other__":                  1,
+		"api|route=__other__,status=__other__": 1,
+		"api":                                  1,
+		"web|route=/c":                         1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		keys := make([]string, 0, len(got))
+		for k := range got {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		t.Fatalf("states = %v (%v), want %v", got, keys, want)
+	}
+}
@@ -3,13 +3,36 @@ package logsum
 import (
 	"context"
 	"encoding/json"
+	"math"
+	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/caio/go-tdigest/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/locator"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/sketch"
+)
+
+// overflowValue collects top-K values past topk_max_values.
+const overflowValue = "__other__"
+
+var (
+	stateBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mirador_logsum_state_bytes",
+		Help: "Approximate window state per service at the last flush (sketches, reservoir, top-K, templates).",
+	}, []string{"service"})
+	topkOverflow = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mirador_logsum_topk_overflow_total",
+		Help: "Top-K field values folded into __other__ by topk_max_values.",
+	})
 )
 
 // processor aggregates JSON logs into per-service, fixed-size windows.
@@ -22,31 +45,64 @@ type processor struct {
 
 	// percentile field (optional) to compute latency-like quantiles
 	quantField string
-	// If quantField is numeric (int/float), we can optionally compute simple quantiles using a compact reservoir.
-	// For simplicity and low overhead here, we provide a tiny fixed-cap reservoir per service.
+	// quantiles come from a t-digest per window, or with quantile_sketch:
+	// reservoir from a uniform sample of reservoirCap values (Algorithm R)
+	useReservoir bool
 	reservoirCap int
+	rng          *rand.Rand // seeded (reservoir_seed) so sampling is reproducible
+	// extra quantiles of quantField for Aggregate.Quantiles (config `quantiles:`)
+	quantiles []float64
+	// attach mergeable state (Aggregate.Sketch) for rollups
+	emitSketch bool
 
 	// top-k categorical keys to summarize
-	topKeys  []string
-	topLimit int
+	topKeys      []string
+	topLimit     int
+	topMaxValues int // distinct values tracked per field per window; more count as __other__
+
+	// fields counted with HyperLogLog as "distinct_<field>" (users: "unique_users")
+	distinctKeys []string
+
+	// optional split of each service into per-operation windows (e.g. route)
+	groupBy   []string
+	maxGroups int            // distinct groups per service per window; 0 = unlimited
+	groups    map[string]int // groups seen per service in the current window
+
+	// trace exemplars and links in Aggregate.Locator (see internal/locator)
+	loc locator.Config
 
-	// state: per service window
+	// log template mining (see drain.go); miners persist across windows
+	tmplCfg templateCfg
+	miners  map[string]*miner
+
+	// state: per service (or service+group) window
 	state map[string]*wState
+	acks  ack.Batch // acks of envelopes folded into the current window
 }
 
 type wState struct {
+	svc   string
+	group map[string]string
+
 	start int64
 	end   int64
 
 	total uint64
 	errs  uint64
 
-	seenUsers map[string]struct{}
+	// distinct counts by label name ("unique_users", "distinct_<field>")
+	distinct map[string]*sketch.HLL
 
 	top map[string]map[string]uint64 // key -> value -> count
 
-	// compact reservoir for quantiles (optional)
-	res []float64
+	// quantField values: digest, or reservoir sample (quantile_sketch: reservoir)
+	td      *tdigest.TDigest
+	res     []float64
+	resSeen uint64 // values offered to the reservoir
+
+	tmpl map[*cluster]uint64 // lines per log template this window
+
+	exemplars []locator.Exemplar // lines with trace context, most useful kept
 }
 
 func New(cfg config.ProcessorCfg) *processor {
@@ -104,10 +160,39 @@ func New(cfg config.ProcessorCfg) *processor {
 		}
 	}
 
+	var groupBy []string
+	if arr, ok := cfg.Extra["group_by"].([]any); ok {
+		for _, it := range arr {
+			if s, ok := it.(string); ok && s != "" {
+				groupBy = append(groupBy, s)
+			}
+		}
+	}
+	maxGroups := 100
+	if v, ok := cfg.Extra["max_groups_per_service"].(int); ok && v >= 0 {
+		maxGroups = v
+	}
+
 	reservoirCap := 256
 	if v, ok := cfg.Extra["reservoir_cap"].(int); ok && v > 0 {
 		reservoirCap = v
 	}
+	seed := int64(1)
+	if v, ok := cfg.Extra["reservoir_seed"].(int); ok {
+		seed = int64(v)
+	}
+	var distinctKeys []string
+	if arr, ok := cfg.Extra["distinct_fields"].([]any); ok {
+		for _, it := range arr {
+			if s, ok := it.(string); ok && s != "" {
+				distinctKeys = append(distinctKeys, s)
+			}
+		}
+	}
+	topMaxValues := 1000
+	if v, ok := cfg.Extra["topk_max_values"].(int); ok && v > 0 {
+		topMaxValues = v
+	}
 
 	return &processor{
 		winSec:       w,
@@ -116,9 +201,21 @@ func New(cfg config.ProcessorCfg) *processor {
 		errLevels:    errLevels,
 		userKeys:     userKeys,
 		quantField:   quantField,
+		useReservoir: cfg.ExtraString("quantile_sketch", "tdigest") == "reservoir",
 		reservoirCap: reservoirCap,
+		rng:          rand.New(rand.NewSource(seed)),
+		distinctKeys: distinctKeys,
+		topMaxValues: topMaxValues,
+		quantiles:    cfg.Quantiles,
+		emitSketch:   cfg.ExtraBool("emit_sketch", false),
 		topKeys:      topKeys,
 		topLimit:     topLimit,
+		groupBy:      groupBy,
+		maxGroups:    maxGroups,
+		groups:       map[string]int{},
+		tmplCfg:      parseTemplateCfg(cfg),
+		loc:          locator.NewConfig(cfg),
+		miners:       map[string]*miner{},
 		state:        map[string]*wState{},
 	}
 }
@@ -145,7 +242,11 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 				out <- v
 				continue
 			}
-			p.consume(env.Bytes, winStart)
+			if err := p.consume(env.Bytes, winStart); err != nil {
+				ack.Fail(env.Ack, ack.Malformed(err))
+				continue
+			}
+			p.acks.Add(env.Ack)
 
 		case now := <-ticker.C:
 			if now.Unix() >= winStart+int64(p.winSec) {
@@ -156,10 +257,10 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 	}
 }
 
-func (p *processor) consume(raw []byte, winStart int64) {
+func (p *processor) consume(raw []byte, winStart int64) error {
 	var obj map[string]any
 	if err := json.Unmarshal(raw, &obj); err != nil {
-		return
+		return err
 	}
 
 	// service identity
@@ -167,21 +268,24 @@ func (p *processor) consume(raw []byte, winStart int64) {
 	if svc == "" {
 		svc = "unknown"
 	}
-	st := p.ensure(svc, winStart)
+	st := p.ensure(svc, p.groupOf(obj, svc), winStart)
 
 	// level -> errors
 	lvl := strings.ToLower(getStr(obj, p.lvlKey))
-	if _, isErr := p.errLevels[lvl]; isErr {
+	_, isErr := p.errLevels[lvl]
+	if isErr {
 		st.errs++
 	}
 	st.total++
 
-	// unique users
+	// unique users and other distinct counts
 	if uid := firstNonEmpty(obj, p.userKeys...); uid != "" {
-		if st.seenUsers == nil {
-			st.seenUsers = map[string]struct{}{}
+		st.addDistinct("unique_users", uid)
+	}
+	for _, k := range p.distinctKeys {
+		if v := getStr(obj, k); v != "" {
+			st.addDistinct("distinct_"+k, v)
 		}
-		st.seenUsers[uid] = struct{}{}
 	}
 
 	// top-k categorical fields
@@ -198,29 +302,70 @@ func (p *processor) consume(raw []byte, winStart int64) {
 			m = map[string]uint64{}
 			st.top[k] = m
 		}
+		if _, ok := m[val]; !ok && len(m) >= p.topMaxValues {
+			topkOverflow.Inc()
+			val = overflowValue
+		}
 		m[val]++
 	}
 
+	// log templates
+	if p.tmplCfg.enabled {
+		if msg := getStr(obj, p.tmplCfg.msgField, "message", "msg", "body", "log"); msg != "" {
+			if c := p.minerFor(svc).add(msg, winStart); c != nil {
+				c.win++
+				if st.tmpl == nil {
+					st.tmpl = map[*cluster]uint64{}
+				}
+				st.tmpl[c]++
+			}
+		}
+	}
+
 	// optional numeric quantile field (e.g. latency)
+	var val float64
 	if p.quantField != "" {
 		if f, ok := getFloat(obj, p.quantField); ok {
-			// simple reservoir sampling with cap; for NRT this is usually enough
-			if len(st.res) < p.reservoirCap {
-				st.res = append(st.res, f)
+			val = f
+			if p.useReservoir {
+				p.sample(st, f)
 			} else {
-				// replace a random element — cheap LCG using timestamp for determinism-free sampling
-				// (not cryptographically strong; fine for sketching)
-				i := int(time.Now().UnixNano() % int64(p.reservoirCap))
-				st.res[i] = f
+				if st.td == nil {
+					st.td = sketch.NewDigest()
+				}
+				_ = st.td.Add(f)
 			}
 		}
 	}
+
+	// trace context → exemplars
+	if tid := getStr(obj, "trace_id"); tid != "" {
+		ex := locator.Exemplar{TraceID: tid, SpanID: getStr(obj, "span_id"), Value: val}
+		if isErr {
+			ex.Reason = "error"
+		}
+		st.exemplars = p.loc.Add(st.exemplars, ex)
+	}
+	return nil
 }
 
 func (p *processor) flush(out chan<- any, winStart int64) {
 	winEnd := winStart + int64(p.winSec)
+	acks := p.acks.Release(len(p.state))
+	i := 0
+	for _, m := range p.miners {
+		m.markChanges()
+	}
+	sizes := map[string]int{}
+	for _, st := range p.state {
+		sizes[st.svc] += st.sizeBytes()
+	}
+	stateBytes.Reset()
+	for svc, n := range sizes {
+		stateBytes.WithLabelValues(svc).Set(float64(n))
+	}
 
-	for svc, st := range p.state {
+	for _, st := range p.state {
 		labels := map[string]string{}
 
 		// top-k summaries
@@ -249,22 +394,44 @@ func (p *processor) flush(out chan<- any, winStart int64) {
 			}
 		}
 
-		// unique user count
-		if st.seenUsers != nil {
-			labels["unique_users"] = strconv.Itoa(len(st.seenUsers))
-		} else {
-			labels["unique_users"] = "0"
+		// log templates
+		tmplText := ""
+		if m, ok := p.miners[st.svc]; ok {
+			tmplText = templateSummary(templateLabels(m, st.tmpl, winStart, p.tmplCfg.top, labels))
+		}
+
+		// unique users and distinct_<field> counts (HyperLogLog estimates)
+		labels["unique_users"] = "0"
+		for k, h := range st.distinct {
+			labels[k] = strconv.FormatUint(h.Estimate(), 10)
 		}
 
 		// quantiles (if quantField provided)
 		p50, p95, p99 := 0.0, 0.0, 0.0
-		if len(st.res) > 0 {
+		var qs map[string]float64
+		if st.td != nil && st.td.Count() > 0 {
+			p50 = st.td.Quantile(0.50)
+			p95 = st.td.Quantile(0.95)
+			p99 = st.td.Quantile(0.99)
+			if len(p.quantiles) > 0 {
+				qs = make(map[string]float64, len(p.quantiles))
+				for _, q := range p.quantiles {
+					qs[model.QuantileKey(q)] = st.td.Quantile(q)
+				}
+			}
+		} else if len(st.res) > 0 {
 			cp := make([]float64, len(st.res))
 			copy(cp, st.res)
 			sort.Float64s(cp)
 			p50 = percentile(cp, 0.50)
 			p95 = percentile(cp, 0.95)
 			p99 = percentile(cp, 0.99)
+			if len(p.quantiles) > 0 {
+				qs = make(map[string]float64, len(p.quantiles))
+				for _, q := range p.quantiles {
+					qs[model.QuantileKey(q)] = percentile(cp, q)
+				}
+			}
 		}
 
 		// event rate and error rate
@@ -275,46 +442,171 @@ func (p *processor) flush(out chan<- any, winStart int64) {
 		}
 
 		agg := model.Aggregate{
-			Service:     svc,
+			Service:     st.svc,
+			Group:       st.group,
 			WindowStart: winStart,
 			WindowEnd:   winEnd,
 			Labels:      labels,
-			Locator:     "{}", // free-form for deep-links later
+			Locator:     p.loc.Build(st.svc, winStart, winEnd, st.exemplars),
 			Count:       st.total,
 			RPS:         rps,
 			ErrorRate:   errRate,
 			P50:         p50,
 			P95:         p95,
 			P99:         p99,
-			SummaryText: buildSummaryText(svc, st.total, errRate, labels),
+			Quantiles:   qs,
+			SummaryText: buildSummaryText(st.svc, st.group, st.total, errRate, labels) + tmplText,
+			Ack:         acks[i],
+		}
+		if p.emitSketch {
+			agg.Sketch = p.sketchOf(st)
 		}
+		i++
 		out <- agg
 	}
 
 	// reset window state
 	p.state = map[string]*wState{}
+	p.groups = map[string]int{}
+	for _, m := range p.miners {
+		m.endWindow()
+	}
+}
+
+func (p *processor) minerFor(svc string) *miner {
+	m, ok := p.miners[svc]
+	if !ok {
+		m = newMiner(&p.tmplCfg)
+		p.miners[svc] = m
+	}
+	return m
+}
+
+// groupOf returns the group_by values present in the record, nil when none
+// are. Past max_groups_per_service new groups fold into "__other__".
+func (p *processor) groupOf(obj map[string]any, svc string) map[string]string {
+	var group map[string]string
+	for _, k := range p.groupBy {
+		if v := getStr(obj, k); v != "" {
+			if group == nil {
+				group = make(map[string]string, len(p.groupBy))
+			}
+			group[k] = v
+		}
+	}
+	if group == nil || p.maxGroups == 0 {
+		return group
+	}
+	key := model.Aggregate{Service: svc, Group: group}.SeriesKey()
+	if _, seen := p.state[key]; !seen && p.groups[svc] >= p.maxGroups {
+		for k := range group {
+			group[k] = "__other__"
+		}
+	}
+	return group
+}
+
+// sketchOf builds the mergeable state of a window. Reservoir samples enter
+// the digest weighted by how many values each one stands for, so windows of
+// different volume merge in proportion.
+func (p *processor) sketchOf(st *wState) *model.Sketch {
+	sk := &model.Sketch{
+		Requests: float64(st.total),
+		OK:       float64(st.total - st.errs),
+		Errors:   float64(st.errs),
+	}
+	if st.td != nil {
+		sk.Digest = sketch.EncodeDigest(st.td)
+	} else if len(st.res) > 0 {
+		td := sketch.NewDigest()
+		w := uint64(math.Round(float64(st.resSeen) / float64(len(st.res))))
+		if w == 0 {
+			w = 1
+		}
+		for _, v := range st.res {
+			_ = td.AddWeighted(v, w)
+		}
+		sk.Digest = sketch.EncodeDigest(td)
+	}
+	if len(st.distinct) > 0 {
+		sk.HLL = make(map[string][]byte, len(st.distinct))
+		for k, h := range st.distinct {
+			sk.HLL[k] = h.Bytes()
+		}
+	}
+	return sk
 }
 
-func (p *processor) ensure(svc string, winStart int64) *wState {
-	if st, ok := p.state[svc]; ok {
+// sample offers f to the window's reservoir (Algorithm R): the first
+// reservoirCap values fill it, then value n replaces a random slot with
+// probability cap/n, keeping a uniform sample of everything seen.
+func (p *processor) sample(st *wState, f float64) {
+	st.resSeen++
+	if len(st.res) < p.reservoirCap {
+		st.res = append(st.res, f)
+		return
+	}
+	if j := p.rng.Int63n(int64(st.resSeen)); j < int64(p.reservoirCap) {
+		st.res[j] = f
+	}
+}
+
+func (st *wState) addDistinct(label, v string) {
+	if st.distinct == nil {
+		st.distinct = map[string]*sketch.HLL{}
+	}
+	h, ok := st.distinct[label]
+	if !ok {
+		h = sketch.NewHLL()
+		st.distinct[label] = h
+	}
+	h.Add(v)
+}
+
+// sizeBytes approximates the window state's memory: sketches, reservoir,
+// top-K maps and template counts.
+func (st *wState) sizeBytes() int {
+	n := 8*cap(st.res) + sketch.DigestSizeBytes(st.td)
+	for _, h := range st.distinct {
+		n += h.SizeBytes()
+	}
+	for _, m := range st.top {
+		for v := range m {
+			n += len(v) + 16
+		}
+	}
+	return n + 16*len(st.tmpl)
+}
+
+func (p *processor) ensure(svc string, group map[string]string, winStart int64) *wState {
+	key := model.Aggregate{Service: svc, Group: group}.SeriesKey()
+	if st, ok := p.state[key]; ok {
 		return st
 	}
+	if group != nil {
+		p.groups[svc]++
+	}
 	st := &wState{
+		svc:   svc,
+		group: group,
 		start: winStart,
 		end:   winStart,
 		top:   map[string]map[string]uint64{},
-		res:   make([]float64, 0, p.reservoirCap),
 	}
-	p.state[svc] = st
+	p.state[key] = st
 	return st
 }
 
 // -------------------- helpers --------------------
 
-func buildSummaryText(svc string, total uint64, errRate float64, labels map[string]string) string {
+func buildSummaryText(svc string, group map[string]string, total uint64, errRate float64, labels map[string]string) string {
 	sb := strings.Builder{}
 	sb.WriteString("logs summary: service=")
 	sb.WriteString(svc)
+	if gk := (model.Aggregate{Group: group}).GroupKey(); gk != "" {
+		sb.WriteString(" group=")
+		sb.WriteString(gk)
+	}
 	sb.WriteString(" total=")
 	sb.WriteString(strconv.FormatUint(total, 10))
 	sb.WriteString(" error_rate=")
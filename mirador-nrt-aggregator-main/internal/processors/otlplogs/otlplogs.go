@@ -9,11 +9,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
 
 	colllog "go.opentelemetry.io/proto/otlp/collector/logs/v1"
 	com "go.opentelemetry.io/proto/otlp/common/v1"
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
 	res "go.opentelemetry.io/proto/otlp/resource/v1"
 	"google.golang.org/protobuf/proto"
 )
@@ -29,6 +31,16 @@ import (
 //	extra.scope_prefix:    "scope."     # prefix for scope fields (default: "scope.")
 //	extra.level_alias:     "level"      # add duplicate field (severityText) under this key (default: "level")
 //	extra.service_key:     "service.name" # which resource attr to copy as top-level service key (default "service.name")
+//	extra.normalize_level: true         # map SeverityNumber / vendor text (WARNING, E, fatal) to trace|debug|info|warn|error|fatal (default: true)
+//	extra.body_parsers:    [json, logfmt] # parse string bodies into fields, first match wins; "auto" = both (default: none)
+//	extra.body_prefix:     ""           # prefix for parsed body fields (default: "")
+//	extra.extract:                      # regex rules; named groups become fields
+//	  - field: body
+//	    regex: 'status=(?P<status>\d{3})'
+//
+// Parsed and extracted fields never overwrite fields of the record itself. A
+// trace/span ID found in them (traceId, trace.id, otelTraceID, ...) is kept
+// as trace_id/span_id so logsum windows can link to traces.
 type processor struct {
 	includeRes   bool
 	includeScope bool
@@ -37,6 +49,11 @@ type processor struct {
 	scopePrefix  string
 	levelAlias   string
 	serviceKey   string
+
+	normalize  bool
+	parsers    []string
+	bodyPrefix string
+	extract    []extractRule
 }
 
 func New(cfg config.ProcessorCfg) *processor {
@@ -68,6 +85,19 @@ func New(cfg config.ProcessorCfg) *processor {
 	if s, ok := cfg.Extra["service_key"].(string); ok && s != "" {
 		serviceKey = s
 	}
+	var parsers []string
+	if arr, ok := cfg.Extra["body_parsers"].([]any); ok {
+		for _, x := range arr {
+			switch s, _ := x.(string); s {
+			case "json", "logfmt":
+				parsers = append(parsers, s)
+			case "auto":
+				parsers = append(parsers, "json", "logfmt")
+			default:
+				log.Printf("[otlplogs] unknown body parser %q", s)
+			}
+		}
+	}
 
 	return &processor{
 		includeRes:   inRes,
@@ -77,6 +107,10 @@ func New(cfg config.ProcessorCfg) *processor {
 		scopePrefix:  scopePrefix,
 		levelAlias:   levelAlias,
 		serviceKey:   serviceKey,
+		normalize:    cfg.ExtraBool("normalize_level", true),
+		parsers:      parsers,
+		bodyPrefix:   cfg.ExtraString("body_prefix", ""),
+		extract:      parseExtractRules(cfg.Extra["extract"]),
 	}
 }
 
@@ -115,6 +149,16 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 func (p *processor) flattenAndEmit(ctx context.Context, req *colllog.ExportLogsServiceRequest, src model.Envelope, out chan<- any) {
 	now := time.Now().Unix()
 
+	// One source envelope becomes one envelope per record; split its Ack accordingly.
+	n := 0
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			n += len(sl.LogRecords)
+		}
+	}
+	acks := ack.Fanout(src.Ack, n)
+	i := 0
+
 	for _, rl := range req.ResourceLogs {
 		rattrs := attrsToMapRes(rl.GetResource())
 
@@ -126,6 +170,8 @@ func (p *processor) flattenAndEmit(ctx context.Context, req *colllog.ExportLogsS
 			}
 
 			for _, rec := range sl.LogRecords {
+				recAck := acks[i]
+				i++
 				obj := make(map[string]any, 16)
 
 				// Timestamps
@@ -137,13 +183,11 @@ func (p *processor) flattenAndEmit(ctx context.Context, req *colllog.ExportLogsS
 				}
 
 				// Severity
-				if txt := rec.GetSeverityText(); txt != "" {
+				txt, num := rec.GetSeverityText(), rec.GetSeverityNumber()
+				if txt != "" {
 					obj["severity_text"] = txt
-					if p.levelAlias != "" {
-						obj[p.levelAlias] = strings.ToLower(txt)
-					}
 				}
-				if num := rec.GetSeverityNumber(); num != 0 {
+				if num != 0 {
 					obj["severity_number"] = num
 				}
 
@@ -190,11 +234,21 @@ func (p *processor) flattenAndEmit(ctx context.Context, req *colllog.ExportLogsS
 					}
 				}
 
+				p.enrich(obj, rec.GetBody())
+
+				// Level: SeverityNumber, then SeverityText, then a level parsed from the body
+				if p.levelAlias != "" {
+					if lvl := p.level(obj, txt, num); lvl != "" {
+						obj[p.levelAlias] = lvl
+					}
+				}
+
 				// Marshal one JSON object per record and emit
 				b, err := json.Marshal(obj)
 				if err != nil {
 					// Fail-open: skip bad record
 					log.Printf("[otlplogs] json marshal error: %v", err)
+					ack.Done(recAck)
 					continue
 				}
 				select {
@@ -203,6 +257,7 @@ func (p *processor) flattenAndEmit(ctx context.Context, req *colllog.ExportLogsS
 					Bytes:  b,
 					Attrs:  src.Attrs, // preserve transport attrs if any
 					TSUnix: now,
+					Ack:    recAck,
 				}:
 				case <-ctx.Done():
 					return
@@ -212,6 +267,47 @@ func (p *processor) flattenAndEmit(ctx context.Context, req *colllog.ExportLogsS
 	}
 }
 
+// enrich adds fields parsed from the body and extracted by regex rules,
+// then promotes any trace context found in them.
+func (p *processor) enrich(obj map[string]any, body *com.AnyValue) {
+	var parsed map[string]any
+	if len(p.parsers) > 0 {
+		switch b := anyVal(body).(type) {
+		case string:
+			parsed = parseBody(b, p.parsers)
+		case map[string]any: // structured (kvlist) body
+			parsed = flatten(b)
+		}
+	}
+	for k, v := range parsed {
+		if _, exists := obj[p.bodyPrefix+k]; !exists {
+			obj[p.bodyPrefix+k] = v
+		}
+	}
+	for _, r := range p.extract {
+		r.apply(obj)
+	}
+	if len(parsed) > 0 || len(p.extract) > 0 {
+		promoteTraceContext(obj, p.bodyPrefix)
+	}
+}
+
+func (p *processor) level(obj map[string]any, txt string, num logs.SeverityNumber) string {
+	if !p.normalize {
+		return strings.ToLower(txt)
+	}
+	if lvl := levelOfNumber(num); lvl != "" {
+		return lvl
+	}
+	if txt != "" {
+		return normalizeLevel(txt)
+	}
+	if s := levelFromFields(obj, p.bodyPrefix); s != "" {
+		return normalizeLevel(s)
+	}
+	return ""
+}
+
 // ---------- helpers ----------
 
 func attrsToMapRes(r *res.Resource) map[string]string {
@@ -0,0 +1,217 @@
+package otlplogs
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+)
+
+// ---------- severity ----------
+
+// levelOfNumber maps an OTel SeverityNumber to its short name: 1-4 trace,
+// 5-8 debug, 9-12 info, 13-16 warn, 17-20 error, 21-24 fatal. Numbers outside
+// 1-24 are unknown ("").
+func levelOfNumber(n logs.SeverityNumber) string {
+	switch {
+	case n > 24:
+		return ""
+	case n >= 21:
+		return "fatal"
+	case n >= 17:
+		return "error"
+	case n >= 13:
+		return "warn"
+	case n >= 9:
+		return "info"
+	case n >= 5:
+		return "debug"
+	case n >= 1:
+		return "trace"
+	}
+	return ""
+}
+
+// levelAliases covers the text levels of common loggers (syslog, log4j/JUL,
+// glog/klog single letters, Python, Go).
+var levelAliases = map[string]string{
+	"trace": "trace", "trc": "trace", "t": "trace", "finest": "trace", "finer": "trace", "verbose": "trace", "v": "trace",
+	"debug": "debug", "dbg": "debug", "d": "debug", "fine": "debug", "config": "debug",
+	"info": "info", "inf": "info", "i": "info", "information": "info", "informational": "info", "notice": "info", "n": "info",
+	"warn": "warn", "warning": "warn", "wrn": "warn", "w": "warn",
+	"error": "error", "err": "error", "e": "error", "severe": "error",
+	"fatal": "fatal", "ftl": "fatal", "f": "fatal", "critical": "fatal", "crit": "fatal", "c": "fatal",
+	"alert": "fatal", "emerg": "fatal", "emergency": "fatal", "panic": "fatal", "dpanic": "fatal",
+}
+
+// normalizeLevel maps a vendor level ("WARNING", "E", "Error2") to trace,
+// debug, info, warn, error or fatal; unknown text is returned lowercased.
+func normalizeLevel(txt string) string {
+	s := strings.ToLower(strings.TrimSpace(txt))
+	if lvl, ok := levelAliases[s]; ok {
+		return lvl
+	}
+	// OTel short names carry a digit suffix (INFO2, ERROR3)
+	if base := strings.TrimRightFunc(s, unicode.IsDigit); base != s {
+		if lvl, ok := levelAliases[base]; ok {
+			return lvl
+		}
+	}
+	return s
+}
+
+// ---------- body parsing ----------
+
+// parseBody tries the configured parsers in order on a string body and
+// returns the fields of the first that matches.
+func parseBody(body string, parsers []string) map[string]any {
+	s := strings.TrimSpace(body)
+	for _, name := range parsers {
+		switch name {
+		case "json":
+			if !strings.HasPrefix(s, "{") {
+				continue
+			}
+			var m map[string]any
+			if err := json.Unmarshal([]byte(s), &m); err == nil {
+				return flatten(m)
+			}
+		case "logfmt":
+			if m := common.ParseLogfmtStrict(s); m != nil {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// flatten turns nested objects into dotted keys ({"http":{"status":500}} →
+// "http.status"), matching how attributes are named.
+func flatten(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	var walk func(prefix string, m map[string]any)
+	walk = func(prefix string, m map[string]any) {
+		for k, v := range m {
+			if sub, ok := v.(map[string]any); ok {
+				walk(prefix+k+".", sub)
+				continue
+			}
+			out[prefix+k] = v
+		}
+	}
+	walk("", m)
+	return out
+}
+
+// ---------- regex extraction ----------
+
+// extractRule copies the named groups of re, matched against a string
+// field, into the record.
+type extractRule struct {
+	field string
+	re    *regexp.Regexp
+}
+
+// parseExtractRules reads:
+//
+//	extract:
+//	  - field: body                     # default body
+//	    regex: 'status=(?P<http_status_code>\d{3}) took (?P<latency_ms>\d+)ms'
+func parseExtractRules(v any) []extractRule {
+	xs, _ := v.([]any)
+	var out []extractRule
+	for _, it := range xs {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		pat, _ := m["regex"].(string)
+		re, err := regexp.Compile(pat)
+		if pat == "" || err != nil {
+			log.Printf("[otlplogs] extract: bad regex %q: %v", pat, err)
+			continue
+		}
+		if len(re.SubexpNames()) < 2 {
+			log.Printf("[otlplogs] extract: regex %q has no named groups", pat)
+			continue
+		}
+		field, _ := m["field"].(string)
+		if field == "" {
+			field = "body"
+		}
+		out = append(out, extractRule{field: field, re: re})
+	}
+	return out
+}
+
+// apply adds the named groups of the first match; existing fields win.
+func (r extractRule) apply(obj map[string]any) {
+	s, ok := obj[r.field].(string)
+	if !ok {
+		return
+	}
+	m := r.re.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	for i, name := range r.re.SubexpNames() {
+		if name == "" || m[i] == "" {
+			continue
+		}
+		if _, exists := obj[name]; !exists {
+			obj[name] = m[i]
+		}
+	}
+}
+
+// ---------- trace context ----------
+
+// Datadog's dd.trace_id/dd.span_id are left out: they are decimal and carry
+// only the low 64 bits of the trace ID, so they would not match a W3C ID.
+var (
+	traceIDKeys = []string{"trace_id", "traceId", "traceID", "trace.id", "otelTraceID", "logging.googleapis.com/trace"}
+	spanIDKeys  = []string{"span_id", "spanId", "spanID", "span.id", "otelSpanID", "logging.googleapis.com/spanId"}
+)
+
+// promoteTraceContext copies a trace/span ID found in parsed or extracted
+// fields (unprefixed, or under the body prefix) to trace_id/span_id, unless
+// the record already carries them.
+func promoteTraceContext(obj map[string]any, prefix string) {
+	promote := func(dst string, keys []string) {
+		if s, ok := obj[dst].(string); ok && s != "" {
+			return
+		}
+		for _, k := range keys {
+			s, ok := obj[k].(string)
+			if !ok || s == "" {
+				s, _ = obj[prefix+k].(string)
+			}
+			if s != "" {
+				// GCP: projects/<p>/traces/<id>
+				if i := strings.LastIndexByte(s, '/'); i >= 0 {
+					s = s[i+1:]
+				}
+				obj[dst] = strings.ToLower(s)
+				return
+			}
+		}
+	}
+	promote("trace_id", traceIDKeys)
+	promote("span_id", spanIDKeys)
+}
+
+// levelFromFields finds a level in parsed body fields when the record has
+// no severity of its own.
+func levelFromFields(obj map[string]any, prefix string) string {
+	for _, k := range []string{"level", "severity", "lvl", "loglevel", "log.level", "levelname"} {
+		if s, ok := obj[prefix+k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,187 @@
+package otlplogs
+
+import (
+	"reflect"
+	"testing"
+
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+func TestLevelOfNumber(t *testing.T) {
+	tests := []struct {
+		n    logs.SeverityNumber
+		want string
+	}{
+		{0, ""},
+		{1, "trace"},
+		{4, "trace"},
+		{5, "debug"},
+		{9, "info"},
+		{12, "info"},
+		{13, "warn"},
+		{17, "error"},
+		{20, "error"},
+		{21, "fatal"},
+		{24, "fatal"},
+		{25, ""},
+		{100, ""},
+		{-1, ""},
+	}
+	for _, tt := range tests {
+		if got := levelOfNumber(tt.n); got != tt.want {
+			t.Errorf("levelOfNumber(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeLevel(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"WARNING", "warn"},
+		{" Error ", "error"},
+		{"E", "error"},
+		{"ERROR2", "error"},
+		{"INFO4", "info"},
+		{"severe", "error"},
+		{"crit", "fatal"},
+		{"dpanic", "fatal"},
+		{"notice", "info"},
+		{"Custom", "custom"},
+		{"level5", "level5"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeLevel(tt.in); got != tt.want {
+			t.Errorf("normalizeLevel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]any
+		want map[string]any
+	}{
+		{"flat", map[string]any{"a": 1.0, "b": "x"}, map[string]any{"a": 1.0, "b": "x"}},
+		{
+			"nested objects become dotted keys",
+			map[string]any{"http": map[string]any{"status": 500.0, "req": map[string]any{"method": "GET"}}},
+			map[string]any{"http.status": 500.0, "http.req.method": "GET"},
+		},
+		{"arrays are kept", map[string]any{"tags": []any{"a", "b"}}, map[string]any{"tags": []any{"a", "b"}}},
+		{"empty object disappears", map[string]any{"a": map[string]any{}}, map[string]any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := flatten(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractRuleApply(t *testing.T) {
+	rules := parseExtractRules([]any{
+		map[string]any{"regex": `status=(?P<http_status_code>\d{3}) took (?P<latency_ms>\d+)ms`},
+		map[string]any{"field": "path", "regex": `^/api/(?P<api_version>v\d+)/(?P<unmatched>x)?`},
+		map[string]any{"regex": `(`},         // bad regex: skipped
+		map[string]any{"regex": `no groups`}, // no named groups: skipped
+	})
+	if len(rules) != 2 {
+		t.Fatalf("rules = %d, want 2", len(rules))
+	}
+	tests := []struct {
+		name string
+		in   map[string]any
+		want map[string]any
+	}{
+		{
+			name: "named groups of the body",
+			in:   map[string]any{"body": "GET / status=503 took 12ms"},
+			want: map[string]any{"body": "GET / status=503 took 12ms", "http_status_code": "503", "latency_ms": "12"},
+		},
+		{
+			name: "existing fields win",
+			in:   map[string]any{"body": "status=503 took 12ms", "http_status_code": 200},
+			want: map[string]any{"body": "status=503 took 12ms", "http_status_code": 200, "latency_ms": "12"},
+		},
+		{
+			name: "other field, empty groups skipped",
+			in:   map[string]any{"path": "/api/v2/users"},
+			want: map[string]any{"path": "/api/v2/users", "api_version": "v2"},
+		},
+		{
+			name: "no match",
+			in:   map[string]any{"body": "hello"},
+			want: map[string]any{"body": "hello"},
+		},
+		{
+			name: "non-string field",
+			in:   map[string]any{"body": 42},
+			want: map[string]any{"body": 42},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range rules {
+				r.apply(tt.in)
+			}
+			if !reflect.DeepEqual(tt.in, tt.want) {
+				t.Fatalf("got %v, want %v", tt.in, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromoteTraceContext(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]any
+		want map[string]any // trace_id and span_id after promotion
+	}{
+		{
+			name: "camel case keys",
+			in:   map[string]any{"traceId": "4BF92F3577B34DA6A3CE929D0E0E4736", "spanId": "00F067AA0BA902B7"},
+			want: map[string]any{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "span_id": "00f067aa0ba902b7"},
+		},
+		{
+			name: "under the body prefix",
+			in:   map[string]any{"body.trace.id": "abc", "body.span.id": "def"},
+			want: map[string]any{"trace_id": "abc", "span_id": "def"},
+		},
+		{
+			name: "GCP trace path",
+			in:   map[string]any{"logging.googleapis.com/trace": "projects/p/traces/ABC123"},
+			want: map[string]any{"trace_id": "abc123"},
+		},
+		{
+			name: "record context wins",
+			in:   map[string]any{"trace_id": "rec", "traceId": "body"},
+			want: map[string]any{"trace_id": "rec"},
+		},
+		{
+			name: "decimal Datadog IDs are not promoted",
+			in:   map[string]any{"dd.trace_id": "1234567890", "dd.span_id": "987"},
+			want: map[string]any{},
+		},
+		{
+			name: "non-string values are ignored",
+			in:   map[string]any{"traceId": 12.0},
+			want: map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promoteTraceContext(tt.in, "body.")
+			got := map[string]any{}
+			for _, k := range []string{"trace_id", "span_id"} {
+				if v, ok := tt.in[k]; ok {
+					got[k] = v
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
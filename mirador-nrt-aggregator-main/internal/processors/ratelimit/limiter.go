@@ -0,0 +1,492 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"gopkg.in/yaml.v3"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+var (
+	allowedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_ratelimit_allowed_total",
+		Help: "Envelopes admitted within their key's rate.",
+	}, []string{"limiter"})
+
+	throttledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_ratelimit_throttled_total",
+		Help: "Envelopes over their key's rate, by key and action taken (drop, sample_drop, sample_keep, tag).",
+	}, []string{"limiter", "key", "action"})
+)
+
+// Actions applied to envelopes over the limit.
+const (
+	ActionDrop   = "drop"   // ack and discard
+	ActionSample = "sample" // keep 1 in round(1/sample_rate), drop the rest
+	ActionTag    = "tag"    // pass through with Attrs["ratelimit.throttled"]="true"
+)
+
+// OtherKey collects keys beyond max_keys.
+const OtherKey = "__other__"
+
+// Limit is the per-key budget.
+type Limit struct {
+	Rate       float64 `yaml:"rate" json:"rate"`   // units per second; <= 0 means unlimited
+	Burst      float64 `yaml:"burst" json:"burst"` // bucket size (default max(rate, 1))
+	Action     string  `yaml:"action" json:"action"`
+	SampleRate float64 `yaml:"sample_rate" json:"sample_rate"`
+}
+
+// Limiter applies token buckets keyed by an envelope attribute. It backs both
+// the ratelimit processor and receiver-level `rate_limit:` blocks.
+//
+// Config (processor extras, or the receiver's rate_limit map):
+//
+//	key_by: [attr:tenant, service]   # sources tried in order (default [service]):
+//	                                 #   service     json_logs "service" field, OTLP resource service.name,
+//	                                 #               PromRW service/job label
+//	                                 #   attr:<k>    envelope attribute (e.g. attr:kafka.key, attr:loki.tenant)
+//	                                 #   field:<k>   top-level JSON field of json_logs
+//	                                 #   kind        envelope kind
+//	default_key: unknown             # when no source yields a value
+//	unit: envelopes                  # envelopes | bytes (an envelope larger than burst costs burst)
+//	rate: 1000                       # default per-key rate (units/s)
+//	burst: 2000
+//	action: drop                     # drop | sample | tag
+//	sample_rate: 0.1                 # action=sample: fraction of over-limit envelopes kept
+//	overrides:                       # per-key limits (fields default to the values above)
+//	  checkout: {rate: 5000, burst: 10000}
+//	  noisy-batch: {rate: 50, action: sample}
+//	overrides_file: /etc/mirador/ratelimits.yaml   # same shape as overrides; reloaded on change
+//	overrides_reload_ms: 10000
+//	max_keys: 10000                  # tracked buckets; further keys share __other__
+//	service_field: service           # json_logs field used by the service source
+type Limiter struct {
+	name         string
+	keyBy        []string
+	defaultKey   string
+	bytes        bool
+	def          Limit
+	serviceField string
+	maxKeys      int
+
+	overridesFile string
+	reload        time.Duration
+
+	mu        sync.Mutex
+	inline    map[string]Limit
+	overrides map[string]Limit
+	fileMod   time.Time
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+	over   uint64 // over-limit count, drives deterministic sampling
+}
+
+// NewLimiter builds a limiter named name (used as the metrics label) from extras.
+func NewLimiter(name string, extra map[string]any) *Limiter {
+	keyBy := []string{"service"}
+	if arr, ok := extra["key_by"].([]any); ok && len(arr) > 0 {
+		keyBy = keyBy[:0]
+		for _, it := range arr {
+			if s, ok := it.(string); ok && s != "" {
+				keyBy = append(keyBy, s)
+			}
+		}
+	} else if s, ok := extra["key_by"].(string); ok && s != "" {
+		keyBy = []string{s}
+	}
+	defKey := "unknown"
+	if s, ok := extra["default_key"].(string); ok && s != "" {
+		defKey = s
+	}
+	unit, _ := extra["unit"].(string)
+	svcField := "service"
+	if s, ok := extra["service_field"].(string); ok && s != "" {
+		svcField = s
+	}
+	maxKeys := 10000
+	if v, ok := extra["max_keys"].(int); ok && v > 0 {
+		maxKeys = v
+	}
+	reload := 10 * time.Second
+	if v, ok := extra["overrides_reload_ms"].(int); ok && v > 0 {
+		reload = time.Duration(v) * time.Millisecond
+	}
+
+	def := Limit{Action: ActionDrop, SampleRate: 0.1}
+	def = mergeLimit(def, limitFrom(extra))
+	inline := map[string]Limit{}
+	if m, ok := extra["overrides"].(map[string]any); ok {
+		for k, v := range m {
+			if lm, ok := v.(map[string]any); ok {
+				inline[k] = limitFrom(lm)
+			}
+		}
+	}
+	overridesFile, _ := extra["overrides_file"].(string)
+
+	l := &Limiter{
+		name:          name,
+		keyBy:         keyBy,
+		defaultKey:    defKey,
+		bytes:         strings.EqualFold(unit, "bytes"),
+		def:           def,
+		serviceField:  svcField,
+		maxKeys:       maxKeys,
+		overridesFile: overridesFile,
+		reload:        reload,
+		inline:        inline,
+		overrides:     inline,
+		buckets:       map[string]*bucket{},
+	}
+	l.loadOverrides()
+	return l
+}
+
+// Run reloads overrides_file until done is closed; a no-op without a file.
+func (l *Limiter) Run(done <-chan struct{}) {
+	if l.overridesFile == "" {
+		return
+	}
+	t := time.NewTicker(l.reload)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			l.loadOverrides()
+		}
+	}
+}
+
+// Admit decides what happens to env. It returns the (possibly tagged)
+// envelope and whether to forward it; dropped envelopes must be acked by the
+// caller.
+func (l *Limiter) Admit(env model.Envelope) (model.Envelope, bool) {
+	key := l.keyOf(env)
+	cost := 1.0
+	if l.bytes {
+		cost = float64(len(env.Bytes))
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	b, key := l.bucketFor(key, now)
+	// A payload larger than the bucket could never be admitted; it takes a
+	// full bucket instead.
+	ok := b.take(math.Min(cost, b.limit.Burst), now)
+	var action string
+	keep := true
+	tag := false
+	if !ok {
+		b.over++
+		action = b.limit.Action
+		tag = action == ActionTag
+		switch action {
+		case ActionTag:
+		case ActionSample:
+			every := uint64(1)
+			if b.limit.SampleRate > 0 && b.limit.SampleRate < 1 {
+				every = uint64(math.Round(1 / b.limit.SampleRate))
+			} else if b.limit.SampleRate <= 0 {
+				every = 0
+			}
+			if every == 0 || b.over%every != 1%every {
+				keep = false
+				action = "sample_drop"
+			} else {
+				action = "sample_keep"
+			}
+		default:
+			keep = false
+			action = ActionDrop
+		}
+	}
+	l.mu.Unlock()
+
+	if ok {
+		allowedTotal.WithLabelValues(l.name).Inc()
+		return env, true
+	}
+	throttledTotal.WithLabelValues(l.name, key, action).Inc()
+	if keep && tag {
+		attrs := make(map[string]string, len(env.Attrs)+2)
+		for k, v := range env.Attrs {
+			attrs[k] = v
+		}
+		attrs["ratelimit.throttled"] = "true"
+		attrs["ratelimit.key"] = key
+		env.Attrs = attrs
+	}
+	return env, keep
+}
+
+// bucketFor returns (creating if needed) key's bucket and the key it is
+// tracked under: OtherKey once max_keys is reached. Called with l.mu held.
+func (l *Limiter) bucketFor(key string, now time.Time) (*bucket, string) {
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if ok {
+		return b, key
+	}
+	if len(l.buckets) >= l.maxKeys {
+		if _, listed := l.overrides[key]; !listed {
+			key = OtherKey
+			if b, ok := l.buckets[key]; ok {
+				return b, key
+			}
+		}
+	}
+	lim := l.limitFor(key)
+	b = &bucket{limit: lim, tokens: lim.Burst, last: now}
+	l.buckets[key] = b
+	return b, key
+}
+
+// sweep forgets buckets that have refilled completely (idle keys).
+func (l *Limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.limit.Rate <= 0 || b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= b.limit.Burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (l *Limiter) limitFor(key string) Limit {
+	if o, ok := l.overrides[key]; ok {
+		return mergeLimit(l.def, o)
+	}
+	return l.def
+}
+
+func (b *bucket) take(cost float64, now time.Time) bool {
+	if b.limit.Rate <= 0 {
+		return true
+	}
+	b.tokens = math.Min(b.limit.Burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return true
+	}
+	return false
+}
+
+// ---------------- overrides ----------------
+
+func (l *Limiter) loadOverrides() {
+	if l.overridesFile == "" {
+		return
+	}
+	fi, err := os.Stat(l.overridesFile)
+	if err != nil {
+		log.Printf("[ratelimit:%s] overrides_file: %v", l.name, err)
+		return
+	}
+	l.mu.Lock()
+	unchanged := fi.ModTime().Equal(l.fileMod)
+	l.mu.Unlock()
+	if unchanged {
+		return
+	}
+	b, err := os.ReadFile(l.overridesFile)
+	if err != nil {
+		log.Printf("[ratelimit:%s] overrides_file: %v", l.name, err)
+		return
+	}
+	var raw map[string]Limit
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		log.Printf("[ratelimit:%s] overrides_file %s: %v (keeping previous overrides)", l.name, l.overridesFile, err)
+		return
+	}
+
+	merged := make(map[string]Limit, len(l.inline)+len(raw))
+	for k, v := range l.inline {
+		merged[k] = v
+	}
+	for k, v := range raw {
+		merged[k] = v // file wins over inline
+	}
+	l.mu.Lock()
+	l.overrides = merged
+	l.fileMod = fi.ModTime()
+	// Re-apply limits to live buckets so changes take effect immediately.
+	for k, b := range l.buckets {
+		b.limit = l.limitFor(k)
+		b.tokens = math.Min(b.tokens, b.limit.Burst)
+	}
+	l.mu.Unlock()
+	log.Printf("[ratelimit:%s] loaded %d overrides from %s", l.name, len(raw), l.overridesFile)
+}
+
+func limitFrom(m map[string]any) Limit {
+	var lim Limit
+	lim.Rate = number(m["rate"])
+	lim.Burst = number(m["burst"])
+	lim.SampleRate = number(m["sample_rate"])
+	if s, ok := m["action"].(string); ok {
+		lim.Action = strings.ToLower(strings.TrimSpace(s))
+	}
+	return lim
+}
+
+// mergeLimit fills o's unset fields from base.
+func mergeLimit(base, o Limit) Limit {
+	out := base
+	if o.Rate != 0 {
+		out.Rate = o.Rate
+		if o.Burst == 0 {
+			out.Burst = 0 // recomputed from the new rate below
+		}
+	}
+	if o.Burst != 0 {
+		out.Burst = o.Burst
+	}
+	if o.Action != "" {
+		out.Action = o.Action
+	}
+	if o.SampleRate != 0 {
+		out.SampleRate = o.SampleRate
+	}
+	if out.Burst <= 0 {
+		out.Burst = math.Max(out.Rate, 1)
+	}
+	return out
+}
+
+func number(v any) float64 {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case float64:
+		return t
+	}
+	return 0
+}
+
+// ---------------- key extraction ----------------
+
+func (l *Limiter) keyOf(env model.Envelope) string {
+	var logObj map[string]any
+	for _, src := range l.keyBy {
+		var v string
+		switch {
+		case src == "kind":
+			v = env.Kind
+		case strings.HasPrefix(src, "attr:"):
+			v = env.Attrs[src[len("attr:"):]]
+		case strings.HasPrefix(src, "field:"), src == "service":
+			field := l.serviceField
+			if src != "service" {
+				field = src[len("field:"):]
+			}
+			if env.Kind == model.KindJSONLogs {
+				if logObj == nil {
+					_ = json.Unmarshal(env.Bytes, &logObj)
+				}
+				if s, ok := logObj[field].(string); ok {
+					v = s
+				}
+			} else if src == "service" {
+				v = payloadService(env)
+			}
+		}
+		if v != "" {
+			return v
+		}
+	}
+	return l.defaultKey
+}
+
+// payloadService scans the first resource's service.name in an OTLP request
+// (or the service/job label of the first PromRW series) without a full decode.
+func payloadService(env model.Envelope) string {
+	switch env.Kind {
+	case model.KindMetrics, model.KindTraces:
+		// Export*ServiceRequest.resource_* (1) → Resource (1) → attributes (1)
+		rs := firstBytes(env.Bytes, 1)
+		res := firstBytes(rs, 1)
+		var svc string
+		eachBytes(res, 1, func(kv []byte) bool {
+			if string(firstBytes(kv, 1)) == "service.name" {
+				svc = string(firstBytes(firstBytes(kv, 2), 1))
+				return false
+			}
+			return true
+		})
+		return svc
+	case model.KindPromRW:
+		// WriteRequest.timeseries (1) → labels (1) {name 1, value 2}
+		var svc, job string
+		eachBytes(firstBytes(env.Bytes, 1), 1, func(lb []byte) bool {
+			switch string(firstBytes(lb, 1)) {
+			case "service":
+				svc = string(firstBytes(lb, 2))
+			case "job":
+				job = string(firstBytes(lb, 2))
+			}
+			return svc == ""
+		})
+		if svc != "" {
+			return svc
+		}
+		return job
+	}
+	return ""
+}
+
+func firstBytes(b []byte, field protowire.Number) []byte {
+	var out []byte
+	eachBytes(b, field, func(v []byte) bool { out = v; return false })
+	return out
+}
+
+// eachBytes calls fn for every length-delimited occurrence of field until fn
+// returns false; malformed input simply ends the scan.
+func eachBytes(b []byte, field protowire.Number, fn func([]byte) bool) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		if typ == protowire.BytesType && num == field {
+			v, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return
+			}
+			b = b[m:]
+			if !fn(v) {
+				return
+			}
+			continue
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return
+		}
+		b = b[m:]
+	}
+}
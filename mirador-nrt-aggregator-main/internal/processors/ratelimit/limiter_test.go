@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+func tenantEnv(tenant string, size int) model.Envelope {
+	return model.Envelope{
+		Kind:  model.KindJSONLogs,
+		Bytes: []byte(strings.Repeat("x", size)),
+		Attrs: map[string]string{"tenant": tenant},
+	}
+}
+
+// With a near-zero rate the bucket never refills during the test, so only
+// the burst is admitted and everything after it is over the limit.
+func TestLimiterActions(t *testing.T) {
+	tests := []struct {
+		name       string
+		extra      map[string]any
+		sends      int
+		wantKept   int
+		wantTagged int
+	}{
+		{"drop", map[string]any{"action": "drop"}, 21, 1, 0},
+		{"sample keeps 1 in 10", map[string]any{"action": "sample", "sample_rate": 0.1}, 21, 3, 0},
+		{"sample 1 in 2", map[string]any{"action": "sample", "sample_rate": 0.5}, 11, 6, 0},
+		{"sample_rate 0 keeps none", map[string]any{"action": "sample", "sample_rate": -1}, 11, 1, 0},
+		{"tag keeps all", map[string]any{"action": "tag"}, 5, 5, 4},
+		{"burst", map[string]any{"action": "drop", "burst": 3}, 5, 3, 0},
+		{"unlimited", map[string]any{"rate": 0}, 5, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extra := map[string]any{"key_by": []any{"attr:tenant"}, "rate": 0.0001, "burst": 1}
+			for k, v := range tt.extra {
+				extra[k] = v
+			}
+			l := NewLimiter(tt.name, extra)
+			kept, tagged := 0, 0
+			for i := 0; i < tt.sends; i++ {
+				env, keep := l.Admit(tenantEnv("a", 10))
+				if keep {
+					kept++
+				}
+				if env.Attrs["ratelimit.throttled"] == "true" {
+					tagged++
+				}
+			}
+			if kept != tt.wantKept || tagged != tt.wantTagged {
+				t.Fatalf("kept=%d tagged=%d, want kept=%d tagged=%d", kept, tagged, tt.wantKept, tt.wantTagged)
+			}
+		})
+	}
+}
+
+func TestLimiterBytes(t *testing.T) {
+	l := NewLimiter("bytes", map[string]any{"key_by": []any{"attr:tenant"}, "unit": "bytes", "rate": 0.0001, "burst": 100})
+	tests := []struct {
+		name string
+		size int
+		want bool
+	}{
+		{"larger than burst takes the full bucket", 1000, true},
+		{"bucket is empty", 1, false},
+	}
+	for _, tt := range tests {
+		if _, keep := l.Admit(tenantEnv("a", tt.size)); keep != tt.want {
+			t.Fatalf("%s: keep = %v, want %v", tt.name, keep, tt.want)
+		}
+	}
+}
+
+func TestLimiterOtherKey(t *testing.T) {
+	l := NewLimiter("keys", map[string]any{
+		"key_by": []any{"attr:tenant"}, "rate": 0.0001, "burst": 1, "action": "tag", "max_keys": 1,
+		"overrides": map[string]any{"vip": map[string]any{"rate": 0.0001}},
+	})
+	tests := []struct {
+		tenant  string
+		wantKey string // ratelimit.key of the second, throttled envelope
+	}{
+		{"a", "a"},
+		{"b", OtherKey},
+		{"c", OtherKey},
+		{"vip", "vip"},
+	}
+	for _, tt := range tests {
+		l.Admit(tenantEnv(tt.tenant, 1))
+		env, _ := l.Admit(tenantEnv(tt.tenant, 1))
+		if got := env.Attrs["ratelimit.key"]; got != tt.wantKey {
+			t.Fatalf("%s: ratelimit.key = %q, want %q", tt.tenant, got, tt.wantKey)
+		}
+	}
+}
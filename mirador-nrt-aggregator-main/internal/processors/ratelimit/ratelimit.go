@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+type processor struct {
+	lim *Limiter
+}
+
+// New builds the ratelimit processor. Envelopes are limited per key (see
+// Limiter for the config); aggregates and other values pass through.
+//
+// Example config snippet:
+// processors:
+//
+//	ratelimit/logs:
+//	  key_by: [attr:loki.tenant, service]
+//	  rate: 2000
+//	  burst: 4000
+//	  action: sample
+//	  sample_rate: 0.05
+//	  overrides_file: /etc/mirador/ratelimits.yaml
+func New(pc config.ProcessorCfg) *processor {
+	name := pc.Name
+	if name == "" {
+		name = "ratelimit"
+	}
+	return &processor{lim: NewLimiter(name, pc.Extra)}
+}
+
+func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) error {
+	defer close(out)
+	go p.lim.Run(ctx.Done())
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			env, isEnv := v.(model.Envelope)
+			if !isEnv {
+				out <- v
+				continue
+			}
+			env, keep := p.lim.Admit(env)
+			if !keep {
+				ack.Done(env.Ack) // throttled on purpose → nothing left to deliver
+				continue
+			}
+			out <- env
+		}
+	}
+}
+
+// Receiver is the subset of pipeline.Receiver wrapped by WrapReceiver.
+type Receiver interface {
+	Start(ctx context.Context, out chan<- model.Envelope) error
+}
+
+type limitedReceiver struct {
+	inner Receiver
+	lim   *Limiter
+}
+
+// WrapReceiver limits what r emits before it reaches any pipeline, using the
+// receiver's `rate_limit:` block (same keys as the processor). Limiting at the
+// receiver applies once for every subscribing pipeline and before fan-out
+// copies.
+func WrapReceiver(name string, r Receiver, cfg map[string]any) Receiver {
+	return &limitedReceiver{inner: r, lim: NewLimiter(name, cfg)}
+}
+
+func (w *limitedReceiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	go w.lim.Run(ctx.Done())
+	mid := make(chan model.Envelope, 64)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case env := <-mid:
+				env, keep := w.lim.Admit(env)
+				if !keep {
+					ack.Done(env.Ack)
+					continue
+				}
+				select {
+				case out <- env:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return w.inner.Start(ctx, mid)
+}
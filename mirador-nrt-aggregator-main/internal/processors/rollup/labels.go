@@ -0,0 +1,170 @@
+package rollup
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Per-window labels of the summarizer and logsum describe a single input
+// window, so copying them from the newest input would misreport the rollup.
+// Counts are summed, ratios recomputed, top-k and template lists merged, and
+// labels that cannot be derived from the inputs are dropped. Everything else
+// (source, signal, gauge features, ...) is taken from the newest input.
+var (
+	summedLabels = map[string]bool{
+		"scrapes_up":          true,
+		"scrapes_down":        true,
+		"templates_new_count": true, // a template is new in one window only
+	}
+	mergedTemplateLists = map[string]bool{
+		"templates_top": true,
+		"templates_new": true,
+	}
+	droppedLabels = map[string]bool{
+		"scrape_failure_ratio":    true, // recomputed from the summed scrapes
+		"templates_distinct":      true, // distinct over the union is unknown
+		"templates_changed":       true, // relative to per-window baselines
+		"templates_changed_count": true,
+	}
+)
+
+// labelAcc merges the per-window labels of a window's inputs.
+type labelAcc struct {
+	sums  map[string]float64
+	tops  map[string]map[string]uint64 // top_<field>: value → count
+	tmpls map[string]map[int]*tmplEntry
+	limit map[string]int // longest input list per top_/templates_ label
+}
+
+// tmplEntry mirrors logsum's templates_* JSON entries.
+type tmplEntry struct {
+	ID       int    `json:"id"`
+	Template string `json:"template"`
+	Count    uint64 `json:"count"`
+}
+
+func newLabelAcc() *labelAcc {
+	return &labelAcc{
+		sums:  map[string]float64{},
+		tops:  map[string]map[string]uint64{},
+		tmpls: map[string]map[int]*tmplEntry{},
+		limit: map[string]int{},
+	}
+}
+
+func (acc *labelAcc) add(labels map[string]string) {
+	for k, v := range labels {
+		switch {
+		case summedLabels[k]:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				acc.sums[k] += f
+			}
+		case strings.HasPrefix(k, "top_"):
+			m := acc.tops[k]
+			if m == nil {
+				m = map[string]uint64{}
+				acc.tops[k] = m
+			}
+			n := 0
+			for _, part := range strings.Split(v, ",") {
+				i := strings.LastIndexByte(part, ':')
+				if i < 0 {
+					continue
+				}
+				c, err := strconv.ParseUint(part[i+1:], 10, 64)
+				if err != nil {
+					continue
+				}
+				m[part[:i]] += c
+				n++
+			}
+			acc.limit[k] = max(acc.limit[k], n)
+		case mergedTemplateLists[k]:
+			var xs []tmplEntry
+			if err := json.Unmarshal([]byte(v), &xs); err != nil {
+				continue
+			}
+			m := acc.tmpls[k]
+			if m == nil {
+				m = map[int]*tmplEntry{}
+				acc.tmpls[k] = m
+			}
+			for _, x := range xs {
+				if cur, ok := m[x.ID]; ok {
+					cur.Count += x.Count
+					cur.Template = x.Template // templates generalize over time; keep the latest
+				} else {
+					e := x
+					m[x.ID] = &e
+				}
+			}
+			acc.limit[k] = max(acc.limit[k], len(xs))
+		}
+	}
+}
+
+// apply replaces the per-window labels in labels with the merged ones.
+func (acc *labelAcc) apply(labels map[string]string) {
+	for k := range labels {
+		if droppedLabels[k] || summedLabels[k] || mergedTemplateLists[k] || strings.HasPrefix(k, "top_") {
+			delete(labels, k)
+		}
+	}
+	for k, v := range acc.sums {
+		labels[k] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if up, down := acc.sums["scrapes_up"], acc.sums["scrapes_down"]; up+down > 0 {
+		labels["scrape_failure_ratio"] = ftoa(down / (up + down))
+	}
+
+	for k, m := range acc.tops {
+		type kv struct {
+			val string
+			n   uint64
+		}
+		buf := make([]kv, 0, len(m))
+		for v, n := range m {
+			buf = append(buf, kv{v, n})
+		}
+		sort.Slice(buf, func(i, j int) bool {
+			if buf[i].n != buf[j].n {
+				return buf[i].n > buf[j].n
+			}
+			return buf[i].val < buf[j].val
+		})
+		parts := make([]string, 0, acc.limit[k])
+		for _, e := range buf[:min(acc.limit[k], len(buf))] {
+			parts = append(parts, e.val+":"+strconv.FormatUint(e.n, 10))
+		}
+		if len(parts) > 0 {
+			labels[k] = strings.Join(parts, ",")
+		}
+	}
+
+	for k, m := range acc.tmpls {
+		xs := make([]*tmplEntry, 0, len(m))
+		for _, e := range m {
+			xs = append(xs, e)
+		}
+		sort.Slice(xs, func(i, j int) bool {
+			if xs[i].Count != xs[j].Count {
+				return xs[i].Count > xs[j].Count
+			}
+			return xs[i].ID < xs[j].ID
+		})
+		xs = xs[:min(acc.limit[k], len(xs))]
+		if len(xs) == 0 {
+			continue
+		}
+		// no HTML escaping: templates are full of <*> and <NUM>
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(xs); err == nil {
+			labels[k] = strings.TrimSuffix(buf.String(), "\n")
+		}
+	}
+}
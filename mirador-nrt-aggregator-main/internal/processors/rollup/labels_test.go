@@ -0,0 +1,71 @@
+package rollup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelAcc(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []map[string]string // oldest first; the last one is the newest input
+		want   map[string]string
+	}{
+		{
+			name: "scrape counts are summed and the ratio recomputed",
+			inputs: []map[string]string{
+				{"source": "metrics", "scrapes_up": "3.000000", "scrapes_down": "1.000000", "scrape_failure_ratio": "0.250000"},
+				{"source": "metrics", "scrapes_up": "4.000000", "scrapes_down": "0.000000", "scrape_failure_ratio": "0.000000"},
+			},
+			want: map[string]string{"source": "metrics", "scrapes_up": "7", "scrapes_down": "1", "scrape_failure_ratio": "0.125000"},
+		},
+		{
+			name: "top-k lists are merged",
+			inputs: []map[string]string{
+				{"top_route": "/a:5,/b:3"},
+				{"top_route": "/b:4,/c:1"},
+			},
+			want: map[string]string{"top_route": "/b:7,/a:5"},
+		},
+		{
+			name: "template lists are merged, per-window template labels dropped",
+			inputs: []map[string]string{
+				{
+					"templates_top":           `[{"id":1,"template":"GET <*>","count":5},{"id":2,"template":"timeout <*>","count":2}]`,
+					"templates_new":           `[{"id":2,"template":"timeout <*>","count":2}]`,
+					"templates_new_count":     "1",
+					"templates_distinct":      "2",
+					"templates_changed":       `[{"id":1,"template":"GET <*>","count":5,"baseline":1,"ratio":5}]`,
+					"templates_changed_count": "1",
+				},
+				{
+					"templates_top":       `[{"id":2,"template":"timeout <*>","count":9},{"id":3,"template":"retry <*>","count":1}]`,
+					"templates_new":       `[{"id":3,"template":"retry <*>","count":1}]`,
+					"templates_new_count": "1",
+					"templates_distinct":  "2",
+				},
+			},
+			want: map[string]string{
+				"templates_top":       `[{"id":2,"template":"timeout <*>","count":11},{"id":1,"template":"GET <*>","count":5}]`,
+				"templates_new":       `[{"id":2,"template":"timeout <*>","count":2}]`,
+				"templates_new_count": "2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := newLabelAcc()
+			for _, in := range tt.inputs {
+				acc.add(in)
+			}
+			labels := map[string]string{}
+			for k, v := range tt.inputs[len(tt.inputs)-1] {
+				labels[k] = v
+			}
+			acc.apply(labels)
+			if !reflect.DeepEqual(labels, tt.want) {
+				t.Fatalf("got %v, want %v", labels, tt.want)
+			}
+		})
+	}
+}
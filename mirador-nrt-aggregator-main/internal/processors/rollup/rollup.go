@@ -0,0 +1,343 @@
+package rollup
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caio/go-tdigest/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/sketch"
+)
+
+var (
+	mergedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mirador_rollup_merged_total",
+		Help: "Aggregates merged into a rollup window.",
+	})
+	unmergeableTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mirador_rollup_unmergeable_total",
+		Help: "Aggregates passed through unmerged because they carry no (valid) sketch.",
+	})
+	lateTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mirador_rollup_late_total",
+		Help: "Aggregates that arrived after their rollup window was emitted.",
+	})
+)
+
+// processor merges aggregates carrying a Sketch (see model.Sketch) into
+// coarser windows per series (service + group, kept apart by source/signal
+// labels) and emits one merged aggregate per series and window. Quantiles
+// come from the merged t-digest, rates from the merged totals, distinct
+// counts from merged HLLs, so a 1h rollup of twelve 5m windows reports what a
+// single 1h window would have. The merged output carries its own Sketch, so
+// rollups chain (1m → 5m → 1h).
+type processor struct {
+	winSec     int
+	inputWin   int              // only merge aggregates of this length; 0 = any shorter window
+	delay      time.Duration    // wait past the window end for late inputs
+	now        func() time.Time // flush clock; replaced in tests
+	keepInputs bool             // forward inputs unchanged as well
+	quantiles  []float64
+
+	windows map[string]*window
+	emitted int64 // end of the newest emitted window; inputs ending before are late
+}
+
+type window struct {
+	service string
+	group   map[string]string
+	start   int64
+
+	td     *tdigest.TDigest
+	req    float64
+	ok     float64
+	errs   float64
+	count  uint64
+	hll    map[string]*sketch.HLL
+	qkeys  map[string]struct{} // quantile names seen on inputs, recomputed on output
+	labels map[string]string   // labels of the newest input
+	merged *labelAcc           // per-window labels of every input (see labels.go)
+	loc    string              // locator of the newest input
+	newest int64               // WindowStart of the newest input
+	n      int
+	acks   ack.Batch
+}
+
+func New(cfg config.ProcessorCfg) *processor {
+	w := cfg.WindowSeconds
+	if w <= 0 {
+		w = 300
+	}
+	inputWin := 0
+	if v, ok := cfg.Extra["input_window_seconds"].(int); ok && v > 0 {
+		inputWin = v
+	}
+	delayMs := defaultDelayMs(w)
+	if v, ok := cfg.Extra["flush_delay_ms"].(int); ok && v >= 0 {
+		delayMs = v
+	}
+	return &processor{
+		winSec:     w,
+		inputWin:   inputWin,
+		delay:      time.Duration(delayMs) * time.Millisecond,
+		keepInputs: cfg.ExtraBool("keep_inputs", true),
+		quantiles:  cfg.Quantiles,
+		now:        time.Now,
+		windows:    map[string]*window{},
+	}
+}
+
+// defaultDelayMs is 5s plus 1s per minute of window (1m: 6s, 5m: 10s, 1h:
+// 65s). A level's last input is emitted only once the level below has waited
+// out its own delay, so chained levels need increasing delays or that input
+// arrives late and is dropped from the coarser window.
+func defaultDelayMs(winSec int) int {
+	return 5000 + winSec/60*1000
+}
+
+func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) error {
+	defer close(out)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			a, ok := v.(model.Aggregate)
+			if !ok || !p.mergeable(a) {
+				out <- v
+				continue
+			}
+			if a.Sketch == nil {
+				unmergeableTotal.Inc()
+				out <- v
+				continue
+			}
+			start := trunc(a.WindowStart, int64(p.winSec))
+			if start+int64(p.winSec) <= p.emitted {
+				lateTotal.Inc()
+				if p.keepInputs {
+					out <- v
+				} else {
+					ack.Done(a.Ack) // its window is already out; nothing left to deliver
+				}
+				continue
+			}
+			if err := p.add(start, a, !p.keepInputs); err != nil {
+				log.Printf("[rollup] %s: bad sketch: %v", a.Service, err)
+				unmergeableTotal.Inc()
+				out <- v
+				continue
+			}
+			mergedTotal.Inc()
+			if p.keepInputs {
+				out <- v
+			}
+
+		case <-ticker.C:
+			p.flush(out, p.now().Add(-p.delay).Unix())
+		}
+	}
+}
+
+// mergeable reports whether a is an input of this rollup level.
+func (p *processor) mergeable(a model.Aggregate) bool {
+	length := int(a.WindowEnd - a.WindowStart)
+	if p.inputWin > 0 {
+		return length == p.inputWin
+	}
+	return length > 0 && length < p.winSec
+}
+
+func (p *processor) add(start int64, a model.Aggregate, holdAck bool) error {
+	td, err := sketch.DecodeDigest(a.Sketch.Digest)
+	if err != nil {
+		return err
+	}
+	hlls := make(map[string]*sketch.HLL, len(a.Sketch.HLL))
+	for k, b := range a.Sketch.HLL {
+		h, err := sketch.HLLFromBytes(b)
+		if err != nil {
+			return err
+		}
+		hlls[k] = h
+	}
+
+	key := seriesKey(a) + "@" + strconv.FormatInt(start, 10)
+	w, ok := p.windows[key]
+	if !ok {
+		w = &window{
+			service: a.Service,
+			group:   a.Group,
+			start:   start,
+			td:      sketch.NewDigest(),
+			hll:     map[string]*sketch.HLL{},
+			qkeys:   map[string]struct{}{},
+			merged:  newLabelAcc(),
+			newest:  a.WindowStart,
+		}
+		p.windows[key] = w
+	}
+	if err := w.td.Merge(td); err != nil {
+		return err
+	}
+	for k, h := range hlls {
+		if cur, ok := w.hll[k]; ok {
+			cur.Merge(h)
+		} else {
+			w.hll[k] = h
+		}
+	}
+	w.req += a.Sketch.Requests
+	w.ok += a.Sketch.OK
+	w.errs += a.Sketch.Errors
+	w.count += a.Count
+	for k := range a.Quantiles {
+		w.qkeys[k] = struct{}{}
+	}
+	w.merged.add(a.Labels)
+	if a.WindowStart >= w.newest || w.labels == nil {
+		w.newest = a.WindowStart
+		w.labels = a.Labels
+		if a.Locator != "" && a.Locator != "{}" {
+			w.loc = a.Locator
+		}
+	}
+	w.n++
+	if holdAck {
+		w.acks.Add(a.Ack)
+	}
+	return nil
+}
+
+// flush emits every window that ended at or before cutoff.
+func (p *processor) flush(out chan<- any, cutoff int64) {
+	win := int64(p.winSec)
+	keys := make([]string, 0, len(p.windows))
+	for k, w := range p.windows {
+		if w.start+win <= cutoff {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w := p.windows[k]
+		delete(p.windows, k)
+		if end := w.start + win; end > p.emitted {
+			p.emitted = end
+		}
+		out <- p.build(w)
+	}
+}
+
+func (p *processor) build(w *window) model.Aggregate {
+	win := float64(p.winSec)
+	var p50, p95, p99 float64
+	var qs map[string]float64
+	if w.td.Count() > 0 {
+		p50 = w.td.Quantile(0.50)
+		p95 = w.td.Quantile(0.95)
+		p99 = w.td.Quantile(0.99)
+		for _, q := range p.quantiles {
+			w.qkeys[model.QuantileKey(q)] = struct{}{}
+		}
+		for k := range w.qkeys {
+			q, err := strconv.ParseFloat(strings.TrimPrefix(k, "q"), 64)
+			if err != nil || q <= 0 || q >= 1 {
+				continue
+			}
+			if qs == nil {
+				qs = map[string]float64{}
+			}
+			qs[k] = w.td.Quantile(q)
+		}
+	}
+	errRate := 0.0
+	if total := w.ok + w.errs; total > 0 {
+		errRate = w.errs / total
+	}
+	rps := w.req / win
+
+	labels := make(map[string]string, len(w.labels)+len(w.hll)+1)
+	for k, v := range w.labels {
+		labels[k] = v
+	}
+	w.merged.apply(labels)
+	hlls := make(map[string][]byte, len(w.hll))
+	for k, h := range w.hll {
+		labels[k] = strconv.FormatUint(h.Estimate(), 10)
+		hlls[k] = h.Bytes()
+	}
+	labels["rollup_windows"] = strconv.Itoa(w.n)
+
+	loc := w.loc
+	if loc == "" {
+		loc = "{}"
+	}
+	a := model.Aggregate{
+		Service:     w.service,
+		Group:       w.group,
+		WindowStart: w.start,
+		WindowEnd:   w.start + int64(p.winSec),
+		Labels:      labels,
+		Locator:     loc,
+		Count:       w.count,
+		RPS:         rps,
+		ErrorRate:   errRate,
+		P50:         p50,
+		P95:         p95,
+		P99:         p99,
+		Quantiles:   qs,
+		Sketch: &model.Sketch{
+			Digest:   sketch.EncodeDigest(w.td),
+			Requests: w.req,
+			OK:       w.ok,
+			Errors:   w.errs,
+		},
+		Ack: w.acks.Release(1)[0],
+	}
+	if len(hlls) > 0 {
+		a.Sketch.HLL = hlls
+	}
+	a.SummaryText = buildSummaryText(a, p.winSec)
+	return a
+}
+
+// seriesKey keeps aggregates of different producers (summarizer, logsum)
+// apart even when they share a service name. servicegraph aggregates carry no
+// Sketch and pass through unmerged.
+func seriesKey(a model.Aggregate) string {
+	return a.SeriesKey() + "|" + a.Labels["source"] + "|" + a.Labels["signal"]
+}
+
+func buildSummaryText(a model.Aggregate, winSec int) string {
+	s := "rollup service=" + a.Service
+	if gk := a.GroupKey(); gk != "" {
+		s += " group=" + gk
+	}
+	return s +
+		" window=" + strconv.Itoa(winSec) + "s" +
+		" rps=" + ftoa(a.RPS) +
+		" error_rate=" + ftoa(a.ErrorRate) +
+		" p99=" + ftoa(a.P99) +
+		" count=" + strconv.FormatUint(a.Count, 10)
+}
+
+func ftoa(f float64) string { return strconv.FormatFloat(f, 'f', 6, 64) }
+
+func trunc(ts int64, win int64) int64 { return ts - (ts % win) }
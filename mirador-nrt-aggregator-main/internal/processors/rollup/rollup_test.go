@@ -0,0 +1,104 @@
+package rollup
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/sketch"
+)
+
+func minuteAggregate(start int64, latency float64) model.Aggregate {
+	td := sketch.NewDigest()
+	_ = td.Add(latency)
+	return model.Aggregate{
+		Service:     "checkout",
+		WindowStart: start,
+		WindowEnd:   start + 60,
+		Labels:      map[string]string{"source": "metrics"},
+		Count:       1,
+		Sketch:      &model.Sketch{Digest: sketch.EncodeDigest(td), Requests: 10, OK: 9, Errors: 1},
+	}
+}
+
+// TestChainedLevels runs 1m inputs through rollup/5m into rollup/1h with the
+// default delays and checks that the hour holds all twelve 5m windows: the
+// last one is emitted only after the 5m delay, so the 1h level must wait
+// longer.
+func TestChainedLevels(t *testing.T) {
+	const hour = int64(1_700_002_800) // aligned to the hour
+	var clock atomic.Int64
+	clock.Store(hour)
+	now := func() time.Time { return time.Unix(clock.Load(), 0) }
+
+	p5 := New(config.ProcessorCfg{WindowSeconds: 300, Extra: map[string]any{
+		"input_window_seconds": 60,
+		"keep_inputs":          false,
+	}})
+	p1h := New(config.ProcessorCfg{WindowSeconds: 3600, Extra: map[string]any{
+		"input_window_seconds": 300,
+		"keep_inputs":          false,
+	}})
+	p5.now, p1h.now = now, now
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan any)
+	mid := make(chan any, 16)
+	out := make(chan any, 16)
+	go p5.Start(ctx, in, mid)
+	go p1h.Start(ctx, mid, out)
+
+	for m := int64(0); m < 60; m++ {
+		in <- minuteAggregate(hour+m*60, float64(m))
+	}
+
+	// Past the hour and the 5m delay for all but the last 5m window, then
+	// past the last one, then past the 1h delay.
+	for _, after := range []int64{8, 12, 70} {
+		clock.Store(hour + 3600 + after)
+		time.Sleep(600 * time.Millisecond)
+		if after < 70 {
+			select {
+			case v := <-out:
+				t.Fatalf("1h window emitted %ds after the hour: %+v", after, v)
+			default:
+			}
+		}
+	}
+
+	select {
+	case v := <-out:
+		a := v.(model.Aggregate)
+		if a.WindowStart != hour || a.WindowEnd != hour+3600 {
+			t.Fatalf("window = [%d, %d), want [%d, %d)", a.WindowStart, a.WindowEnd, hour, hour+3600)
+		}
+		if got := a.Labels["rollup_windows"]; got != "12" {
+			t.Errorf("rollup_windows = %s, want 12", got)
+		}
+		if a.Count != 60 || a.Sketch.Requests != 600 || a.Sketch.Errors != 60 {
+			t.Errorf("count=%d requests=%v errors=%v, want 60/600/60", a.Count, a.Sketch.Requests, a.Sketch.Errors)
+		}
+		if a.ErrorRate != 0.1 {
+			t.Errorf("error_rate = %v, want 0.1", a.ErrorRate)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no 1h window emitted")
+	}
+}
+
+func TestDefaultDelayGrowsWithWindow(t *testing.T) {
+	tests := []struct{ win, want int }{
+		{60, 6000},
+		{300, 10000},
+		{3600, 65000},
+	}
+	for _, tt := range tests {
+		if got := defaultDelayMs(tt.win); got != tt.want {
+			t.Errorf("defaultDelayMs(%d) = %d, want %d", tt.win, got, tt.want)
+		}
+	}
+}
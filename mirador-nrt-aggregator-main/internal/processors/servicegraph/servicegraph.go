@@ -0,0 +1,440 @@
+package servicegraph
+
+import (
+	"container/list"
+	"context"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caio/go-tdigest/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	res "go.opentelemetry.io/proto/otlp/resource/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Connection types reported in the connection_type label.
+const (
+	connDirect    = ""                 // instrumented client → instrumented server
+	connVirtual   = "virtual_node"     // uninstrumented peer named by span attributes
+	connMessaging = "messaging_system" // producer → consumer
+	connDatabase  = "database"         // client span carrying db.* attributes
+)
+
+var (
+	completedEdges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_servicegraph_edges_total",
+		Help: "Service graph edges recorded, by how the pair was completed.",
+	}, []string{"connection_type"})
+
+	expiredSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_servicegraph_unpaired_spans_total",
+		Help: "Client/server spans that expired from the store without a counterpart or virtual peer.",
+	}, []string{"side"})
+
+	droppedSpans = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mirador_servicegraph_dropped_spans_total",
+		Help: "Spans not stored because the pairing store was full.",
+	})
+)
+
+// processor pairs CLIENT/PRODUCER spans with their SERVER/CONSUMER children
+// (possibly arriving in different batches) and emits one Aggregate per
+// client→server edge per window. Trace envelopes pass through untouched so
+// spanmetrics and the rest of the pipeline still see them.
+type processor struct {
+	winSec    int
+	ttl       time.Duration
+	maxItems  int
+	svcAttr   string
+	peerAttrs []string // span attributes naming an uninstrumented peer, in order
+	rootNode  string   // client name for server spans without a parent; "" skips them
+
+	store map[string]*pending // traceID+spanID of the client span → half-built edge
+	order *list.List          // *pending by insertion (= expiry) order
+	edges map[edgeKey]*edgeStats
+}
+
+// pending is one client/server pair waiting for its other half.
+type pending struct {
+	key     string
+	expires time.Time
+	elem    *list.Element
+
+	client, server       string
+	clientDur, serverDur float64 // seconds
+	hasClient, hasServer bool
+	failed               bool
+	conn                 string
+	peer                 string // virtual peer from the client span's attributes
+}
+
+type edgeKey struct {
+	client, server, conn string
+}
+
+type edgeStats struct {
+	calls  uint64
+	failed uint64
+	td     *tdigest.TDigest // latency in seconds (server side when known)
+}
+
+// New builds the servicegraph processor.
+//
+// Example config snippet:
+// processors:
+//
+//	servicegraph:
+//	  window_seconds: 60
+//	  store_ttl_ms: 2000          # how long a half edge waits for its counterpart
+//	  store_max_items: 10000
+//	  service_attribute: service.name
+//	  virtual_node_peer_attributes: [peer.service, db.name, db.system, server.address, net.peer.name]
+//	  root_node: user             # client for server spans without a parent ("" = skip)
+func New(cfg config.ProcessorCfg) *processor {
+	w := cfg.WindowSeconds
+	if w <= 0 {
+		w = 60
+	}
+	ttl := 2 * time.Second
+	if v, ok := cfg.Extra["store_ttl_ms"].(int); ok && v > 0 {
+		ttl = time.Duration(v) * time.Millisecond
+	}
+	maxItems := 10000
+	if v, ok := cfg.Extra["store_max_items"].(int); ok && v > 0 {
+		maxItems = v
+	}
+	peerAttrs := []string{"peer.service", "db.name", "db.system", "server.address", "net.peer.name"}
+	if xs, ok := cfg.Extra["virtual_node_peer_attributes"].([]any); ok {
+		peerAttrs = peerAttrs[:0]
+		for _, it := range xs {
+			if s, ok := it.(string); ok && s != "" {
+				peerAttrs = append(peerAttrs, s)
+			}
+		}
+	}
+	return &processor{
+		winSec:    w,
+		ttl:       ttl,
+		maxItems:  maxItems,
+		svcAttr:   cfg.ExtraString("service_attribute", "service.name"),
+		peerAttrs: peerAttrs,
+		rootNode:  cfg.ExtraString("root_node", "user"),
+		store:     map[string]*pending{},
+		order:     list.New(),
+		edges:     map[edgeKey]*edgeStats{},
+	}
+}
+
+func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) error {
+	defer close(out)
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	winStart := trunc(time.Now().Unix(), int64(p.winSec))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			if env, ok := v.(model.Envelope); ok && env.Kind == model.KindTraces {
+				p.consume(env.Bytes, time.Now())
+			}
+			out <- v
+
+		case now := <-ticker.C:
+			p.expire(now)
+			if now.Unix() >= winStart+int64(p.winSec) {
+				p.flush(out, winStart)
+				winStart = trunc(now.Unix(), int64(p.winSec))
+			}
+		}
+	}
+}
+
+// consume feeds every client/server span of one OTLP request into the store.
+// Undecodable payloads are left to spanmetrics to reject.
+func (p *processor) consume(raw []byte, now time.Time) {
+	et := &colltr.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(raw, et); err != nil {
+		log.Printf("[servicegraph] cannot unmarshal traces: %v", err)
+		return
+	}
+	for _, rs := range et.ResourceSpans {
+		rAttrs := attrsToMapRes(rs.GetResource())
+		svc := firstNonEmpty(rAttrs[p.svcAttr], rAttrs["service.name"], "unknown")
+		for _, ss := range rs.ScopeSpans {
+			for _, sp := range ss.Spans {
+				p.consumeSpan(svc, sp, now)
+			}
+		}
+	}
+}
+
+func (p *processor) consumeSpan(svc string, sp *tr.Span, now time.Time) {
+	failed := sp.GetStatus().GetCode() == tr.Status_STATUS_CODE_ERROR
+	dur := spanSeconds(sp)
+
+	switch sp.GetKind() {
+	case tr.Span_SPAN_KIND_CLIENT, tr.Span_SPAN_KIND_PRODUCER:
+		attrs := attrsToMap(sp.Attributes)
+		peer := p.peerName(attrs)
+		// Databases are never instrumented on the far side; no point waiting.
+		if attrs["db.system"] != "" && peer != "" {
+			p.record(edgeKey{client: svc, server: peer, conn: connDatabase}, dur, failed)
+			return
+		}
+		e := p.upsert(edgeID(sp.GetTraceId(), sp.GetSpanId()), now)
+		if e == nil {
+			return
+		}
+		e.client, e.clientDur, e.hasClient, e.peer = svc, dur, true, peer
+		e.failed = e.failed || failed
+		if sp.GetKind() == tr.Span_SPAN_KIND_PRODUCER {
+			e.conn = connMessaging
+		}
+		p.complete(e)
+
+	case tr.Span_SPAN_KIND_SERVER, tr.Span_SPAN_KIND_CONSUMER:
+		if len(sp.GetParentSpanId()) == 0 {
+			if p.rootNode != "" {
+				p.record(edgeKey{client: p.rootNode, server: svc, conn: connVirtual}, dur, failed)
+			}
+			return
+		}
+		e := p.upsert(edgeID(sp.GetTraceId(), sp.GetParentSpanId()), now)
+		if e == nil {
+			return
+		}
+		e.server, e.serverDur, e.hasServer = svc, dur, true
+		e.failed = e.failed || failed
+		if sp.GetKind() == tr.Span_SPAN_KIND_CONSUMER {
+			e.conn = connMessaging
+		}
+		p.complete(e)
+	}
+}
+
+// upsert returns the pending pair for key, creating it unless the store is full.
+func (p *processor) upsert(key string, now time.Time) *pending {
+	if e, ok := p.store[key]; ok {
+		return e
+	}
+	if len(p.store) >= p.maxItems {
+		droppedSpans.Inc()
+		return nil
+	}
+	e := &pending{key: key, expires: now.Add(p.ttl)}
+	e.elem = p.order.PushBack(e)
+	p.store[key] = e
+	return e
+}
+
+// complete records e once both halves are in.
+func (p *processor) complete(e *pending) {
+	if !e.hasClient || !e.hasServer {
+		return
+	}
+	p.remove(e)
+	dur := e.serverDur
+	if dur <= 0 {
+		dur = e.clientDur
+	}
+	p.record(edgeKey{client: e.client, server: e.server, conn: e.conn}, dur, e.failed)
+}
+
+// expire drops half edges past their TTL. A client span naming its peer
+// becomes an edge to that virtual node; anything else is counted as unpaired.
+func (p *processor) expire(now time.Time) {
+	for el := p.order.Front(); el != nil; el = p.order.Front() {
+		e := el.Value.(*pending)
+		if now.Before(e.expires) {
+			return
+		}
+		p.remove(e)
+		switch {
+		case e.hasClient && e.peer != "":
+			conn := e.conn
+			if conn == connDirect {
+				conn = connVirtual
+			}
+			p.record(edgeKey{client: e.client, server: e.peer, conn: conn}, e.clientDur, e.failed)
+		case e.hasClient:
+			expiredSpans.WithLabelValues("client").Inc()
+		default:
+			expiredSpans.WithLabelValues("server").Inc()
+		}
+	}
+}
+
+func (p *processor) remove(e *pending) {
+	delete(p.store, e.key)
+	p.order.Remove(e.elem)
+}
+
+func (p *processor) record(k edgeKey, dur float64, failed bool) {
+	st, ok := p.edges[k]
+	if !ok {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		st = &edgeStats{td: td}
+		p.edges[k] = st
+	}
+	st.calls++
+	if failed {
+		st.failed++
+	}
+	if st.td != nil && dur >= 0 {
+		_ = st.td.Add(dur)
+	}
+	completedEdges.WithLabelValues(connLabel(k.conn)).Inc()
+}
+
+func (p *processor) flush(out chan<- any, winStart int64) {
+	winEnd := winStart + int64(p.winSec)
+	keys := make([]edgeKey, 0, len(p.edges))
+	for k := range p.edges {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].client != keys[j].client {
+			return keys[i].client < keys[j].client
+		}
+		return keys[i].server < keys[j].server
+	})
+	for _, k := range keys {
+		st := p.edges[k]
+		var p50, p95, p99 float64
+		if st.td != nil && st.td.Count() > 0 {
+			p50 = st.td.Quantile(0.50)
+			p95 = st.td.Quantile(0.95)
+			p99 = st.td.Quantile(0.99)
+		}
+		rps := float64(st.calls) / float64(p.winSec)
+		errRate := float64(st.failed) / float64(st.calls)
+		labels := map[string]string{
+			"signal":          "service_graph",
+			"client":          k.client,
+			"server":          k.server,
+			"connection_type": connLabel(k.conn),
+			"calls":           strconv.FormatUint(st.calls, 10),
+			"failed":          strconv.FormatUint(st.failed, 10),
+		}
+		out <- model.Aggregate{
+			Service:     k.client,
+			Group:       map[string]string{"server": k.server, "connection_type": connLabel(k.conn)},
+			WindowStart: winStart,
+			WindowEnd:   winEnd,
+			Labels:      labels,
+			Locator:     "{}",
+			Count:       st.calls,
+			RPS:         rps,
+			ErrorRate:   errRate,
+			P50:         p50,
+			P95:         p95,
+			P99:         p99,
+			SummaryText: buildSummaryText(k, rps, errRate, p99),
+		}
+	}
+	p.edges = map[edgeKey]*edgeStats{}
+}
+
+// peerName is the first configured peer attribute present on a client span.
+func (p *processor) peerName(attrs map[string]string) string {
+	for _, k := range p.peerAttrs {
+		if v := attrs[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// -------------------- helpers --------------------
+
+func buildSummaryText(k edgeKey, rps, errRate, p99 float64) string {
+	sb := strings.Builder{}
+	sb.WriteString("service graph edge: client=")
+	sb.WriteString(k.client)
+	sb.WriteString(" server=")
+	sb.WriteString(k.server)
+	if k.conn != connDirect {
+		sb.WriteString(" connection_type=")
+		sb.WriteString(k.conn)
+	}
+	sb.WriteString(" rps=")
+	sb.WriteString(ftoa(rps))
+	sb.WriteString(" error_rate=")
+	sb.WriteString(ftoa(errRate))
+	sb.WriteString(" p99=")
+	sb.WriteString(ftoa(p99))
+	return sb.String()
+}
+
+// connLabel spells out direct edges so the label is never empty.
+func connLabel(conn string) string {
+	if conn == connDirect {
+		return "direct"
+	}
+	return conn
+}
+
+func edgeID(traceID, spanID []byte) string {
+	return hex.EncodeToString(traceID) + ":" + hex.EncodeToString(spanID)
+}
+
+func spanSeconds(sp *tr.Span) float64 {
+	start, end := sp.GetStartTimeUnixNano(), sp.GetEndTimeUnixNano()
+	if end <= start {
+		return 0
+	}
+	return float64(end-start) / 1e9
+}
+
+func attrsToMapRes(r *res.Resource) map[string]string {
+	if r == nil {
+		return map[string]string{}
+	}
+	return attrsToMap(r.Attributes)
+}
+
+func attrsToMap(xs []*com.KeyValue) map[string]string {
+	out := map[string]string{}
+	for _, a := range xs {
+		if s := a.GetValue().GetStringValue(); s != "" {
+			out[a.Key] = s
+		}
+	}
+	return out
+}
+
+func firstNonEmpty(xs ...string) string {
+	for _, s := range xs {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func trunc(ts int64, win int64) int64 { return ts - (ts % win) }
+
+func ftoa(f float64) string {
+	// compact float format
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
@@ -0,0 +1,230 @@
+package servicegraph
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	res "go.opentelemetry.io/proto/otlp/resource/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+var (
+	traceID = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	t0      = time.Unix(1_700_000_000, 0)
+)
+
+type spanOpt func(*tr.Span)
+
+func attr(k, v string) spanOpt {
+	return func(sp *tr.Span) {
+		sp.Attributes = append(sp.Attributes, &com.KeyValue{Key: k, Value: &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: v}}})
+	}
+}
+
+func failed(sp *tr.Span) { sp.Status = &tr.Status{Code: tr.Status_STATUS_CODE_ERROR} }
+
+func span(kind tr.Span_SpanKind, id, parent byte, ms uint64, opts ...spanOpt) *tr.Span {
+	sp := &tr.Span{
+		TraceId:           traceID,
+		SpanId:            []byte{0, 0, 0, 0, 0, 0, 0, id},
+		Kind:              kind,
+		StartTimeUnixNano: 1e9,
+		EndTimeUnixNano:   1e9 + ms*1e6,
+	}
+	if parent != 0 {
+		sp.ParentSpanId = []byte{0, 0, 0, 0, 0, 0, 0, parent}
+	}
+	for _, o := range opts {
+		o(sp)
+	}
+	return sp
+}
+
+func batch(t *testing.T, service string, spans ...*tr.Span) []byte {
+	t.Helper()
+	req := &colltr.ExportTraceServiceRequest{ResourceSpans: []*tr.ResourceSpans{{
+		Resource: &res.Resource{Attributes: []*com.KeyValue{
+			{Key: "service.name", Value: &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: service}}},
+		}},
+		ScopeSpans: []*tr.ScopeSpans{{Spans: spans}},
+	}}}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// edges flushes p and returns "client>server/connection_type" → "calls/failed".
+func edges(p *processor) map[string]string {
+	out := make(chan any, 100)
+	p.flush(out, t0.Unix())
+	close(out)
+	m := map[string]string{}
+	for v := range out {
+		a := v.(model.Aggregate)
+		m[a.Labels["client"]+">"+a.Labels["server"]+"/"+a.Labels["connection_type"]] = a.Labels["calls"] + "/" + a.Labels["failed"]
+	}
+	return m
+}
+
+func TestPairingAcrossBatches(t *testing.T) {
+	client := span(tr.Span_SPAN_KIND_CLIENT, 1, 9, 100)
+	server := span(tr.Span_SPAN_KIND_SERVER, 2, 1, 80)
+
+	for _, order := range []string{"client first", "server first"} {
+		t.Run(order, func(t *testing.T) {
+			p := New(config.ProcessorCfg{})
+			if order == "client first" {
+				p.consume(batch(t, "frontend", client), t0)
+				p.consume(batch(t, "cart", server), t0.Add(time.Second))
+			} else {
+				p.consume(batch(t, "cart", server), t0)
+				p.consume(batch(t, "frontend", client), t0.Add(time.Second))
+			}
+			if len(p.store) != 0 {
+				t.Fatalf("store holds %d half edges after pairing", len(p.store))
+			}
+			out := make(chan any, 10)
+			p.flush(out, t0.Unix())
+			close(out)
+			var got []model.Aggregate
+			for v := range out {
+				got = append(got, v.(model.Aggregate))
+			}
+			if len(got) != 1 {
+				t.Fatalf("edges = %d, want 1", len(got))
+			}
+			a := got[0]
+			if a.Service != "frontend" || a.Labels["server"] != "cart" || a.Labels["connection_type"] != "direct" || a.Count != 1 {
+				t.Fatalf("edge = %+v", a)
+			}
+			if want := map[string]string{"server": "cart", "connection_type": "direct"}; !reflect.DeepEqual(a.Group, want) {
+				t.Fatalf("group = %v, want %v", a.Group, want)
+			}
+			if a.P50 != 0.08 { // the server's own duration
+				t.Fatalf("p50 = %v, want 0.08", a.P50)
+			}
+		})
+	}
+}
+
+func TestExpiryAndVirtualEdges(t *testing.T) {
+	p := New(config.ProcessorCfg{Extra: map[string]any{"store_ttl_ms": 1000}})
+	p.consume(batch(t, "frontend",
+		span(tr.Span_SPAN_KIND_CLIENT, 1, 9, 10, attr("peer.service", "payments")),
+		span(tr.Span_SPAN_KIND_CLIENT, 2, 9, 10, attr("db.system", "postgresql"), attr("db.name", "orders")),
+		span(tr.Span_SPAN_KIND_PRODUCER, 3, 9, 10, attr("server.address", "kafka:9092")),
+		span(tr.Span_SPAN_KIND_CLIENT, 4, 9, 10), // no peer: dropped at expiry
+		span(tr.Span_SPAN_KIND_CLIENT, 5, 9, 10, attr("peer.service", "search")),
+	), t0)
+	p.consume(batch(t, "cart", span(tr.Span_SPAN_KIND_SERVER, 7, 6, 10)), t0) // parent never arrives
+
+	// Databases are recorded at once; the rest waits for the TTL.
+	if want := map[string]string{"frontend>orders/database": "1/0"}; !reflect.DeepEqual(edges(p), want) {
+		t.Fatalf("before expiry: %v", edges(p))
+	}
+
+	p.consume(batch(t, "search", span(tr.Span_SPAN_KIND_SERVER, 8, 5, 10)), t0.Add(500*time.Millisecond))
+	p.expire(t0.Add(999 * time.Millisecond))
+	if len(p.store) != 4 {
+		t.Fatalf("store = %d before the TTL, want 4", len(p.store))
+	}
+	p.expire(t0.Add(time.Second))
+	if len(p.store) != 0 {
+		t.Fatalf("store = %d after the TTL, want 0", len(p.store))
+	}
+	want := map[string]string{
+		"frontend>payments/virtual_node":       "1/0",
+		"frontend>kafka:9092/messaging_system": "1/0",
+		"frontend>search/direct":               "1/0", // paired before expiry
+	}
+	if got := edges(p); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after expiry:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestMessagingPair(t *testing.T) {
+	p := New(config.ProcessorCfg{})
+	p.consume(batch(t, "orders", span(tr.Span_SPAN_KIND_PRODUCER, 1, 9, 5)), t0)
+	p.consume(batch(t, "billing", span(tr.Span_SPAN_KIND_CONSUMER, 2, 1, 20)), t0)
+	if want := map[string]string{"orders>billing/messaging_system": "1/0"}; !reflect.DeepEqual(edges(p), want) {
+		t.Fatalf("edges = %v", edges(p))
+	}
+}
+
+func TestRootNode(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]any
+		want map[string]string
+	}{
+		{"default user", nil, map[string]string{"user>frontend/virtual_node": "2/1"}},
+		{"custom", map[string]any{"root_node": "internet"}, map[string]string{"internet>frontend/virtual_node": "2/1"}},
+		{"disabled", map[string]any{"root_node": ""}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(config.ProcessorCfg{Extra: tt.cfg})
+			p.consume(batch(t, "frontend",
+				span(tr.Span_SPAN_KIND_SERVER, 1, 0, 10),
+				span(tr.Span_SPAN_KIND_SERVER, 2, 0, 10, failed),
+				span(tr.Span_SPAN_KIND_INTERNAL, 3, 0, 10),
+			), t0)
+			if got := edges(p); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("edges = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedEdges(t *testing.T) {
+	p := New(config.ProcessorCfg{WindowSeconds: 10})
+	for i := byte(1); i <= 4; i++ {
+		var cOpts, sOpts []spanOpt
+		switch i {
+		case 1:
+			cOpts = append(cOpts, failed) // client saw the failure
+		case 2:
+			sOpts = append(sOpts, failed) // server reported it
+		}
+		p.consume(batch(t, "frontend", span(tr.Span_SPAN_KIND_CLIENT, i, 99, 10, cOpts...)), t0)
+		p.consume(batch(t, "cart", span(tr.Span_SPAN_KIND_SERVER, 100+i, i, 10, sOpts...)), t0)
+	}
+	out := make(chan any, 10)
+	p.flush(out, t0.Unix())
+	close(out)
+	a := (<-out).(model.Aggregate)
+	if a.Labels["calls"] != "4" || a.Labels["failed"] != "2" || a.ErrorRate != 0.5 {
+		t.Fatalf("calls=%s failed=%s error_rate=%v, want 4, 2, 0.5", a.Labels["calls"], a.Labels["failed"], a.ErrorRate)
+	}
+	if got, want := a.RPS, 0.4; got != want {
+		t.Fatalf("rps = %v, want %v", got, want)
+	}
+	if len(edges(p)) != 0 {
+		t.Fatal("flush kept the window's edges")
+	}
+}
+
+func TestStoreFull(t *testing.T) {
+	p := New(config.ProcessorCfg{Extra: map[string]any{"store_max_items": 2}})
+	for i := byte(1); i <= 3; i++ {
+		p.consume(batch(t, "frontend", span(tr.Span_SPAN_KIND_CLIENT, i, 99, 10)), t0)
+	}
+	if len(p.store) != 2 {
+		t.Fatalf("store = %d, want 2", len(p.store))
+	}
+	// The dropped span's server half finds no room either.
+	p.consume(batch(t, "cart", span(tr.Span_SPAN_KIND_SERVER, 10, 3, 10)), t0)
+	p.consume(batch(t, "cart", span(tr.Span_SPAN_KIND_SERVER, 11, 1, 10)), t0)
+	if got := edges(p); !reflect.DeepEqual(got, map[string]string{"frontend>cart/direct": "1/0"}) {
+		t.Fatalf("edges = %v", got)
+	}
+}
@@ -0,0 +1,263 @@
+package spanmetrics
+
+import (
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// overflowValue replaces the configured attribute dimensions once a service
+// exceeds max_series_per_service.
+const overflowValue = "__other__"
+
+var overflowSpans = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "mirador_spanmetrics_overflow_spans_total",
+	Help: "Spans whose dimensions were collapsed to __other__ by the per-service series cap.",
+})
+
+// Where a dimension value is looked up.
+const (
+	sourceAny      = "any" // span attributes first, then resource
+	sourceSpan     = "span"
+	sourceResource = "resource"
+)
+
+// dimension is one metric label taken from the span or its resource.
+type dimension struct {
+	name    string   // label key on the emitted metrics
+	source  string   // any | span | resource
+	aliases []string // older semconv keys tried after name
+	def     string   // value when the attribute is missing; "" omits the label
+}
+
+// defaultDimensions covers both the legacy and the current HTTP semconv keys
+// plus RPC, DB and messaging spans. Labels only appear when the span has them.
+var defaultDimensions = []dimension{
+	{name: "service.name", source: sourceResource},
+	{name: "http.method", source: sourceAny},
+	{name: "http.request.method", source: sourceAny},
+	{name: "http.route", source: sourceAny},
+	{name: "rpc.method", source: sourceAny},
+	{name: "db.system", source: sourceAny},
+	{name: "messaging.destination.name", source: sourceAny, aliases: []string{"messaging.destination"}},
+	{name: "span.kind", source: sourceSpan},
+	{name: "status.code", source: sourceSpan},
+}
+
+// parseDimensions reads the `dimensions:` list. Items are either plain keys
+// or maps:
+//
+//	dimensions:
+//	  - service.name
+//	  - resource.deployment.environment      # resource attribute only
+//	  - name: http.request.method
+//	    aliases: [http.method]               # tried when name is missing
+//	    default: UNKNOWN
+//	  - name: k8s.namespace.name
+//	    source: resource                     # any (default) | span | resource
+//
+// A missing or empty list keeps defaultDimensions.
+func parseDimensions(v any) []dimension {
+	xs, ok := v.([]any)
+	if !ok || len(xs) == 0 {
+		return defaultDimensions
+	}
+	out := make([]dimension, 0, len(xs))
+	for _, it := range xs {
+		var d dimension
+		switch t := it.(type) {
+		case string:
+			d = dimension{name: strings.TrimSpace(t)}
+		case map[string]any:
+			d.name, _ = t["name"].(string)
+			d.name = strings.TrimSpace(d.name)
+			d.source, _ = t["source"].(string)
+			d.def, _ = t["default"].(string)
+			if as, ok := t["aliases"].([]any); ok {
+				for _, a := range as {
+					if s, ok := a.(string); ok && s != "" {
+						d.aliases = append(d.aliases, s)
+					}
+				}
+			}
+		default:
+			continue
+		}
+		if d.name == "" {
+			continue
+		}
+		// Shorthand prefixes pin the source: "resource.x" / "span.x" (except the
+		// built-in span.kind / span.name).
+		if d.source == "" {
+			d.source = sourceAny
+			switch {
+			case strings.HasPrefix(d.name, "resource."):
+				d.name, d.source = strings.TrimPrefix(d.name, "resource."), sourceResource
+			case strings.HasPrefix(d.name, "span.") && !isSpanField(d.name):
+				d.name, d.source = strings.TrimPrefix(d.name, "span."), sourceSpan
+			}
+		}
+		switch d.source {
+		case sourceAny, sourceSpan, sourceResource:
+		default:
+			log.Printf("[spanmetrics] dimension %q: unknown source %q, using any", d.name, d.source)
+			d.source = sourceAny
+		}
+		out = append(out, d)
+	}
+	if len(out) == 0 {
+		return defaultDimensions
+	}
+	return out
+}
+
+// isSpanField reports the dimensions derived from span fields rather than
+// attributes.
+func isSpanField(name string) bool {
+	switch name {
+	case "span.kind", "status.code", "span.name":
+		return true
+	}
+	return false
+}
+
+// value resolves d for one span; "" means the label is omitted. legacy keeps
+// the enum prefixes on span.kind / status.code (span_kind_server,
+// status_code_error) as emitted before the values were shortened.
+func (d dimension) value(sp *tr.Span, spanAttrs, resAttrs map[string]string, legacy bool) string {
+	switch d.name {
+	case "span.kind":
+		return enumValue(sp.Kind.String(), "SPAN_KIND_", legacy)
+	case "status.code":
+		// "unset" / "ok" / "error", which the summarizer's status check understands.
+		return enumValue(sp.Status.GetCode().String(), "STATUS_CODE_", legacy)
+	case "span.name":
+		if sp.GetName() != "" {
+			return sp.GetName()
+		}
+		return d.def
+	}
+	for _, k := range append([]string{d.name}, d.aliases...) {
+		if d.source != sourceResource {
+			if v := spanAttrs[k]; v != "" {
+				return v
+			}
+		}
+		if d.source != sourceSpan {
+			if v := resAttrs[k]; v != "" {
+				return v
+			}
+		}
+	}
+	return d.def
+}
+
+func enumValue(s, prefix string, legacy bool) string {
+	if !legacy {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	return strings.ToLower(s)
+}
+
+// seriesLimiter caps the distinct label sets per service. Once a service is at
+// the cap, the attribute dimensions of new label sets collapse to __other__,
+// while already-seen ones keep flowing. service.name, span.kind and
+// status.code are kept, so overflow traffic still counts towards the right
+// service's request and error rates (at most kinds × codes extra series).
+// Counts reset every window so a burst of junk routes does not pin a service
+// to __other__ forever.
+type seriesLimiter struct {
+	max     int
+	window  time.Duration
+	resetAt time.Time
+	seen    map[string]map[uint64]struct{} // service → label-set hashes
+}
+
+func newSeriesLimiter(max int, window time.Duration) *seriesLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &seriesLimiter{max: max, window: window, resetAt: time.Now().Add(window), seen: map[string]map[uint64]struct{}{}}
+}
+
+// admit returns labels unchanged when the series fits under the cap, or the
+// collapsed label set otherwise. A nil limiter admits everything.
+func (l *seriesLimiter) admit(svcKey string, labels []*com.KeyValue) []*com.KeyValue {
+	if l == nil {
+		return labels
+	}
+	if l.window > 0 && time.Now().After(l.resetAt) {
+		l.seen = map[string]map[uint64]struct{}{}
+		l.resetAt = time.Now().Add(l.window)
+	}
+	svc := ""
+	for _, kv := range labels {
+		if kv.Key == svcKey {
+			svc = kv.GetValue().GetStringValue()
+			break
+		}
+	}
+	set := l.seen[svc]
+	if set == nil {
+		set = map[uint64]struct{}{}
+		l.seen[svc] = set
+	}
+	h := labelsHash(labels)
+	if _, ok := set[h]; ok {
+		return labels
+	}
+	if len(set) < l.max {
+		set[h] = struct{}{}
+		return labels
+	}
+	overflowSpans.Inc()
+	out := make([]*com.KeyValue, 0, len(labels))
+	for _, kv := range labels {
+		switch kv.Key {
+		case svcKey, "span.kind", "status.code":
+			out = append(out, kv)
+		default:
+			out = append(out, strKV(kv.Key, overflowValue))
+		}
+	}
+	return out
+}
+
+func labelsHash(labels []*com.KeyValue) uint64 {
+	parts := make([]string, 0, len(labels))
+	for _, kv := range labels {
+		parts = append(parts, kv.Key+"="+kv.GetValue().GetStringValue())
+	}
+	sort.Strings(parts)
+	h := fnv.New64a()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// anyString renders scalar attribute values so numeric keys such as
+// http.response.status_code can be used as dimensions.
+func anyString(v *com.AnyValue) string {
+	switch x := v.GetValue().(type) {
+	case *com.AnyValue_StringValue:
+		return x.StringValue
+	case *com.AnyValue_IntValue:
+		return strconv.FormatInt(x.IntValue, 10)
+	case *com.AnyValue_BoolValue:
+		return strconv.FormatBool(x.BoolValue)
+	case *com.AnyValue_DoubleValue:
+		return strconv.FormatFloat(x.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
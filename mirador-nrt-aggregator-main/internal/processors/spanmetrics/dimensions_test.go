@@ -0,0 +1,62 @@
+package spanmetrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	tr "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func labelMap(kvs []*com.KeyValue) map[string]string {
+	m := map[string]string{}
+	for _, kv := range kvs {
+		m[kv.Key] = kv.GetValue().GetStringValue()
+	}
+	return m
+}
+
+func TestSeriesLimiterAdmit(t *testing.T) {
+	route := func(svc, r, code string) []*com.KeyValue {
+		return []*com.KeyValue{
+			strKV("service.name", svc),
+			strKV("http.route", r),
+			strKV("span.kind", "server"),
+			strKV("status.code", code),
+		}
+	}
+	tests := []struct {
+		name   string
+		labels []*com.KeyValue
+		want   map[string]string
+	}{
+		{"first series fits", route("api", "/a", "ok"),
+			map[string]string{"service.name": "api", "http.route": "/a", "span.kind": "server", "status.code": "ok"}},
+		{"second series fits", route("api", "/b", "ok"),
+			map[string]string{"service.name": "api", "http.route": "/b", "span.kind": "server", "status.code": "ok"}},
+		{"seen series keeps flowing", route("api", "/a", "ok"),
+			map[string]string{"service.name": "api", "http.route": "/a", "span.kind": "server", "status.code": "ok"}},
+		{"overflow keeps kind and status", route("api", "/c", "error"),
+			map[string]string{"service.name": "api", "http.route": overflowValue, "span.kind": "server", "status.code": "error"}},
+		{"other services have their own cap", route("web", "/c", "ok"),
+			map[string]string{"service.name": "web", "http.route": "/c", "span.kind": "server", "status.code": "ok"}},
+	}
+	l := newSeriesLimiter(2, time.Hour)
+	for _, tt := range tests {
+		if got := labelMap(l.admit("service.name", tt.labels)); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnumDimensionValues(t *testing.T) {
+	sp := &tr.Span{Kind: tr.Span_SPAN_KIND_SERVER, Status: &tr.Status{Code: tr.Status_STATUS_CODE_ERROR}}
+	kind, code := dimension{name: "span.kind"}, dimension{name: "status.code"}
+	if k, c := kind.value(sp, nil, nil, false), code.value(sp, nil, nil, false); k != "server" || c != "error" {
+		t.Errorf("values = %q %q, want server error", k, c)
+	}
+	if k, c := kind.value(sp, nil, nil, true), code.value(sp, nil, nil, true); k != "span_kind_server" || c != "status_code_error" {
+		t.Errorf("legacy values = %q %q, want span_kind_server status_code_error", k, c)
+	}
+}
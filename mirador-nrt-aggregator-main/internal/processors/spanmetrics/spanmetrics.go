@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
 
@@ -21,13 +22,17 @@ import (
 
 // processor converts OTLP Traces → OTLP Metrics (RED-style) and also emits errors_total.
 type processor struct {
-	dimensions         []string // attribute keys to propagate as metric labels
-	errorEventAttrDims []string // event attribute keys to pull into error labels (e.g., "exception.type")
-	errorEventNames    []string // names treated as error events, default ["exception"]
+	dimensions         []dimension    // labels taken from span/resource attributes
+	series             *seriesLimiter // per-service cap on distinct label sets; nil = unlimited
+	errorEventAttrDims []string       // event attribute keys to pull into error labels (e.g., "exception.type")
+	errorEventNames    []string       // names treated as error events, default ["exception"]
 	errFromStatus      bool
 	errFromEvents      bool
 	histBounds         []float64 // seconds
 	defaultSvcAttr     string
+	legacyLabelValues  bool    // span_kind_server / status_code_error instead of server / error
+	exemplars          bool    // attach trace/span exemplars to slow and error spans
+	exemplarSlowSec    float64 // spans at least this long count as slow
 }
 
 func New(cfg config.ProcessorCfg) *processor {
@@ -72,14 +77,44 @@ func New(cfg config.ProcessorCfg) *processor {
 		}
 	}
 
+	// Cardinality cap: distinct dimension sets per service within the window.
+	maxSeries := 0
+	if v, ok := cfg.Extra["max_series_per_service"].(int); ok {
+		maxSeries = v
+	}
+	window := time.Hour
+	if v, ok := cfg.Extra["series_window_ms"].(int); ok && v > 0 {
+		window = time.Duration(v) * time.Millisecond
+	}
+
+	legacyValues := false
+	if v, ok := cfg.Extra["legacy_label_values"].(bool); ok {
+		legacyValues = v
+	}
+
+	// Exemplars: trace/span IDs of slow and error spans, so windows flagged
+	// downstream can be opened as a concrete trace.
+	exemplars := true
+	if v, ok := cfg.Extra["exemplars"].(bool); ok {
+		exemplars = v
+	}
+	slowSec := 0.5
+	if v, ok := cfg.Extra["exemplar_slow_threshold_ms"].(int); ok && v >= 0 {
+		slowSec = float64(v) / 1000
+	}
+
 	return &processor{
-		dimensions:         []string{"service.name","http.method","http.route","span.kind","status.code"},
+		dimensions:         parseDimensions(cfg.Extra["dimensions"]),
+		series:             newSeriesLimiter(maxSeries, window),
 		errorEventAttrDims: evtAttrDims,
 		errorEventNames:    lowerSlice(evtNames),
 		errFromStatus:      errFromStatus,
 		errFromEvents:      errFromEvents,
 		histBounds:         bounds,
 		defaultSvcAttr:     "service.name",
+		legacyLabelValues:  legacyValues,
+		exemplars:          exemplars,
+		exemplarSlowSec:    slowSec,
 	}
 }
 
@@ -98,7 +133,12 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 				out <- v
 				continue
 			}
-			rmList := p.tracesToResourceMetrics(env.Bytes)
+			rmList, err := p.tracesToResourceMetrics(env.Bytes)
+			if err != nil {
+				// Undecodable traces are reported back so the receiver can dead-letter them.
+				ack.Fail(env.Ack, ack.Malformed(err))
+				continue
+			}
 			if len(rmList) == 0 {
 				out <- v
 				continue
@@ -110,16 +150,16 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 				out <- v
 				continue
 			}
-			out <- model.Envelope{Kind: model.KindMetrics, Bytes: b, Attrs: env.Attrs, TSUnix: env.TSUnix}
+			out <- model.Envelope{Kind: model.KindMetrics, Bytes: b, Attrs: env.Attrs, TSUnix: env.TSUnix, Ack: env.Ack}
 		}
 	}
 }
 
-func (p *processor) tracesToResourceMetrics(raw []byte) []*met.ResourceMetrics {
+func (p *processor) tracesToResourceMetrics(raw []byte) ([]*met.ResourceMetrics, error) {
 	et := &colltr.ExportTraceServiceRequest{}
 	if err := proto.Unmarshal(raw, et); err != nil {
 		log.Printf("[spanmetrics] cannot unmarshal traces: %v", err)
-		return nil
+		return nil, err
 	}
 
 	var out []*met.ResourceMetrics
@@ -145,14 +185,20 @@ func (p *processor) tracesToResourceMetrics(raw []byte) []*met.ResourceMetrics {
 				// Build base labels: configured dimensions from span/resource
 				baseLabels := p.buildDimensions(sp, rAttrMap)
 
+				isErr := p.isErrorSpan(sp)
+
 				// requests_total (always 1 per span)
 				sm.Metrics = append(sm.Metrics, p.buildRequestsTotal(int64(start), int64(end), baseLabels))
 
 				// duration_seconds histogram
-				sm.Metrics = append(sm.Metrics, p.buildDurationHistogram(int64(start), int64(end), durSec, baseLabels))
+				var ex *met.Exemplar
+				if p.exemplars && (isErr || durSec >= p.exemplarSlowSec) {
+					ex = buildExemplar(sp, end, durSec, isErr)
+				}
+				sm.Metrics = append(sm.Metrics, p.buildDurationHistogram(int64(start), int64(end), durSec, baseLabels, ex))
 
 				// errors_total?
-				if p.isErrorSpan(sp) {
+				if isErr {
 					errLabels := baseLabels
 					// If error from events, try to augment labels with requested event attributes
 					if p.errFromEvents {
@@ -160,7 +206,7 @@ func (p *processor) tracesToResourceMetrics(raw []byte) []*met.ResourceMetrics {
 							errLabels = append(copyLabels(baseLabels), evL...)
 						}
 					}
-					sm.Metrics = append(sm.Metrics, p.buildErrorsTotal(int64(start), int64(end), errLabels))
+					sm.Metrics = append(sm.Metrics, p.buildErrorsTotal(int64(start), int64(end), errLabels, ex))
 				}
 			}
 		}
@@ -168,13 +214,13 @@ func (p *processor) tracesToResourceMetrics(raw []byte) []*met.ResourceMetrics {
 			out = append(out, rm)
 		}
 	}
-	return out
+	return out, nil
 }
 
 // ---- error detection ----
 
 func (p *processor) isErrorSpan(sp *tr.Span) bool {
-	if p.errFromStatus && strings.EqualFold(sp.GetStatus().GetCode().String(), "ERROR") {
+	if p.errFromStatus && sp.GetStatus().GetCode() == tr.Status_STATUS_CODE_ERROR {
 		return true
 	}
 	if p.errFromEvents {
@@ -233,13 +279,16 @@ func (p *processor) buildRequestsTotal(tsStart, tsEnd int64, labels []*com.KeyVa
 	}
 }
 
-func (p *processor) buildErrorsTotal(tsStart, tsEnd int64, labels []*com.KeyValue) *met.Metric {
+func (p *processor) buildErrorsTotal(tsStart, tsEnd int64, labels []*com.KeyValue, ex *met.Exemplar) *met.Metric {
 	dp := &met.NumberDataPoint{
 		TimeUnixNano:      uint64(tsEnd),
 		StartTimeUnixNano: uint64(tsStart),
 		Attributes:        labels,
 		Value:             &met.NumberDataPoint_AsDouble{AsDouble: 1},
 	}
+	if ex != nil {
+		dp.Exemplars = []*met.Exemplar{ex}
+	}
 	return &met.Metric{
 		Name:        "errors_total",
 		Description: "Total number of error spans (via status or error events)",
@@ -254,7 +303,7 @@ func (p *processor) buildErrorsTotal(tsStart, tsEnd int64, labels []*com.KeyValu
 	}
 }
 
-func (p *processor) buildDurationHistogram(tsStart, tsEnd int64, durSec float64, labels []*com.KeyValue) *met.Metric {
+func (p *processor) buildDurationHistogram(tsStart, tsEnd int64, durSec float64, labels []*com.KeyValue, ex *met.Exemplar) *met.Metric {
 	bidx := bucketIndex(p.histBounds, durSec)
 	bCounts := make([]uint64, len(p.histBounds)+1)
 	if bidx >= 0 && bidx < len(bCounts) {
@@ -271,6 +320,9 @@ func (p *processor) buildDurationHistogram(tsStart, tsEnd int64, durSec float64,
 		Count:             uint64(1),
 		Sum:               protoFloat64(durSec),
 	}
+	if ex != nil {
+		dp.Exemplars = []*met.Exemplar{ex}
+	}
 	return &met.Metric{
 		Name:        "duration_seconds",
 		Description: "Span duration in seconds (histogram)",
@@ -284,40 +336,45 @@ func (p *processor) buildDurationHistogram(tsStart, tsEnd int64, durSec float64,
 	}
 }
 
+// buildExemplar points at the span behind a data point. exemplar.reason tells
+// consumers whether it was picked for being slow or failing.
+func buildExemplar(sp *tr.Span, end uint64, durSec float64, isErr bool) *met.Exemplar {
+	reason := "slow"
+	if isErr {
+		reason = "error"
+	}
+	return &met.Exemplar{
+		TimeUnixNano:       end,
+		Value:              &met.Exemplar_AsDouble{AsDouble: durSec},
+		TraceId:            sp.GetTraceId(),
+		SpanId:             sp.GetSpanId(),
+		FilteredAttributes: []*com.KeyValue{strKV("exemplar.reason", reason)},
+	}
+}
+
 // ---- label/dimension helpers ----
 
 func (p *processor) buildDimensions(sp *tr.Span, resAttrs map[string]string) []*com.KeyValue {
-	labels := make([]*com.KeyValue, 0, len(p.dimensions)+4)
+	labels := make([]*com.KeyValue, 0, len(p.dimensions)+1)
 	spanAttr := attrsToMapSpan(sp.Attributes)
 
-	// Always include span.kind/status.code if requested via dimensions list
-	if in(p.dimensions, "span.kind") {
-		labels = append(labels, strKV("span.kind", strings.ToLower(sp.Kind.String())))
-	}
-	if in(p.dimensions, "status.code") {
-		labels = append(labels, strKV("status.code", strings.ToLower(sp.Status.GetCode().String())))
-	}
-
-	for _, k := range p.dimensions {
-		kn := strings.TrimSpace(k)
-		if kn == "" || kn == "span.kind" || kn == "status.code" {
-			continue
-		}
-		if v, ok := spanAttr[kn]; ok && v != "" {
-			labels = append(labels, strKV(kn, v))
+	hasSvc := false
+	for _, d := range p.dimensions {
+		v := d.value(sp, spanAttr, resAttrs, p.legacyLabelValues)
+		if v == "" {
 			continue
 		}
-		if v, ok := resAttrs[kn]; ok && v != "" {
-			labels = append(labels, strKV(kn, v))
-			continue
+		labels = append(labels, strKV(d.name, v))
+		if d.name == p.defaultSvcAttr {
+			hasSvc = true
 		}
 	}
 
 	// Ensure service.name if present in resource
-	if svc, ok := resAttrs[p.defaultSvcAttr]; ok && svc != "" {
+	if svc, ok := resAttrs[p.defaultSvcAttr]; ok && svc != "" && !hasSvc {
 		labels = append(labels, strKV(p.defaultSvcAttr, svc))
 	}
-	return labels
+	return p.series.admit(p.defaultSvcAttr, labels)
 }
 
 func attrsToMapRes(r *res.Resource) map[string]string {
@@ -326,7 +383,7 @@ func attrsToMapRes(r *res.Resource) map[string]string {
 		return out
 	}
 	for _, a := range r.Attributes {
-		if s := a.GetValue().GetStringValue(); s != "" {
+		if s := anyString(a.GetValue()); s != "" {
 			out[a.Key] = s
 		}
 	}
@@ -336,7 +393,7 @@ func attrsToMapRes(r *res.Resource) map[string]string {
 func attrsToMapSpan(xs []*com.KeyValue) map[string]string {
 	out := map[string]string{}
 	for _, a := range xs {
-		if s := a.GetValue().GetStringValue(); s != "" {
+		if s := anyString(a.GetValue()); s != "" {
 			out[a.Key] = s
 		}
 	}
@@ -398,15 +455,6 @@ func safeEnd(start, end uint64) uint64 {
 	return end
 }
 
-func in(xs []string, target string) bool {
-	for _, s := range xs {
-		if s == target {
-			return true
-		}
-	}
-	return false
-}
-
 func inLower(s string, xs []string) bool {
 	ls := strings.ToLower(s)
 	for _, t := range xs {
@@ -0,0 +1,231 @@
+package summarizer
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	prompb "github.com/prometheus/prometheus/prompb"
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// bucketRange returns the value range of explicit bucket i, following
+// histogram_quantile: the first bucket starts at 0 (when its bound is
+// positive) and the +Inf bucket collapses onto the highest finite bound.
+func bucketRange(bounds []float64, i int) (lo, hi float64) {
+	switch {
+	case i == 0:
+		if bounds[0] > 0 {
+			return 0, bounds[0]
+		}
+		return bounds[0], bounds[0]
+	case i >= len(bounds):
+		last := bounds[len(bounds)-1]
+		return last, last
+	}
+	return bounds[i-1], bounds[i]
+}
+
+// promHists gathers the _bucket series of each remote-write histogram over a
+// window (keyed by their labels minus le) so they can be de-cumulated once
+// every bucket is in, whichever requests they arrived in.
+type promHists map[string]*promHist
+
+type promHist struct {
+	st      *svc
+	buckets map[float64]float64 // le → count up to le, delta-ed over time and summed over the window
+}
+
+func (h promHists) add(st *svc, lbls []prompb.Label, le, n float64) {
+	key := promSeriesKey(lbls, "le")
+	ph, ok := h[key]
+	if !ok {
+		ph = &promHist{st: st, buckets: map[float64]float64{}}
+		h[key] = ph
+	}
+	ph.buckets[le] += n
+}
+
+// flush turns every gathered histogram into per-bucket counts and adds them to
+// the owning service's digest.
+func (h promHists) flush() {
+	for _, ph := range h {
+		les := make([]float64, 0, len(ph.buckets))
+		for le := range ph.buckets {
+			les = append(les, le)
+		}
+		sort.Float64s(les)
+		bounds := make([]float64, 0, len(les))
+		for _, le := range les {
+			if !math.IsInf(le, 1) {
+				bounds = append(bounds, le)
+			}
+		}
+		if len(bounds) == 0 {
+			continue
+		}
+		prev := 0.0
+		for i, le := range les {
+			cum := ph.buckets[le]
+			n := cum - prev
+			prev = math.Max(prev, cum)
+			if n <= 0 {
+				continue
+			}
+			lo, hi := bucketRange(bounds, i)
+			addSpread(ph.st, lo, hi, uint64(math.Round(n)))
+		}
+	}
+}
+
+// promSeriesKey identifies a remote-write series by its sorted labels,
+// leaving out any names in skip.
+func promSeriesKey(lbls []prompb.Label, skip ...string) string {
+	parts := make([]string, 0, len(lbls))
+next:
+	for _, l := range lbls {
+		for _, s := range skip {
+			if l.Name == s {
+				continue next
+			}
+		}
+		parts = append(parts, l.Name+"="+l.Value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "|")
+}
+
+// consumeExpHistogram folds an OTLP exponential histogram into the latency
+// digest. Each populated bucket's count is spread evenly across the bucket
+// (see addSpread) instead of being piled on its upper bound.
+func (p *processor) consumeExpHistogram(name, unit string, h *met.ExponentialHistogram, t target) {
+	rule := p.rules.match(name)
+	latency := isLatencyMetric(name, unit)
+	if rule != nil {
+		latency = rule.latency
+	}
+	scale := rule.scale(unit)
+	isDelta := h.GetAggregationTemporality() == met.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	for _, dp := range h.GetDataPoints() {
+		st := p.stateFor(t, dp.GetAttributes())
+		p.countHistogram(rule, name, t.res, isDelta, dp.GetCount(), dp.GetAttributes(), dp.GetExemplars(), st)
+		if !latency {
+			continue
+		}
+		p.addOTLPExemplars(st, name, "slow", dp.GetExemplars())
+		// Buckets are keyed by scale as well: a producer may rescale between
+		// exports, which renumbers every bucket.
+		prefix := name + ":exp:" + strconv.Itoa(int(dp.GetScale())) + ":"
+		count := func(key string, c uint64) uint64 {
+			if isDelta {
+				return c
+			}
+			return uint64(maxf(delta(p, seriesKey(t.res, prefix+key, dp.GetAttributes()), float64(c)), 0))
+		}
+
+		if n := count("zero", dp.GetZeroCount()); n > 0 {
+			_ = st.td.AddWeighted(0, n)
+			st.count += n
+		}
+		base := math.Exp2(math.Exp2(-float64(dp.GetScale())))
+		pos := dp.GetPositive()
+		for i, c := range pos.GetBucketCounts() {
+			idx := int(pos.GetOffset()) + i
+			n := count(strconv.Itoa(idx), c)
+			if n == 0 {
+				continue
+			}
+			// Bucket idx covers (base^idx, base^(idx+1)].
+			lo := math.Pow(base, float64(idx))
+			hi := lo * base
+			addSpread(st, lo*scale, hi*scale, n)
+		}
+		// Negative buckets cannot hold latencies; they are ignored.
+	}
+}
+
+// consumeSummary merges OTLP Summary quantiles into the latency digest. The
+// count observed this window is spread over the quantile ranges: the mass
+// between q[i] and q[i+1] is spread between their values.
+func (p *processor) consumeSummary(name, unit string, s *met.Summary, t target) {
+	rule := p.rules.match(name)
+	if rule != nil && !rule.latency || rule == nil && !isLatencyMetric(name, unit) {
+		return
+	}
+	scale := rule.scale(unit)
+	for _, dp := range s.GetDataPoints() {
+		// OTLP summaries are cumulative.
+		n := delta(p, seriesKey(t.res, name+":summary:count", dp.GetAttributes()), float64(dp.GetCount()))
+		qs := dp.GetQuantileValues()
+		if n <= 0 || len(qs) == 0 {
+			continue
+		}
+		st := p.stateFor(t, dp.GetAttributes())
+		prevQ, prevV := 0.0, qs[0].GetValue()
+		for _, q := range qs {
+			w := uint64(math.Round(n * (q.GetQuantile() - prevQ)))
+			addSpread(st, prevV*scale, q.GetValue()*scale, w)
+			prevQ, prevV = q.GetQuantile(), q.GetValue()
+		}
+		// Mass above the highest reported quantile sits at that quantile.
+		addSpread(st, prevV*scale, prevV*scale, uint64(math.Round(n*(1-prevQ))))
+	}
+}
+
+// spreadPoints bounds how many weighted samples one bucket contributes.
+const spreadPoints = 8
+
+// addSpread adds n observations spread uniformly over [lo, hi]: up to
+// spreadPoints samples at the midpoints of equal sub-ranges, sharing n as
+// their weights. A single point lands on the bucket midpoint. NaN bounds
+// (e.g. a NaN summary quantile) are skipped without counting.
+func addSpread(st *svc, lo, hi float64, n uint64) {
+	if n == 0 || math.IsNaN(lo) || math.IsNaN(hi) {
+		return
+	}
+	st.count += n
+	k := uint64(spreadPoints)
+	if n < k {
+		k = n
+	}
+	if hi <= lo {
+		_ = st.td.AddWeighted(lo, n)
+		return
+	}
+	step := (hi - lo) / float64(k)
+	for j := uint64(0); j < k; j++ {
+		w := n / k
+		if j < n%k {
+			w++
+		}
+		_ = st.td.AddWeighted(lo+step*(float64(j)+0.5), w)
+	}
+}
+
+// isLatencyMetric keeps non-latency distributions (payload sizes, GC counts)
+// that no rule claims out of the latency digest: time units, or a
+// duration-like name when the unit is missing.
+func isLatencyMetric(name, unit string) bool {
+	switch unit {
+	case "s", "ms", "us", "µs", "ns":
+		return true
+	case "", "1":
+		n := strings.ToLower(name)
+		return strings.Contains(n, "duration") || strings.Contains(n, "latency") || strings.HasSuffix(n, "_seconds")
+	}
+	return false
+}
+
+// unitScale converts a UCUM time unit to seconds.
+func unitScale(unit string) float64 {
+	switch unit {
+	case "ms":
+		return 1e-3
+	case "us", "µs":
+		return 1e-6
+	case "ns":
+		return 1e-9
+	}
+	return 1
+}
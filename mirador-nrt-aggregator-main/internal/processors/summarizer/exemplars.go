@@ -0,0 +1,51 @@
+package summarizer
+
+import (
+	"encoding/hex"
+
+	prompb "github.com/prometheus/prometheus/prompb"
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/locator"
+)
+
+// addOTLPExemplars collects exemplars from one data point. reason is used
+// when the exemplar does not carry exemplar.reason itself.
+func (p *processor) addOTLPExemplars(st *svc, metric, reason string, xs []*met.Exemplar) {
+	for _, e := range xs {
+		if len(e.GetTraceId()) == 0 {
+			continue
+		}
+		v := e.GetAsDouble()
+		if _, ok := e.GetValue().(*met.Exemplar_AsInt); ok {
+			v = float64(e.GetAsInt())
+		}
+		st.exemplars = p.loc.Add(st.exemplars, locator.Exemplar{
+			TraceID: hex.EncodeToString(e.GetTraceId()),
+			SpanID:  hex.EncodeToString(e.GetSpanId()),
+			Value:   v,
+			TSUnix:  float64(e.GetTimeUnixNano()) / 1e9,
+			Reason:  getAttr(e.GetFilteredAttributes(), "exemplar.reason", reason),
+			Metric:  metric,
+		})
+	}
+}
+
+// addPromExemplars collects remote-write exemplars (trace_id/span_id labels).
+func (p *processor) addPromExemplars(st *svc, metric, reason string, xs []prompb.Exemplar) {
+	for _, e := range xs {
+		lbls := labelsToMap(e.Labels)
+		id := firstNonEmpty(lbls["trace_id"], lbls["traceID"], lbls["TraceID"])
+		if id == "" {
+			continue
+		}
+		st.exemplars = p.loc.Add(st.exemplars, locator.Exemplar{
+			TraceID: id,
+			SpanID:  firstNonEmpty(lbls["span_id"], lbls["spanID"]),
+			Value:   e.Value,
+			TSUnix:  float64(e.Timestamp) / 1e3,
+			Reason:  reason,
+			Metric:  metric,
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package summarizer
+
+import (
+	"log"
+	"math"
+
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// gaugeSpec surfaces one gauge-like metric (CPU, queue depth, pool
+// saturation, ...) as a numeric aggregate label, so iforest can use it as
+// "labels.<feature>".
+type gaugeSpec struct {
+	feature string // label key on the aggregate
+	agg     string // avg | max | min | last
+}
+
+// gaugeState reduces every point of one gauge seen in the window.
+type gaugeState struct {
+	sum, min, max, last float64
+	lastTS              uint64
+	n                   int
+}
+
+// parseGauges reads the `gauges:` list. Items are metric names or maps:
+//
+//	gauges:
+//	  - process.cpu.utilization
+//	  - metric: queue_depth            # OTLP gauge / up-down sum, or PromRW series
+//	    feature: queue_depth_max       # default: the metric name
+//	    agg: max                       # avg (default) | max | min | last
+func parseGauges(v any) map[string]gaugeSpec {
+	xs, ok := v.([]any)
+	if !ok || len(xs) == 0 {
+		return nil
+	}
+	out := make(map[string]gaugeSpec, len(xs))
+	for _, it := range xs {
+		var metric string
+		spec := gaugeSpec{agg: "avg"}
+		switch t := it.(type) {
+		case string:
+			metric = t
+		case map[string]any:
+			metric, _ = t["metric"].(string)
+			spec.feature, _ = t["feature"].(string)
+			if a, ok := t["agg"].(string); ok && a != "" {
+				spec.agg = a
+			}
+		}
+		if metric == "" {
+			continue
+		}
+		switch spec.agg {
+		case "avg", "max", "min", "last":
+		default:
+			log.Printf("[summarizer] gauge %q: unknown agg %q, using avg", metric, spec.agg)
+			spec.agg = "avg"
+		}
+		if spec.feature == "" {
+			spec.feature = metric
+		}
+		out[metric] = spec
+	}
+	return out
+}
+
+func (st *svc) observeGauge(feature string, v float64, ts uint64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	if st.gauges == nil {
+		st.gauges = map[string]*gaugeState{}
+	}
+	g, ok := st.gauges[feature]
+	if !ok {
+		g = &gaugeState{min: v, max: v}
+		st.gauges[feature] = g
+	}
+	g.sum += v
+	g.n++
+	g.min = math.Min(g.min, v)
+	g.max = math.Max(g.max, v)
+	if ts >= g.lastTS {
+		g.last, g.lastTS = v, ts
+	}
+}
+
+func (g *gaugeState) value(agg string) float64 {
+	switch agg {
+	case "max":
+		return g.max
+	case "min":
+		return g.min
+	case "last":
+		return g.last
+	}
+	return g.sum / float64(g.n)
+}
+
+// consumeGauge records the points of a configured OTLP gauge.
+func (p *processor) consumeGauge(spec gaugeSpec, dps []*met.NumberDataPoint, t target) {
+	for _, dp := range dps {
+		p.stateFor(t, dp.GetAttributes()).observeGauge(spec.feature, numberOf(dp), dp.GetTimeUnixNano())
+	}
+}
+
+// gaugeLabels writes the reduced gauges into the aggregate labels.
+func (p *processor) gaugeLabels(st *svc) {
+	for _, spec := range p.gauges {
+		if g, ok := st.gauges[spec.feature]; ok && g.n > 0 {
+			st.labels[spec.feature] = ftoa(g.value(spec.agg))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package summarizer
+
+import (
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// overflowGroup replaces every grouping value once a service has
+// max_groups_per_service groups in the window.
+const overflowGroup = "__other__"
+
+var groupOverflow = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "mirador_summarizer_group_overflow_total",
+	Help: "Data points folded into the __other__ group by the per-service group cap.",
+})
+
+// target is the service a batch of points belongs to, plus the resource
+// attributes group_by keys may come from.
+type target struct {
+	svc string
+	res map[string]string
+}
+
+// stateFor returns the window state a point with the given OTLP attributes
+// feeds: the service's own state, or a per-operation one under group_by.
+func (p *processor) stateFor(t target, attrs []*com.KeyValue) *svc {
+	if len(p.groupBy) == 0 {
+		return p.ensureSvc(t.svc, nil)
+	}
+	return p.stateForLabels(t, dpLabels(attrs))
+}
+
+// stateForLabels is stateFor for already flattened labels (PromRW, or OTLP
+// attributes rendered by dpLabels). Keys are looked up in the point's labels
+// first, then in the resource; a point carrying none of them stays at the
+// service level.
+func (p *processor) stateForLabels(t target, labels map[string]string) *svc {
+	if len(p.groupBy) == 0 {
+		return p.ensureSvc(t.svc, nil)
+	}
+	var group map[string]string
+	for _, k := range p.groupBy {
+		v := labels[k]
+		if v == "" {
+			v = t.res[k]
+		}
+		if v == "" {
+			continue
+		}
+		if group == nil {
+			group = make(map[string]string, len(p.groupBy))
+		}
+		group[k] = v
+	}
+	if group != nil && p.maxGroups > 0 {
+		if _, seen := p.state[stateKey(t.svc, group)]; !seen && p.groups[t.svc] >= p.maxGroups {
+			groupOverflow.Inc()
+			for k := range group {
+				group[k] = overflowGroup
+			}
+		}
+	}
+	return p.ensureSvc(t.svc, group)
+}
+
+// stateKey keys p.state; it matches the aggregate's SeriesKey.
+func stateKey(name string, group map[string]string) string {
+	return model.Aggregate{Service: name, Group: group}.SeriesKey()
+}
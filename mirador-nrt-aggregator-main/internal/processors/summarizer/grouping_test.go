@@ -0,0 +1,126 @@
+package summarizer
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+func groupedProc(maxGroups int, groupBy ...any) *processor {
+	return New(config.ProcessorCfg{Extra: map[string]any{
+		"group_by":               groupBy,
+		"max_groups_per_service": maxGroups,
+	}})
+}
+
+func stateKeys(p *processor) []string {
+	var out []string
+	for k := range p.state {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestStateForLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		res       map[string]string
+		labels    map[string]string
+		wantGroup map[string]string
+	}{
+		{
+			name:      "point labels",
+			labels:    map[string]string{"http.route": "/cart", "code": "200"},
+			wantGroup: map[string]string{"http.route": "/cart"},
+		},
+		{
+			name:      "resource fills keys the point lacks",
+			res:       map[string]string{"k8s.namespace.name": "shop", "http.route": "/res"},
+			labels:    map[string]string{"http.route": "/cart"},
+			wantGroup: map[string]string{"http.route": "/cart", "k8s.namespace.name": "shop"},
+		},
+		{
+			name:      "resource only",
+			res:       map[string]string{"k8s.namespace.name": "shop"},
+			wantGroup: map[string]string{"k8s.namespace.name": "shop"},
+		},
+		{
+			name:   "empty values do not group",
+			labels: map[string]string{"http.route": ""},
+		},
+		{
+			name:   "no keys stays at the service level",
+			labels: map[string]string{"code": "200"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := groupedProc(100, "http.route", "k8s.namespace.name")
+			st := p.stateForLabels(target{svc: "api", res: tt.res}, tt.labels)
+			if !reflect.DeepEqual(st.group, tt.wantGroup) {
+				t.Fatalf("group = %v, want %v", st.group, tt.wantGroup)
+			}
+			if p.state[stateKey("api", tt.wantGroup)] != st {
+				t.Fatalf("state not stored under its series key; keys %v", stateKeys(p))
+			}
+		})
+	}
+}
+
+func TestStateForWithoutGroupBy(t *testing.T) {
+	p := groupedProc(100)
+	attrs := []*com.KeyValue{{Key: "http.route", Value: &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: "/cart"}}}}
+	if st := p.stateFor(target{svc: "api"}, attrs); st.group != nil {
+		t.Fatalf("group = %v without group_by", st.group)
+	}
+}
+
+func TestStateForRendersAttributes(t *testing.T) {
+	p := groupedProc(100, "rpc.grpc.status_code")
+	attrs := []*com.KeyValue{{Key: "rpc.grpc.status_code", Value: &com.AnyValue{Value: &com.AnyValue_IntValue{IntValue: 14}}}}
+	st := p.stateFor(target{svc: "api"}, attrs)
+	if want := map[string]string{"rpc.grpc.status_code": "14"}; !reflect.DeepEqual(st.group, want) {
+		t.Fatalf("group = %v, want %v", st.group, want)
+	}
+}
+
+func TestGroupOverflow(t *testing.T) {
+	p := groupedProc(2, "http.route", "rpc.method")
+	tg := target{svc: "api"}
+	a := p.stateForLabels(tg, map[string]string{"http.route": "/a"})
+	b := p.stateForLabels(tg, map[string]string{"http.route": "/b", "rpc.method": "Get"})
+	c := p.stateForLabels(tg, map[string]string{"http.route": "/c", "rpc.method": "Put"})
+	d := p.stateForLabels(tg, map[string]string{"http.route": "/d", "rpc.method": "Del"})
+
+	if want := map[string]string{"http.route": "__other__", "rpc.method": "__other__"}; !reflect.DeepEqual(c.group, want) {
+		t.Fatalf("overflow group = %v, want %v", c.group, want)
+	}
+	if c != d {
+		t.Fatal("overflowing groups of the same keys do not share __other__")
+	}
+	// Known groups keep their own state past the cap.
+	if p.stateForLabels(tg, map[string]string{"http.route": "/a"}) != a || p.stateForLabels(tg, map[string]string{"http.route": "/b", "rpc.method": "Get"}) != b {
+		t.Fatal("a group seen before the cap was folded")
+	}
+	// The service-level state and other services are not capped.
+	if st := p.stateForLabels(tg, nil); st.group != nil {
+		t.Fatalf("service-level group = %v", st.group)
+	}
+	if st := p.stateForLabels(target{svc: "web"}, map[string]string{"http.route": "/c"}); st.group["http.route"] != "/c" {
+		t.Fatalf("other service folded: %v", st.group)
+	}
+}
+
+func TestGroupCapDisabled(t *testing.T) {
+	p := groupedProc(0, "http.route")
+	for _, r := range []string{"/a", "/b", "/c", "/d"} {
+		if st := p.stateForLabels(target{svc: "api"}, map[string]string{"http.route": r}); st.group["http.route"] != r {
+			t.Fatalf("route %s folded with max_groups_per_service: 0", r)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package summarizer
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// redRule maps metric names to their RED role(s). Rules are tried in order,
+// first match wins: the configured red_rules, then the red_presets.
+type redRule struct {
+	re        *regexp.Regexp
+	request   bool // each count is a request; error_when decides ok vs error
+	error     bool // each count is an error (and a request)
+	latency   bool // distribution of durations → latency digest
+	errorWhen []predicate
+	unit      string // overrides the metric's own unit ("ms", "us", "s", ...)
+}
+
+// predicate is one `label op value` test; ops: == != > >= < <= =~ !~.
+// A missing label never matches.
+type predicate struct {
+	label string
+	op    string
+	value string
+	num   float64
+	isNum bool
+	re    *regexp.Regexp
+}
+
+var predicateRE = regexp.MustCompile(`^\s*([A-Za-z_][\w.\-]*)\s*(==|!=|>=|<=|=~|!~|>|<)\s*(.*?)\s*$`)
+
+// okStatus mirrors what the summarizer always treated as a successful status.
+const okStatus = `(?i)ok|unset|2.*`
+
+// redPresets are shipped rule sets, referenced by name from red_presets.
+var redPresets = map[string][]map[string]any{
+	// OpenTelemetry semantic conventions (OTLP names and their Prometheus
+	// translations).
+	"otel_semconv": {
+		{"match": `^http\.server\.request\.duration$`, "roles": []any{"latency", "request"},
+			"error_when": []any{"http.response.status_code >= 500", "error.type =~ .+"}},
+		{"match": `^http\.server\.duration$`, "roles": []any{"latency", "request"},
+			"error_when": []any{"http.status_code >= 500"}},
+		{"match": `^rpc\.server\.duration$`, "roles": []any{"latency", "request"},
+			"error_when": []any{"rpc.grpc.status_code > 0", "error.type =~ .+"}},
+		{"match": `^messaging\.process\.duration$`, "roles": []any{"latency", "request"},
+			"error_when": []any{"error.type =~ .+"}},
+		{"match": `^http_server_request_duration_seconds_count$`, "role": "request",
+			"error_when": []any{"http_response_status_code >= 500", "error_type =~ .+"}},
+		{"match": `^(http_server_request|rpc_server)_duration_seconds_bucket$`, "role": "latency"},
+	},
+	// go-grpc-prometheus / grpc-ecosystem middleware.
+	"grpc": {
+		{"match": `^grpc_server_handled_total$`, "role": "request", "error_when": []any{"grpc_code != OK"}},
+		{"match": `^grpc_server_handling_seconds_bucket$`, "role": "latency"},
+	},
+	// Micrometer / Spring Boot.
+	"micrometer": {
+		{"match": `^http_server_requests_seconds_count$`, "role": "request",
+			"error_when": []any{"status >= 500", "outcome == SERVER_ERROR"}},
+		{"match": `^http_server_requests_seconds_bucket$`, "role": "latency"},
+	},
+	// The original suffix conventions (spanmetrics output, *_requests_total).
+	"legacy": {
+		{"match": `requests_total$`, "role": "request",
+			"error_when": []any{"status.code !~ " + okStatus, "status_code !~ " + okStatus, "code !~ " + okStatus}},
+		{"match": `errors_total$`, "role": "error"},
+		{"match": `_duration_seconds_bucket$`, "role": "latency"},
+	},
+}
+
+var defaultPresets = []any{"otel_semconv", "grpc", "micrometer", "legacy"}
+
+// redRules is the ordered rule list plus a per-name match cache.
+type redRules struct {
+	rules []*redRule
+	cache map[string]*redRule // nil entries cache misses
+}
+
+// maxRuleCache bounds the per-name cache; past it lookups are uncached.
+const maxRuleCache = 10000
+
+// parseRules builds the rule list from red_rules and red_presets.
+//
+//	red_rules:
+//	  - match: '^checkout_latency_ms$'
+//	    role: latency                  # request | error | latency (or roles: [...])
+//	    unit: ms
+//	  - match: '^jobs_processed_total$'
+//	    role: request
+//	    error_when: ['result != success']
+//	red_presets: [otel_semconv, grpc, micrometer, legacy]   # default: all
+func parseRules(extra map[string]any) *redRules {
+	rs := &redRules{cache: map[string]*redRule{}}
+	if xs, ok := extra["red_rules"].([]any); ok {
+		for _, it := range xs {
+			if m, ok := it.(map[string]any); ok {
+				if r := parseRule(m); r != nil {
+					rs.rules = append(rs.rules, r)
+				}
+			}
+		}
+	}
+	presets := defaultPresets
+	if xs, ok := extra["red_presets"].([]any); ok {
+		presets = xs
+	}
+	for _, it := range presets {
+		name, _ := it.(string)
+		defs, ok := redPresets[name]
+		if !ok {
+			log.Printf("[summarizer] unknown red preset %q", name)
+			continue
+		}
+		for _, m := range defs {
+			if r := parseRule(m); r != nil {
+				rs.rules = append(rs.rules, r)
+			}
+		}
+	}
+	return rs
+}
+
+func parseRule(m map[string]any) *redRule {
+	pat, _ := m["match"].(string)
+	re, err := regexp.Compile(pat)
+	if pat == "" || err != nil {
+		log.Printf("[summarizer] red rule: bad match %q: %v", pat, err)
+		return nil
+	}
+	r := &redRule{re: re}
+	r.unit, _ = m["unit"].(string)
+	roles := []any{m["role"]}
+	if xs, ok := m["roles"].([]any); ok {
+		roles = xs
+	}
+	for _, it := range roles {
+		switch it {
+		case "request":
+			r.request = true
+		case "error":
+			r.error = true
+		case "latency":
+			r.latency = true
+		case nil:
+		default:
+			log.Printf("[summarizer] red rule %q: unknown role %v", pat, it)
+		}
+	}
+	if !r.request && !r.error && !r.latency {
+		log.Printf("[summarizer] red rule %q: no role, skipped", pat)
+		return nil
+	}
+	if xs, ok := m["error_when"].([]any); ok {
+		for _, it := range xs {
+			s, _ := it.(string)
+			pr, ok := parsePredicate(s)
+			if !ok {
+				log.Printf("[summarizer] red rule %q: bad predicate %q", pat, s)
+				continue
+			}
+			r.errorWhen = append(r.errorWhen, pr)
+		}
+	}
+	return r
+}
+
+func parsePredicate(s string) (predicate, bool) {
+	m := predicateRE.FindStringSubmatch(s)
+	if m == nil {
+		return predicate{}, false
+	}
+	pr := predicate{label: m[1], op: m[2], value: strings.Trim(m[3], `"'`)}
+	switch pr.op {
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + pr.value + ")$")
+		if err != nil {
+			return predicate{}, false
+		}
+		pr.re = re
+	default:
+		if f, err := strconv.ParseFloat(pr.value, 64); err == nil {
+			pr.num, pr.isNum = f, true
+		}
+	}
+	return pr, true
+}
+
+func (pr predicate) match(labels map[string]string) bool {
+	v, ok := labels[pr.label]
+	if !ok || v == "" {
+		return false
+	}
+	switch pr.op {
+	case "=~":
+		return pr.re.MatchString(v)
+	case "!~":
+		return !pr.re.MatchString(v)
+	case "==":
+		return v == pr.value
+	case "!=":
+		return v != pr.value
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || !pr.isNum {
+		return false
+	}
+	switch pr.op {
+	case ">":
+		return f > pr.num
+	case ">=":
+		return f >= pr.num
+	case "<":
+		return f < pr.num
+	case "<=":
+		return f <= pr.num
+	}
+	return false
+}
+
+// match returns the first rule for name, or nil.
+func (rs *redRules) match(name string) *redRule {
+	if r, ok := rs.cache[name]; ok {
+		return r
+	}
+	var hit *redRule
+	for _, r := range rs.rules {
+		if r.re.MatchString(name) {
+			hit = r
+			break
+		}
+	}
+	if len(rs.cache) < maxRuleCache {
+		rs.cache[name] = hit
+	}
+	return hit
+}
+
+// isError reports whether any error_when predicate holds for labels.
+func (r *redRule) isError(labels map[string]string) bool {
+	for _, pr := range r.errorWhen {
+		if pr.match(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// scale converts the rule's (or else the metric's) unit to seconds.
+func (r *redRule) scale(metricUnit string) float64 {
+	if r != nil && r.unit != "" {
+		return unitScale(r.unit)
+	}
+	return unitScale(metricUnit)
+}
+
+// promUnit guesses a remote-write series' unit from its name.
+func promUnit(name string) string {
+	switch {
+	case strings.Contains(name, "_milliseconds"):
+		return "ms"
+	case strings.Contains(name, "_microseconds"):
+		return "us"
+	}
+	return "s"
+}
+
+// dpLabels renders OTLP data point attributes for predicate matching.
+func dpLabels(xs []*com.KeyValue) map[string]string {
+	out := make(map[string]string, len(xs))
+	for _, a := range xs {
+		switch v := a.GetValue().GetValue().(type) {
+		case *com.AnyValue_StringValue:
+			out[a.Key] = v.StringValue
+		case *com.AnyValue_IntValue:
+			out[a.Key] = strconv.FormatInt(v.IntValue, 10)
+		case *com.AnyValue_DoubleValue:
+			out[a.Key] = strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+		case *com.AnyValue_BoolValue:
+			out[a.Key] = strconv.FormatBool(v.BoolValue)
+		}
+	}
+	return out
+}
@@ -0,0 +1,228 @@
+package summarizer
+
+import (
+	"strings"
+	"testing"
+
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+func TestParsePredicate(t *testing.T) {
+	tests := []struct {
+		in      string
+		ok      bool
+		label   string
+		op      string
+		value   string
+		numeric bool
+	}{
+		{"http.response.status_code >= 500", true, "http.response.status_code", ">=", "500", true},
+		{"grpc_code!=OK", true, "grpc_code", "!=", "OK", false},
+		{`outcome == "SERVER_ERROR"`, true, "outcome", "==", "SERVER_ERROR", false},
+		{"error.type =~ .+", true, "error.type", "=~", ".+", false},
+		{"status !~ 2..", true, "status", "!~", "2..", false},
+		{"status =~ (", false, "", "", "", false},
+		{"no operator", false, "", "", "", false},
+		{"", false, "", "", "", false},
+	}
+	for _, tt := range tests {
+		pr, ok := parsePredicate(tt.in)
+		if ok != tt.ok {
+			t.Errorf("parsePredicate(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if pr.label != tt.label || pr.op != tt.op || pr.value != tt.value || pr.isNum != tt.numeric {
+			t.Errorf("parsePredicate(%q) = %+v", tt.in, pr)
+		}
+	}
+}
+
+func TestPredicateMatch(t *testing.T) {
+	tests := []struct {
+		pred   string
+		labels map[string]string
+		want   bool
+	}{
+		{"code >= 500", map[string]string{"code": "503"}, true},
+		{"code >= 500", map[string]string{"code": "500"}, true},
+		{"code >= 500", map[string]string{"code": "404"}, false},
+		{"code > 0", map[string]string{"code": "1"}, true},
+		{"code < 300", map[string]string{"code": "200"}, true},
+		{"code <= 299", map[string]string{"code": "300"}, false},
+		{"code >= 500", map[string]string{"code": "5xx"}, false}, // non-numeric label
+		{"code > high", map[string]string{"code": "600"}, false}, // non-numeric value
+		{"code >= 500", map[string]string{}, false},              // missing label
+		{"code != OK", map[string]string{}, false},               // missing never matches
+		{"code !~ 2..", map[string]string{}, false},
+		{"code != OK", map[string]string{"code": ""}, false}, // empty counts as missing
+		{"code != OK", map[string]string{"code": "NOT_FOUND"}, true},
+		{"code == OK", map[string]string{"code": "OK"}, true},
+		{"error.type =~ time", map[string]string{"error.type": "timeout"}, false}, // anchored
+		{"error.type =~ time.*", map[string]string{"error.type": "timeout"}, true},
+		{"error.type =~ a|b", map[string]string{"error.type": "ab"}, false}, // alternation anchored too
+		{"status !~ 2..", map[string]string{"status": "204"}, false},
+		{"status !~ 2..", map[string]string{"status": "1204"}, true},
+	}
+	for _, tt := range tests {
+		pr, ok := parsePredicate(tt.pred)
+		if !ok {
+			t.Fatalf("parsePredicate(%q) failed", tt.pred)
+		}
+		if got := pr.match(tt.labels); got != tt.want {
+			t.Errorf("%q on %v = %v, want %v", tt.pred, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestRulePrecedence(t *testing.T) {
+	rs := parseRules(map[string]any{
+		"red_rules": []any{
+			map[string]any{"match": `^http_server_requests_seconds_count$`, "role": "request", "error_when": []any{"status >= 400"}},
+			map[string]any{"match": `^checkout_latency_ms$`, "role": "latency", "unit": "ms"},
+			map[string]any{"match": `(`, "role": "latency"},    // bad regex: skipped
+			map[string]any{"match": `^x$`, "role": "nonsense"}, // no role: skipped
+		},
+	})
+	tests := []struct {
+		name    string
+		metric  string
+		labels  map[string]string
+		wantNil bool
+		request bool
+		latency bool
+		isError bool
+	}{
+		// red_rules beat the micrometer preset (which errors only on >= 500).
+		{name: "red_rules first", metric: "http_server_requests_seconds_count", labels: map[string]string{"status": "404"}, request: true, isError: true},
+		{name: "user latency rule", metric: "checkout_latency_ms", latency: true},
+		{name: "otel_semconv", metric: "http.server.request.duration", labels: map[string]string{"http.response.status_code": "502"}, request: true, latency: true, isError: true},
+		{name: "grpc", metric: "grpc_server_handled_total", labels: map[string]string{"grpc_code": "Unavailable"}, request: true, isError: true},
+		{name: "grpc OK", metric: "grpc_server_handled_total", labels: map[string]string{"grpc_code": "OK"}, request: true},
+		// grpc comes before legacy's requests_total$ suffix rule.
+		{name: "legacy suffix", metric: "calls_requests_total", labels: map[string]string{"code": "500"}, request: true, isError: true},
+		{name: "no rule", metric: "process_cpu_seconds_total", wantNil: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rs.match(tt.metric)
+			if tt.wantNil {
+				if r != nil {
+					t.Fatalf("matched %s", r.re)
+				}
+				return
+			}
+			if r == nil {
+				t.Fatal("no rule matched")
+			}
+			if r.request != tt.request || r.latency != tt.latency {
+				t.Errorf("roles request=%v latency=%v, want %v %v", r.request, r.latency, tt.request, tt.latency)
+			}
+			if got := r.isError(tt.labels); got != tt.isError {
+				t.Errorf("isError = %v, want %v", got, tt.isError)
+			}
+		})
+	}
+	// Cached lookups answer the same.
+	if r := rs.match("checkout_latency_ms"); r == nil || !r.latency {
+		t.Fatal("cached lookup lost the rule")
+	}
+}
+
+func TestPresetSelection(t *testing.T) {
+	rs := parseRules(map[string]any{"red_presets": []any{"legacy", "unknown"}})
+	if rs.match("http.server.request.duration") != nil {
+		t.Fatal("otel_semconv rule active without its preset")
+	}
+	if rs.match("api_requests_total") == nil {
+		t.Fatal("legacy preset not loaded")
+	}
+}
+
+// baselineOK is the summarizer's original isOKStatus.
+func baselineOK(code string) bool {
+	c := strings.ToLower(code)
+	switch c {
+	case "ok", "unset", "2xx", "200", "201", "202", "204":
+		return true
+	}
+	return strings.HasPrefix(c, "2")
+}
+
+func TestLegacyPresetMatchesIsOKStatus(t *testing.T) {
+	rs := parseRules(map[string]any{"red_presets": []any{"legacy"}})
+	r := rs.match("http_requests_total")
+	if r == nil {
+		t.Fatal("legacy request rule missing")
+	}
+	codes := []string{
+		"OK", "ok", "Unset", "UNSET", "2xx", "200", "204", "299", "2",
+		"500", "404", "1xx", "ERROR", "STATUS_CODE_ERROR", "STATUS_CODE_OK", "okay",
+	}
+	for _, label := range []string{"status.code", "status_code", "code"} {
+		for _, code := range codes {
+			got := r.isError(map[string]string{label: code})
+			if want := !baselineOK(code); got != want {
+				t.Errorf("%s=%q: isError = %v, want %v", label, code, got, want)
+			}
+		}
+	}
+	if r.isError(map[string]string{}) {
+		t.Error("no status label counted as an error")
+	}
+	if r := rs.match("http_errors_total"); r == nil || !r.error {
+		t.Error("errors_total is not an error rule")
+	}
+}
+
+func TestScale(t *testing.T) {
+	ms := &redRule{unit: "ms"}
+	tests := []struct {
+		name string
+		r    *redRule
+		unit string
+		want float64
+	}{
+		{"rule unit ms", ms, "", 1e-3},
+		{"rule unit overrides metric unit", ms, "s", 1e-3},
+		{"metric unit us", &redRule{}, "us", 1e-6},
+		{"no rule, metric unit ns", nil, "ns", 1e-9},
+		{"seconds", nil, "s", 1},
+		{"unknown unit", nil, "By", 1},
+		{"prom name in ms", nil, promUnit("rpc_latency_milliseconds_bucket"), 1e-3},
+		{"prom name in us", nil, promUnit("db_query_microseconds_bucket"), 1e-6},
+		{"prom name in s", nil, promUnit("http_request_duration_seconds_bucket"), 1},
+	}
+	for _, tt := range tests {
+		if got := tt.r.scale(tt.unit); got != tt.want {
+			t.Errorf("%s: scale = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A rule's unit turns millisecond buckets into seconds in the digest.
+func TestRuleUnitScalesLatency(t *testing.T) {
+	p := New(config.ProcessorCfg{WindowSeconds: 60, Extra: map[string]any{
+		"red_rules": []any{map[string]any{"match": `^checkout_latency$`, "role": "latency", "unit": "ms"}},
+	}})
+	h := &met.Histogram{
+		AggregationTemporality: met.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+		DataPoints: []*met.HistogramDataPoint{{
+			Count:          10,
+			ExplicitBounds: []float64{100, 1000},
+			BucketCounts:   []uint64{0, 10, 0},
+		}},
+	}
+	p.consumeHistogram("checkout_latency", "", h, target{svc: "api"})
+	st := p.state[stateKey("api", nil)]
+	if st == nil || st.td == nil || st.td.Count() == 0 {
+		t.Fatal("latency digest not fed")
+	}
+	if q := st.td.Quantile(0.5); q < 0.1 || q > 1 {
+		t.Fatalf("p50 = %v s, want within the 100ms-1s bucket", q)
+	}
+}
@@ -3,13 +3,17 @@ package summarizer
 import (
 	"context"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/caio/go-tdigest/v4"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/locator"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/sketch"
 
 	prompb "github.com/prometheus/prometheus/prompb"
 	"google.golang.org/protobuf/proto"
@@ -24,22 +28,42 @@ import (
 // for latency percentiles and simple counters for RPS & error-rate.
 type processor struct {
 	windowSec        int
-	svcAttr          string          // attribute to identify service (default "service.name")
-	bucketSampleCap  int             // cap synthetic samples per bucket to bound cost (default 50)
-	state            map[string]*svc // per service state for current window
-	last             map[string]float64
+	quantiles        []float64             // extra quantiles for Aggregate.Quantiles (config `quantiles:`)
+	emitSketch       bool                  // attach mergeable state (Aggregate.Sketch) for rollups
+	svcAttr          string                // attribute to identify service (default "service.name")
+	state            map[string]*svc       // per service (or service+group) state for current window
+	last             map[string]lastSample // cumulative series → previous sample (see delta)
+	windows          int64                 // windows flushed so far; ages last
+	lastTTL          int64                 // windows a cumulative series may go unseen; 0 = forever
 	acceptOTLP       bool
 	acceptPromRemote bool
+	acks             ack.Batch // acks of envelopes folded into the current window
+	loc              locator.Config
+	gauges           map[string]gaugeSpec // metric name → feature surfaced in Labels
+	rules            *redRules            // metric name → RED role (see rules.go)
+	groupBy          []string             // label keys splitting a service into per-operation aggregates
+	maxGroups        int                  // distinct groups per service per window; 0 = unlimited
+	groups           map[string]int       // groups seen per service in the current window
+	hists            promHists            // remote-write histogram buckets of the current window
 }
 
 type svc struct {
+	name  string
+	group map[string]string // nil at the service level
 	td    *tdigest.TDigest
 	req   float64
 	ok    float64
 	err   float64
 	count uint64
+	// scrape health from promscrape "up" series (successful/failed scrapes this window)
+	scrapesUp   float64
+	scrapesDown float64
 	// You can stash useful facets here in future (e.g., top routes) and pass in Labels.
 	labels map[string]string
+	// exemplars are the traces worth opening for this window (see exemplars.go)
+	exemplars []locator.Exemplar
+	// configured gauges reduced over the window, by feature
+	gauges map[string]*gaugeState
 }
 
 func New(cfg config.ProcessorCfg) *processor {
@@ -48,31 +72,39 @@ func New(cfg config.ProcessorCfg) *processor {
 		w = 60
 	}
 	svcAttr := cfg.ExtraString("service_attribute", "service.name")
-	cap := 50
-	if v, ok := cfg.Extra["bucket_sample_cap"]; ok {
-		switch t := v.(type) {
-		case int:
-			if t > 0 {
-				cap = t
-			}
-		case float64:
-			if t > 0 {
-				cap = int(t)
-			}
-		case string:
-			if n, err := strconv.Atoi(t); err == nil && n > 0 {
-				cap = n
+	maxGroups := 100
+	if v, ok := cfg.Extra["max_groups_per_service"].(int); ok && v >= 0 {
+		maxGroups = v
+	}
+	var groupBy []string
+	if xs, ok := cfg.Extra["group_by"].([]any); ok {
+		for _, it := range xs {
+			if s, ok := it.(string); ok && s != "" {
+				groupBy = append(groupBy, s)
 			}
 		}
 	}
+	lastTTL := int64(10)
+	if v, ok := cfg.Extra["series_ttl_windows"].(int); ok && v >= 0 {
+		lastTTL = int64(v)
+	}
 	return &processor{
 		windowSec:        w,
+		quantiles:        cfg.Quantiles,
+		emitSketch:       cfg.ExtraBool("emit_sketch", false),
 		svcAttr:          svcAttr,
-		bucketSampleCap:  cap,
 		state:            map[string]*svc{},
-		last:             map[string]float64{},
+		last:             map[string]lastSample{},
+		lastTTL:          lastTTL,
 		acceptOTLP:       true,
 		acceptPromRemote: true,
+		loc:              locator.NewConfig(cfg),
+		gauges:           parseGauges(cfg.Extra["gauges"]),
+		rules:            parseRules(cfg.Extra),
+		groupBy:          groupBy,
+		maxGroups:        maxGroups,
+		groups:           map[string]int{},
+		hists:            promHists{},
 	}
 }
 
@@ -102,12 +134,20 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 			switch env.Kind {
 			case model.KindMetrics:
 				if p.acceptOTLP {
-					p.consumeOTLPMetrics(env.Bytes, winStart)
+					if err := p.consumeOTLPMetrics(env.Bytes, winStart); err != nil {
+						ack.Fail(env.Ack, ack.Malformed(err))
+						continue
+					}
 				}
+				p.acks.Add(env.Ack)
 			case model.KindPromRW:
 				if p.acceptPromRemote {
-					p.consumePromRW(env.Bytes, winStart)
+					if err := p.consumePromRW(env.Bytes, winStart, env.Attrs[model.AttrCumulative] == "true"); err != nil {
+						ack.Fail(env.Ack, ack.Malformed(err))
+						continue
+					}
 				}
+				p.acks.Add(env.Ack)
 			default:
 				// Not a metrics envelope → pass along
 				out <- v
@@ -124,13 +164,23 @@ func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) er
 
 func (p *processor) flush(out chan<- any, winStart int64) {
 	winEnd := winStart + int64(p.windowSec)
-	for svcName, st := range p.state {
+	p.hists.flush()
+	acks := p.acks.Release(len(p.state))
+	i := 0
+	for _, st := range p.state {
 		// Quantiles
 		var p50, p95, p99 float64
+		var qs map[string]float64
 		if st.td != nil && st.td.Count() > 0 {
 			p50 = st.td.Quantile(0.50)
 			p95 = st.td.Quantile(0.95)
 			p99 = st.td.Quantile(0.99)
+			if len(p.quantiles) > 0 {
+				qs = make(map[string]float64, len(p.quantiles))
+				for _, q := range p.quantiles {
+					qs[model.QuantileKey(q)] = st.td.Quantile(q)
+				}
+			}
 		}
 		// Rates
 		rps := st.req / float64(p.windowSec)
@@ -139,90 +189,176 @@ func (p *processor) flush(out chan<- any, winStart int64) {
 			errRate = st.err / total
 		}
 
+		if st.scrapesUp+st.scrapesDown > 0 {
+			st.labels["scrapes_up"] = ftoa(st.scrapesUp)
+			st.labels["scrapes_down"] = ftoa(st.scrapesDown)
+			st.labels["scrape_failure_ratio"] = ftoa(st.scrapesDown / (st.scrapesUp + st.scrapesDown))
+		}
+		p.gaugeLabels(st)
+
 		agg := model.Aggregate{
-			Service:     svcName,
+			Service:     st.name,
+			Group:       st.group,
 			WindowStart: winStart,
 			WindowEnd:   winEnd,
 			Count:       st.count,
 			P50:         p50,
 			P95:         p95,
 			P99:         p99,
+			Quantiles:   qs,
 			RPS:         rps,
 			ErrorRate:   errRate,
 			Labels:      st.labels,
-			Locator:     "{}",
-			SummaryText: buildSummaryText(svcName, rps, errRate, st.count),
+			Locator:     p.loc.Build(st.name, winStart, winEnd, st.exemplars),
+			SummaryText: buildSummaryText(st.name, st.group, rps, errRate, st.count),
+			Ack:         acks[i],
+		}
+		if p.emitSketch {
+			agg.Sketch = &model.Sketch{Digest: sketch.EncodeDigest(st.td), Requests: st.req, OK: st.ok, Errors: st.err}
 		}
+		i++
 		out <- agg
 	}
 	// reset window state (not the delta map)
 	p.state = map[string]*svc{}
+	p.groups = map[string]int{}
+	p.hists = promHists{}
+	p.expireLast()
+}
+
+// expireLast ends the window for delta: baselines of cumulative series not
+// seen for lastTTL windows (gone pods, rotated label values) are dropped, so
+// the map does not grow with every series ever seen.
+func (p *processor) expireLast() {
+	p.windows++
+	if p.lastTTL <= 0 {
+		return
+	}
+	for k, s := range p.last {
+		if p.windows-s.win > p.lastTTL {
+			delete(p.last, k)
+		}
+	}
 }
 
 // ---------------- OTLP Metrics ----------------
 
-func (p *processor) consumeOTLPMetrics(raw []byte, winStart int64) {
+func (p *processor) consumeOTLPMetrics(raw []byte, winStart int64) error {
 	var em coll.ExportMetricsServiceRequest
 	if err := proto.Unmarshal(raw, &em); err != nil {
 		log.Printf("[summarizer] failed to unmarshal OTLP metrics: %v", err)
-		return
+		return err
 	}
 	for _, rm := range em.ResourceMetrics {
 		resAttrs := attrsToMap(rm.GetResource())
 		svcName := firstNonEmpty(resAttrs[p.svcAttr], resAttrs["service"], resAttrs["service.name"], "unknown")
-		st := p.ensureSvc(svcName)
+		t := target{svc: svcName, res: resAttrs}
 
 		for _, sm := range rm.ScopeMetrics {
 			for _, m := range sm.Metrics {
 				switch d := m.Data.(type) {
 				case *met.Metric_Sum:
-					p.consumeSum(m.GetName(), resAttrs, d.Sum, st)
+					if g, ok := p.gauges[m.GetName()]; ok && !d.Sum.GetIsMonotonic() {
+						// up-down counters (queue depth, pool usage) read like gauges
+						p.consumeGauge(g, d.Sum.GetDataPoints(), t)
+						continue
+					}
+					p.consumeSum(m.GetName(), d.Sum, t)
 				case *met.Metric_Histogram:
-					p.consumeHistogram(m.GetName(), resAttrs, d.Histogram, st)
+					p.consumeHistogram(m.GetName(), m.GetUnit(), d.Histogram, t)
 				case *met.Metric_ExponentialHistogram:
-					// Not supported yet: skip
-				default:
-					// Gauge or Summary → ignore for NRT RED
+					p.consumeExpHistogram(m.GetName(), m.GetUnit(), d.ExponentialHistogram, t)
+				case *met.Metric_Summary:
+					p.consumeSummary(m.GetName(), m.GetUnit(), d.Summary, t)
+				case *met.Metric_Gauge:
+					if g, ok := p.gauges[m.GetName()]; ok {
+						p.consumeGauge(g, d.Gauge.GetDataPoints(), t)
+					}
 				}
 			}
 		}
 	}
+	return nil
 }
 
-func (p *processor) consumeSum(name string, res map[string]string, s *met.Sum, st *svc) {
+func (p *processor) consumeSum(name string, s *met.Sum, t target) {
+	rule := p.rules.match(name)
+	if rule == nil || (!rule.request && !rule.error) {
+		return
+	}
 	// We treat SUM datapoints as counters, compute delta by series key
 	isDelta := s.GetAggregationTemporality() == met.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
 	for _, dp := range s.GetDataPoints() {
 		val := numberOf(dp)
 		if !isDelta {
-			key := seriesKey(res, name, dp.GetAttributes())
+			key := seriesKey(t.res, name, dp.GetAttributes())
 			val = delta(p, key, val)
 		}
-		if strings.HasSuffix(name, "requests_total") {
-			st.req += val
-			// error OR ok decision based on status code attr if present
-			code := getAttr(dp.GetAttributes(), "status.code", "")
-			if code == "" {
-				code = getAttr(dp.GetAttributes(), "status_code", "")
-			}
-			if code != "" && !isOKStatus(code) {
-				st.err += val
-			} else {
-				st.ok += val
-			}
-			st.count += uint64(maxf(val, 0))
+		labels := dpLabels(dp.GetAttributes())
+		st := p.stateForLabels(t, labels)
+		if p.countRequests(rule, labels, val, st) {
+			p.addOTLPExemplars(st, name, "error", dp.GetExemplars())
 		}
-		if strings.HasSuffix(name, "errors_total") {
-			st.err += val
+	}
+}
+
+// countHistogram counts a distribution's observations as requests when its
+// rule has a request/error role (e.g. http.server.request.duration).
+func (p *processor) countHistogram(rule *redRule, name string, res map[string]string, isDelta bool, count uint64, attrs []*com.KeyValue, exs []*met.Exemplar, st *svc) {
+	if rule == nil || (!rule.request && !rule.error) {
+		return
+	}
+	n := float64(count)
+	if !isDelta {
+		n = delta(p, seriesKey(res, name+":count", attrs), n)
+	}
+	cnt := st.count
+	if p.countRequests(rule, dpLabels(attrs), n, st) {
+		p.addOTLPExemplars(st, name, "error", exs)
+	}
+	if rule.latency {
+		st.count = cnt // the latency digest counts these observations
+	}
+}
+
+// countRequests applies a request/error rule to val observations and reports
+// whether they were errors.
+func (p *processor) countRequests(rule *redRule, labels map[string]string, val float64, st *svc) bool {
+	isErr := rule.error || rule.isError(labels)
+	if isErr {
+		st.err += val
+		if rule.error {
 			st.req += val // errors imply requests too, to keep rate stable
-			st.count += uint64(maxf(val, 0))
 		}
+	} else {
+		st.ok += val
+	}
+	if rule.request {
+		st.req += val
 	}
+	st.count += uint64(maxf(val, 0))
+	return isErr
 }
 
-func (p *processor) consumeHistogram(name string, res map[string]string, h *met.Histogram, st *svc) {
+// consumeHistogram feeds explicit-bucket histograms into the latency digest.
+// Histograms no rule claims count as latency only when their unit or name
+// says so (see isLatencyMetric); a request role also counts every
+// observation as a request.
+func (p *processor) consumeHistogram(name, unit string, h *met.Histogram, t target) {
+	rule := p.rules.match(name)
+	latency := isLatencyMetric(name, unit)
+	if rule != nil {
+		latency = rule.latency
+	}
+	scale := rule.scale(unit)
 	isDelta := h.GetAggregationTemporality() == met.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
 	for _, dp := range h.GetDataPoints() {
+		st := p.stateFor(t, dp.GetAttributes())
+		p.countHistogram(rule, name, t.res, isDelta, dp.GetCount(), dp.GetAttributes(), dp.GetExemplars(), st)
+		if !latency {
+			continue
+		}
+		p.addOTLPExemplars(st, name, "slow", dp.GetExemplars())
 		bounds := dp.GetExplicitBounds()
 		counts := make([]float64, len(dp.GetBucketCounts()))
 		if isDelta {
@@ -232,103 +368,131 @@ func (p *processor) consumeHistogram(name string, res map[string]string, h *met.
 		} else {
 			// cumulative → delta using per-bucket series key
 			for i, c := range dp.GetBucketCounts() {
-				key := seriesKey(res, name+":bucket:"+strconv.Itoa(i), dp.GetAttributes())
+				key := seriesKey(t.res, name+":bucket:"+strconv.Itoa(i), dp.GetAttributes())
 				counts[i] = delta(p, key, float64(c))
 			}
 		}
-		// Add capped number of representative samples per bucket upper bound
-		for i := 0; i < len(counts) && i < len(bounds); i++ {
-			reps := int(minf(counts[i], float64(p.bucketSampleCap)))
-			if reps <= 0 {
-				continue
+		// Bucket counts become weighted samples spread across each bucket,
+		// the same linear interpolation histogram_quantile applies.
+		if len(bounds) == 0 {
+			// A single (-Inf, +Inf) bucket only tells us the mean.
+			if len(counts) == 1 && counts[0] > 0 && dp.Sum != nil && dp.GetCount() > 0 {
+				mean := dp.GetSum() / float64(dp.GetCount()) * scale
+				addSpread(st, mean, mean, uint64(math.Round(counts[0])))
 			}
-			ub := bounds[i]
-			for j := 0; j < reps; j++ {
-				st.td.Add(ub)
+			continue
+		}
+		for i, c := range counts {
+			if i > len(bounds) {
+				break
 			}
-			st.count += uint64(reps)
+			lo, hi := bucketRange(bounds, i)
+			addSpread(st, lo*scale, hi*scale, uint64(math.Round(c)))
 		}
 	}
 }
 
 // ---------------- Prometheus Remote Write ----------------
 
-func (p *processor) consumePromRW(raw []byte, winStart int64) {
+// consumePromRW reads one WriteRequest. Counters are deltas (VM/Agent remote
+// write) unless cumulative is set, as for promscrape envelopes.
+func (p *processor) consumePromRW(raw []byte, winStart int64, cumulative bool) error {
 	var wr prompb.WriteRequest
 	if err := wr.Unmarshal(raw); err != nil {
 		log.Printf("[summarizer] failed to unmarshal PromRW: %v", err)
-		return
+		return err
 	}
 	for _, ts := range wr.Timeseries {
 		lbls := labelsToMap(ts.Labels)
 		name := lbls["__name__"]
 		svcName := firstNonEmpty(lbls[p.svcAttr], lbls["service.name"], lbls["service"], lbls["job"], "unknown")
-		st := p.ensureSvc(svcName)
+		st := p.stateForLabels(target{svc: svcName}, lbls)
 
-		switch {
-		case strings.HasSuffix(name, "_duration_seconds_bucket"):
-			le := lbls["le"]
-			if le == "" {
-				continue
-			}
-			ub, err := strconv.ParseFloat(le, 64)
-			if err != nil {
-				continue
-			}
-			// Treat sample values as deltas (common with VM/Agent); cap to avoid hot loops
+		if g, ok := p.gauges[name]; ok {
 			for _, s := range ts.Samples {
-				reps := int(minf(s.Value, float64(p.bucketSampleCap)))
-				for j := 0; j < reps; j++ {
-					st.td.Add(ub)
-				}
-				st.count += uint64(maxf(s.Value, 0))
+				st.observeGauge(g.feature, s.Value, uint64(s.Timestamp)*1e6)
 			}
+			continue
+		}
 
-		case strings.HasSuffix(name, "_requests_total"):
-			code := firstNonEmpty(lbls["status_code"], lbls["code"])
+		if name == "up" {
+			// Target health from promscrape: each sample is one scrape attempt.
 			for _, s := range ts.Samples {
-				v := maxf(s.Value, 0)
-				st.req += v
-				if code != "" && !isOKStatus(code) {
-					st.err += v
+				if s.Value >= 1 {
+					st.scrapesUp++
 				} else {
-					st.ok += v
+					st.scrapesDown++
 				}
-				st.count += uint64(v)
 			}
+			continue
+		}
+
+		rule := p.rules.match(name)
+		switch {
+		case rule == nil:
+
+		case rule.latency && strings.HasSuffix(name, "_bucket"):
+			le, err := strconv.ParseFloat(lbls["le"], 64)
+			if err != nil {
+				continue
+			}
+			p.addPromExemplars(st, name, "slow", ts.Exemplars)
+			// Buckets are cumulative over time and over le: delta each series
+			// here, de-cumulate across le at flush, once the whole window's
+			// buckets are in.
+			var d float64
+			key := promSeriesKey(ts.Labels)
+			for _, s := range ts.Samples {
+				d += delta(p, key, s.Value)
+			}
+			p.hists.add(st, ts.Labels, le*rule.scale(promUnit(name)), d)
 
-		case strings.HasSuffix(name, "_errors_total"):
+		case rule.request || rule.error:
+			key := promSeriesKey(ts.Labels)
 			for _, s := range ts.Samples {
-				v := maxf(s.Value, 0)
-				st.err += v
-				st.req += v
-				st.count += uint64(v)
+				v := s.Value
+				if cumulative {
+					v = delta(p, key, v)
+				}
+				if p.countRequests(rule, lbls, maxf(v, 0), st) {
+					p.addPromExemplars(st, name, "error", ts.Exemplars)
+				}
 			}
 		}
 	}
+	return nil
 }
 
 // ---------------- helpers ----------------
 
-func (p *processor) ensureSvc(name string) *svc {
-	if s, ok := p.state[name]; ok {
+// ensureSvc returns the window state for a service, or for one of its groups
+// when group is non-nil.
+func (p *processor) ensureSvc(name string, group map[string]string) *svc {
+	key := stateKey(name, group)
+	if s, ok := p.state[key]; ok {
 		return s
 	}
+	if group != nil {
+		p.groups[name]++
+	}
 	ns := &svc{
-		td: func() *tdigest.TDigest {
-			td, _ := tdigest.New(tdigest.Compression(100)) // Ignore error for now as it shouldn't happen with valid compression
-			return td
-		}(),
+		name:   name,
+		group:  group,
+		td:     sketch.NewDigest(),
 		labels: map[string]string{},
 	}
-	p.state[name] = ns
+	p.state[key] = ns
 	return ns
 }
 
 func trunc(ts int64, win int64) int64 { return ts - (ts % win) }
 
-func buildSummaryText(svc string, rps, errRate float64, count uint64) string {
-	return "summary service=" + svc +
+func buildSummaryText(svc string, group map[string]string, rps, errRate float64, count uint64) string {
+	s := "summary service=" + svc
+	if gk := (model.Aggregate{Group: group}).GroupKey(); gk != "" {
+		s += " group=" + gk
+	}
+	return s +
 		" rps=" + ftoa(rps) +
 		" error_rate=" + ftoa(errRate) +
 		" count=" + strconv.FormatUint(count, 10)
@@ -390,13 +554,27 @@ func seriesKey(res map[string]string, name string, attrs []*com.KeyValue) string
 	return b.String()
 }
 
+// lastSample is the previous value of a cumulative series and the window it
+// was seen in.
+type lastSample struct {
+	v   float64
+	win int64
+}
+
+// delta turns a cumulative value into its increase since the previous sample
+// of the same series. The first sample of a series is only a baseline: its
+// value covers the series' whole lifetime, not this window. That includes a
+// series back after its baseline expired (see expireLast).
 func delta(p *processor, key string, cur float64) float64 {
-	prev := p.last[key]
-	d := cur - prev
+	prev, seen := p.last[key]
+	p.last[key] = lastSample{v: cur, win: p.windows}
+	if !seen {
+		return 0
+	}
+	d := cur - prev.v
 	if d < 0 {
-		d = 0
+		d = cur // counter reset: it restarted from zero
 	}
-	p.last[key] = cur
 	return d
 }
 
@@ -417,27 +595,9 @@ func labelsToMap(lbls []prompb.Label) map[string]string {
 	return m
 }
 
-func isOKStatus(code string) bool {
-	// normalize a few common encodings
-	c := strings.ToLower(code)
-	switch c {
-	case "ok", "unset", "2xx", "200", "201", "202", "204":
-		return true
-	default:
-		// treat anything starting with "2" as ok
-		return strings.HasPrefix(c, "2")
-	}
-}
-
 func maxf(a, b float64) float64 {
 	if a > b {
 		return a
 	}
 	return b
 }
-func minf(a, b float64) float64 {
-	if a < b {
-		return a
-	}
-	return b
-}
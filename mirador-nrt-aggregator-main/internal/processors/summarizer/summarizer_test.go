@@ -0,0 +1,217 @@
+package summarizer
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	prompb "github.com/prometheus/prometheus/prompb"
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+func promCounter(name string, v float64, lbls ...string) []byte {
+	ls := []prompb.Label{{Name: "__name__", Value: name}, {Name: "job", Value: "api"}}
+	for i := 0; i+1 < len(lbls); i += 2 {
+		ls = append(ls, prompb.Label{Name: lbls[i], Value: lbls[i+1]})
+	}
+	wr := prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  ls,
+		Samples: []prompb.Sample{{Value: v, Timestamp: 1}},
+	}}}
+	b, _ := wr.Marshal()
+	return b
+}
+
+func TestPromRWCounters(t *testing.T) {
+	tests := []struct {
+		name       string
+		cumulative bool
+		values     []float64
+		wantReq    float64
+	}{
+		{"remote write deltas add up", false, []float64{5, 7, 3}, 15},
+		{"scraped totals are deltaed from a baseline", true, []float64{100, 150, 170}, 70},
+		{"counter reset restarts from zero", true, []float64{100, 150, 10}, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(config.ProcessorCfg{WindowSeconds: 60})
+			for _, v := range tt.values {
+				if err := p.consumePromRW(promCounter("http_requests_total", v, "code", "200"), 0, tt.cumulative); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st := p.state[stateKey("api", nil)]
+			if st == nil {
+				t.Fatal("no state for api")
+			}
+			if st.req != tt.wantReq {
+				t.Fatalf("req = %v, want %v", st.req, tt.wantReq)
+			}
+		})
+	}
+}
+
+func promBuckets(name string, buckets map[float64]float64) []byte {
+	var wr prompb.WriteRequest
+	for le, v := range buckets {
+		wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: name},
+				{Name: "job", Value: "api"},
+				{Name: "le", Value: strconv.FormatFloat(le, 'g', -1, 64)},
+			},
+			Samples: []prompb.Sample{{Value: v, Timestamp: 1}},
+		})
+	}
+	b, _ := wr.Marshal()
+	return b
+}
+
+// A histogram's buckets may arrive spread over several write requests; they
+// are only de-cumulated across le once the window is complete.
+func TestPromRWHistogramAcrossRequests(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		name      string
+		requests  []map[float64]float64
+		wantCount uint64
+		maxP99    float64
+	}{
+		{
+			name: "first scrape is a baseline",
+			requests: []map[float64]float64{
+				{0.1: 10, 0.5: 20, inf: 20},
+			},
+			wantCount: 0,
+		},
+		{
+			name: "one request per scrape",
+			requests: []map[float64]float64{
+				{0.1: 10, 0.5: 20, inf: 20},
+				{0.1: 14, 0.5: 30, inf: 30},
+			},
+			wantCount: 10,
+			maxP99:    0.5,
+		},
+		{
+			name: "buckets split over requests",
+			requests: []map[float64]float64{
+				{0.1: 10, 0.5: 20, inf: 20},
+				{0.5: 30},
+				{0.1: 14, inf: 30},
+			},
+			wantCount: 10,
+			maxP99:    0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(config.ProcessorCfg{WindowSeconds: 60})
+			for _, r := range tt.requests {
+				if err := p.consumePromRW(promBuckets("http_request_duration_seconds_bucket", r), 0, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p.hists.flush()
+			st := p.state[stateKey("api", nil)]
+			if st == nil {
+				t.Fatal("no state for api")
+			}
+			if st.count != tt.wantCount {
+				t.Fatalf("count = %d, want %d", st.count, tt.wantCount)
+			}
+			if tt.wantCount > 0 {
+				if q := st.td.Quantile(0.99); q > tt.maxP99 {
+					t.Fatalf("p99 = %v, want <= %v", q, tt.maxP99)
+				}
+			}
+		})
+	}
+}
+
+// Explicit-bucket histograms no rule claims only feed the latency digest
+// when their unit or name marks them as durations.
+func TestHistogramLatency(t *testing.T) {
+	tests := []struct {
+		name, unit string
+		want       bool
+	}{
+		{"http.server.request.duration", "s", true}, // default rule
+		{"db.client.operation.time", "ms", true},
+		{"queue_wait_latency", "", true},
+		{"http.server.request.body.size", "By", false},
+		{"batch_items", "1", false},
+		{"payload_bytes", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(config.ProcessorCfg{WindowSeconds: 60})
+			h := &met.Histogram{
+				AggregationTemporality: met.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				DataPoints: []*met.HistogramDataPoint{{
+					Count:          6,
+					ExplicitBounds: []float64{1, 10},
+					BucketCounts:   []uint64{1, 2, 3},
+				}},
+			}
+			p.consumeHistogram(tt.name, tt.unit, h, target{svc: "api"})
+			st := p.state[stateKey("api", nil)]
+			got := st != nil && st.td != nil && st.td.Count() > 0
+			if got != tt.want {
+				t.Fatalf("latency digest fed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeltaBaselineExpiry(t *testing.T) {
+	p := New(config.ProcessorCfg{WindowSeconds: 60, Extra: map[string]any{"series_ttl_windows": 2}})
+	out := make(chan any, 16)
+	window := func(vals ...float64) float64 {
+		for _, v := range vals {
+			if err := p.consumePromRW(promCounter("http_requests_total", v, "code", "200"), 0, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req := 0.0
+		if st := p.state[stateKey("api", nil)]; st != nil {
+			req = st.req
+		}
+		p.flush(out, 0)
+		return req
+	}
+
+	if got := window(100, 150); got != 50 {
+		t.Fatalf("first window req = %v, want 50", got)
+	}
+	window()
+	if got := window(170); got != 20 {
+		t.Fatalf("req after one idle window = %v, want 20 (baseline kept)", got)
+	}
+	window()
+	window()
+	window()
+	if n := len(p.last); n != 0 {
+		t.Fatalf("baselines after three idle windows = %d, want 0", n)
+	}
+	if got := window(500); got != 0 {
+		t.Fatalf("req after expiry = %v, want 0 (new baseline)", got)
+	}
+}
+
+func TestAddSpreadSkipsNaN(t *testing.T) {
+	p := New(config.ProcessorCfg{WindowSeconds: 60})
+	st := p.stateFor(target{svc: "api"}, nil)
+	addSpread(st, math.NaN(), 1, 5)
+	addSpread(st, 0, math.NaN(), 5)
+	if st.count != 0 || st.td.Count() != 0 {
+		t.Fatalf("count = %d, digest = %d; want NaN buckets skipped", st.count, st.td.Count())
+	}
+	addSpread(st, 0, 1, 5)
+	if st.count != 5 || st.td.Count() != 5 {
+		t.Fatalf("count = %d, digest = %d; want 5", st.count, st.td.Count())
+	}
+}
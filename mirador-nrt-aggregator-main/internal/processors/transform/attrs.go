@@ -0,0 +1,289 @@
+package transform
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+
+	prompb "github.com/prometheus/prometheus/prompb"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// attrMap is one attribute set a statement works on: OTLP attributes, a
+// JSON log object, PromRW series labels or Aggregate.Labels.
+type attrMap interface {
+	get(k string) (any, bool)
+	// set stores v under k and reports whether that changed the set.
+	set(k string, v any) bool
+	del(k string) bool
+	keys() []string
+	// view is the set as a plain map for CEL (attrs["k"]).
+	view() map[string]any
+}
+
+// ---------- OTLP attributes ----------
+
+type kvAttrs struct{ kvs *[]*com.KeyValue }
+
+func (a kvAttrs) get(k string) (any, bool) {
+	for _, kv := range *a.kvs {
+		if kv.GetKey() == k {
+			return fromAnyValue(kv.GetValue()), true
+		}
+	}
+	return nil, false
+}
+
+func (a kvAttrs) set(k string, v any) bool {
+	av := toAnyValue(v)
+	for _, kv := range *a.kvs {
+		if kv.GetKey() == k {
+			if proto.Equal(kv.Value, av) {
+				return false
+			}
+			kv.Value = av
+			return true
+		}
+	}
+	*a.kvs = append(*a.kvs, &com.KeyValue{Key: k, Value: av})
+	return true
+}
+
+func (a kvAttrs) del(k string) bool {
+	kvs := *a.kvs
+	for i, kv := range kvs {
+		if kv.GetKey() == k {
+			*a.kvs = append(kvs[:i], kvs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (a kvAttrs) keys() []string {
+	out := make([]string, 0, len(*a.kvs))
+	for _, kv := range *a.kvs {
+		out = append(out, kv.GetKey())
+	}
+	return out
+}
+
+func (a kvAttrs) view() map[string]any {
+	m := make(map[string]any, len(*a.kvs))
+	for _, kv := range *a.kvs {
+		m[kv.GetKey()] = fromAnyValue(kv.GetValue())
+	}
+	return m
+}
+
+func fromAnyValue(v *com.AnyValue) any {
+	switch vv := v.GetValue().(type) {
+	case *com.AnyValue_StringValue:
+		return vv.StringValue
+	case *com.AnyValue_BoolValue:
+		return vv.BoolValue
+	case *com.AnyValue_IntValue:
+		return vv.IntValue
+	case *com.AnyValue_DoubleValue:
+		return vv.DoubleValue
+	case *com.AnyValue_BytesValue:
+		return vv.BytesValue
+	case *com.AnyValue_ArrayValue:
+		out := []any{}
+		for _, it := range vv.ArrayValue.GetValues() {
+			out = append(out, fromAnyValue(it))
+		}
+		return out
+	case *com.AnyValue_KvlistValue:
+		out := map[string]any{}
+		for _, kv := range vv.KvlistValue.GetValues() {
+			out[kv.GetKey()] = fromAnyValue(kv.GetValue())
+		}
+		return out
+	}
+	return nil
+}
+
+func toAnyValue(v any) *com.AnyValue {
+	switch t := v.(type) {
+	case *com.AnyValue:
+		return t
+	case string:
+		return &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: t}}
+	case bool:
+		return &com.AnyValue{Value: &com.AnyValue_BoolValue{BoolValue: t}}
+	case int:
+		return &com.AnyValue{Value: &com.AnyValue_IntValue{IntValue: int64(t)}}
+	case int64:
+		return &com.AnyValue{Value: &com.AnyValue_IntValue{IntValue: t}}
+	case uint64:
+		return &com.AnyValue{Value: &com.AnyValue_IntValue{IntValue: int64(t)}}
+	case float64:
+		return &com.AnyValue{Value: &com.AnyValue_DoubleValue{DoubleValue: t}}
+	case []byte:
+		return &com.AnyValue{Value: &com.AnyValue_BytesValue{BytesValue: t}}
+	case []any:
+		arr := &com.ArrayValue{}
+		for _, it := range t {
+			arr.Values = append(arr.Values, toAnyValue(it))
+		}
+		return &com.AnyValue{Value: &com.AnyValue_ArrayValue{ArrayValue: arr}}
+	case map[string]any:
+		kvs := &com.KeyValueList{}
+		for k, it := range t {
+			kvs.Values = append(kvs.Values, &com.KeyValue{Key: k, Value: toAnyValue(it)})
+		}
+		return &com.AnyValue{Value: &com.AnyValue_KvlistValue{KvlistValue: kvs}}
+	}
+	s, _ := str(v)
+	return &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: s}}
+}
+
+// ---------- JSON log object ----------
+
+type mapAttrs map[string]any
+
+func (a mapAttrs) get(k string) (any, bool) { v, ok := a[k]; return v, ok }
+func (a mapAttrs) set(k string, v any) bool {
+	switch t := v.(type) { // keep JSON-native numbers
+	case int:
+		v = float64(t)
+	case int64:
+		v = float64(t)
+	case uint64:
+		v = float64(t)
+	}
+	if old, ok := a[k]; ok && reflect.DeepEqual(old, v) {
+		return false
+	}
+	a[k] = v
+	return true
+}
+func (a mapAttrs) del(k string) bool {
+	_, ok := a[k]
+	delete(a, k)
+	return ok
+}
+func (a mapAttrs) keys() []string {
+	out := make([]string, 0, len(a))
+	for k := range a {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+func (a mapAttrs) view() map[string]any { return a }
+
+// ---------- string labels (Aggregate.Labels) ----------
+
+type labelAttrs struct{ m *map[string]string }
+
+func (a labelAttrs) get(k string) (any, bool) { v, ok := (*a.m)[k]; return v, ok }
+func (a labelAttrs) set(k string, v any) bool {
+	if *a.m == nil {
+		*a.m = map[string]string{}
+	}
+	s, _ := str(v)
+	if old, ok := (*a.m)[k]; ok && old == s {
+		return false
+	}
+	(*a.m)[k] = s
+	return true
+}
+func (a labelAttrs) del(k string) bool {
+	_, ok := (*a.m)[k]
+	delete(*a.m, k)
+	return ok
+}
+func (a labelAttrs) keys() []string {
+	out := make([]string, 0, len(*a.m))
+	for k := range *a.m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+func (a labelAttrs) view() map[string]any {
+	m := make(map[string]any, len(*a.m))
+	for k, v := range *a.m {
+		m[k] = v
+	}
+	return m
+}
+
+// ---------- PromRW series labels ----------
+
+// promAttrs keeps labels sorted by name, as remote write requires.
+type promAttrs struct{ ls *[]prompb.Label }
+
+func (a promAttrs) get(k string) (any, bool) {
+	for _, l := range *a.ls {
+		if l.Name == k {
+			return l.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (a promAttrs) set(k string, v any) bool {
+	s, _ := str(v)
+	for i := range *a.ls {
+		if (*a.ls)[i].Name == k {
+			if (*a.ls)[i].Value == s {
+				return false
+			}
+			(*a.ls)[i].Value = s
+			return true
+		}
+	}
+	*a.ls = append(*a.ls, prompb.Label{Name: k, Value: s})
+	sort.Slice(*a.ls, func(i, j int) bool { return (*a.ls)[i].Name < (*a.ls)[j].Name })
+	return true
+}
+
+func (a promAttrs) del(k string) bool {
+	ls := *a.ls
+	for i, l := range ls {
+		if l.Name == k {
+			*a.ls = append(ls[:i], ls[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (a promAttrs) keys() []string {
+	out := make([]string, 0, len(*a.ls))
+	for _, l := range *a.ls {
+		out = append(out, l.Name)
+	}
+	return out
+}
+
+func (a promAttrs) view() map[string]any {
+	m := make(map[string]any, len(*a.ls))
+	for _, l := range *a.ls {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+// str renders scalar values as strings; arrays, maps and nil do not convert.
+func str(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case bool:
+		return strconv.FormatBool(t), true
+	case int:
+		return strconv.Itoa(t), true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case uint64:
+		return strconv.FormatUint(t, 10), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	}
+	return "", false
+}
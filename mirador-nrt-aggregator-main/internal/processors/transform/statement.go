@@ -0,0 +1,331 @@
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Contexts a statement can apply to.
+const (
+	ctxResource  = "resource"  // OTLP resource attributes
+	ctxScope     = "scope"     // OTLP instrumentation scope attributes
+	ctxDatapoint = "datapoint" // OTLP metric data point attributes, PromRW series labels
+	ctxSpan      = "span"      // OTLP span attributes
+	ctxLog       = "log"       // OTLP log record attributes, JSON log fields
+	ctxLabels    = "labels"    // model.Aggregate.Labels
+)
+
+var allContexts = []string{ctxResource, ctxScope, ctxDatapoint, ctxSpan, ctxLog, ctxLabels}
+
+// statement is one action on the attributes of its contexts. Statements run
+// in order, so a later one sees what earlier ones wrote.
+type statement struct {
+	action   string
+	contexts map[string]bool
+
+	keys       []string       // explicit keys
+	keyPattern *regexp.Regexp // or every key matching
+
+	value     any         // set: constant
+	valueExpr cel.Program // set: derived value
+	to        string      // rename: new key (with key_pattern, may use $1)
+	length    int         // truncate: max runes; hash: hex digits kept (0 = all)
+	salt      string      // hash
+	pattern   *regexp.Regexp
+	repl      string
+
+	where cel.Program // optional guard
+}
+
+// celEnv exposes the attribute set as attrs, the enclosing OTLP resource as
+// resource (empty elsewhere) and the context name as context.
+func celEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("attrs", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("context", cel.StringType),
+	)
+}
+
+// parseStatements reads:
+//
+//	statements:
+//	  - action: set                  # set | delete | rename | hash | truncate | replace
+//	    context: resource            # or a list; default: every context
+//	    key: service.name            # or keys: [...], or key_pattern: '<regex>'
+//	    value_expr: 'attrs["service.namespace"] + "/" + attrs["service.name"]'   # or value: <constant>
+//	    where: '"service.namespace" in attrs'
+//	  - action: rename
+//	    key_pattern: '^http_(.*)$'
+//	    to: 'http.$1'
+//	  - action: replace
+//	    key: service.name
+//	    pattern: '-(prod|staging|v\d+)$'
+//	    replacement: ''
+//	  - action: hash                 # hex SHA-256 of salt+value
+//	    keys: [user.email]
+//	    length: 16
+//	  - action: truncate
+//	    key: db.statement
+//	    length: 256
+//	  - action: delete
+//	    context: [datapoint, labels]
+//	    key_pattern: '^(pod|container_id|request_id)$'
+//
+// Invalid statements are logged and skipped.
+func parseStatements(v any) []*statement {
+	env, err := celEnv()
+	if err != nil {
+		log.Printf("[transform] cel env init error: %v", err)
+		return nil
+	}
+	xs, _ := v.([]any)
+	var out []*statement
+	for i, it := range xs {
+		m, ok := it.(map[string]any)
+		if !ok {
+			log.Printf("[transform] statement %d: not a map", i)
+			continue
+		}
+		st, err := parseStatement(env, m)
+		if err != nil {
+			log.Printf("[transform] statement %d: %v; skipped", i, err)
+			continue
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+func parseStatement(env *cel.Env, m map[string]any) (*statement, error) {
+	st := &statement{contexts: map[string]bool{}}
+	st.action, _ = m["action"].(string)
+
+	switch c := m["context"].(type) {
+	case nil:
+		for _, c := range allContexts {
+			st.contexts[c] = true
+		}
+	case string:
+		st.contexts[c] = true
+	case []any:
+		for _, x := range c {
+			s, _ := x.(string)
+			st.contexts[s] = true
+		}
+	}
+	for c := range st.contexts {
+		if !contains(allContexts, c) {
+			return nil, fmt.Errorf("unknown context %q", c)
+		}
+	}
+
+	if k, ok := m["key"].(string); ok && k != "" {
+		st.keys = append(st.keys, k)
+	}
+	if ks, ok := m["keys"].([]any); ok {
+		for _, x := range ks {
+			if k, ok := x.(string); ok && k != "" {
+				st.keys = append(st.keys, k)
+			}
+		}
+	}
+	if p, ok := m["key_pattern"].(string); ok && p != "" {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("key_pattern: %w", err)
+		}
+		st.keyPattern = re
+	}
+	if len(st.keys) == 0 && st.keyPattern == nil {
+		return nil, fmt.Errorf("needs key, keys or key_pattern")
+	}
+	if n, ok := m["length"].(int); ok {
+		st.length = n
+	}
+
+	switch st.action {
+	case "set":
+		if len(st.keys) != 1 || st.keyPattern != nil {
+			return nil, fmt.Errorf("set needs exactly one key")
+		}
+		if src, ok := m["value_expr"].(string); ok && src != "" {
+			prg, err := compile(env, src, false)
+			if err != nil {
+				return nil, fmt.Errorf("value_expr: %w", err)
+			}
+			st.valueExpr = prg
+		} else if v, ok := m["value"]; ok {
+			st.value = v
+		} else {
+			return nil, fmt.Errorf("set needs value or value_expr")
+		}
+	case "delete":
+	case "rename":
+		st.to, _ = m["to"].(string)
+		if st.to == "" {
+			return nil, fmt.Errorf("rename needs to")
+		}
+	case "hash":
+		st.salt, _ = m["salt"].(string)
+	case "truncate":
+		if st.length <= 0 {
+			return nil, fmt.Errorf("truncate needs length > 0")
+		}
+	case "replace":
+		p, _ := m["pattern"].(string)
+		re, err := regexp.Compile(p)
+		if p == "" || err != nil {
+			return nil, fmt.Errorf("replace needs a valid pattern: %v", err)
+		}
+		st.pattern = re
+		st.repl, _ = m["replacement"].(string)
+	default:
+		return nil, fmt.Errorf("unknown action %q", st.action)
+	}
+
+	if src, ok := m["where"].(string); ok && src != "" {
+		prg, err := compile(env, src, true)
+		if err != nil {
+			return nil, fmt.Errorf("where: %w", err)
+		}
+		st.where = prg
+	}
+	return st, nil
+}
+
+func compile(env *cel.Env, src string, wantBool bool) (cel.Program, error) {
+	ast, iss := env.Compile(src)
+	if iss != nil && iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if wantBool && ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("%q is not a bool expression", src)
+	}
+	return env.Program(ast)
+}
+
+// apply runs the statement on one attribute set and reports whether it
+// changed anything. resource is the enclosing OTLP resource, if any.
+func (st *statement) apply(ctx string, a attrMap, resource map[string]any) bool {
+	if !st.contexts[ctx] {
+		return false
+	}
+	vars := func() map[string]any {
+		if resource == nil {
+			resource = map[string]any{}
+		}
+		return map[string]any{"attrs": a.view(), "resource": resource, "context": ctx}
+	}
+	if st.where != nil {
+		out, _, err := st.where.Eval(vars())
+		if err != nil {
+			evalErrors.Inc()
+			return false
+		}
+		if b, ok := out.Value().(bool); !ok || !b {
+			return false
+		}
+	}
+
+	if st.action == "set" {
+		v := st.value
+		if st.valueExpr != nil {
+			out, _, err := st.valueExpr.Eval(vars())
+			if err != nil {
+				evalErrors.Inc()
+				return false
+			}
+			v = out.Value()
+		}
+		if !a.set(st.keys[0], v) {
+			return false // already had that value
+		}
+		appliedTotal.WithLabelValues(st.action).Inc()
+		return true
+	}
+
+	changed := false
+	for _, k := range st.match(a) {
+		v, ok := a.get(k)
+		if !ok {
+			continue
+		}
+		switch st.action {
+		case "delete":
+			changed = a.del(k) || changed
+		case "rename":
+			to := st.to
+			if st.keyPattern != nil {
+				to = st.keyPattern.ReplaceAllString(k, st.to)
+			}
+			if to == k {
+				continue
+			}
+			a.del(k)
+			a.set(to, v)
+			changed = true
+		case "hash":
+			s, ok := str(v)
+			if !ok {
+				continue
+			}
+			sum := sha256.Sum256([]byte(st.salt + s))
+			h := hex.EncodeToString(sum[:])
+			if st.length > 0 && st.length < len(h) {
+				h = h[:st.length]
+			}
+			changed = a.set(k, h) || changed
+		case "truncate":
+			s, ok := str(v)
+			if !ok {
+				continue
+			}
+			if r := []rune(s); len(r) > st.length {
+				a.set(k, string(r[:st.length]))
+				changed = true
+			}
+		case "replace":
+			s, ok := str(v)
+			if !ok {
+				continue
+			}
+			if r := st.pattern.ReplaceAllString(s, st.repl); r != s {
+				a.set(k, r)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		appliedTotal.WithLabelValues(st.action).Inc()
+	}
+	return changed
+}
+
+// match returns the statement's keys present in a.
+func (st *statement) match(a attrMap) []string {
+	if st.keyPattern == nil {
+		return st.keys
+	}
+	var out []string
+	for _, k := range a.keys() {
+		if st.keyPattern.MatchString(k) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func contains(xs []string, s string) bool {
+	for _, x := range xs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
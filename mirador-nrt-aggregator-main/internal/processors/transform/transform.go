@@ -0,0 +1,314 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	prompb "github.com/prometheus/prometheus/prompb"
+	colllog "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collmet "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	colltr "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resv1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+var (
+	appliedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_transform_applied_total",
+		Help: "Attribute sets changed by a transform statement, by action.",
+	}, []string{"action"})
+	evalErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mirador_transform_eval_errors_total",
+		Help: "CEL where/value_expr evaluations that failed; the statement was skipped.",
+	})
+	undecodable = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mirador_transform_undecodable_total",
+		Help: "Envelopes passed through untransformed because their payload did not decode.",
+	})
+)
+
+// processor rewrites attributes before summarization: OTLP resource, scope,
+// data point, span and log record attributes, PromRW series labels, JSON log
+// fields and Aggregate.Labels. Where filter keeps or drops, transform sets,
+// deletes, renames, hashes, truncates and regex-replaces, e.g. to normalize
+// service.name across teams or strip high-cardinality labels.
+//
+// Config (processors.transform): a `statements:` list, see parseStatements.
+// Payloads are only re-encoded when a statement changed something; envelopes
+// that do not decode pass through untouched.
+type processor struct {
+	statements []*statement
+	contexts   map[string]bool // union of the statements' contexts
+}
+
+func New(cfg config.ProcessorCfg) *processor {
+	sts := parseStatements(cfg.Extra["statements"])
+	if len(sts) == 0 {
+		log.Printf("[transform] no valid statements; passing everything through")
+	}
+	ctxs := map[string]bool{}
+	for _, st := range sts {
+		for c := range st.contexts {
+			ctxs[c] = true
+		}
+	}
+	return &processor{statements: sts, contexts: ctxs}
+}
+
+func (p *processor) Start(ctx context.Context, in <-chan any, out chan<- any) error {
+	defer close(out)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			switch t := v.(type) {
+			case model.Envelope:
+				out <- p.envelope(t)
+			case model.Aggregate:
+				out <- p.aggregate(t)
+			default:
+				out <- v
+			}
+		}
+	}
+}
+
+// run applies every statement, in order, to one attribute set.
+func (p *processor) run(ctx string, a attrMap, resource map[string]any) bool {
+	changed := false
+	for _, st := range p.statements {
+		if st.apply(ctx, a, resource) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *processor) aggregate(a model.Aggregate) model.Aggregate {
+	if !p.contexts[ctxLabels] {
+		return a
+	}
+	// Labels may be shared with an upstream processor's state (rollup keeps
+	// the newest input's labels); work on a copy.
+	labels := make(map[string]string, len(a.Labels))
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	if p.run(ctxLabels, labelAttrs{&labels}, nil) {
+		a.Labels = labels
+	}
+	return a
+}
+
+func (p *processor) envelope(env model.Envelope) model.Envelope {
+	if len(p.statements) == 0 {
+		return env
+	}
+	var (
+		b   []byte
+		err error
+	)
+	switch env.Kind {
+	case model.KindMetrics:
+		b, err = p.metrics(env.Bytes)
+	case model.KindTraces:
+		b, err = p.traces(env.Bytes)
+	case model.KindPromRW:
+		b, err = p.promrw(env.Bytes)
+	case model.KindJSONLogs:
+		// JSON lines after otlplogs/jsonlogs receivers, OTLP protobuf before
+		if t := bytes.TrimSpace(env.Bytes); len(t) > 0 && t[0] == '{' {
+			b, err = p.jsonLog(env.Bytes)
+		} else {
+			b, err = p.otlpLogs(env.Bytes)
+		}
+	default:
+		return env
+	}
+	if err != nil {
+		undecodable.Inc()
+		return env
+	}
+	if b != nil {
+		env.Bytes = b
+	}
+	return env
+}
+
+// Each payload handler returns the re-encoded payload, or nil if nothing
+// changed.
+
+func (p *processor) metrics(raw []byte) ([]byte, error) {
+	var req collmet.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	changed := false
+	for _, rm := range req.ResourceMetrics {
+		res := p.resource(&rm.Resource, &changed)
+		for _, sm := range rm.ScopeMetrics {
+			p.scope(sm.Scope, res, &changed)
+			if !p.contexts[ctxDatapoint] {
+				continue
+			}
+			for _, m := range sm.Metrics {
+				for _, kvs := range dataPointAttrs(m) {
+					if p.run(ctxDatapoint, kvAttrs{kvs}, res) {
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return marshalIf(changed, &req)
+}
+
+func (p *processor) traces(raw []byte) ([]byte, error) {
+	var req colltr.ExportTraceServiceRequest
+	if err := proto.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	changed := false
+	for _, rs := range req.ResourceSpans {
+		res := p.resource(&rs.Resource, &changed)
+		for _, ss := range rs.ScopeSpans {
+			p.scope(ss.Scope, res, &changed)
+			if !p.contexts[ctxSpan] {
+				continue
+			}
+			for _, sp := range ss.Spans {
+				if p.run(ctxSpan, kvAttrs{&sp.Attributes}, res) {
+					changed = true
+				}
+			}
+		}
+	}
+	return marshalIf(changed, &req)
+}
+
+func (p *processor) otlpLogs(raw []byte) ([]byte, error) {
+	var req colllog.ExportLogsServiceRequest
+	if err := proto.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	changed := false
+	for _, rl := range req.ResourceLogs {
+		res := p.resource(&rl.Resource, &changed)
+		for _, sl := range rl.ScopeLogs {
+			p.scope(sl.Scope, res, &changed)
+			if !p.contexts[ctxLog] {
+				continue
+			}
+			for _, rec := range sl.LogRecords {
+				if p.run(ctxLog, kvAttrs{&rec.Attributes}, res) {
+					changed = true
+				}
+			}
+		}
+	}
+	return marshalIf(changed, &req)
+}
+
+func (p *processor) promrw(raw []byte) ([]byte, error) {
+	var wr prompb.WriteRequest
+	if err := wr.Unmarshal(raw); err != nil {
+		return nil, err
+	}
+	if !p.contexts[ctxDatapoint] {
+		return nil, nil
+	}
+	changed := false
+	for i := range wr.Timeseries {
+		if p.run(ctxDatapoint, promAttrs{&wr.Timeseries[i].Labels}, nil) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return wr.Marshal()
+}
+
+func (p *processor) jsonLog(raw []byte) ([]byte, error) {
+	if !p.contexts[ctxLog] {
+		return nil, nil
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	if !p.run(ctxLog, mapAttrs(obj), nil) {
+		return nil, nil
+	}
+	return json.Marshal(obj)
+}
+
+// resource transforms the resource attributes (creating the resource if a
+// statement adds to an empty one) and returns them for CEL's `resource`.
+func (p *processor) resource(r **resv1.Resource, changed *bool) map[string]any {
+	if *r == nil {
+		*r = &resv1.Resource{}
+	}
+	a := kvAttrs{&(*r).Attributes}
+	if p.contexts[ctxResource] && p.run(ctxResource, a, nil) {
+		*changed = true
+	}
+	return a.view()
+}
+
+func (p *processor) scope(s *com.InstrumentationScope, res map[string]any, changed *bool) {
+	if s == nil || !p.contexts[ctxScope] {
+		return
+	}
+	if p.run(ctxScope, kvAttrs{&s.Attributes}, res) {
+		*changed = true
+	}
+}
+
+// dataPointAttrs returns the attribute lists of every data point of m.
+func dataPointAttrs(m *met.Metric) []*[]*com.KeyValue {
+	var out []*[]*com.KeyValue
+	switch d := m.Data.(type) {
+	case *met.Metric_Gauge:
+		for _, dp := range d.Gauge.GetDataPoints() {
+			out = append(out, &dp.Attributes)
+		}
+	case *met.Metric_Sum:
+		for _, dp := range d.Sum.GetDataPoints() {
+			out = append(out, &dp.Attributes)
+		}
+	case *met.Metric_Histogram:
+		for _, dp := range d.Histogram.GetDataPoints() {
+			out = append(out, &dp.Attributes)
+		}
+	case *met.Metric_ExponentialHistogram:
+		for _, dp := range d.ExponentialHistogram.GetDataPoints() {
+			out = append(out, &dp.Attributes)
+		}
+	case *met.Metric_Summary:
+		for _, dp := range d.Summary.GetDataPoints() {
+			out = append(out, &dp.Attributes)
+		}
+	}
+	return out
+}
+
+func marshalIf(changed bool, m proto.Message) ([]byte, error) {
+	if !changed {
+		return nil, nil
+	}
+	return proto.Marshal(m)
+}
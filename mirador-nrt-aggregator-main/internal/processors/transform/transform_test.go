@@ -0,0 +1,261 @@
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	prompb "github.com/prometheus/prometheus/prompb"
+	colllog "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+func newProc(t *testing.T, statements ...map[string]any) *processor {
+	t.Helper()
+	xs := make([]any, len(statements))
+	for i, st := range statements {
+		xs[i] = st
+	}
+	p := New(config.ProcessorCfg{Extra: map[string]any{"statements": xs}})
+	if len(p.statements) != len(statements) {
+		t.Fatalf("parsed %d of %d statements", len(p.statements), len(statements))
+	}
+	return p
+}
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		st          map[string]any
+		in          map[string]any
+		want        map[string]any
+		wantChanged bool
+	}{
+		{
+			name:        "set constant",
+			st:          map[string]any{"action": "set", "key": "env", "value": "prod"},
+			in:          map[string]any{"a": "x"},
+			want:        map[string]any{"a": "x", "env": "prod"},
+			wantChanged: true,
+		},
+		{
+			name: "set to the current value is no change",
+			st:   map[string]any{"action": "set", "key": "env", "value": "prod"},
+			in:   map[string]any{"env": "prod"},
+			want: map[string]any{"env": "prod"},
+		},
+		{
+			name: "set number kept JSON-native",
+			st:   map[string]any{"action": "set", "key": "n", "value": 3},
+			in:   map[string]any{"n": 3.0},
+			want: map[string]any{"n": 3.0},
+		},
+		{
+			name:        "set value_expr",
+			st:          map[string]any{"action": "set", "key": "service", "value_expr": `attrs["ns"] + "/" + attrs["service"]`},
+			in:          map[string]any{"ns": "shop", "service": "cart"},
+			want:        map[string]any{"ns": "shop", "service": "shop/cart"},
+			wantChanged: true,
+		},
+		{
+			name: "where guard false",
+			st:   map[string]any{"action": "set", "key": "env", "value": "prod", "where": `"k8s" in attrs`},
+			in:   map[string]any{"a": "x"},
+			want: map[string]any{"a": "x"},
+		},
+		{
+			name:        "delete by key_pattern",
+			st:          map[string]any{"action": "delete", "key_pattern": "^(pod|request_id)$"},
+			in:          map[string]any{"pod": "p-1", "request_id": "r", "route": "/a"},
+			want:        map[string]any{"route": "/a"},
+			wantChanged: true,
+		},
+		{
+			name: "delete missing key",
+			st:   map[string]any{"action": "delete", "key": "pod"},
+			in:   map[string]any{"route": "/a"},
+			want: map[string]any{"route": "/a"},
+		},
+		{
+			name:        "rename",
+			st:          map[string]any{"action": "rename", "key": "svc", "to": "service"},
+			in:          map[string]any{"svc": "cart"},
+			want:        map[string]any{"service": "cart"},
+			wantChanged: true,
+		},
+		{
+			name:        "rename key_pattern with $1",
+			st:          map[string]any{"action": "rename", "key_pattern": "^http_(.*)$", "to": "http.$1"},
+			in:          map[string]any{"http_method": "GET", "http_route": "/a", "other": 1.0},
+			want:        map[string]any{"http.method": "GET", "http.route": "/a", "other": 1.0},
+			wantChanged: true,
+		},
+		{
+			name:        "hash with salt and length",
+			st:          map[string]any{"action": "hash", "key": "user.email", "salt": "s3", "length": 16},
+			in:          map[string]any{"user.email": "a@b.c"},
+			want:        map[string]any{"user.email": sha("s3a@b.c")[:16]},
+			wantChanged: true,
+		},
+		{
+			name:        "hash full length of a number",
+			st:          map[string]any{"action": "hash", "key": "user.id"},
+			in:          map[string]any{"user.id": 42.0},
+			want:        map[string]any{"user.id": sha("42")},
+			wantChanged: true,
+		},
+		{
+			name:        "truncate on runes",
+			st:          map[string]any{"action": "truncate", "key": "msg", "length": 4},
+			in:          map[string]any{"msg": "héllo wörld"},
+			want:        map[string]any{"msg": "héll"},
+			wantChanged: true,
+		},
+		{
+			name: "truncate short value",
+			st:   map[string]any{"action": "truncate", "key": "msg", "length": 4},
+			in:   map[string]any{"msg": "hé"},
+			want: map[string]any{"msg": "hé"},
+		},
+		{
+			name:        "replace",
+			st:          map[string]any{"action": "replace", "key": "service", "pattern": `-(prod|v\d+)$`, "replacement": ""},
+			in:          map[string]any{"service": "cart-v2"},
+			want:        map[string]any{"service": "cart"},
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProc(t, tt.st)
+			if got := p.run(ctxLog, mapAttrs(tt.in), nil); got != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", got, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(tt.in, tt.want) {
+				t.Fatalf("got %v, want %v", tt.in, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidStatementsSkipped(t *testing.T) {
+	p := New(config.ProcessorCfg{Extra: map[string]any{"statements": []any{
+		map[string]any{"action": "set", "key": "a"},                     // no value
+		map[string]any{"action": "truncate", "key": "a"},                // no length
+		map[string]any{"action": "rename", "key": "a"},                  // no to
+		map[string]any{"action": "delete", "key": "a", "context": "x"},  // bad context
+		map[string]any{"action": "explode", "key": "a"},                 // bad action
+		map[string]any{"action": "delete"},                              // no key
+		map[string]any{"action": "delete", "key": "a", "where": "1 + "}, // bad CEL
+		map[string]any{"action": "delete", "key": "a"},
+	}}})
+	if len(p.statements) != 1 {
+		t.Fatalf("statements = %d, want 1", len(p.statements))
+	}
+}
+
+func TestPromRWLabelsStaySorted(t *testing.T) {
+	p := newProc(t,
+		map[string]any{"action": "rename", "key": "a_pod", "to": "z_pod"},
+		map[string]any{"action": "set", "key": "env", "value": "prod", "context": "datapoint"},
+	)
+	wr := prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: "up"},
+			{Name: "a_pod", Value: "p-1"},
+			{Name: "job", Value: "node"},
+		},
+		Samples: []prompb.Sample{{Value: 1}},
+	}}}
+	raw, err := wr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := p.envelope(model.Envelope{Kind: model.KindPromRW, Bytes: raw})
+
+	var got prompb.WriteRequest
+	if err := got.Unmarshal(env.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	want := []prompb.Label{
+		{Name: "__name__", Value: "up"},
+		{Name: "env", Value: "prod"},
+		{Name: "job", Value: "node"},
+		{Name: "z_pod", Value: "p-1"},
+	}
+	if !reflect.DeepEqual(got.Timeseries[0].Labels, want) {
+		t.Fatalf("labels = %v, want %v", got.Timeseries[0].Labels, want)
+	}
+}
+
+func TestJSONLogsPayloadDetection(t *testing.T) {
+	p := newProc(t, map[string]any{"action": "set", "key": "env", "value": "prod", "context": "log"})
+
+	t.Run("JSON object", func(t *testing.T) {
+		env := p.envelope(model.Envelope{Kind: model.KindJSONLogs, Bytes: []byte(` {"message":"hi"}`)})
+		var obj map[string]any
+		if err := json.Unmarshal(env.Bytes, &obj); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]any{"message": "hi", "env": "prod"}; !reflect.DeepEqual(obj, want) {
+			t.Fatalf("got %v, want %v", obj, want)
+		}
+	})
+
+	t.Run("OTLP protobuf", func(t *testing.T) {
+		req := &colllog.ExportLogsServiceRequest{ResourceLogs: []*logs.ResourceLogs{{
+			ScopeLogs: []*logs.ScopeLogs{{LogRecords: []*logs.LogRecord{{
+				Body: &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: "hi"}},
+			}}}},
+		}}}
+		raw, err := proto.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := p.envelope(model.Envelope{Kind: model.KindJSONLogs, Bytes: raw})
+		var got colllog.ExportLogsServiceRequest
+		if err := proto.Unmarshal(env.Bytes, &got); err != nil {
+			t.Fatal(err)
+		}
+		attrs := got.ResourceLogs[0].ScopeLogs[0].LogRecords[0].Attributes
+		if len(attrs) != 1 || attrs[0].Key != "env" || attrs[0].Value.GetStringValue() != "prod" {
+			t.Fatalf("attributes = %v", attrs)
+		}
+	})
+
+	t.Run("unchanged payload is not re-encoded", func(t *testing.T) {
+		raw := []byte(`{"env": "prod"}`)
+		env := p.envelope(model.Envelope{Kind: model.KindJSONLogs, Bytes: raw})
+		if string(env.Bytes) != string(raw) {
+			t.Fatalf("bytes = %s, want the input", env.Bytes)
+		}
+	})
+}
+
+func TestAggregateLabelsCopied(t *testing.T) {
+	p := newProc(t,
+		map[string]any{"action": "delete", "key": "pod", "context": "labels"},
+		map[string]any{"action": "set", "key": "env", "value": "prod", "context": "labels"},
+	)
+	shared := map[string]string{"pod": "p-1", "source": "metrics"}
+	out := p.aggregate(model.Aggregate{Labels: shared})
+
+	if want := map[string]string{"pod": "p-1", "source": "metrics"}; !reflect.DeepEqual(shared, want) {
+		t.Fatalf("input labels mutated: %v", shared)
+	}
+	if want := map[string]string{"env": "prod", "source": "metrics"}; !reflect.DeepEqual(out.Labels, want) {
+		t.Fatalf("labels = %v, want %v", out.Labels, want)
+	}
+}
@@ -44,7 +44,8 @@ import (
 //       include_fields: ["org_id","success","error_code"]   # will be spliced into the text context if present
 //     metrics:
 //       # build numerical features -> (optional) PCA projection
-//       p90_approx: true            # use (p50+p95)/2 as p90 approximation
+//       p90_approx: true            # use (p50+p95)/2 as p90 approximation (unless q0.9 is configured upstream)
+//       quantiles: ["q0.75","q0.999"] # extra features from Aggregate.Quantiles, appended after the base 8
 //       ema_alpha: 0.3              # optional EMA smoothing across windows (per service)
 //       pca:
 //         enabled: true
@@ -80,6 +81,7 @@ type processor struct {
 
 	// METRICS options
 	p90Approx bool
+	quantiles []string // Aggregate.Quantiles names appended to the base features
 	emaAlpha  float64
 	pca       *pcaModel // optional
 	// EMA state per service for metrics (RPS, ErrorRate, p50,p90,p95,p99,errCount,countNorm)
@@ -159,6 +161,7 @@ func New(cfg config.ProcessorCfg) *processor {
 	if v, ok := nestedBool(cfg.Extra, "metrics", "p90_approx"); ok {
 		p90Approx = v
 	}
+	metricQuantiles, _ := nestedStringSlice(cfg.Extra, "metrics", "quantiles")
 	emaAlpha := 0.0
 	if v, ok := nestedNumber(cfg.Extra, "metrics", "ema_alpha"); ok {
 		emaAlpha = v
@@ -199,6 +202,7 @@ func New(cfg config.ProcessorCfg) *processor {
 		logsInclude: logsInc,
 
 		p90Approx: p90Approx,
+		quantiles: metricQuantiles,
 		emaAlpha:  emaAlpha,
 		pca:       pca,
 		ema:       map[string][]float64{},
@@ -295,9 +299,11 @@ func (p *processor) buildLogsText(a model.Aggregate) string {
 
 func (p *processor) buildMetricsVector(a model.Aggregate) []float32 {
 	// Build numeric features for service health
-	// base: [p50, p90, p95, p99, rps, error_rate, error_count, count_norm]
+	// base: [p50, p90, p95, p99, rps, error_rate, error_count, count_norm, <metrics.quantiles>...]
 	p90 := a.P95
-	if p.p90Approx {
+	if q, ok := a.Quantile("q0.9"); ok {
+		p90 = q
+	} else if p.p90Approx {
 		p90 = 0.5*(a.P50+a.P95)
 	}
 	errCount := a.ErrorRate * float64(a.Count)
@@ -307,6 +313,10 @@ func (p *processor) buildMetricsVector(a model.Aggregate) []float32 {
 		a.P50, p90, a.P95, a.P99,
 		a.RPS, a.ErrorRate, errCount, countNorm,
 	}
+	for _, name := range p.quantiles {
+		q, _ := a.Quantile(name) // missing → 0 keeps the vector length stable
+		base = append(base, q)
+	}
 
 	// Optional EMA smoothing per service
 	if p.emaAlpha > 0 && p.emaAlpha <= 1 {
@@ -376,6 +386,7 @@ func (p *processor) buildTracesText(a model.Aggregate) string {
 		"rps=" + f6(a.RPS),
 		"error_rate=" + f6(a.ErrorRate),
 	}
+	parts = append(parts, quantileParts(a)...)
 	// include a few span/resource attrs if upstream added them into Labels
 	// (e.g., http.method, http.route, status.code, span.kind, etc.)
 	if len(p.traceAttrs) > 0 {
@@ -697,6 +708,16 @@ func nestedInt(extra map[string]any, k1, k2 string) (int, bool) {
 	return 0, false
 }
 
+// quantileParts renders Aggregate.Quantiles as sorted "q0.999=..." tokens.
+func quantileParts(a model.Aggregate) []string {
+	parts := make([]string, 0, len(a.Quantiles))
+	for k, v := range a.Quantiles {
+		parts = append(parts, k+"="+f6(v))
+	}
+	sort.Strings(parts)
+	return parts
+}
+
 // ---------------------- Fallback summary ----------------------
 
 func (p *processor) defaultSummary(a model.Aggregate) string {
@@ -712,5 +733,8 @@ func (p *processor) defaultSummary(a model.Aggregate) string {
 	sb.WriteString(" p99="); sb.WriteString(f6(a.P99))
 	sb.WriteString(" rps="); sb.WriteString(f6(a.RPS))
 	sb.WriteString(" err_rate="); sb.WriteString(f6(a.ErrorRate))
+	for _, qp := range quantileParts(a) {
+		sb.WriteString(" "); sb.WriteString(qp)
+	}
 	return sb.String()
 }
\ No newline at end of file
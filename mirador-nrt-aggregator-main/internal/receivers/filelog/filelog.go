@@ -0,0 +1,656 @@
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+var linesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mirador_filelog_lines_total",
+	Help: "Lines read by the filelog receiver, by parse result (parsed, unparsed).",
+}, []string{"result"})
+
+// Receiver tails files matching glob patterns and emits one KindJSONLogs
+// envelope per line.
+//
+// Files are identified by a fingerprint (their first fingerprint_bytes, after
+// decompression for *.gz), not by path, so:
+//   - rename rotation (app.log → app.log.1) keeps reading the renamed file from
+//     its offset, provided include also matches the rotated name;
+//   - copytruncate is detected when the file shrinks below its offset (or its
+//     head changes) and the file is re-read from the start;
+//   - a gzip'd rotated file (app.log.1.gz) resumes after the lines already read
+//     from the plain file and is then marked done; while the rotator is still
+//     compressing and app.log.1 exists with the same head, the .gz is skipped.
+//
+// Offsets advance only once the envelopes carrying the lines are acked (after
+// export); a failed export rewinds to the last committed offset. Committed
+// offsets are written to offsets_file so restarts neither skip nor re-read
+// lines (a crash between export and the next save may repeat a poll's worth).
+//
+// Config (receivers.filelog):
+//
+//	include: ["/var/log/app/*.log*"]   # globs (required)
+//	exclude: ["/var/log/app/debug*"]
+//	start_at: end                     # end | beginning — for files found at startup without a stored offset
+//	poll_interval_ms: 500
+//	offsets_file: /var/lib/mirador/filelog-offsets.json   # empty = in-memory only
+//	fingerprint_bytes: 1000
+//	max_line_bytes: 65536             # longer lines are truncated
+//	max_batch_bytes: 16777216         # read at most this much per file per poll
+//	state_ttl_ms: 3600000             # forget files not seen for this long
+//	format: json                      # json | logfmt | regex | none
+//	regex: '^(?P<ts>\S+) (?P<level>\w+) (?P<message>.*)$'   # format=regex: named groups → fields
+//	service: billing                  # static service when the line has none
+//	service_fields: [service, app]    # first non-empty field → "service"
+//	level_fields: [level, lvl, severity]   # first non-empty field → "level"
+//
+// Every record carries "message" (the raw line unless parsed), "ts" (now
+// unless parsed), "log.file.path" and "log.file.name".
+type Receiver struct {
+	name       string
+	include    []string
+	exclude    []string
+	startAtEnd bool
+	interval   time.Duration
+
+	offsetsFile string
+	fpBytes     int
+	maxLine     int
+	maxBatch    int64
+	stateTTL    time.Duration
+
+	format        string
+	re            *regexp.Regexp
+	service       string
+	serviceFields []string
+	levelFields   []string
+
+	mu     sync.Mutex
+	states []*fileState
+	dirty  bool
+}
+
+// fileState is the tracked position of one file. Exported fields are persisted.
+type fileState struct {
+	Path        string `json:"path"`
+	Fingerprint []byte `json:"fingerprint"`
+	Offset      int64  `json:"offset"`         // committed (acked) offset
+	Done        bool   `json:"done,omitempty"` // gzip file fully consumed
+	SeenUnix    int64  `json:"seen_unix"`
+
+	read    int64    // next byte to read (>= Offset)
+	drained bool     // gzip file read to EOF, waiting for acks
+	gen     int      // bumped on rewind; acks of older batches are ignored
+	pending []*batch // in-flight batches, in read order
+}
+
+type batch struct {
+	gen   int
+	end   int64
+	eof   bool
+	acked bool
+}
+
+type offsetsDoc struct {
+	Files []*fileState `json:"files"`
+}
+
+// New builds a filelog receiver.
+func New(rc config.ReceiverCfg) *Receiver {
+	interval := 500 * time.Millisecond
+	if v, ok := rc.Extra["poll_interval_ms"].(int); ok && v > 0 {
+		interval = time.Duration(v) * time.Millisecond
+	}
+	startAtEnd := true
+	if s, ok := rc.Extra["start_at"].(string); ok && strings.EqualFold(s, "beginning") {
+		startAtEnd = false
+	}
+	offsetsFile, _ := rc.Extra["offsets_file"].(string)
+	fp := 1000
+	if v, ok := rc.Extra["fingerprint_bytes"].(int); ok && v > 0 {
+		fp = v
+	}
+	maxLine := 64 * 1024
+	if v, ok := rc.Extra["max_line_bytes"].(int); ok && v > 0 {
+		maxLine = v
+	}
+	maxBatch := int64(16 * 1024 * 1024)
+	if v, ok := rc.Extra["max_batch_bytes"].(int); ok && v > 0 {
+		maxBatch = int64(v)
+	}
+	ttl := time.Hour
+	if v, ok := rc.Extra["state_ttl_ms"].(int); ok && v > 0 {
+		ttl = time.Duration(v) * time.Millisecond
+	}
+
+	format := "json"
+	if s, ok := rc.Extra["format"].(string); ok && s != "" {
+		format = strings.ToLower(strings.TrimSpace(s))
+	}
+	var re *regexp.Regexp
+	if s, ok := rc.Extra["regex"].(string); ok && s != "" {
+		var err error
+		if re, err = regexp.Compile(s); err != nil {
+			log.Printf("[filelog] invalid regex %q: %v; lines will be unparsed", s, err)
+			re = nil
+		}
+	}
+	if format == "regex" && re == nil {
+		format = "none"
+	}
+	service, _ := rc.Extra["service"].(string)
+
+	return &Receiver{
+		name:          rc.Name,
+		include:       stringList(rc.Extra["include"], nil),
+		exclude:       stringList(rc.Extra["exclude"], nil),
+		startAtEnd:    startAtEnd,
+		interval:      interval,
+		offsetsFile:   offsetsFile,
+		fpBytes:       fp,
+		maxLine:       maxLine,
+		maxBatch:      maxBatch,
+		stateTTL:      ttl,
+		format:        format,
+		re:            re,
+		service:       service,
+		serviceFields: stringList(rc.Extra["service_fields"], []string{"service", "service.name", "app"}),
+		levelFields:   stringList(rc.Extra["level_fields"], []string{"level", "lvl", "severity"}),
+	}
+}
+
+// Start polls the include globs until ctx is cancelled, then saves offsets.
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	if len(r.include) == 0 {
+		return fmt.Errorf("filelog %q: include is required", r.name)
+	}
+	if err := r.load(); err != nil {
+		return fmt.Errorf("filelog %q: load offsets: %w", r.name, err)
+	}
+	if r.offsetsFile == "" {
+		log.Printf("[filelog] offsets_file not set; offsets are not persisted across restarts")
+	}
+	log.Printf("[filelog] tailing %v every %s (format=%s)", r.include, r.interval, r.format)
+
+	first := true
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.poll(ctx, out, first)
+		first = false
+		if err := r.save(); err != nil {
+			log.Printf("[filelog] save offsets: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			// Persist whatever was acked while shutting down.
+			if err := r.save(); err != nil {
+				log.Printf("[filelog] save offsets: %v", err)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ---------------- polling ----------------
+
+func (r *Receiver) poll(ctx context.Context, out chan<- model.Envelope, first bool) {
+	claimed := map[*fileState]bool{}
+	for _, path := range r.match() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := r.pollFile(ctx, out, path, first, claimed); err != nil {
+			log.Printf("[filelog] %s: %v", path, err)
+		}
+	}
+
+	// Forget files that have been gone for longer than state_ttl.
+	cutoff := time.Now().Add(-r.stateTTL).Unix()
+	r.mu.Lock()
+	kept := r.states[:0]
+	for _, st := range r.states {
+		if claimed[st] || st.SeenUnix >= cutoff || len(st.pending) > 0 {
+			kept = append(kept, st)
+		} else {
+			r.dirty = true
+		}
+	}
+	r.states = kept
+	r.mu.Unlock()
+}
+
+func (r *Receiver) match() []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, pat := range r.include {
+		ms, err := filepath.Glob(pat)
+		if err != nil {
+			log.Printf("[filelog] bad include pattern %q: %v", pat, err)
+			continue
+		}
+		for _, p := range ms {
+			if seen[p] || r.excluded(p) {
+				continue
+			}
+			if fi, err := os.Stat(p); err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (r *Receiver) excluded(path string) bool {
+	for _, pat := range r.exclude {
+		if ok, _ := filepath.Match(pat, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Receiver) pollFile(ctx context.Context, out chan<- model.Envelope, path string, first bool, claimed map[*fileState]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	gz := strings.HasSuffix(path, ".gz")
+
+	var src io.Reader = f
+	if gz {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil // still being written by the rotator
+		}
+		defer gr.Close()
+		src = gr
+	}
+	br := bufio.NewReaderSize(src, 64*1024)
+	head, _ := br.Peek(r.fpBytes)
+	if len(head) == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	if gz && r.shadowed(path, head, claimed) {
+		r.mu.Unlock()
+		return nil
+	}
+	st := r.lookup(path, head, claimed)
+	if st == nil {
+		st = &fileState{Fingerprint: append([]byte(nil), head...)}
+		if first && r.startAtEnd {
+			if gz {
+				st.Done = true
+			} else {
+				st.Offset, st.read = fi.Size(), fi.Size()
+			}
+		}
+		r.states = append(r.states, st)
+		r.dirty = true
+	}
+	claimed[st] = true
+	if st.Path != path {
+		st.Path = path
+		r.dirty = true
+	}
+	st.SeenUnix = time.Now().Unix()
+	if len(head) > len(st.Fingerprint) {
+		st.Fingerprint = append([]byte(nil), head...)
+	}
+	if !gz && fi.Size() < st.read {
+		// copytruncate: same head, file shrank below our position.
+		st.rewind(0)
+		r.dirty = true
+	}
+	from, gen := st.read, st.gen
+	skip := st.Done || st.drained || (!gz && fi.Size() == st.read)
+	r.mu.Unlock()
+	if skip {
+		return nil
+	}
+
+	if gz {
+		if _, err := io.CopyN(io.Discard, br, from); err != nil {
+			return fmt.Errorf("gzip shorter than offset: %w", err)
+		}
+	} else {
+		if _, err := f.Seek(from, io.SeekStart); err != nil {
+			return err
+		}
+		br.Reset(f)
+	}
+
+	lines, end, eof, err := r.readLines(br, from, gz)
+	if err != nil {
+		return err
+	}
+	if end == from && !eof {
+		return nil
+	}
+
+	b := &batch{gen: gen, end: end, eof: gz && eof}
+	r.mu.Lock()
+	if st.gen != gen {
+		r.mu.Unlock()
+		return nil // rewound while reading
+	}
+	st.read = end
+	st.drained = b.eof
+	st.pending = append(st.pending, b)
+	r.mu.Unlock()
+
+	acks := ack.Fanout(&batchAcker{r: r, st: st, b: b}, len(lines))
+	now := time.Now()
+	for i, line := range lines {
+		rec, err := json.Marshal(r.record(path, line, now))
+		if err != nil {
+			ack.Done(acks[i])
+			continue
+		}
+		select {
+		case out <- model.Envelope{Kind: model.KindJSONLogs, Bytes: rec, Attrs: map[string]string{"source": "filelog"}, TSUnix: now.Unix(), Ack: acks[i]}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// lookup finds the state for a file: same path and matching head first, then
+// any unclaimed state whose fingerprint prefixes head (renamed or compressed).
+// Called with r.mu held.
+func (r *Receiver) lookup(path string, head []byte, claimed map[*fileState]bool) *fileState {
+	var best *fileState
+	for _, st := range r.states {
+		if claimed[st] || len(st.Fingerprint) == 0 || !bytes.HasPrefix(head, st.Fingerprint) {
+			continue
+		}
+		if st.Path == path {
+			return st
+		}
+		if best == nil || len(st.Fingerprint) > len(best.Fingerprint) {
+			best = st
+		}
+	}
+	return best
+}
+
+// shadowed reports whether the uncompressed sibling of a .gz path (app.log.1
+// for app.log.1.gz) was polled with the same head: the rotator is still
+// compressing, and the .gz takes over that state once the sibling is removed.
+// Called with r.mu held.
+func (r *Receiver) shadowed(path string, head []byte, claimed map[*fileState]bool) bool {
+	plain := strings.TrimSuffix(path, ".gz")
+	for st := range claimed {
+		if st.Path == plain && len(st.Fingerprint) > 0 && bytes.HasPrefix(head, st.Fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// readLines reads complete lines from br (positioned at from) up to
+// max_batch_bytes. A trailing line without '\n' is left for the next poll,
+// except in gzip files, which are complete by definition.
+func (r *Receiver) readLines(br *bufio.Reader, from int64, gz bool) ([]string, int64, bool, error) {
+	var (
+		lines []string
+		pos   = from
+		cur   []byte // line so far, capped at max_line_bytes+2
+		n     int64  // full length of the current line
+	)
+	for pos-from < r.maxBatch {
+		chunk, err := br.ReadSlice('\n')
+		n += int64(len(chunk))
+		if room := r.maxLine + 2 - len(cur); room > 0 {
+			cur = append(cur, chunk[:min(room, len(chunk))]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			if gz && n > 0 {
+				pos += n
+				lines = append(lines, r.trimLine(cur))
+			}
+			return lines, pos, true, nil
+		}
+		if err != nil {
+			return nil, from, false, err
+		}
+		pos += n
+		if l := r.trimLine(cur); l != "" {
+			lines = append(lines, l)
+		}
+		cur, n = cur[:0], 0
+	}
+	return lines, pos, false, nil
+}
+
+func (r *Receiver) trimLine(b []byte) string {
+	b = bytes.TrimRight(b, "\r\n")
+	if len(b) > r.maxLine {
+		b = b[:r.maxLine]
+	}
+	return string(b)
+}
+
+// rewind moves the read position back to off and invalidates in-flight batches.
+// Called with r.mu held.
+func (st *fileState) rewind(off int64) {
+	st.Offset, st.read = off, off
+	st.drained = false
+	st.Done = false
+	st.pending = nil
+	st.gen++
+}
+
+// batchAcker commits a batch's end offset once it and every earlier batch of
+// the same file are acked. Malformed nacks count as acked (the lines would
+// never succeed); any other failure rewinds to the committed offset.
+type batchAcker struct {
+	r  *Receiver
+	st *fileState
+	b  *batch
+}
+
+func (a *batchAcker) Ack() { a.resolve(nil) }
+
+func (a *batchAcker) Nack(err error) { a.resolve(err) }
+
+func (a *batchAcker) resolve(err error) {
+	r, st := a.r, a.st
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a.b.gen != st.gen {
+		return
+	}
+	if err != nil && !ack.IsMalformed(err) {
+		log.Printf("[filelog] %s: export failed, re-reading from offset %d: %v", st.Path, st.Offset, err)
+		st.rewind(st.Offset)
+		return
+	}
+	a.b.acked = true
+	for len(st.pending) > 0 && st.pending[0].acked {
+		b := st.pending[0]
+		st.pending = st.pending[1:]
+		st.Offset = b.end
+		if b.eof {
+			st.Done = true
+		}
+		r.dirty = true
+	}
+}
+
+// ---------------- records ----------------
+
+func (r *Receiver) record(path, line string, now time.Time) map[string]any {
+	var obj map[string]any
+	switch r.format {
+	case "json":
+		if strings.HasPrefix(strings.TrimSpace(line), "{") {
+			_ = json.Unmarshal([]byte(line), &obj)
+		}
+	case "logfmt":
+		// Plain text parses as bare keys; require at least one key=value.
+		if strings.Contains(line, "=") {
+			obj = common.ParseLogfmt(line)
+		}
+	case "regex":
+		if m := r.re.FindStringSubmatch(line); m != nil {
+			obj = map[string]any{}
+			for i, name := range r.re.SubexpNames() {
+				if name != "" && m[i] != "" {
+					obj[name] = m[i]
+				}
+			}
+		}
+	}
+	if obj == nil {
+		linesTotal.WithLabelValues("unparsed").Inc()
+		obj = map[string]any{}
+	} else if r.format != "none" {
+		linesTotal.WithLabelValues("parsed").Inc()
+	}
+
+	if _, ok := obj["message"]; !ok {
+		if msg, ok := obj["msg"]; ok {
+			obj["message"] = msg
+		} else {
+			obj["message"] = line
+		}
+	}
+	if _, ok := obj["ts"]; !ok {
+		obj["ts"] = now.UTC().Format(time.RFC3339Nano)
+	}
+	if s := firstField(obj, r.serviceFields); s != "" {
+		obj["service"] = s
+	} else if r.service != "" {
+		obj["service"] = r.service
+	}
+	if s := firstField(obj, r.levelFields); s != "" {
+		obj["level"] = strings.ToLower(s)
+	}
+	obj["log.file.path"] = path
+	obj["log.file.name"] = filepath.Base(path)
+	return obj
+}
+
+func firstField(obj map[string]any, keys []string) string {
+	for _, k := range keys {
+		switch v := obj[k].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// ---------------- offsets persistence ----------------
+
+func (r *Receiver) load() error {
+	if r.offsetsFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(r.offsetsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var doc offsetsDoc
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, st := range doc.Files {
+		if st == nil || len(st.Fingerprint) == 0 {
+			continue
+		}
+		st.read = st.Offset
+		r.states = append(r.states, st)
+	}
+	log.Printf("[filelog] restored offsets for %d files from %s", len(r.states), r.offsetsFile)
+	return nil
+}
+
+// save writes committed offsets atomically (temp file + rename) when changed.
+func (r *Receiver) save() error {
+	if r.offsetsFile == "" {
+		return nil
+	}
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(offsetsDoc{Files: r.states})
+	r.dirty = false
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.offsetsFile), 0o755); err != nil {
+		return err
+	}
+	tmp := r.offsetsFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.offsetsFile)
+}
+
+func stringList(v any, def []string) []string {
+	arr, ok := v.([]any)
+	if !ok {
+		return def
+	}
+	out := make([]string, 0, len(arr))
+	for _, it := range arr {
+		if s, ok := it.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
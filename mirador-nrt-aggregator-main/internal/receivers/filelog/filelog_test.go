@@ -0,0 +1,243 @@
+package filelog
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+func newTestReceiver(t *testing.T, dir string, extra map[string]any) *Receiver {
+	t.Helper()
+	cfg := map[string]any{
+		"include":  []any{filepath.Join(dir, "app.log*")},
+		"start_at": "beginning",
+		"format":   "none",
+	}
+	for k, v := range extra {
+		cfg[k] = v
+	}
+	r := New(config.ReceiverCfg{Name: "filelog", Extra: cfg})
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// pollOnce runs one poll and returns the emitted envelopes.
+func pollOnce(t *testing.T, r *Receiver, first bool) []model.Envelope {
+	t.Helper()
+	out := make(chan model.Envelope, 100)
+	r.poll(context.Background(), out, first)
+	close(out)
+	var envs []model.Envelope
+	for e := range out {
+		envs = append(envs, e)
+	}
+	return envs
+}
+
+func messages(t *testing.T, envs []model.Envelope) []string {
+	t.Helper()
+	var out []string
+	for _, e := range envs {
+		var rec map[string]any
+		if err := json.Unmarshal(e.Bytes, &rec); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rec["message"].(string))
+	}
+	return out
+}
+
+func ackAll(envs []model.Envelope) {
+	for _, e := range envs {
+		ack.Done(e.Ack)
+	}
+}
+
+func writeFile(t *testing.T, path, s string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeGzip(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expect(t *testing.T, envs []model.Envelope, want ...string) {
+	t.Helper()
+	if got := messages(t, envs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestRenameRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	r := newTestReceiver(t, dir, nil)
+
+	writeFile(t, path, "a\nb\n")
+	envs := pollOnce(t, r, true)
+	expect(t, envs, "a", "b")
+	ackAll(envs)
+
+	// The writer still appends to the renamed file before reopening.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "x\n")
+	writeFile(t, path, "c\n")
+	expect(t, pollOnce(t, r, false), "c", "x")
+}
+
+func TestCopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	r := newTestReceiver(t, dir, map[string]any{"fingerprint_bytes": 4})
+
+	writeFile(t, path, "hdr\na\nb\n")
+	envs := pollOnce(t, r, true)
+	expect(t, envs, "hdr", "a", "b")
+	ackAll(envs)
+
+	// Copied away and truncated: same head, shorter than the offset.
+	writeFile(t, path, "hdr\nc\n")
+	expect(t, pollOnce(t, r, false), "hdr", "c")
+}
+
+func TestGzipResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	r := newTestReceiver(t, dir, nil)
+
+	writeFile(t, path, "a\nb\n")
+	envs := pollOnce(t, r, true)
+	expect(t, envs, "a", "b")
+	ackAll(envs)
+
+	// logrotate compress: app.log → app.log.1 (one more line written), then
+	// app.log.1.gz is written while app.log.1 still exists.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "c\n")
+	writeGzip(t, path+".1.gz", "a\nb\nc\n")
+	envs = pollOnce(t, r, false)
+	expect(t, envs, "c")
+	ackAll(envs)
+
+	// Compression done: the .gz takes over the state and has nothing left.
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, pollOnce(t, r, false))
+	expect(t, pollOnce(t, r, false))
+	for _, st := range r.states {
+		if st.Path == path+".1.gz" && !st.Done {
+			t.Fatalf("gzip state not done: %+v", st)
+		}
+	}
+}
+
+func TestGzipResumeWithoutPlainFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	r := newTestReceiver(t, dir, nil)
+
+	writeFile(t, path, "a\nb\n")
+	envs := pollOnce(t, r, true)
+	ackAll(envs)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	writeGzip(t, path+".1.gz", "a\nb\nc\nd\n")
+	envs = pollOnce(t, r, false)
+	expect(t, envs, "c", "d")
+	ackAll(envs)
+	expect(t, pollOnce(t, r, false))
+}
+
+func TestOffsetsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	extra := map[string]any{"offsets_file": filepath.Join(dir, "state", "offsets.json")}
+	r := newTestReceiver(t, dir, extra)
+
+	writeFile(t, path, "a\nb\n")
+	envs := pollOnce(t, r, true)
+	ackAll(envs)
+	appendFile(t, path, "c\n")
+	expect(t, pollOnce(t, r, false), "c") // read, never acked
+	if err := r.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart: only acked lines are skipped; start_at does not apply.
+	r2 := newTestReceiver(t, dir, map[string]any{"offsets_file": extra["offsets_file"], "start_at": "end"})
+	appendFile(t, path, "d\n")
+	expect(t, pollOnce(t, r2, true), "c", "d")
+}
+
+func TestNackRewinds(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	r := newTestReceiver(t, dir, nil)
+
+	writeFile(t, path, "a\nb\n")
+	envs := pollOnce(t, r, true)
+	ackAll(envs)
+	appendFile(t, path, "c\nd\n")
+	envs = pollOnce(t, r, false)
+	expect(t, envs, "c", "d")
+
+	envs[0].Ack.Nack(errors.New("export failed"))
+	envs[1].Ack.Ack() // from the failed batch: ignored
+	envs = pollOnce(t, r, false)
+	expect(t, envs, "c", "d")
+
+	// Malformed lines count as delivered.
+	envs[0].Ack.Nack(ack.Malformed(errors.New("bad line")))
+	envs[1].Ack.Ack()
+	expect(t, pollOnce(t, r, false))
+	if st := r.states[0]; st.Offset != 8 {
+		t.Fatalf("offset = %d, want 8", st.Offset)
+	}
+}
@@ -0,0 +1,413 @@
+package fluentforward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+var recordsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mirador_fluentforward_records_total",
+	Help: "Log records received over the Fluent Forward protocol, by event mode.",
+}, []string{"mode"})
+
+// Receiver implements the Fluent Forward protocol (v1) server side, as spoken
+// by Fluent Bit / Fluentd `forward` outputs. All four event modes are accepted:
+//
+//	Message:                 [tag, time, record, option?]
+//	Forward:                 [tag, [[time, record], ...], option?]
+//	PackedForward:           [tag, bin(msgpack [time, record] stream), option?]
+//	CompressedPackedForward: PackedForward with option.compressed = "gzip"
+//
+// When option.chunk is set the chunk is acknowledged ({"ack": chunk}) once its
+// records were handed to the pipeline. Each record becomes one KindJSONLogs
+// envelope: the record fields plus "ts" (RFC3339Nano), "tag" and "service"
+// (derived from the tag unless the record already has one).
+//
+// Config (receivers.fluentforward):
+//
+//	endpoint: "0.0.0.0:24224"
+//	shared_key: string            # enables the HELO/PING/PONG handshake
+//	self_hostname: string         # reported in PONG (default os.Hostname)
+//	tag_service_regex: string     # first capture group of the tag becomes service (default: whole tag)
+//	max_message_bytes: int        # largest single entry/chunk (default 16 MiB)
+//	tls.cert_file / tls.key_file / tls.client_ca_file / tls.require_client_cert
+type Receiver struct {
+	endpoint     string
+	sharedKey    string
+	selfHostname string
+	tagService   *regexp.Regexp
+	maxMsgBytes  int
+
+	tlsEnabled        bool
+	tlsCertFile       string
+	tlsKeyFile        string
+	tlsClientCAFile   string
+	requireClientCert bool
+}
+
+// New builds a Fluent Forward receiver.
+func New(rc config.ReceiverCfg) *Receiver {
+	sharedKey, _ := rc.Extra["shared_key"].(string)
+	host, _ := rc.Extra["self_hostname"].(string)
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	var tagRe *regexp.Regexp
+	if s, ok := rc.Extra["tag_service_regex"].(string); ok && s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			log.Printf("[fluentforward] invalid tag_service_regex %q: %v", s, err)
+		} else {
+			tagRe = re
+		}
+	}
+	maxMsg := 16 * 1024 * 1024
+	if v, ok := rc.Extra["max_message_bytes"].(int); ok && v > 0 {
+		maxMsg = v
+	}
+	tlsEnabled := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "enabled"); ok {
+		tlsEnabled = b
+	}
+	requireClientCert := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "require_client_cert"); ok {
+		requireClientCert = b
+	}
+	return &Receiver{
+		endpoint:          rc.Endpoint,
+		sharedKey:         sharedKey,
+		selfHostname:      host,
+		tagService:        tagRe,
+		maxMsgBytes:       maxMsg,
+		tlsEnabled:        tlsEnabled,
+		tlsCertFile:       common.NestedString(rc.Extra, "tls", "cert_file"),
+		tlsKeyFile:        common.NestedString(rc.Extra, "tls", "key_file"),
+		tlsClientCAFile:   common.NestedString(rc.Extra, "tls", "client_ca_file"),
+		requireClientCert: requireClientCert,
+	}
+}
+
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	addr := r.endpoint
+	if strings.TrimSpace(addr) == "" {
+		addr = ":24224"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if r.tlsEnabled {
+		tlsCfg, err := common.ServerTLS(r.tlsCertFile, r.tlsKeyFile, r.tlsClientCAFile, r.requireClientCert)
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("fluentforward tls: %w", err)
+		}
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+	go func() { <-ctx.Done(); _ = ln.Close() }()
+	log.Printf("[fluentforward] listening on %s tls=%v auth=%v", addr, r.tlsEnabled, r.sharedKey != "")
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("[fluentforward] accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go r.handleConn(ctx, conn, out)
+	}
+}
+
+func (r *Receiver) handleConn(ctx context.Context, conn net.Conn, out chan<- model.Envelope) {
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() { <-cctx.Done(); _ = conn.Close() }()
+
+	peer := conn.RemoteAddr().String()
+	dec := &decoder{r: bufio.NewReaderSize(conn, 64*1024), limit: r.maxMsgBytes}
+
+	if r.sharedKey != "" {
+		if err := r.handshake(conn, dec); err != nil {
+			log.Printf("[fluentforward] peer=%s auth failed: %v", peer, err)
+			return
+		}
+	}
+
+	for {
+		v, err := dec.decode()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && cctx.Err() == nil {
+				log.Printf("[fluentforward] peer=%s read error: %v", peer, err)
+			}
+			return
+		}
+		msg, ok := v.([]any)
+		if !ok || len(msg) < 2 {
+			log.Printf("[fluentforward] peer=%s unexpected message %T", peer, v)
+			return
+		}
+		chunk, err := r.handleEvent(cctx, msg, peer, out)
+		if err != nil {
+			if cctx.Err() == nil {
+				log.Printf("[fluentforward] peer=%s: %v", peer, err)
+			}
+			return
+		}
+		if chunk != "" {
+			if _, err := conn.Write(encode(nil, map[string]any{"ack": chunk})); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleEvent emits the records of one event and returns the chunk id to ack.
+func (r *Receiver) handleEvent(ctx context.Context, msg []any, peer string, out chan<- model.Envelope) (string, error) {
+	tag := asString(msg[0])
+	var (
+		mode    string
+		entries [][2]any // (time, record)
+		option  map[string]any
+	)
+	switch second := msg[1].(type) {
+	case []any:
+		mode = "forward"
+		for _, e := range second {
+			if pair, ok := e.([]any); ok && len(pair) >= 2 {
+				entries = append(entries, [2]any{pair[0], pair[1]})
+			}
+		}
+		if len(msg) > 2 {
+			option, _ = msg[2].(map[string]any)
+		}
+	case []byte, string:
+		mode = "packed_forward"
+		if len(msg) > 2 {
+			option, _ = msg[2].(map[string]any)
+		}
+		raw := []byte(asString(second))
+		if c := asString(option["compressed"]); c != "" {
+			if c != "gzip" {
+				return "", fmt.Errorf("unsupported compression %q", c)
+			}
+			mode = "compressed_packed_forward"
+			gr, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				return "", fmt.Errorf("gzip: %w", err)
+			}
+			// gzip.Reader reads concatenated members, which is how clients append chunks.
+			raw, err = io.ReadAll(io.LimitReader(gr, int64(r.maxMsgBytes)+1))
+			if err != nil {
+				return "", fmt.Errorf("gzip: %w", err)
+			}
+			if len(raw) > r.maxMsgBytes {
+				return "", errTooLarge
+			}
+		}
+		inner := &decoder{r: bufio.NewReader(bytes.NewReader(raw)), limit: r.maxMsgBytes}
+		for {
+			v, err := inner.decode()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return "", fmt.Errorf("packed entries: %w", err)
+			}
+			if pair, ok := v.([]any); ok && len(pair) >= 2 {
+				entries = append(entries, [2]any{pair[0], pair[1]})
+			}
+		}
+	default:
+		// Message mode: [tag, time, record, option?]
+		if len(msg) < 3 {
+			return "", errors.New("message mode: missing record")
+		}
+		mode = "message"
+		entries = [][2]any{{msg[1], msg[2]}}
+		if len(msg) > 3 {
+			option, _ = msg[3].(map[string]any)
+		}
+	}
+
+	attrs := map[string]string{"fluent.tag": tag, "fluent.peer": peer}
+	service := r.serviceFromTag(tag)
+	for _, e := range entries {
+		rec, ok := e[1].(map[string]any)
+		if !ok {
+			continue
+		}
+		b, err := json.Marshal(flatten(rec, tag, service, eventTime(e[0])))
+		if err != nil {
+			log.Printf("[fluentforward] json marshal error: %v", err)
+			continue
+		}
+		select {
+		case out <- model.Envelope{Kind: model.KindJSONLogs, Bytes: b, Attrs: attrs, TSUnix: time.Now().Unix()}:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	recordsTotal.WithLabelValues(mode).Add(float64(len(entries)))
+	return asString(option["chunk"]), nil
+}
+
+// flatten converts a decoded record into JSON-friendly values and adds the
+// fields downstream processors key on. A ts the record already carries wins
+// over the event time.
+func flatten(rec map[string]any, tag, service string, ts time.Time) map[string]any {
+	obj := jsonValue(rec).(map[string]any)
+	if _, ok := obj["ts"]; !ok {
+		obj["ts"] = ts.UTC().Format(time.RFC3339Nano)
+	}
+	obj["tag"] = tag
+	if s, _ := obj["service"].(string); s == "" && service != "" {
+		obj["service"] = service
+	}
+	return obj
+}
+
+func jsonValue(v any) any {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case map[string]any:
+		for k, it := range t {
+			t[k] = jsonValue(it)
+		}
+		return t
+	case []any:
+		for i, it := range t {
+			t[i] = jsonValue(it)
+		}
+		return t
+	case extValue:
+		if tm, ok := extTime(t); ok {
+			return tm.UTC().Format(time.RFC3339Nano)
+		}
+		return hex.EncodeToString(t.data)
+	default:
+		return t
+	}
+}
+
+// eventTime decodes an entry time: integer seconds, float seconds, or the
+// EventTime ext type 0 (uint32 seconds + uint32 nanoseconds, big-endian).
+func eventTime(v any) time.Time {
+	switch t := v.(type) {
+	case int64:
+		return time.Unix(t, 0)
+	case uint64:
+		return time.Unix(int64(t), 0)
+	case float64:
+		sec := int64(t)
+		return time.Unix(sec, int64((t-float64(sec))*1e9))
+	case extValue:
+		if tm, ok := extTime(t); ok {
+			return tm
+		}
+	}
+	return time.Now()
+}
+
+func extTime(e extValue) (time.Time, bool) {
+	if e.typ != 0 || len(e.data) != 8 {
+		return time.Time{}, false
+	}
+	sec := binary.BigEndian.Uint32(e.data[:4])
+	nsec := binary.BigEndian.Uint32(e.data[4:])
+	return time.Unix(int64(sec), int64(nsec)), true
+}
+
+func (r *Receiver) serviceFromTag(tag string) string {
+	if r.tagService != nil {
+		if m := r.tagService.FindStringSubmatch(tag); len(m) > 1 && m[1] != "" {
+			return m[1]
+		}
+	}
+	return tag
+}
+
+// ---------------- shared-key handshake ----------------
+
+// handshake runs HELO → PING → PONG (Forward protocol v1 "Handshake Messages").
+// Only shared-key authentication is supported; user/password auth is not requested.
+func (r *Receiver) handshake(conn net.Conn, dec *decoder) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	helo := []any{"HELO", map[string]any{"nonce": nonce, "auth": []byte{}, "keepalive": true}}
+	if _, err := conn.Write(encode(nil, helo)); err != nil {
+		return err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	v, err := dec.decode()
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	ping, ok := v.([]any)
+	if !ok || len(ping) < 4 || asString(ping[0]) != "PING" {
+		return errors.New("expected PING")
+	}
+	clientHost := asString(ping[1])
+	salt := asString(ping[2])
+	digest := asString(ping[3])
+
+	want := sha512Hex(salt, clientHost, string(nonce), r.sharedKey)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(want)) != 1 {
+		_, _ = conn.Write(encode(nil, []any{"PONG", false, "shared_key mismatch", r.selfHostname, ""}))
+		return fmt.Errorf("shared_key mismatch from %q", clientHost)
+	}
+	pong := []any{"PONG", true, "", r.selfHostname, sha512Hex(salt, r.selfHostname, string(nonce), r.sharedKey)}
+	_, err = conn.Write(encode(nil, pong))
+	return err
+}
+
+func sha512Hex(parts ...string) string {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func asString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	default:
+		return ""
+	}
+}
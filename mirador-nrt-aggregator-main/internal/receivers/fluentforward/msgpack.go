@@ -0,0 +1,321 @@
+package fluentforward
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Minimal MessagePack support for the Forward protocol: enough to decode any
+// value a Fluent client sends and to encode the handful of server replies
+// (HELO, PONG, ack). Maps decode to map[string]any (non-string keys are
+// formatted), str to string, bin to []byte, ext to extValue.
+
+type extValue struct {
+	typ  int8
+	data []byte
+}
+
+var (
+	errTooLarge = errors.New("msgpack: value exceeds max_message_bytes")
+	errTooDeep  = errors.New("msgpack: nesting exceeds 64 levels")
+)
+
+// maxDepth bounds array/map nesting so a crafted message cannot exhaust the
+// stack.
+const maxDepth = 64
+
+type decoder struct {
+	r     *bufio.Reader
+	limit int // max size of a single str/bin/ext and max container length
+}
+
+func (d *decoder) decode() (any, error) {
+	return d.value(0)
+}
+
+// value decodes one value nested depth containers deep.
+func (d *decoder) value(depth int) (any, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.readMap(int(c&0x0f), depth+1)
+	case c&0xf0 == 0x90:
+		return d.readArray(int(c&0x0f), depth+1)
+	case c&0xe0 == 0xa0:
+		return d.readStr(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLen(c - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readLen(c - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.readExt(n)
+	case 0xca:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.readBytes(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		u := beUint(b)
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case 0xd0:
+		b, err := d.readBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int8(b[0])), nil
+	case 0xd1:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 0xd2:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case 0xd3:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.readExt(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLen(c - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.readStr(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLen(c - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.readArray(n, depth+1)
+	case 0xde, 0xdf:
+		n, err := d.readLen(c - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.readMap(n, depth+1)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%02x", c)
+}
+
+// readLen reads a big-endian length of 1, 2 or 4 bytes (width index 0, 1, 2).
+func (d *decoder) readLen(width byte) (int, error) {
+	b, err := d.readBytes(1 << width)
+	if err != nil {
+		return 0, err
+	}
+	n := beUint(b)
+	if n > uint64(d.limit) {
+		return 0, errTooLarge
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readBytes(n int) ([]byte, error) {
+	if n > d.limit {
+		return nil, errTooLarge
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+func (d *decoder) readStr(n int) (string, error) {
+	b, err := d.readBytes(n)
+	return string(b), err
+}
+
+func (d *decoder) readExt(n int) (any, error) {
+	t, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.readBytes(n)
+	if err != nil {
+		return nil, err
+	}
+	return extValue{typ: int8(t), data: b}, nil
+}
+
+func (d *decoder) readArray(n, depth int) ([]any, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+	out := make([]any, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (d *decoder) readMap(n, depth int) (map[string]any, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+	out := make(map[string]any, min(n, 1024))
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		out[keyString(k)] = v
+	}
+	return out, nil
+}
+
+func keyString(k any) string {
+	switch t := k.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case uint64:
+		return strconv.FormatUint(t, 10)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func beUint(b []byte) uint64 {
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u
+}
+
+// ---------------- encoding (server replies only) ----------------
+
+func encode(buf []byte, v any) []byte {
+	switch t := v.(type) {
+	case nil:
+		return append(buf, 0xc0)
+	case bool:
+		if t {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case int:
+		return encodeInt(buf, int64(t))
+	case int64:
+		return encodeInt(buf, t)
+	case string:
+		n := len(t)
+		switch {
+		case n < 32:
+			buf = append(buf, 0xa0|byte(n))
+		case n < 1<<8:
+			buf = append(buf, 0xd9, byte(n))
+		case n < 1<<16:
+			buf = append(buf, 0xda, byte(n>>8), byte(n))
+		default:
+			buf = append(buf, 0xdb)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+		}
+		return append(buf, t...)
+	case []byte:
+		n := len(t)
+		switch {
+		case n < 1<<8:
+			buf = append(buf, 0xc4, byte(n))
+		case n < 1<<16:
+			buf = append(buf, 0xc5, byte(n>>8), byte(n))
+		default:
+			buf = append(buf, 0xc6)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+		}
+		return append(buf, t...)
+	case []any:
+		if n := len(t); n < 16 {
+			buf = append(buf, 0x90|byte(n))
+		} else {
+			buf = append(buf, 0xdc, byte(n>>8), byte(n))
+		}
+		for _, it := range t {
+			buf = encode(buf, it)
+		}
+		return buf
+	case map[string]any:
+		if n := len(t); n < 16 {
+			buf = append(buf, 0x80|byte(n))
+		} else {
+			buf = append(buf, 0xde, byte(n>>8), byte(n))
+		}
+		for k, it := range t {
+			buf = encode(buf, k)
+			buf = encode(buf, it)
+		}
+		return buf
+	default:
+		return encode(buf, fmt.Sprint(t))
+	}
+}
+
+func encodeInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(buf, byte(i))
+	case i < 0 && i >= -32:
+		return append(buf, byte(int8(i)))
+	default:
+		buf = append(buf, 0xd3)
+		return binary.BigEndian.AppendUint64(buf, uint64(i))
+	}
+}
@@ -0,0 +1,145 @@
+package fluentforward
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func decodeBytes(b []byte, limit int) (any, error) {
+	d := &decoder{r: bufio.NewReader(bytes.NewReader(b)), limit: limit}
+	return d.decode()
+}
+
+// nested returns depth arrays wrapping nil: 0x91 0x91 ... 0xc0.
+func nested(depth int) []byte {
+	return append(bytes.Repeat([]byte{0x91}, depth), 0xc0)
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want any
+	}{
+		{"positive fixint", []byte{0x05}, int64(5)},
+		{"negative fixint", []byte{0xff}, int64(-1)},
+		{"nil", []byte{0xc0}, nil},
+		{"bool", []byte{0xc3}, true},
+		{"uint8", []byte{0xcc, 0xc8}, int64(200)},
+		{"uint64 above int64", []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(1<<64 - 1)},
+		{"int16", []byte{0xd1, 0xff, 0x38}, int64(-200)},
+		{"float32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, float64(1.5)},
+		{"float64", []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, float64(1.5)},
+		{"fixstr", []byte{0xa3, 'a', 'b', 'c'}, "abc"},
+		{"str8", []byte{0xd9, 0x02, 'h', 'i'}, "hi"},
+		{"bin8", []byte{0xc4, 0x02, 0x01, 0x02}, []byte{1, 2}},
+		{"fixext4 (EventTime)", []byte{0xd6, 0x00, 0, 0, 0, 1}, extValue{typ: 0, data: []byte{0, 0, 0, 1}}},
+		{"fixarray", []byte{0x92, 0x01, 0xa1, 'x'}, []any{int64(1), "x"}},
+		{"fixmap with int key", []byte{0x82, 0xa1, 'k', 0x01, 0x07, 0xc2}, map[string]any{"k": int64(1), "7": false}},
+		{"array16", []byte{0xdc, 0x00, 0x01, 0xc0}, []any{nil}},
+		{"max depth", nested(maxDepth), func() any {
+			var v any
+			for i := 0; i < maxDepth; i++ {
+				v = []any{v}
+			}
+			return v
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBytes(tt.in, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    []byte
+		limit int
+		want  error
+	}{
+		{"too deep", nested(maxDepth + 1), 1 << 20, errTooDeep},
+		{"deep map", append(bytes.Repeat([]byte{0x81, 0xa1, 'k'}, maxDepth+1), 0xc0), 1 << 20, errTooDeep},
+		{"str over limit", []byte{0xd9, 0x10}, 8, errTooLarge},
+		{"array over limit", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, 1024, errTooLarge},
+		{"truncated", []byte{0xa3, 'a'}, 1 << 20, io.ErrUnexpectedEOF},
+		{"empty", nil, 1 << 20, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeBytes(tt.in, tt.limit)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if _, err := decodeBytes([]byte{0xc1}, 1<<20); err == nil {
+		t.Fatal("0xc1 (never used) decoded without error")
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []any{
+		nil,
+		true,
+		int64(42),
+		int64(-5),
+		int64(-1 << 40),
+		"short",
+		string(bytes.Repeat([]byte{'s'}, 300)),
+		[]byte{0, 1, 2},
+		[]any{int64(1), "two", []any{false}},
+		map[string]any{"ack": "chunk-id", "n": int64(3)},
+	}
+	for _, v := range tests {
+		got, err := decodeBytes(encode(nil, v), 1<<20)
+		if err != nil {
+			t.Fatalf("%#v: %v", v, err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Fatalf("round trip: got %#v, want %#v", got, v)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		rec     map[string]any
+		service string
+		want    map[string]any
+	}{
+		{
+			name:    "adds ts, tag and service",
+			rec:     map[string]any{"log": []byte("hello")},
+			service: "api",
+			want:    map[string]any{"log": "hello", "ts": "2024-05-01T12:00:00Z", "tag": "app.api", "service": "api"},
+		},
+		{
+			name: "keeps the record's own ts and service",
+			rec:  map[string]any{"ts": "2024-04-30T00:00:00Z", "service": "web"},
+			want: map[string]any{"ts": "2024-04-30T00:00:00Z", "tag": "app.api", "service": "web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := flatten(tt.rec, "app.api", tt.service, ts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
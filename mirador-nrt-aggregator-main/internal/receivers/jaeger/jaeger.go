@@ -0,0 +1,204 @@
+package jaeger
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/codec"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// Receiver implements the Jaeger collector's Thrift-over-HTTP endpoint
+// (POST /api/traces, Content-Type application/x-thrift or
+// application/vnd.apache.thrift.binary): one jaeger.thrift Batch per request
+// in the Thrift binary protocol, as sent by jaeger-client libraries with a
+// collector endpoint configured.
+//
+// Spans are translated to an OTLP ExportTraceServiceRequest (KindTraces) so
+// spanmetrics and the summarizer see them like any OTLP span: span.kind and
+// error / otel.status_code tags become kind and status, logs become events,
+// the Process service name and tags become the resource.
+//
+// Supported rc.Extra keys:
+//   - path: string (default "/api/traces")
+//   - max_body_bytes, read_timeout_ms, write_timeout_ms, idle_timeout_ms
+//   - tls.enabled, tls.cert_file, tls.key_file, tls.client_ca_file, tls.require_client_cert
+type Receiver struct {
+	endpoint string
+	path     string
+
+	maxBodyBytes int64
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	tlsEnabled        bool
+	tlsCertFile       string
+	tlsKeyFile        string
+	tlsClientCAFile   string
+	requireClientCert bool
+}
+
+// New builds a Jaeger Thrift-over-HTTP receiver.
+func New(rc config.ReceiverCfg) *Receiver {
+	path := "/api/traces"
+	if s, ok := rc.Extra["path"].(string); ok && strings.TrimSpace(s) != "" {
+		path = s
+	}
+	maxBody := int64(16 * 1024 * 1024)
+	if v, ok := rc.Extra["max_body_bytes"].(int); ok && v > 0 {
+		maxBody = int64(v)
+	}
+	rt := 30 * time.Second
+	if v, ok := rc.Extra["read_timeout_ms"].(int); ok && v > 0 {
+		rt = time.Duration(v) * time.Millisecond
+	}
+	wt := 30 * time.Second
+	if v, ok := rc.Extra["write_timeout_ms"].(int); ok && v > 0 {
+		wt = time.Duration(v) * time.Millisecond
+	}
+	it := 120 * time.Second
+	if v, ok := rc.Extra["idle_timeout_ms"].(int); ok && v > 0 {
+		it = time.Duration(v) * time.Millisecond
+	}
+
+	tlsEnabled := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "enabled"); ok {
+		tlsEnabled = b
+	}
+	requireClientCert := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "require_client_cert"); ok {
+		requireClientCert = b
+	}
+
+	return &Receiver{
+		endpoint:          rc.Endpoint,
+		path:              path,
+		maxBodyBytes:      maxBody,
+		readTimeout:       rt,
+		writeTimeout:      wt,
+		idleTimeout:       it,
+		tlsEnabled:        tlsEnabled,
+		tlsCertFile:       common.NestedString(rc.Extra, "tls", "cert_file"),
+		tlsKeyFile:        common.NestedString(rc.Extra, "tls", "key_file"),
+		tlsClientCAFile:   common.NestedString(rc.Extra, "tls", "client_ca_file"),
+		requireClientCert: requireClientCert,
+	}
+}
+
+// Start launches the HTTP server; shutdown is graceful on ctx cancel.
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	addr := r.endpoint
+	if strings.TrimSpace(addr) == "" {
+		addr = ":14268"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc(r.path, func(w http.ResponseWriter, req *http.Request) { r.handleTraces(w, req, out) })
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  r.readTimeout,
+		WriteTimeout: r.writeTimeout,
+		IdleTimeout:  r.idleTimeout,
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if r.tlsEnabled {
+		tlsCfg, err := common.ServerTLS(r.tlsCertFile, r.tlsKeyFile, r.tlsClientCAFile, r.requireClientCert)
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("jaeger tls: %w", err)
+		}
+		srv.TLSConfig = tlsCfg
+		log.Printf("[jaeger] listening on https://%s path=%s", addr, r.path)
+	} else {
+		log.Printf("[jaeger] listening on http://%s path=%s", addr, r.path)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var serveErr error
+		if r.tlsEnabled {
+			serveErr = srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr = srv.Serve(ln)
+		}
+		if serveErr != nil && serveErr != http.ErrServerClosed {
+			errCh <- serveErr
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shctx)
+		return nil
+	case e := <-errCh:
+		return e
+	}
+}
+
+func (r *Receiver) handleTraces(w http.ResponseWriter, req *http.Request, out chan<- model.Envelope) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var reader io.Reader = http.MaxBytesReader(w, req.Body, r.maxBodyBytes)
+	defer req.Body.Close()
+	if strings.Contains(strings.ToLower(req.Header.Get("Content-Encoding")), "gzip") {
+		gr, err := gzip.NewReader(reader)
+		if err != nil {
+			http.Error(w, "invalid gzip", http.StatusBadRequest)
+			return
+		}
+		defer gr.Close()
+		reader = gr
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		http.Error(w, "read error", http.StatusBadRequest)
+		return
+	}
+
+	if ct := strings.ToLower(req.Header.Get("Content-Type")); ct != "" && !strings.Contains(ct, "thrift") {
+		http.Error(w, "unsupported content type: "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+	tr, err := codec.JaegerThriftToOTLP(body)
+	if err != nil {
+		http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(tr.ResourceSpans) > 0 {
+		b, err := proto.Marshal(tr)
+		if err != nil {
+			http.Error(w, "encode error", http.StatusInternalServerError)
+			return
+		}
+		// Non-blocking forward (drop on backpressure, but still 202)
+		select {
+		case out <- model.Envelope{Kind: model.KindTraces, Bytes: b, Attrs: map[string]string{"source": "jaeger"}, TSUnix: time.Now().Unix()}:
+		default:
+			log.Printf("[jaeger] dropping request: pipeline backpressure")
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
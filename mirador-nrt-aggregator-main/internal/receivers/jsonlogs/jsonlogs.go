@@ -15,6 +15,7 @@ import (
 
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+	kafkarx "github.com/platformbuilds/mirador-nrt-aggregator/internal/receivers/kafka"
 )
 
 const (
@@ -178,19 +179,29 @@ func (r *HTTPReceiver) Start(ctx context.Context, out chan<- model.Envelope) err
 
 // ----------------------------- Kafka receiver -----------------------------
 
+// KafkaReceiver shares delivery (commit_mode, commit_interval_ms, max_pending)
+// and security (sasl.*, tls.*) options with the kafka receiver.
 type KafkaReceiver struct {
 	brokers []string
 	topic   string
 	group   string
 
-	// optional: max bytes, etc. via Extra (not strictly required)
+	commitMode     string
+	commitInterval time.Duration
+	maxPending     int
+	extra          map[string]any
 }
 
 func NewKafka(rc config.ReceiverCfg) *KafkaReceiver {
+	mode, interval, maxPending := kafkarx.CommitOptions(rc.Extra)
 	return &KafkaReceiver{
-		brokers: rc.Brokers,
-		topic:   rc.Topic,
-		group:   rc.Group,
+		brokers:        rc.Brokers,
+		topic:          rc.Topic,
+		group:          rc.Group,
+		commitMode:     mode,
+		commitInterval: interval,
+		maxPending:     maxPending,
+		extra:          rc.Extra,
 	}
 }
 
@@ -198,21 +209,41 @@ func (r *KafkaReceiver) Start(ctx context.Context, out chan<- model.Envelope) er
 	if len(r.brokers) == 0 || r.topic == "" {
 		return ErrKafkaConfig
 	}
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  r.brokers,
-		GroupID:  r.groupOrDefault(),
-		Topic:    r.topic,
-		MaxBytes: 10 * 1024 * 1024, // 10MB per message
-	})
+	dialer, err := kafkarx.NewDialer(r.extra)
+	if err != nil {
+		return err
+	}
+	dial := func() *kafka.Reader {
+		return kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  r.brokers,
+			GroupID:  r.groupOrDefault(),
+			Topic:    r.topic,
+			MaxBytes: 10 * 1024 * 1024, // 10MB per message
+			Dialer:   dialer,
+		})
+	}
 
-	defer func() {
-		_ = reader.Close()
-	}()
+	var (
+		reader  *kafka.Reader
+		tracker *kafkarx.OffsetTracker
+	)
+	if r.commitMode == "export" {
+		tracker = kafkarx.NewOffsetTracker(dial, r.groupOrDefault(), r.topic, r.commitInterval, r.maxPending)
+		done := make(chan struct{})
+		tctx, cancel := context.WithCancel(ctx)
+		go func() { tracker.Run(tctx); close(done) }()
+		defer func() { cancel(); <-done }()
+	} else {
+		reader = dial()
+		defer func() {
+			_ = reader.Close()
+		}()
+	}
 
-	log.Printf("[jsonlogs/kafka] consuming topic=%s group=%s brokers=%v", r.topic, r.groupOrDefault(), r.brokers)
+	log.Printf("[jsonlogs/kafka] consuming topic=%s group=%s brokers=%v commit_mode=%s", r.topic, r.groupOrDefault(), r.brokers, r.commitMode)
 
 	for {
-		m, err := reader.ReadMessage(ctx)
+		m, err := kafkarx.Fetch(ctx, reader, tracker)
 		if err != nil {
 			// context canceled → graceful exit
 			if err == context.Canceled || err == context.DeadlineExceeded {
@@ -230,12 +261,20 @@ func (r *KafkaReceiver) Start(ctx context.Context, out chan<- model.Envelope) er
 			}
 		}
 
+		var msgAck model.Acker
+		if tracker != nil {
+			if msgAck = tracker.Track(ctx, m); msgAck == nil {
+				return nil
+			}
+		}
+
 		attrs := headersToMap(m.Headers)
 		out <- model.Envelope{
 			Kind:   model.KindJSONLogs,
 			Bytes:  m.Value,
 			Attrs:  attrs,
 			TSUnix: time.Now().Unix(), // receiver timestamp
+			Ack:    msgAck,
 		}
 	}
 }
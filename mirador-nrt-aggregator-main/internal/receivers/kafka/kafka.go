@@ -1,16 +1,23 @@
 package kafka
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 
 	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
 
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/codec"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
 )
@@ -21,14 +28,35 @@ import (
 //   - "traces":    OTLP ExportTracesServiceRequest
 //   - "prom_rw":   Prometheus Remote Write (prompb.WriteRequest)
 //   - "json_logs": JSON payload per message (or NDJSON if extra.ndjson = true)
+//   - "auto":      per-message kind from a header (see codec.Options)
+//
+// Payload encoding (rc.Extra.encoding): raw (default) | otlp_proto | otlp_json |
+// jaeger_proto | zipkin_json, matching the OpenTelemetry Collector Kafka exporter.
+//
+// Delivery (rc.Extra):
+//   - commit_mode: "receive" (default) commits as soon as the message is read;
+//     "export" commits an offset only after the aggregates built from it were
+//     exported (at-least-once, see OffsetTracker).
+//   - commit_interval_ms: int (default 1000) batching interval for export-mode commits
+//   - max_pending: int (default 10000) fetched-but-uncommitted messages, over
+//     all partitions, before fetching pauses. In export mode a message stays
+//     pending until its window (and any rollup holding it) is exported, so size
+//     it to the message rate times that delay.
+//
+// Security (rc.Extra, see NewDialer): sasl.*, tls.*
 type Receiver struct {
 	brokers []string
 	topic   string
 	group   string
 	kind    string
 
-	maxBytes int  // per message fetch cap
-	ndjson   bool // if true and kind=json_logs, split message by lines
+	maxBytes int // per message fetch cap
+	codec    codec.Options
+
+	commitMode     string
+	commitInterval time.Duration
+	maxPending     int
+	extra          map[string]any
 }
 
 // New builds a Kafka receiver.
@@ -37,17 +65,19 @@ func New(rc config.ReceiverCfg, kind string) *Receiver {
 	if v, ok := rc.Extra["max_bytes"].(int); ok && v > 0 {
 		maxBytes = v
 	}
-	ndjson := false
-	if v, ok := rc.Extra["ndjson"].(bool); ok {
-		ndjson = v
-	}
+	opts := codec.FromExtra(rc.Extra, kind)
+	mode, interval, maxPending := CommitOptions(rc.Extra)
 	return &Receiver{
-		brokers:  rc.Brokers,
-		topic:    rc.Topic,
-		group:    rc.Group,
-		kind:     normalizeKind(kind),
-		maxBytes: maxBytes,
-		ndjson:   ndjson,
+		brokers:        rc.Brokers,
+		topic:          rc.Topic,
+		group:          rc.Group,
+		kind:           opts.Kind,
+		maxBytes:       maxBytes,
+		codec:          opts,
+		commitMode:     mode,
+		commitInterval: interval,
+		maxPending:     maxPending,
+		extra:          rc.Extra,
 	}
 }
 
@@ -55,19 +85,41 @@ func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
 	if len(r.brokers) == 0 || strings.TrimSpace(r.topic) == "" {
 		return errors.New("kafka receiver: missing brokers or topic")
 	}
+	dialer, err := NewDialer(r.extra)
+	if err != nil {
+		return fmt.Errorf("kafka receiver: %w", err)
+	}
+
+	dial := func() *kafkago.Reader {
+		return kafkago.NewReader(kafkago.ReaderConfig{
+			Brokers:  r.brokers,
+			GroupID:  r.groupOrDefault(),
+			Topic:    r.topic,
+			MaxBytes: r.maxBytes,
+			Dialer:   dialer,
+		})
+	}
 
-	reader := kafkago.NewReader(kafkago.ReaderConfig{
-		Brokers:  r.brokers,
-		GroupID:  r.groupOrDefault(),
-		Topic:    r.topic,
-		MaxBytes: r.maxBytes,
-	})
-	defer func() { _ = reader.Close() }()
+	var (
+		reader  *kafkago.Reader
+		tracker *OffsetTracker
+	)
+	if r.commitMode == "export" {
+		tracker = NewOffsetTracker(dial, r.groupOrDefault(), r.topic, r.commitInterval, r.maxPending)
+		done := make(chan struct{})
+		tctx, cancel := context.WithCancel(ctx)
+		go func() { tracker.Run(tctx); close(done) }()
+		// The tracker owns its reader: final commit, then close.
+		defer func() { cancel(); <-done }()
+	} else {
+		reader = dial()
+		defer func() { _ = reader.Close() }()
+	}
 
-	log.Printf("[kafka/%s] consuming topic=%s group=%s brokers=%v", r.kind, r.topic, r.groupOrDefault(), r.brokers)
+	log.Printf("[kafka/%s] consuming topic=%s group=%s brokers=%v encoding=%s commit_mode=%s", r.kind, r.topic, r.groupOrDefault(), r.brokers, r.codec.Encoding, r.commitMode)
 
 	for {
-		msg, err := reader.ReadMessage(ctx)
+		msg, err := Fetch(ctx, reader, tracker)
 		if err != nil {
 			// graceful exit on context cancellation
 			if err == context.Canceled || err == context.DeadlineExceeded {
@@ -83,73 +135,35 @@ func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
 			}
 		}
 
-		attrs := headersToMap(msg.Headers)
-		ts := time.Now().Unix()
-
-		switch r.kind {
-		case "json_logs":
-			// Either emit the whole message as one JSON event,
-			// or split NDJSON into multiple envelopes if configured.
-			if r.ndjson {
-				sc := bufio.NewScanner(bytes.NewReader(msg.Value))
-				// allow reasonably long lines (up to ~10MB)
-				buf := make([]byte, 0, 64*1024)
-				sc.Buffer(buf, r.maxBytes)
-				for sc.Scan() {
-					line := strings.TrimSpace(sc.Text())
-					if line == "" {
-						continue
-					}
-					out <- model.Envelope{
-						Kind:   model.KindJSONLogs,
-						Bytes:  []byte(line),
-						Attrs:  attrs,
-						TSUnix: ts,
-					}
-				}
-				if err := sc.Err(); err != nil {
-					log.Printf("[kafka/json_logs] scan error: %v", err)
-				}
-			} else {
-				out <- model.Envelope{
-					Kind:   model.KindJSONLogs,
-					Bytes:  msg.Value,
-					Attrs:  attrs,
-					TSUnix: ts,
-				}
-			}
-
-		case "metrics":
-			out <- model.Envelope{
-				Kind:   model.KindMetrics,
-				Bytes:  msg.Value,
-				Attrs:  attrs,
-				TSUnix: ts,
-			}
-
-		case "traces":
-			out <- model.Envelope{
-				Kind:   model.KindTraces,
-				Bytes:  msg.Value,
-				Attrs:  attrs,
-				TSUnix: ts,
+		var msgAck model.Acker
+		if tracker != nil {
+			if msgAck = tracker.Track(ctx, msg); msgAck == nil {
+				return nil // cancelled while waiting for a free slot
 			}
+		}
 
-		case "prom_rw":
-			out <- model.Envelope{
-				Kind:   model.KindPromRW,
-				Bytes:  msg.Value,
-				Attrs:  attrs,
-				TSUnix: ts,
-			}
+		attrs := headersToMap(msg.Headers)
+		if len(msg.Key) > 0 {
+			attrs["kafka.key"] = string(msg.Key)
+		}
+		ts := time.Now().Unix()
 
-		default:
-			// Fallback to metrics for safety
+		payloads, err := r.codec.Decode(msg.Value, attrs)
+		if err != nil {
+			// Undecodable messages are skipped (see OffsetTracker) rather than
+			// stalling the partition.
+			log.Printf("[kafka/%s] partition=%d offset=%d decode error (encoding=%s): %v", r.kind, msg.Partition, msg.Offset, r.codec.Encoding, err)
+			ack.Fail(msgAck, ack.Malformed(err))
+			continue
+		}
+		acks := ack.Fanout(msgAck, len(payloads))
+		for i, p := range payloads {
 			out <- model.Envelope{
-				Kind:   model.KindMetrics,
-				Bytes:  msg.Value,
+				Kind:   p.Kind,
+				Bytes:  p.Bytes,
 				Attrs:  attrs,
 				TSUnix: ts,
+				Ack:    acks[i],
 			}
 		}
 	}
@@ -163,6 +177,127 @@ func (r *Receiver) groupOrDefault() string {
 	return g
 }
 
+// ----------------------------- shared helpers -----------------------------
+// Exported so jsonlogs/kafka consumes with identical delivery and security semantics.
+
+// CommitOptions reads commit_mode / commit_interval_ms / max_pending from extras.
+func CommitOptions(extra map[string]any) (mode string, interval time.Duration, maxPending int) {
+	mode = "receive"
+	if s, ok := extra["commit_mode"].(string); ok && s != "" {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "export":
+			mode = "export"
+		default:
+			mode = "receive"
+		}
+	}
+	interval = time.Second
+	if v, ok := extra["commit_interval_ms"].(int); ok && v > 0 {
+		interval = time.Duration(v) * time.Millisecond
+	}
+	maxPending = 10000
+	if v, ok := extra["max_pending"].(int); ok && v > 0 {
+		maxPending = v
+	}
+	return mode, interval, maxPending
+}
+
+// Fetch reads the next message. Without a tracker the offset is committed on
+// read (legacy behaviour); otherwise the tracker's reader is used and
+// committing is left to it.
+func Fetch(ctx context.Context, reader *kafkago.Reader, tracker *OffsetTracker) (kafkago.Message, error) {
+	if tracker != nil {
+		return tracker.Fetch(ctx)
+	}
+	return reader.ReadMessage(ctx)
+}
+
+// NewDialer builds a kafka-go dialer from receiver extras. Nil is returned when
+// neither SASL nor TLS is configured (kafka-go then uses its default dialer).
+//
+//	sasl:
+//	  mechanism: PLAIN | SCRAM-SHA-256 | SCRAM-SHA-512
+//	  username: string
+//	  password: string
+//	  password_file: string   # used when password is empty
+//	tls:
+//	  enabled: bool
+//	  ca_file: string
+//	  cert_file: string       # client cert for mTLS
+//	  key_file: string
+//	  server_name: string
+//	  insecure_skip_verify: bool
+func NewDialer(extra map[string]any) (*kafkago.Dialer, error) {
+	mech, err := saslMechanism(extra)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := tlsConfig(extra)
+	if err != nil {
+		return nil, err
+	}
+	if mech == nil && tlsCfg == nil {
+		return nil, nil
+	}
+	return &kafkago.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		SASLMechanism: mech,
+		TLS:           tlsCfg,
+	}, nil
+}
+
+func saslMechanism(extra map[string]any) (sasl.Mechanism, error) {
+	m, ok := extra["sasl"].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	user, _ := m["username"].(string)
+	pass, _ := m["password"].(string)
+	if pass == "" {
+		if f, ok := m["password_file"].(string); ok && f != "" {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("sasl password_file: %w", err)
+			}
+			pass = strings.TrimSpace(string(b))
+		}
+	}
+	mech, _ := m["mechanism"].(string)
+	switch strings.ToUpper(strings.TrimSpace(mech)) {
+	case "", "PLAIN":
+		return plain.Mechanism{Username: user, Password: pass}, nil
+	case "SCRAM-SHA-256":
+		return scram.Mechanism(scram.SHA256, user, pass)
+	case "SCRAM-SHA-512":
+		return scram.Mechanism(scram.SHA512, user, pass)
+	default:
+		return nil, fmt.Errorf("unsupported sasl mechanism %q", mech)
+	}
+}
+
+func tlsConfig(extra map[string]any) (*tls.Config, error) {
+	m, ok := extra["tls"].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	if enabled, _ := m["enabled"].(bool); !enabled {
+		return nil, nil
+	}
+	ca, _ := m["ca_file"].(string)
+	cert, _ := m["cert_file"].(string)
+	key, _ := m["key_file"].(string)
+	insecure, _ := m["insecure_skip_verify"].(bool)
+	cfg, err := common.ClientTLS(ca, cert, key, insecure)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := m["server_name"].(string); ok {
+		cfg.ServerName = s
+	}
+	return cfg, nil
+}
+
 func headersToMap(hdrs []kafkago.Header) map[string]string {
 	if len(hdrs) == 0 {
 		return map[string]string{}
@@ -173,17 +308,3 @@ func headersToMap(hdrs []kafkago.Header) map[string]string {
 	}
 	return m
 }
-
-func normalizeKind(k string) string {
-	k = strings.ToLower(strings.TrimSpace(k))
-	switch k {
-	case "metrics", "traces", "prom_rw", "json_logs":
-		return k
-	case "promremotewrite", "prometheusremotewrite", "prom-remote-write":
-		return "prom_rw"
-	case "json", "jsonlogs", "logs":
-		return "json_logs"
-	default:
-		return "metrics"
-	}
-}
@@ -0,0 +1,413 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// Consumer metrics, shared by every Kafka-backed receiver (kafka, jsonlogs/kafka).
+var (
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mirador_kafka_consumer_lag",
+		Help: "High watermark minus committed offset per partition (messages not yet safely exported).",
+	}, []string{"group", "topic", "partition"})
+
+	committedOffset = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mirador_kafka_committed_offset",
+		Help: "Last committed offset per partition.",
+	}, []string{"group", "topic", "partition"})
+
+	pendingMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mirador_kafka_pending_messages",
+		Help: "Messages handed to the pipeline whose aggregates have not been exported yet.",
+	}, []string{"group", "topic"})
+
+	nackedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_kafka_nacked_messages_total",
+		Help: "Messages whose derived aggregates failed to export; each failure replays from the committed offsets.",
+	}, []string{"group", "topic", "partition"})
+
+	replays = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_kafka_replays_total",
+		Help: "Reader recreations after a Nack, resuming from the committed offsets.",
+	}, []string{"group", "topic"})
+
+	rebalances = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_kafka_rebalances_total",
+		Help: "Consumer group rebalances after the first join; in-flight messages are dropped and redelivered from the committed offsets.",
+	}, []string{"group", "topic"})
+
+	malformedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_kafka_malformed_messages_total",
+		Help: "Messages that failed to decode in the receiver or pipeline; they are skipped and committed.",
+	}, []string{"group", "topic", "partition"})
+)
+
+// OffsetTracker implements commit-after-export for a consumer-group reader.
+//
+// Every fetched message gets a model.Acker via Track. Offsets are committed per
+// partition only up to the longest contiguous prefix of acked messages, so a
+// crash never skips data whose aggregates were still in flight. A Nack carrying
+// an ack.MalformedError is a poison message: it is logged, counted and
+// committed past, since redelivering it could never succeed.
+//
+// Any other Nack triggers a replay: the acked prefixes are committed, the
+// reader is recreated (after a backoff that grows while replays keep failing)
+// and the group resumes from its committed offsets, so the failed message and
+// everything after it is delivered again (at-least-once). Acks still in flight
+// from before the replay are ignored.
+//
+// A consumer group rebalance restarts every assigned partition at its
+// committed offset, and partitions may move to another member. Everything in
+// flight is then either delivered again or owned elsewhere, so it is dropped
+// like on a replay: lost partitions release their slots instead of waiting
+// for exports that can no longer be committed. Messages the reader buffered
+// before the rebalance may still arrive for a kept partition; an offset at or
+// below the last one seen restarts that partition's queue.
+//
+// Each tracked message holds one of maxPending slots until its offset is
+// committed (or dropped by a replay or rebalance); Track blocks while none is
+// free, which bounds memory and back-pressures the fetch loop when exporters
+// stall. Aggregates are exported at the end of their window (and of any rollup
+// holding their inputs), so maxPending must cover the messages of all
+// partitions read during that time, or fetching stalls until the window closes.
+type OffsetTracker struct {
+	dial     func() *kafkago.Reader
+	group    string
+	topic    string
+	interval time.Duration
+	slots    chan struct{}
+	replay   chan struct{} // signalled by a Nack; Fetch recreates the reader
+
+	mu       sync.Mutex
+	reader   *kafkago.Reader
+	parts    map[int]*partitionState
+	gen      int  // bumped by every replay; older acks are ignored
+	holding  bool // a Nack is waiting for the replay
+	failures int  // consecutive replays without a commit in between
+	joined   bool // the reader's first generation was seen (not a rebalance)
+}
+
+type partitionState struct {
+	queue     []*offsetEntry // fetched, not yet committed, in offset order
+	hwm       int64          // last seen high watermark
+	committed int64          // next offset the group will resume from
+	commitAt  int64          // pending commit (offset of last contiguous ack), -1 if none
+	last      int64          // last queued offset, -1 if none
+}
+
+type offsetEntry struct {
+	offset int64
+	gen    int // -1: not queued (tracked while a replay was pending)
+	done   bool
+}
+
+// NewOffsetTracker builds a tracker; dial creates the consumer-group reader,
+// again for every replay. interval batches commits; maxPending bounds tracked
+// messages.
+func NewOffsetTracker(dial func() *kafkago.Reader, group, topic string, interval time.Duration, maxPending int) *OffsetTracker {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if maxPending <= 0 {
+		maxPending = 10000
+	}
+	return &OffsetTracker{
+		dial:     dial,
+		group:    group,
+		topic:    topic,
+		interval: interval,
+		slots:    make(chan struct{}, maxPending),
+		replay:   make(chan struct{}, 1),
+		reader:   dial(),
+		parts:    map[int]*partitionState{},
+	}
+}
+
+// Run commits acked offsets every interval until ctx is done, then makes a
+// final best-effort commit and closes the reader.
+func (t *OffsetTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			t.commit(fctx)
+			cancel()
+			_ = t.current().Close()
+			return
+		case <-ticker.C:
+			t.commit(ctx)
+		}
+	}
+}
+
+// Fetch reads the next message, replaying from the committed offsets first
+// if a Nack asked for it.
+func (t *OffsetTracker) Fetch(ctx context.Context) (kafkago.Message, error) {
+	for {
+		select {
+		case <-t.replay:
+			if err := t.rewind(ctx); err != nil {
+				return kafkago.Message{}, err
+			}
+		default:
+		}
+		reader := t.current()
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			return msg, err
+		}
+		// Stats resets its counters; nothing else reads them.
+		if n := reader.Stats().Rebalances; n > 0 {
+			t.rebalanced(n)
+		}
+		t.mu.Lock()
+		holding := t.holding
+		t.mu.Unlock()
+		if !holding {
+			return msg, nil
+		}
+		// fetched past a failed message; the replay delivers it again
+	}
+}
+
+func (t *OffsetTracker) current() *kafkago.Reader {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reader
+}
+
+// rewind commits what is safe, waits out the backoff and recreates the
+// reader so the group resumes from its committed offsets.
+func (t *OffsetTracker) rewind(ctx context.Context) error {
+	t.commit(ctx)
+	t.mu.Lock()
+	t.failures++
+	backoff := time.Second << min(t.failures-1, 6) // 1s .. 64s
+	old := t.reader
+	t.mu.Unlock()
+	_ = old.Close()
+
+	log.Printf("[kafka] topic=%s replaying from committed offsets in %s", t.topic, backoff)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(backoff):
+	}
+
+	reader := t.dial()
+	t.mu.Lock()
+	t.reader = reader
+	t.resetLocked()
+	t.holding = false
+	t.joined = false
+	t.mu.Unlock()
+	replays.WithLabelValues(t.group, t.topic).Inc()
+	return nil
+}
+
+// resetLocked forgets every queued entry (releasing its slot); acks for them
+// arrive with an old generation and are ignored.
+func (t *OffsetTracker) resetLocked() {
+	t.gen++
+	for _, ps := range t.parts {
+		for range ps.queue {
+			<-t.slots
+			pendingMessages.WithLabelValues(t.group, t.topic).Dec()
+		}
+		ps.queue = nil
+		ps.committed = -1
+	}
+}
+
+// dropLocked forgets the queued entries of one partition; their acks are
+// ignored.
+func (t *OffsetTracker) dropLocked(ps *partitionState) {
+	for _, e := range ps.queue {
+		e.gen = -1
+		<-t.slots
+		pendingMessages.WithLabelValues(t.group, t.topic).Dec()
+	}
+	ps.queue = nil
+}
+
+// rebalanced handles n new generations of the reader. msg, just fetched, was
+// counted after the rebalance, so everything queued before it is stale.
+func (t *OffsetTracker) rebalanced(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.joined {
+		t.joined = true
+		n--
+	}
+	if n <= 0 || t.holding {
+		return // the pending replay drops everything anyway
+	}
+	log.Printf("[kafka] topic=%s consumer group rebalanced, redelivering from committed offsets", t.topic)
+	rebalances.WithLabelValues(t.group, t.topic).Inc()
+	t.resetLocked()
+	for _, ps := range t.parts {
+		ps.commitAt = -1 // the partition may be owned elsewhere now
+	}
+}
+
+// Track registers msg as in flight and returns the acker to attach to the
+// envelope(s) built from it. It returns nil if ctx is cancelled while waiting
+// for a free slot.
+func (t *OffsetTracker) Track(ctx context.Context, msg kafkago.Message) model.Acker {
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	t.mu.Lock()
+	if t.holding {
+		// Fetched just before a Nack: the replay delivers it again.
+		t.mu.Unlock()
+		<-t.slots
+		return &offsetAcker{t: t, partition: msg.Partition, entry: &offsetEntry{offset: msg.Offset, gen: -1}}
+	}
+	pendingMessages.WithLabelValues(t.group, t.topic).Inc()
+	ps := t.partition(msg.Partition)
+	if ps.last >= 0 && msg.Offset <= ps.last {
+		// The partition restarted at its committed offset (rebalance): what
+		// is queued is delivered again.
+		t.dropLocked(ps)
+		ps.commitAt = -1
+	}
+	ps.last = msg.Offset
+	e := &offsetEntry{offset: msg.Offset, gen: t.gen}
+	ps.queue = append(ps.queue, e)
+	if msg.HighWaterMark > ps.hwm {
+		ps.hwm = msg.HighWaterMark
+	}
+	if ps.committed < 0 {
+		ps.committed = msg.Offset
+	}
+	t.updateLag(msg.Partition, ps)
+	t.mu.Unlock()
+
+	return &offsetAcker{t: t, partition: msg.Partition, entry: e}
+}
+
+func (t *OffsetTracker) partition(p int) *partitionState {
+	ps, ok := t.parts[p]
+	if !ok {
+		ps = &partitionState{committed: -1, commitAt: -1, last: -1}
+		t.parts[p] = ps
+	}
+	return ps
+}
+
+func (t *OffsetTracker) resolve(partition int, e *offsetEntry, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e.gen != t.gen {
+		return // from before a replay or rebalance; its slot was released then
+	}
+	ps := t.partition(partition)
+	if ack.IsMalformed(err) {
+		log.Printf("[kafka] topic=%s partition=%d offset=%d skipping malformed message: %v", t.topic, partition, e.offset, err)
+		malformedMessages.WithLabelValues(t.group, t.topic, strconv.Itoa(partition)).Inc()
+		err = nil
+	}
+	if err != nil {
+		nackedMessages.WithLabelValues(t.group, t.topic, strconv.Itoa(partition)).Inc()
+		if !t.holding {
+			log.Printf("[kafka] topic=%s partition=%d offset=%d export failed, replaying: %v", t.topic, partition, e.offset, err)
+			t.holding = true
+			t.resetLocked()
+			select {
+			case t.replay <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+	e.done = true
+	// Advance over the contiguous acked prefix.
+	n := 0
+	for n < len(ps.queue) && ps.queue[n].done {
+		ps.commitAt = ps.queue[n].offset
+		n++
+	}
+	ps.queue = ps.queue[n:]
+	for ; n > 0; n-- {
+		<-t.slots
+		pendingMessages.WithLabelValues(t.group, t.topic).Dec()
+	}
+}
+
+func (t *OffsetTracker) commit(ctx context.Context) {
+	t.mu.Lock()
+	reader := t.reader
+	var msgs []kafkago.Message
+	for p, ps := range t.parts {
+		if ps.commitAt >= 0 {
+			msgs = append(msgs, kafkago.Message{Topic: t.topic, Partition: p, Offset: ps.commitAt})
+		}
+	}
+	t.mu.Unlock()
+	if len(msgs) == 0 {
+		return
+	}
+
+	if err := reader.CommitMessages(ctx, msgs...); err != nil {
+		log.Printf("[kafka] topic=%s commit error: %v", t.topic, err)
+		return
+	}
+
+	t.mu.Lock()
+	if t.failures > 0 && !t.holding {
+		log.Printf("[kafka] topic=%s recovered after %d replay(s)", t.topic, t.failures)
+		t.failures = 0
+	}
+	for _, m := range msgs {
+		ps := t.partition(m.Partition)
+		if ps.commitAt == m.Offset {
+			ps.commitAt = -1
+		}
+		ps.committed = m.Offset + 1
+		committedOffset.WithLabelValues(t.group, t.topic, strconv.Itoa(m.Partition)).Set(float64(ps.committed))
+		t.updateLag(m.Partition, ps)
+	}
+	t.mu.Unlock()
+}
+func (t *OffsetTracker) updateLag(partition int, ps *partitionState) {
+	if ps.committed < 0 {
+		return
+	}
+	lag := ps.hwm - ps.committed
+	if lag < 0 {
+		lag = 0
+	}
+	consumerLag.WithLabelValues(t.group, t.topic, strconv.Itoa(partition)).Set(float64(lag))
+}
+
+type offsetAcker struct {
+	t         *OffsetTracker
+	partition int
+	entry     *offsetEntry
+	once      sync.Once
+}
+
+func (a *offsetAcker) Ack() {
+	a.once.Do(func() { a.t.resolve(a.partition, a.entry, nil) })
+}
+
+func (a *offsetAcker) Nack(err error) {
+	a.once.Do(func() { a.t.resolve(a.partition, a.entry, err) })
+}
@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// newTestTracker never talks to a broker: the tests only track and resolve.
+func newTestTracker(t *testing.T, maxPending int) *OffsetTracker {
+	t.Helper()
+	dial := func() *kafkago.Reader {
+		return kafkago.NewReader(kafkago.ReaderConfig{Brokers: []string{"127.0.0.1:1"}, GroupID: "g", Topic: "t"})
+	}
+	tr := NewOffsetTracker(dial, "g", "t", time.Hour, maxPending)
+	t.Cleanup(func() { _ = tr.current().Close() })
+	return tr
+}
+
+func track(t *testing.T, tr *OffsetTracker, offsets ...int64) []model.Acker {
+	t.Helper()
+	var out []model.Acker
+	for _, o := range offsets {
+		a := tr.Track(context.Background(), kafkago.Message{Partition: 0, Offset: o, HighWaterMark: 100})
+		if a == nil {
+			t.Fatalf("Track(%d) returned nil", o)
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+func TestOffsetTrackerCommitPrefix(t *testing.T) {
+	boom := errors.New("export failed")
+	tests := []struct {
+		name         string
+		resolve      func(acks []model.Acker)
+		wantCommitAt int64
+		wantQueued   int
+		wantHolding  bool
+	}{
+		{"nothing acked", func([]model.Acker) {}, -1, 3, false},
+		{"gap holds the commit point", func(a []model.Acker) { a[1].Ack(); a[2].Ack() }, -1, 3, false},
+		{"contiguous prefix", func(a []model.Acker) { a[0].Ack(); a[1].Ack() }, 11, 1, false},
+		{"all acked out of order", func(a []model.Acker) { a[2].Ack(); a[0].Ack(); a[1].Ack() }, 12, 0, false},
+		{"malformed is committed past", func(a []model.Acker) { a[0].Nack(ack.Malformed(boom)); a[1].Ack() }, 11, 1, false},
+		{"nack keeps the acked prefix and drops the rest", func(a []model.Acker) { a[0].Ack(); a[1].Nack(boom); a[2].Ack() }, 10, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t, 10)
+			tt.resolve(track(t, tr, 10, 11, 12))
+			ps := tr.parts[0]
+			if ps.commitAt != tt.wantCommitAt {
+				t.Errorf("commitAt = %d, want %d", ps.commitAt, tt.wantCommitAt)
+			}
+			if len(ps.queue) != tt.wantQueued || len(tr.slots) != tt.wantQueued {
+				t.Errorf("queued = %d, slots = %d, want %d", len(ps.queue), len(tr.slots), tt.wantQueued)
+			}
+			if tr.holding != tt.wantHolding {
+				t.Errorf("holding = %v, want %v", tr.holding, tt.wantHolding)
+			}
+		})
+	}
+}
+
+func TestOffsetTrackerReplay(t *testing.T) {
+	tr := newTestTracker(t, 10)
+	acks := track(t, tr, 0, 1)
+	acks[0].Nack(errors.New("export failed"))
+
+	select {
+	case <-tr.replay:
+	default:
+		t.Fatal("nack did not request a replay")
+	}
+	// Tracked while the replay is pending: not queued, holds no slot.
+	late := track(t, tr, 2)
+	if len(tr.slots) != 0 || len(tr.parts[0].queue) != 0 {
+		t.Fatalf("slots = %d, queue = %d after nack, want 0", len(tr.slots), len(tr.parts[0].queue))
+	}
+	// Acks from before the replay must not move the commit point.
+	acks[1].Ack()
+	late[0].Ack()
+	if tr.parts[0].commitAt != -1 {
+		t.Fatalf("stale ack moved commitAt to %d", tr.parts[0].commitAt)
+	}
+
+	// After the replay the same offsets are tracked and committed normally.
+	tr.mu.Lock()
+	tr.resetLocked()
+	tr.holding = false
+	tr.mu.Unlock()
+	for _, a := range track(t, tr, 0, 1, 2) {
+		a.Ack()
+	}
+	if got := tr.parts[0].commitAt; got != 2 {
+		t.Fatalf("commitAt after replay = %d, want 2", got)
+	}
+}
+
+func TestOffsetTrackerMaxPending(t *testing.T) {
+	tr := newTestTracker(t, 2)
+	acks := track(t, tr, 0, 1)
+	acks[1].Ack() // still queued behind offset 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if a := tr.Track(ctx, kafkago.Message{Offset: 2}); a != nil {
+		t.Fatal("Track admitted a message past max_pending")
+	}
+	acks[0].Ack()
+	if a := tr.Track(context.Background(), kafkago.Message{Offset: 2}); a == nil {
+		t.Fatal("Track blocked after the queue drained")
+	}
+}
+
+func TestOffsetTrackerRebalance(t *testing.T) {
+	tr := newTestTracker(t, 10)
+	p0 := track(t, tr, 10, 11, 12)
+	p1 := tr.Track(context.Background(), kafkago.Message{Partition: 1, Offset: 5})
+	p0[0].Ack()
+
+	// The first generation of a reader is its join, not a rebalance.
+	tr.rebalanced(1)
+	if len(tr.slots) != 3 || tr.parts[0].commitAt != 10 {
+		t.Fatalf("join: slots = %d, commitAt = %d, want 3, 10", len(tr.slots), tr.parts[0].commitAt)
+	}
+
+	tr.rebalanced(1)
+	if len(tr.slots) != 0 || len(tr.parts[0].queue) != 0 || len(tr.parts[1].queue) != 0 {
+		t.Fatalf("rebalance kept slots = %d", len(tr.slots))
+	}
+	if tr.parts[0].commitAt != -1 {
+		t.Fatalf("rebalance kept commitAt = %d", tr.parts[0].commitAt)
+	}
+	// Acks for messages of lost or restarted partitions are ignored.
+	p0[1].Ack()
+	p1.Ack()
+	if tr.parts[0].commitAt != -1 || tr.parts[1].commitAt != -1 {
+		t.Fatal("stale ack moved the commit point")
+	}
+
+	// The kept partition is delivered again from its committed offset.
+	for _, a := range track(t, tr, 11, 12) {
+		a.Ack()
+	}
+	if got := tr.parts[0].commitAt; got != 12 {
+		t.Fatalf("commitAt after rebalance = %d, want 12", got)
+	}
+}
+
+func TestOffsetTrackerPartitionRestart(t *testing.T) {
+	tr := newTestTracker(t, 10)
+	stale := track(t, tr, 20, 21) // buffered before an unnoticed rebalance
+	stale[0].Ack()
+
+	// Restarted at the committed offset: the queue starts over.
+	acks := track(t, tr, 18)
+	if len(tr.slots) != 1 || len(tr.parts[0].queue) != 1 || tr.parts[0].commitAt != -1 {
+		t.Fatalf("slots = %d, queue = %d, commitAt = %d, want 1, 1, -1",
+			len(tr.slots), len(tr.parts[0].queue), tr.parts[0].commitAt)
+	}
+	stale[1].Ack()
+	if tr.parts[0].commitAt != -1 {
+		t.Fatal("stale ack moved the commit point")
+	}
+	acks[0].Ack()
+	if got := tr.parts[0].commitAt; got != 18 {
+		t.Fatalf("commitAt = %d, want 18", got)
+	}
+}
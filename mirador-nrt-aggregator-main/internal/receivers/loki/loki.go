@@ -0,0 +1,579 @@
+package loki
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// Receiver implements the Loki push API (POST /loki/api/v1/push) as used by
+// Promtail, Grafana Alloy and the Loki Docker driver:
+//   - Content-Type application/x-protobuf: snappy-compressed logproto.PushRequest
+//   - Content-Type application/json: {"streams":[{"stream":{...},"values":[["<ns>","line"(,{meta})]]}]}
+//     (the legacy {"labels":"{...}","entries":[{"ts","line"}]} form is accepted too)
+//
+// Every line becomes one KindJSONLogs envelope:
+//
+//	{"ts": RFC3339Nano, "message": line, <stream labels>, <structured metadata>,
+//	 <parsed body fields>, "service": ..., "level": ...}
+//
+// Supported rc.Extra keys:
+//   - path: string (default "/loki/api/v1/push")
+//   - service_labels: []string  first non-empty label → "service"
+//     (default [service_name, service, app, job, container])
+//   - level_labels: []string    first non-empty label/field → "level"
+//     (default [level, detected_level, severity, lvl])
+//   - parse_body: none | json | logfmt | auto (default none); parsed fields never
+//     overwrite labels
+//   - label_prefix: string (default "") prefix for label/metadata fields
+//   - max_body_bytes, read_timeout_ms, write_timeout_ms, idle_timeout_ms
+//   - tls.enabled, tls.cert_file, tls.key_file, tls.client_ca_file, tls.require_client_cert
+//
+// The X-Scope-OrgID header is forwarded as the envelope attr "loki.tenant".
+//
+// A push blocks until the pipeline has taken every line, so a slow pipeline
+// slows clients down instead of losing lines. If the request ends first
+// (client gone, shutdown) or the wait nears write_timeout_ms, the reply is
+// 503 and the client retries the batch.
+type Receiver struct {
+	endpoint string
+	path     string
+
+	serviceLabels []string
+	levelLabels   []string
+	parseBody     string
+	labelPrefix   string
+
+	maxBodyBytes int64
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	tlsEnabled        bool
+	tlsCertFile       string
+	tlsKeyFile        string
+	tlsClientCAFile   string
+	requireClientCert bool
+}
+
+// New builds a Loki push receiver.
+func New(rc config.ReceiverCfg) *Receiver {
+	path := "/loki/api/v1/push"
+	if s, ok := rc.Extra["path"].(string); ok && strings.TrimSpace(s) != "" {
+		path = s
+	}
+	svcLabels := stringList(rc.Extra["service_labels"], []string{"service_name", "service", "app", "job", "container"})
+	lvlLabels := stringList(rc.Extra["level_labels"], []string{"level", "detected_level", "severity", "lvl"})
+	parseBody := "none"
+	if s, ok := rc.Extra["parse_body"].(string); ok && s != "" {
+		parseBody = strings.ToLower(strings.TrimSpace(s))
+	}
+	prefix, _ := rc.Extra["label_prefix"].(string)
+
+	maxBody := int64(16 * 1024 * 1024)
+	if v, ok := rc.Extra["max_body_bytes"].(int); ok && v > 0 {
+		maxBody = int64(v)
+	}
+	rt := 30 * time.Second
+	if v, ok := rc.Extra["read_timeout_ms"].(int); ok && v > 0 {
+		rt = time.Duration(v) * time.Millisecond
+	}
+	wt := 30 * time.Second
+	if v, ok := rc.Extra["write_timeout_ms"].(int); ok && v > 0 {
+		wt = time.Duration(v) * time.Millisecond
+	}
+	it := 120 * time.Second
+	if v, ok := rc.Extra["idle_timeout_ms"].(int); ok && v > 0 {
+		it = time.Duration(v) * time.Millisecond
+	}
+
+	tlsEnabled := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "enabled"); ok {
+		tlsEnabled = b
+	}
+	requireClientCert := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "require_client_cert"); ok {
+		requireClientCert = b
+	}
+
+	return &Receiver{
+		endpoint:          rc.Endpoint,
+		path:              path,
+		serviceLabels:     svcLabels,
+		levelLabels:       lvlLabels,
+		parseBody:         parseBody,
+		labelPrefix:       prefix,
+		maxBodyBytes:      maxBody,
+		readTimeout:       rt,
+		writeTimeout:      wt,
+		idleTimeout:       it,
+		tlsEnabled:        tlsEnabled,
+		tlsCertFile:       common.NestedString(rc.Extra, "tls", "cert_file"),
+		tlsKeyFile:        common.NestedString(rc.Extra, "tls", "key_file"),
+		tlsClientCAFile:   common.NestedString(rc.Extra, "tls", "client_ca_file"),
+		requireClientCert: requireClientCert,
+	}
+}
+
+// Start launches the HTTP server; shutdown is graceful on ctx cancel.
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	addr := r.endpoint
+	if strings.TrimSpace(addr) == "" {
+		addr = ":3100"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc(r.path, func(w http.ResponseWriter, req *http.Request) { r.handlePush(w, req, out) })
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  r.readTimeout,
+		WriteTimeout: r.writeTimeout,
+		IdleTimeout:  r.idleTimeout,
+		// Request contexts end with the receiver, so pushes blocked on the
+		// pipeline give up on shutdown.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if r.tlsEnabled {
+		tlsCfg, err := common.ServerTLS(r.tlsCertFile, r.tlsKeyFile, r.tlsClientCAFile, r.requireClientCert)
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("loki tls: %w", err)
+		}
+		srv.TLSConfig = tlsCfg
+		log.Printf("[loki] listening on https://%s path=%s", addr, r.path)
+	} else {
+		log.Printf("[loki] listening on http://%s path=%s", addr, r.path)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var serveErr error
+		if r.tlsEnabled {
+			serveErr = srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr = srv.Serve(ln)
+		}
+		if serveErr != nil && serveErr != http.ErrServerClosed {
+			errCh <- serveErr
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shctx)
+		return nil
+	case e := <-errCh:
+		return e
+	}
+}
+
+// stream is the wire-independent form of one pushed stream.
+type stream struct {
+	labels  map[string]string
+	entries []entry
+}
+
+type entry struct {
+	ts   time.Time
+	line string
+	meta map[string]string
+}
+
+func (r *Receiver) handlePush(w http.ResponseWriter, req *http.Request, out chan<- model.Envelope) {
+	// WriteTimeout only cuts the connection, it does not end req.Context();
+	// give up a little before it so the 503 still reaches the client.
+	ctx := req.Context()
+	if r.writeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.writeTimeout-r.writeTimeout/10)
+		defer cancel()
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var reader io.Reader = http.MaxBytesReader(w, req.Body, r.maxBodyBytes)
+	defer req.Body.Close()
+	if strings.Contains(strings.ToLower(req.Header.Get("Content-Encoding")), "gzip") {
+		gr, err := gzip.NewReader(reader)
+		if err != nil {
+			http.Error(w, "invalid gzip", http.StatusBadRequest)
+			return
+		}
+		defer gr.Close()
+		reader = gr
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		http.Error(w, "read error", http.StatusBadRequest)
+		return
+	}
+
+	var streams []stream
+	if ct := strings.ToLower(req.Header.Get("Content-Type")); strings.Contains(ct, "json") {
+		streams, err = decodeJSON(body)
+	} else {
+		// Default per Loki: snappy-compressed protobuf.
+		var raw []byte
+		if raw, err = snappy.Decode(nil, body); err == nil {
+			streams, err = decodePush(raw)
+		}
+	}
+	if err != nil {
+		http.Error(w, "invalid push request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	attrs := map[string]string{}
+	if t := req.Header.Get("X-Scope-OrgID"); t != "" {
+		attrs["loki.tenant"] = t
+	}
+	now := time.Now().Unix()
+	sent := 0
+	for _, s := range streams {
+		for _, e := range s.entries {
+			b, err := json.Marshal(r.record(s.labels, e))
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- model.Envelope{Kind: model.KindJSONLogs, Bytes: b, Attrs: attrs, TSUnix: now}:
+				sent++
+			case <-ctx.Done():
+				log.Printf("[loki] push aborted after %d lines: %v", sent, ctx.Err())
+				http.Error(w, "pipeline busy, retry", http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// record builds the JSON log for one line.
+func (r *Receiver) record(labels map[string]string, e entry) map[string]any {
+	obj := make(map[string]any, len(labels)+len(e.meta)+4)
+	for k, v := range labels {
+		obj[r.labelPrefix+k] = v
+	}
+	for k, v := range e.meta {
+		obj[r.labelPrefix+k] = v
+	}
+
+	var parsed map[string]any
+	switch r.parseBody {
+	case "json":
+		parsed = parseJSONLine(e.line)
+	case "logfmt":
+		parsed = common.ParseLogfmt(e.line)
+	case "auto":
+		if strings.HasPrefix(strings.TrimSpace(e.line), "{") {
+			parsed = parseJSONLine(e.line)
+		} else {
+			parsed = common.ParseLogfmt(e.line)
+		}
+	}
+	for k, v := range parsed {
+		if _, exists := obj[k]; !exists {
+			obj[k] = v
+		}
+	}
+
+	obj["ts"] = e.ts.UTC().Format(time.RFC3339Nano)
+	obj["message"] = e.line
+
+	if svc := firstValue(labels, e.meta, parsed, r.serviceLabels); svc != "" {
+		obj["service"] = svc
+	}
+	if lvl := firstValue(labels, e.meta, parsed, r.levelLabels); lvl != "" {
+		obj["level"] = strings.ToLower(lvl)
+	}
+	return obj
+}
+
+func firstValue(labels, meta map[string]string, parsed map[string]any, keys []string) string {
+	for _, k := range keys {
+		if v := labels[k]; v != "" {
+			return v
+		}
+		if v := meta[k]; v != "" {
+			return v
+		}
+		if v, ok := parsed[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ---------------- protobuf (logproto.PushRequest) ----------------
+//
+//	PushRequest   { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; uint64 hash = 3; }
+//	EntryAdapter  { Timestamp timestamp = 1; string line = 2; repeated LabelPairAdapter structuredMetadata = 3; }
+//	LabelPairAdapter { string name = 1; string value = 2; }
+
+func decodePush(b []byte) ([]stream, error) {
+	var out []stream
+	err := walk(b, func(num protowire.Number, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var s stream
+		err := walk(v, func(num protowire.Number, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				lbls, err := parseLabels(string(v))
+				if err != nil {
+					return err
+				}
+				s.labels = lbls
+			case 2:
+				e, err := decodeEntry(v)
+				if err != nil {
+					return err
+				}
+				s.entries = append(s.entries, e)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		out = append(out, s)
+		return nil
+	})
+	return out, err
+}
+
+func decodeEntry(b []byte) (entry, error) {
+	var e entry
+	err := walk(b, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			var sec, nanos int64
+			if err := walk(v, func(num protowire.Number, _ []byte, n uint64) error {
+				switch num {
+				case 1:
+					sec = int64(n)
+				case 2:
+					nanos = int64(int32(n))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			e.ts = time.Unix(sec, nanos)
+		case 2:
+			e.line = string(v)
+		case 3:
+			var name, value string
+			if err := walk(v, func(num protowire.Number, v []byte, _ uint64) error {
+				switch num {
+				case 1:
+					name = string(v)
+				case 2:
+					value = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if e.meta == nil {
+				e.meta = map[string]string{}
+			}
+			e.meta[name] = value
+		}
+		return nil
+	})
+	return e, err
+}
+
+// walk iterates the fields of a protobuf message. For length-delimited fields v
+// holds the bytes; for varint/fixed fields n holds the raw value.
+func walk(b []byte, fn func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		var (
+			v []byte
+			n uint64
+		)
+		switch typ {
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			n, l = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var n32 uint32
+			n32, l = protowire.ConsumeFixed32(b)
+			n = uint64(n32)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		if err := fn(num, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLabels parses a Prometheus-style label set: {a="b", c="d"}.
+func parseLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("invalid label set %q", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	out := map[string]string{}
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid label set near %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimSpace(s[eq+1:])
+		if !strings.HasPrefix(s, `"`) {
+			return nil, fmt.Errorf("unquoted value for label %q", name)
+		}
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return nil, fmt.Errorf("unterminated value for label %q", name)
+		}
+		val, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("label %q: %w", name, err)
+		}
+		out[name] = val
+		s = strings.TrimSpace(s[end+1:])
+		s = strings.TrimSpace(strings.TrimPrefix(s, ","))
+	}
+	return out, nil
+}
+
+// ---------------- JSON ----------------
+
+type jsonPush struct {
+	Streams []struct {
+		Stream  map[string]string `json:"stream"`
+		Values  [][]any           `json:"values"`
+		Labels  string            `json:"labels"`
+		Entries []struct {
+			TS   time.Time `json:"ts"`
+			Line string    `json:"line"`
+		} `json:"entries"`
+	} `json:"streams"`
+}
+
+func decodeJSON(b []byte) ([]stream, error) {
+	var req jsonPush
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, err
+	}
+	out := make([]stream, 0, len(req.Streams))
+	for _, js := range req.Streams {
+		s := stream{labels: js.Stream}
+		if s.labels == nil && js.Labels != "" {
+			lbls, err := parseLabels(js.Labels)
+			if err != nil {
+				return nil, err
+			}
+			s.labels = lbls
+		}
+		for _, v := range js.Values {
+			if len(v) < 2 {
+				return nil, errors.New("stream value needs [timestamp, line]")
+			}
+			tsStr, _ := v[0].(string)
+			ns, err := strconv.ParseInt(tsStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %v", v[0])
+			}
+			line, _ := v[1].(string)
+			e := entry{ts: time.Unix(0, ns), line: line}
+			if len(v) > 2 {
+				if m, ok := v[2].(map[string]any); ok {
+					e.meta = make(map[string]string, len(m))
+					for k, mv := range m {
+						if sv, ok := mv.(string); ok {
+							e.meta[k] = sv
+						}
+					}
+				}
+			}
+			s.entries = append(s.entries, e)
+		}
+		for _, le := range js.Entries {
+			s.entries = append(s.entries, entry{ts: le.TS, line: le.Line})
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// ---------------- line parsers ----------------
+
+func parseJSONLine(line string) map[string]any {
+	var m map[string]any
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil
+	}
+	return m
+}
+
+func stringList(v any, def []string) []string {
+	arr, ok := v.([]any)
+	if !ok {
+		return def
+	}
+	out := make([]string, 0, len(arr))
+	for _, it := range arr {
+		if s, ok := it.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,220 @@
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// pbEntry and pbStream build logproto messages field by field.
+func pbEntry(sec, nanos int64, line string, meta ...string) []byte {
+	var ts []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(sec))
+	ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(nanos))
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, ts)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, line)
+	for i := 0; i+1 < len(meta); i += 2 {
+		var kv []byte
+		kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+		kv = protowire.AppendString(kv, meta[i])
+		kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+		kv = protowire.AppendString(kv, meta[i+1])
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, kv)
+	}
+	return b
+}
+
+func pbStream(labels string, entries ...[]byte) []byte {
+	var s []byte
+	s = protowire.AppendTag(s, 1, protowire.BytesType)
+	s = protowire.AppendString(s, labels)
+	for _, e := range entries {
+		s = protowire.AppendTag(s, 2, protowire.BytesType)
+		s = protowire.AppendBytes(s, e)
+	}
+	s = protowire.AppendTag(s, 3, protowire.VarintType) // hash, ignored
+	s = protowire.AppendVarint(s, 42)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, s)
+}
+
+func TestDecodePush(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		want    []stream
+		wantErr bool
+	}{
+		{
+			name: "entry with metadata",
+			in:   pbStream(`{app="api", env="prod"}`, pbEntry(1700000000, 5, "hello", "trace_id", "abc")),
+			want: []stream{{
+				labels:  map[string]string{"app": "api", "env": "prod"},
+				entries: []entry{{ts: time.Unix(1700000000, 5), line: "hello", meta: map[string]string{"trace_id": "abc"}}},
+			}},
+		},
+		{
+			name: "two streams",
+			in: append(pbStream(`{app="a"}`, pbEntry(1, 0, "x"), pbEntry(2, 0, "y")),
+				pbStream(`{app="b"}`, pbEntry(3, 0, "z"))...),
+			want: []stream{
+				{labels: map[string]string{"app": "a"}, entries: []entry{{ts: time.Unix(1, 0), line: "x"}, {ts: time.Unix(2, 0), line: "y"}}},
+				{labels: map[string]string{"app": "b"}, entries: []entry{{ts: time.Unix(3, 0), line: "z"}}},
+			},
+		},
+		{name: "bad labels", in: pbStream(`app=api`, pbEntry(1, 0, "x")), wantErr: true},
+		{name: "truncated", in: pbStream(`{app="a"}`, pbEntry(1, 0, "x"))[:10], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePush(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{`{}`, map[string]string{}, false},
+		{`{a="b"}`, map[string]string{"a": "b"}, false},
+		{`{ a = "b" , c="d,e" }`, map[string]string{"a": "b", "c": "d,e"}, false},
+		{`{path="C:\\logs", msg="say \"hi\""}`, map[string]string{"path": `C:\logs`, "msg": `say "hi"`}, false},
+		{`a="b"`, nil, true},
+		{`{a=b}`, nil, true},
+		{`{a="b}`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseLabels(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandlePush(t *testing.T) {
+	proto := snappy.Encode(nil, pbStream(`{service_name="api", level="WARN"}`, pbEntry(1700000000, 0, "slow query")))
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantStatus  int
+		want        []map[string]any
+	}{
+		{
+			name:        "snappy protobuf",
+			contentType: "application/x-protobuf",
+			body:        proto,
+			wantStatus:  http.StatusNoContent,
+			want: []map[string]any{{
+				"service_name": "api", "level": "warn", "service": "api",
+				"message": "slow query", "ts": "2023-11-14T22:13:20Z",
+			}},
+		},
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        []byte(`{"streams":[{"stream":{"app":"web"},"values":[["1700000000000000000","a"],["1700000001000000000","b",{"user":"u1"}]]}]}`),
+			wantStatus:  http.StatusNoContent,
+			want: []map[string]any{
+				{"app": "web", "service": "web", "message": "a", "ts": "2023-11-14T22:13:20Z"},
+				{"app": "web", "service": "web", "user": "u1", "message": "b", "ts": "2023-11-14T22:13:21Z"},
+			},
+		},
+		{
+			name:        "protobuf without snappy",
+			contentType: "application/x-protobuf",
+			body:        pbStream(`{app="a"}`, pbEntry(1, 0, "x")),
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(config.ReceiverCfg{})
+			out := make(chan model.Envelope, 8)
+			req := httptest.NewRequest(http.MethodPost, r.path, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			r.handlePush(rec, req, out)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			close(out)
+			var got []map[string]any
+			for env := range out {
+				var m map[string]any
+				if err := json.Unmarshal(env.Bytes, &m); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, m)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A push the pipeline cannot take must not be acknowledged.
+func TestHandlePushBackpressure(t *testing.T) {
+	r := New(config.ReceiverCfg{})
+	out := make(chan model.Envelope, 1)
+	body := snappy.Encode(nil, pbStream(`{app="a"}`, pbEntry(1, 0, "x"), pbEntry(2, 0, "y")))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, r.path, bytes.NewReader(body)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	r.handlePush(rec, req, out)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// The write timeout does not end the request context, so the push bounds
+// its own wait and still answers 503.
+func TestHandlePushWriteTimeout(t *testing.T) {
+	r := New(config.ReceiverCfg{Extra: map[string]any{"write_timeout_ms": 100}})
+	out := make(chan model.Envelope, 1)
+	body := snappy.Encode(nil, pbStream(`{app="a"}`, pbEntry(1, 0, "x"), pbEntry(2, 0, "y")))
+	req := httptest.NewRequest(http.MethodPost, r.path, bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	r.handlePush(rec, req, out)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if d := time.Since(start); d >= 100*time.Millisecond {
+		t.Fatalf("push gave up after %v, want before the write timeout", d)
+	}
+}
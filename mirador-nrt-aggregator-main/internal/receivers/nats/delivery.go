@@ -0,0 +1,120 @@
+package nats
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+)
+
+var (
+	ackedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_nats_acked_messages_total",
+		Help: "JetStream messages acknowledged after the pipeline finished with them.",
+	}, []string{"stream", "consumer"})
+
+	nackedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_nats_nacked_messages_total",
+		Help: "JetStream messages nak'ed for redelivery (reason=export) or terminated (reason=malformed).",
+	}, []string{"stream", "consumer", "reason"})
+)
+
+// messageAcker resolves one JetStream message once the pipeline is done with it.
+type messageAcker struct {
+	r    *Receiver
+	msg  jetstream.Msg
+	once sync.Once
+}
+
+func (a *messageAcker) Ack() {
+	a.once.Do(func() {
+		a.r.held.remove(a)
+		if err := a.msg.Ack(); err != nil {
+			log.Printf("[nats/%s] ack %s: %v", a.r.kind, a.msg.Subject(), err)
+			return
+		}
+		ackedMessages.WithLabelValues(a.r.stream, a.r.consumer).Inc()
+	})
+}
+
+func (a *messageAcker) Nack(err error) {
+	a.once.Do(func() {
+		a.r.held.remove(a)
+		a.r.nack(a.msg, err)
+	})
+}
+
+// heldAcks are the messages waiting for their export (ack_mode export).
+type heldAcks struct {
+	mu sync.Mutex
+	m  map[*messageAcker]struct{}
+}
+
+func (h *heldAcks) add(a *messageAcker) {
+	h.mu.Lock()
+	h.m[a] = struct{}{}
+	h.mu.Unlock()
+}
+
+func (h *heldAcks) remove(a *messageAcker) {
+	h.mu.Lock()
+	delete(h.m, a)
+	h.mu.Unlock()
+}
+
+func (h *heldAcks) snapshot() []*messageAcker {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]*messageAcker, 0, len(h.m))
+	for a := range h.m {
+		out = append(out, a)
+	}
+	return out
+}
+
+// keepAlive marks held messages in progress every progressEvery, so
+// JetStream does not redeliver them while a window still holds their ack.
+func (r *Receiver) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(r.progressEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, a := range r.held.snapshot() {
+				if err := a.msg.InProgress(); err != nil {
+					log.Printf("[nats/%s] in progress %s: %v", r.kind, a.msg.Subject(), err)
+				}
+			}
+		}
+	}
+}
+
+// nack terminates malformed messages (redelivery cannot help) and naks the
+// rest with nak_delay so JetStream redelivers them up to max_deliver times.
+func (r *Receiver) nack(msg jetstream.Msg, err error) {
+	delivered := uint64(0)
+	if md, mdErr := msg.Metadata(); mdErr == nil {
+		delivered = md.NumDelivered
+	}
+	reason := "export"
+	var rerr error
+	if ack.IsMalformed(err) {
+		reason = "malformed"
+		rerr = msg.TermWithReason(err.Error())
+	} else {
+		rerr = msg.NakWithDelay(r.nakDelay)
+	}
+	log.Printf("[nats/%s] nack %s delivered=%d reason=%s: %v", r.kind, msg.Subject(), delivered, reason, err)
+	if rerr != nil {
+		log.Printf("[nats/%s] nack %s: %v", r.kind, msg.Subject(), rerr)
+	}
+	nackedMessages.WithLabelValues(r.stream, r.consumer, reason).Inc()
+}
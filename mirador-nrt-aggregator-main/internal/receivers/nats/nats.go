@@ -0,0 +1,400 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	natsio "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/codec"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// Receiver consumes a NATS JetStream stream through a durable pull consumer
+// and forwards messages as model.Envelope, with the same payload handling as
+// the Kafka and Pulsar receivers (encoding, kind=auto, NDJSON splitting).
+//
+// Config mapping (config.ReceiverCfg):
+//   - Endpoint OR Brokers      => server URL(s) (nats://host:4222, tls://host:4222)
+//   - Topic                    => stream name
+//   - Group                    => durable consumer name
+//   - Extra:
+//     subjects: []string                    // consumer filter subjects (default: whole stream)
+//     subject_kinds: map[string]string      // subject pattern (* and > wildcards) → kind; first match wins
+//     kind, encoding, kind_header, default_kind, ndjson, max_bytes   // see codec.Options; headers are NATS headers
+//     deliver_policy: string                // all | new | last (default all; only used when the consumer is created)
+//     ack_mode: string                      // "export" (default) acks after export; "receive" acks on read
+//     ack_wait_ms: int                      // redelivery timeout for unacked messages (default 60000)
+//     progress_interval_ms: int             // ack_mode export: how often held messages are marked in progress (default ack_wait/2)
+//     max_deliver: int                      // delivery attempts before JetStream gives up (default unlimited)
+//     max_ack_pending: int                  // in-flight limit (default 10000)
+//     nak_delay_ms: int                     // delay before a failed export is redelivered (default 5000)
+//     fetch_batch: int                      // messages per pull request (default 256)
+//     fetch_max_wait_ms: int                // pull request expiry (default 5000)
+//     creds_file: string                    // NATS .creds (JWT + nkey seed)
+//     nkey_seed_file: string                // nkey seed for nkey auth
+//     user, password, token: string
+//     tls.ca_file, tls.cert_file, tls.key_file: string; tls.insecure_skip_verify: bool
+//
+// Undecodable messages are terminated (never redelivered); export failures are
+// nak'ed with nak_delay_ms until max_deliver is reached.
+//
+// With ack_mode export a message is acked only when the window holding it is
+// exported, which is typically longer than ack_wait (a 60s summarizer/logsum
+// window plus export time). Held messages are therefore marked in progress
+// every progress_interval_ms, resetting JetStream's ack_wait timer so they
+// are not redelivered (and counted twice) while still in a window.
+// progress_interval_ms must stay well below ack_wait_ms.
+type Receiver struct {
+	servers  string
+	stream   string
+	consumer string
+	kind     string
+
+	codec        codec.Options
+	subjects     []string
+	subjectKinds []subjectKind
+
+	deliverPolicy jetstream.DeliverPolicy
+	ackMode       string
+	ackWait       time.Duration
+	progressEvery time.Duration
+	maxDeliver    int
+	maxAckPending int
+	nakDelay      time.Duration
+	fetchBatch    int
+	fetchMaxWait  time.Duration
+
+	credsFile    string
+	nkeySeedFile string
+	user         string
+	password     string
+	token        string
+	tlsCAFile    string
+	tlsCertFile  string
+	tlsKeyFile   string
+	tlsInsecure  bool
+
+	held heldAcks
+}
+
+type subjectKind struct {
+	pattern string
+	kind    string
+}
+
+// New builds a NATS JetStream receiver. 'kind' should be "metrics" | "traces" | "prom_rw" | "json_logs" | "auto".
+func New(rc config.ReceiverCfg, kind string) *Receiver {
+	servers := strings.TrimSpace(rc.Endpoint)
+	if servers == "" && len(rc.Brokers) > 0 {
+		servers = strings.Join(rc.Brokers, ",")
+	}
+	if v, ok := rc.Extra["kind"].(string); ok && v != "" {
+		kind = v
+	}
+	opts := codec.FromExtra(rc.Extra, kind)
+
+	var subjects []string
+	if arr, ok := rc.Extra["subjects"].([]any); ok {
+		for _, it := range arr {
+			if s, ok := it.(string); ok && s != "" {
+				subjects = append(subjects, s)
+			}
+		}
+	}
+	var kinds []subjectKind
+	if m, ok := rc.Extra["subject_kinds"].(map[string]any); ok {
+		for pat, v := range m {
+			if s, ok := v.(string); ok && s != "" {
+				kinds = append(kinds, subjectKind{pattern: pat, kind: codec.NormalizeKind(s)})
+			}
+		}
+		// Deterministic precedence: more specific (fewer wildcards, longer) patterns first.
+		sortSubjectKinds(kinds)
+	}
+
+	deliver := jetstream.DeliverAllPolicy
+	if s, ok := rc.Extra["deliver_policy"].(string); ok {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "new":
+			deliver = jetstream.DeliverNewPolicy
+		case "last":
+			deliver = jetstream.DeliverLastPolicy
+		}
+	}
+	ackMode := "export"
+	if s, ok := rc.Extra["ack_mode"].(string); ok && strings.EqualFold(strings.TrimSpace(s), "receive") {
+		ackMode = "receive"
+	}
+	ackWait := 60 * time.Second
+	if v, ok := rc.Extra["ack_wait_ms"].(int); ok && v > 0 {
+		ackWait = time.Duration(v) * time.Millisecond
+	}
+	progressEvery := ackWait / 2
+	if v, ok := rc.Extra["progress_interval_ms"].(int); ok && v > 0 {
+		progressEvery = time.Duration(v) * time.Millisecond
+	}
+	if progressEvery >= ackWait {
+		log.Printf("[nats] progress_interval_ms (%s) must be below ack_wait_ms (%s); using %s", progressEvery, ackWait, ackWait/2)
+		progressEvery = ackWait / 2
+	}
+	maxDeliver := -1
+	if v, ok := rc.Extra["max_deliver"].(int); ok && v > 0 {
+		maxDeliver = v
+	}
+	maxAckPending := 10000
+	if v, ok := rc.Extra["max_ack_pending"].(int); ok && v > 0 {
+		maxAckPending = v
+	}
+	nakDelay := 5 * time.Second
+	if v, ok := rc.Extra["nak_delay_ms"].(int); ok && v >= 0 {
+		nakDelay = time.Duration(v) * time.Millisecond
+	}
+	fetchBatch := 256
+	if v, ok := rc.Extra["fetch_batch"].(int); ok && v > 0 {
+		fetchBatch = v
+	}
+	fetchMaxWait := 5 * time.Second
+	if v, ok := rc.Extra["fetch_max_wait_ms"].(int); ok && v > 0 {
+		fetchMaxWait = time.Duration(v) * time.Millisecond
+	}
+
+	credsFile, _ := rc.Extra["creds_file"].(string)
+	nkeySeed, _ := rc.Extra["nkey_seed_file"].(string)
+	user, _ := rc.Extra["user"].(string)
+	password, _ := rc.Extra["password"].(string)
+	token, _ := rc.Extra["token"].(string)
+	insecure, _ := common.NestedBool(rc.Extra, "tls", "insecure_skip_verify")
+
+	return &Receiver{
+		servers:       servers,
+		stream:        rc.Topic,
+		consumer:      rc.Group,
+		kind:          opts.Kind,
+		codec:         opts,
+		subjects:      subjects,
+		subjectKinds:  kinds,
+		deliverPolicy: deliver,
+		ackMode:       ackMode,
+		ackWait:       ackWait,
+		progressEvery: progressEvery,
+		maxDeliver:    maxDeliver,
+		maxAckPending: maxAckPending,
+		nakDelay:      nakDelay,
+		fetchBatch:    fetchBatch,
+		fetchMaxWait:  fetchMaxWait,
+		credsFile:     credsFile,
+		nkeySeedFile:  nkeySeed,
+		user:          user,
+		password:      password,
+		token:         token,
+		tlsCAFile:     common.NestedString(rc.Extra, "tls", "ca_file"),
+		tlsCertFile:   common.NestedString(rc.Extra, "tls", "cert_file"),
+		tlsKeyFile:    common.NestedString(rc.Extra, "tls", "key_file"),
+		tlsInsecure:   insecure,
+		held:          heldAcks{m: map[*messageAcker]struct{}{}},
+	}
+}
+
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	if r.servers == "" || strings.TrimSpace(r.stream) == "" || strings.TrimSpace(r.consumer) == "" {
+		return errors.New("nats receiver: missing server URL, stream (topic) or consumer (group)")
+	}
+
+	connOpts, err := r.connOptions()
+	if err != nil {
+		return err
+	}
+	nc, err := natsio.Connect(r.servers, connOpts...)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return err
+	}
+	cfg := jetstream.ConsumerConfig{
+		Durable:       r.consumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       r.ackWait,
+		MaxDeliver:    r.maxDeliver,
+		MaxAckPending: r.maxAckPending,
+		DeliverPolicy: r.deliverPolicy,
+	}
+	switch len(r.subjects) {
+	case 0:
+	case 1:
+		cfg.FilterSubject = r.subjects[0]
+	default:
+		cfg.FilterSubjects = r.subjects
+	}
+	cons, err := js.CreateOrUpdateConsumer(ctx, r.stream, cfg)
+	if err != nil {
+		return fmt.Errorf("nats consumer %s/%s: %w", r.stream, r.consumer, err)
+	}
+
+	log.Printf("[nats/%s] consuming stream=%s consumer=%s subjects=%v url=%s encoding=%s ack_mode=%s", r.kind, r.stream, r.consumer, r.subjects, r.servers, r.codec.Encoding, r.ackMode)
+
+	if r.ackMode == "export" {
+		go r.keepAlive(ctx)
+	}
+
+	for ctx.Err() == nil {
+		batch, err := cons.Fetch(r.fetchBatch, jetstream.FetchMaxWait(r.fetchMaxWait))
+		if err != nil {
+			log.Printf("[nats/%s] fetch: %v", r.kind, err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for msg := range batch.Messages() {
+			if !r.handle(ctx, msg, out) {
+				return nil
+			}
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, natsio.ErrTimeout) && ctx.Err() == nil {
+			log.Printf("[nats/%s] fetch: %v", r.kind, err)
+		}
+	}
+	return nil
+}
+
+// handle decodes one message and forwards its payloads; false means ctx ended.
+func (r *Receiver) handle(ctx context.Context, msg jetstream.Msg, out chan<- model.Envelope) bool {
+	ts := time.Now().Unix()
+	attrs := headersToMap(msg.Headers())
+	attrs["nats.subject"] = msg.Subject()
+
+	opts := r.codec
+	if k := r.kindFor(msg.Subject()); k != "" {
+		opts.Kind = k
+	}
+	payloads, err := opts.Decode(msg.Data(), attrs)
+	if err != nil {
+		r.nack(msg, ack.Malformed(err))
+		return true
+	}
+
+	var msgAck model.Acker
+	if r.ackMode == "export" {
+		a := &messageAcker{r: r, msg: msg}
+		r.held.add(a)
+		msgAck = a
+	} else if err := msg.Ack(); err != nil {
+		log.Printf("[nats/%s] ack %s: %v", r.kind, msg.Subject(), err)
+	}
+	acks := ack.Fanout(msgAck, len(payloads))
+	for i, p := range payloads {
+		env := model.Envelope{Kind: p.Kind, Bytes: p.Bytes, Attrs: attrs, TSUnix: ts, Ack: acks[i]}
+		if msgAck != nil {
+			// Unacked messages are redelivered after ack_wait, so wait for the
+			// pipeline instead of dropping.
+			select {
+			case out <- env:
+			case <-ctx.Done():
+				return false
+			}
+			continue
+		}
+		select {
+		case out <- env:
+		default:
+			log.Printf("[nats/%s] dropping message due to backpressure", p.Kind)
+		}
+	}
+	return true
+}
+
+// kindFor returns the kind mapped to subject by subject_kinds, if any.
+func (r *Receiver) kindFor(subject string) string {
+	for _, sk := range r.subjectKinds {
+		if subjectMatch(sk.pattern, subject) {
+			return sk.kind
+		}
+	}
+	return ""
+}
+
+// subjectMatch implements NATS subject wildcards: '*' matches one token,
+// '>' matches one or more trailing tokens.
+func subjectMatch(pattern, subject string) bool {
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) || (p != "*" && p != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+func sortSubjectKinds(ks []subjectKind) {
+	wild := func(p string) int { return strings.Count(p, "*") + 2*strings.Count(p, ">") }
+	for i := 1; i < len(ks); i++ {
+		for j := i; j > 0; j-- {
+			a, b := ks[j-1], ks[j]
+			if wild(b.pattern) < wild(a.pattern) ||
+				(wild(b.pattern) == wild(a.pattern) && (len(b.pattern) > len(a.pattern) ||
+					(len(b.pattern) == len(a.pattern) && b.pattern < a.pattern))) {
+				ks[j-1], ks[j] = b, a
+			}
+		}
+	}
+}
+
+func (r *Receiver) connOptions() ([]natsio.Option, error) {
+	opts := []natsio.Option{
+		natsio.Name("mirador-nrt-aggregator"),
+		natsio.MaxReconnects(-1),
+		natsio.ReconnectWait(2 * time.Second),
+	}
+	switch {
+	case r.credsFile != "":
+		opts = append(opts, natsio.UserCredentials(r.credsFile))
+	case r.nkeySeedFile != "":
+		o, err := natsio.NkeyOptionFromSeed(r.nkeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("nats nkey: %w", err)
+		}
+		opts = append(opts, o)
+	case r.token != "":
+		opts = append(opts, natsio.Token(r.token))
+	case r.user != "":
+		opts = append(opts, natsio.UserInfo(r.user, r.password))
+	}
+
+	if r.tlsCAFile != "" || r.tlsCertFile != "" || r.tlsInsecure {
+		cfg, err := common.ClientTLS(r.tlsCAFile, r.tlsCertFile, r.tlsKeyFile, r.tlsInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("nats tls: %w", err)
+		}
+		opts = append(opts, natsio.Secure(cfg))
+	}
+	return opts, nil
+}
+
+func headersToMap(h natsio.Header) map[string]string {
+	out := make(map[string]string, len(h)+1)
+	for k, v := range h {
+		if len(v) > 0 {
+			out[k] = v[0]
+		}
+	}
+	return out
+}
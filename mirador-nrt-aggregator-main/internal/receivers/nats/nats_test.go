@@ -0,0 +1,198 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsio "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+func TestSubjectMatch(t *testing.T) {
+	tests := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"logs.app", "logs.app", true},
+		{"logs.app", "logs.db", false},
+		{"logs.*", "logs.app", true},
+		{"logs.*", "logs.app.x", false},
+		{"logs.>", "logs.app.x", true},
+		{"logs.>", "logs", false},
+		{"*.app", "logs.app", true},
+		{"logs.*.x", "logs.app", false},
+	}
+	for _, tt := range tests {
+		if got := subjectMatch(tt.pattern, tt.subject); got != tt.want {
+			t.Errorf("subjectMatch(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+		}
+	}
+}
+
+// runJetStream starts an embedded JetStream server with one stream, "EVENTS",
+// on subjects events.>.
+func runJetStream(t *testing.T) (string, jetstream.JetStream) {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+	nc, err := natsio.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "EVENTS", Subjects: []string{"events.>"}}); err != nil {
+		t.Fatal(err)
+	}
+	return srv.ClientURL(), js
+}
+
+func startReceiver(t *testing.T, url string, extra map[string]any) <-chan model.Envelope {
+	t.Helper()
+	extra["fetch_max_wait_ms"] = 200
+	r := New(config.ReceiverCfg{Endpoint: url, Topic: "EVENTS", Group: "test", Extra: extra}, "json_logs")
+	out := make(chan model.Envelope, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		if err := r.Start(ctx, out); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+	return out
+}
+
+func receive(t *testing.T, out <-chan model.Envelope) model.Envelope {
+	t.Helper()
+	select {
+	case env := <-out:
+		return env
+	case <-time.After(5 * time.Second):
+		t.Fatal("no envelope received")
+	}
+	return model.Envelope{}
+}
+
+// pending waits until the consumer's ack-pending and redelivery-pending
+// counts settle at want, and fails after a few seconds otherwise.
+func pending(t *testing.T, js jetstream.JetStream, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := -1
+		cons, err := js.Consumer(context.Background(), "EVENTS", "test")
+		switch {
+		case errors.Is(err, jetstream.ErrConsumerNotFound): // receiver not started yet
+		case err != nil:
+			t.Fatal(err)
+		default:
+			info, err := cons.Info(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got = info.NumAckPending + int(info.NumPending); got == want {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("consumer pending = %d, want %d", got, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestReceiverAcks(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   map[string]any
+		payload string
+		resolve func(model.Acker)
+		deliver bool // an envelope reaches the pipeline
+		pending int  // messages left unacked on the consumer
+	}{
+		{
+			name:    "export ack",
+			extra:   map[string]any{},
+			payload: `{"msg":"ok"}`,
+			resolve: func(a model.Acker) { a.Ack() },
+			deliver: true,
+		},
+		{
+			name:    "export nack is redelivered",
+			extra:   map[string]any{"nak_delay_ms": 60000},
+			payload: `{"msg":"ok"}`,
+			resolve: func(a model.Acker) { a.Nack(errors.New("export failed")) },
+			deliver: true,
+			pending: 1,
+		},
+		{
+			name:    "malformed is terminated",
+			extra:   map[string]any{"encoding": "otlp_json", "kind": "metrics"},
+			payload: `not json`,
+		},
+		{
+			name:    "receive mode acks on read",
+			extra:   map[string]any{"ack_mode": "receive"},
+			payload: `{"msg":"ok"}`,
+			deliver: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, js := runJetStream(t)
+			out := startReceiver(t, url, tt.extra)
+			if _, err := js.Publish(context.Background(), "events.app", []byte(tt.payload)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.deliver {
+				env := receive(t, out)
+				if string(env.Bytes) != tt.payload {
+					t.Fatalf("payload = %q, want %q", env.Bytes, tt.payload)
+				}
+				if tt.resolve != nil {
+					tt.resolve(env.Ack)
+				} else if env.Ack != nil {
+					t.Fatal("receive mode should not hand out an acker")
+				}
+			}
+			pending(t, js, tt.pending)
+		})
+	}
+}
+
+// A message held longer than ack_wait (as a window does) must not be
+// redelivered while it is marked in progress.
+func TestReceiverKeepsHeldMessages(t *testing.T) {
+	url, js := runJetStream(t)
+	out := startReceiver(t, url, map[string]any{"ack_wait_ms": 500, "progress_interval_ms": 100})
+	if _, err := js.Publish(context.Background(), "events.app", []byte(`{"msg":"held"}`)); err != nil {
+		t.Fatal(err)
+	}
+	env := receive(t, out)
+
+	select {
+	case dup := <-out:
+		t.Fatalf("held message redelivered: %q", dup.Bytes)
+	case <-time.After(2 * time.Second):
+	}
+	env.Ack.Ack()
+	pending(t, js, 0)
+}
@@ -0,0 +1,554 @@
+package promscrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	prompb "github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v3"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+const acceptHeader = "application/openmetrics-text;version=1.0.0;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+
+// Receiver scrapes Prometheus/OpenMetrics endpoints on a fixed interval and
+// forwards every scrape as a Prometheus Remote Write payload
+// (model.Envelope{Kind: model.KindPromRW}), so the summarizer consumes it
+// exactly like remote-written data. Envelopes carry model.AttrCumulative, so
+// the summarizer deltas scraped counters per series instead of adding their
+// lifetime totals every scrape.
+//
+// Each scrape also yields the synthetic series Prometheus itself records:
+//
+//	up{job,instance}                      1 on success, 0 on any scrape/parse failure
+//	scrape_duration_seconds{job,instance}
+//	scrape_samples_scraped{job,instance}
+//
+// so an unreachable target shows up downstream instead of silently going quiet.
+//
+// Supported rc.Extra keys:
+//   - job_name: string (default rc.Name)
+//   - scrape_interval_ms: int (default 15000)
+//   - scrape_timeout_ms: int (default 10000, capped at the interval)
+//   - metrics_path: string (default "/metrics")
+//   - scheme: string (default "http")
+//   - honor_labels: bool (default false; conflicting scraped labels become exported_<name>)
+//   - max_body_bytes: int (default 16*1024*1024)
+//   - static_configs: [{targets: ["host:port"], labels: {k: v}}]
+//   - file_sd_configs: [{files: ["/etc/mirador/sd/*.yaml"], refresh_interval_ms: 30000}]
+//   - bearer_token_file: string
+//   - tls.ca_file: string
+//   - tls.insecure_skip_verify: bool
+type Receiver struct {
+	job          string
+	interval     time.Duration
+	timeout      time.Duration
+	metricsPath  string
+	scheme       string
+	honorLabels  bool
+	maxBodyBytes int64
+
+	static      []targetGroup
+	fileSD      []fileSDConfig
+	bearerFile  string
+	tlsCAFile   string
+	tlsInsecure bool
+
+	client *http.Client
+}
+
+// targetGroup mirrors the Prometheus static_config / file_sd target group shape.
+type targetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+type fileSDConfig struct {
+	files   []string
+	refresh time.Duration
+}
+
+// target is a single resolved scrape endpoint.
+type target struct {
+	url    string
+	labels map[string]string // job, instance + group labels
+}
+
+// New builds a Prometheus scrape receiver.
+func New(rc config.ReceiverCfg) *Receiver {
+	job := rc.Name
+	if s, ok := rc.Extra["job_name"].(string); ok && strings.TrimSpace(s) != "" {
+		job = s
+	}
+
+	interval := 15 * time.Second
+	if v, ok := rc.Extra["scrape_interval_ms"].(int); ok && v > 0 {
+		interval = time.Duration(v) * time.Millisecond
+	}
+	timeout := 10 * time.Second
+	if v, ok := rc.Extra["scrape_timeout_ms"].(int); ok && v > 0 {
+		timeout = time.Duration(v) * time.Millisecond
+	}
+	if timeout > interval {
+		timeout = interval
+	}
+
+	path := "/metrics"
+	if s, ok := rc.Extra["metrics_path"].(string); ok && strings.TrimSpace(s) != "" {
+		path = s
+	}
+	scheme := "http"
+	if s, ok := rc.Extra["scheme"].(string); ok && strings.TrimSpace(s) != "" {
+		scheme = strings.ToLower(s)
+	}
+	honor := false
+	if b, ok := rc.Extra["honor_labels"].(bool); ok {
+		honor = b
+	}
+	maxBody := int64(16 * 1024 * 1024)
+	if v, ok := rc.Extra["max_body_bytes"].(int); ok && v > 0 {
+		maxBody = int64(v)
+	}
+
+	bearer := ""
+	if s, ok := rc.Extra["bearer_token_file"].(string); ok {
+		bearer = s
+	}
+	insecure := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "insecure_skip_verify"); ok {
+		insecure = b
+	}
+
+	return &Receiver{
+		job:          job,
+		interval:     interval,
+		timeout:      timeout,
+		metricsPath:  path,
+		scheme:       scheme,
+		honorLabels:  honor,
+		maxBodyBytes: maxBody,
+		static:       parseStaticConfigs(rc.Extra["static_configs"]),
+		fileSD:       parseFileSDConfigs(rc.Extra["file_sd_configs"]),
+		bearerFile:   bearer,
+		tlsCAFile:    common.NestedString(rc.Extra, "tls", "ca_file"),
+		tlsInsecure:  insecure,
+	}
+}
+
+// Start runs target discovery and one scrape loop per target until ctx is done.
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	if len(r.static) == 0 && len(r.fileSD) == 0 {
+		return errors.New("promscrape receiver: no static_configs or file_sd_configs")
+	}
+
+	tlsCfg, err := common.ClientTLS(r.tlsCAFile, "", "", r.tlsInsecure)
+	if err != nil {
+		return fmt.Errorf("promscrape tls: %w", err)
+	}
+	r.client = &http.Client{
+		Timeout:   r.timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsCfg, Proxy: http.ProxyFromEnvironment},
+	}
+
+	log.Printf("[promscrape] job=%s interval=%s static_groups=%d file_sd=%d", r.job, r.interval, len(r.static), len(r.fileSD))
+
+	// Refresh at the shortest file_sd interval; static-only configs are resolved once.
+	refresh := time.Duration(0)
+	for _, f := range r.fileSD {
+		if refresh == 0 || f.refresh < refresh {
+			refresh = f.refresh
+		}
+	}
+
+	running := map[string]context.CancelFunc{}
+	var wg sync.WaitGroup
+	defer func() {
+		for _, cancel := range running {
+			cancel()
+		}
+		wg.Wait()
+	}()
+
+	resync := func() {
+		want := r.discover()
+		for key, cancel := range running {
+			if _, ok := want[key]; !ok {
+				cancel()
+				delete(running, key)
+			}
+		}
+		for key, t := range want {
+			if _, ok := running[key]; ok {
+				continue
+			}
+			tctx, cancel := context.WithCancel(ctx)
+			running[key] = cancel
+			wg.Add(1)
+			go func(t target) {
+				defer wg.Done()
+				r.scrapeLoop(tctx, t, out)
+			}(t)
+		}
+	}
+	resync()
+
+	if refresh <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			resync()
+		}
+	}
+}
+
+// discover resolves static and file-based target groups into a keyed target set.
+func (r *Receiver) discover() map[string]target {
+	groups := append([]targetGroup{}, r.static...)
+	for _, f := range r.fileSD {
+		for _, pattern := range f.files {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				log.Printf("[promscrape] bad file_sd pattern %q: %v", pattern, err)
+				continue
+			}
+			for _, path := range matches {
+				gs, err := readTargetFile(path)
+				if err != nil {
+					log.Printf("[promscrape] file_sd %s: %v", path, err)
+					continue
+				}
+				groups = append(groups, gs...)
+			}
+		}
+	}
+
+	out := map[string]target{}
+	for _, g := range groups {
+		for _, addr := range g.Targets {
+			addr = strings.TrimSpace(addr)
+			if addr == "" {
+				continue
+			}
+			lbls := map[string]string{"job": r.job, "instance": addr}
+			for k, v := range g.Labels {
+				lbls[k] = v
+			}
+			u := r.targetURL(addr, lbls)
+			out[u+"|"+labelsKey(lbls)] = target{url: u, labels: lbls}
+		}
+	}
+	return out
+}
+
+// targetURL honours the Prometheus __scheme__ / __metrics_path__ label overrides.
+func (r *Receiver) targetURL(addr string, lbls map[string]string) string {
+	scheme := r.scheme
+	if s := lbls["__scheme__"]; s != "" {
+		scheme = s
+	}
+	path := r.metricsPath
+	if s := lbls["__metrics_path__"]; s != "" {
+		path = s
+	}
+	for k := range lbls {
+		if strings.HasPrefix(k, "__") {
+			delete(lbls, k)
+		}
+	}
+	u := url.URL{Scheme: scheme, Host: addr, Path: path}
+	return u.String()
+}
+
+func (r *Receiver) scrapeLoop(ctx context.Context, t target, out chan<- model.Envelope) {
+	// Spread targets across the interval so they don't all fire at once.
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(t.url))
+	offset := time.Duration(h.Sum64() % uint64(r.interval))
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(offset):
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		wr := r.scrape(ctx, t)
+		b, err := wr.Marshal()
+		if err != nil {
+			log.Printf("[promscrape] marshal error target=%s: %v", t.url, err)
+		} else {
+			env := model.Envelope{
+				Kind:  model.KindPromRW,
+				Bytes: b,
+				Attrs: map[string]string{
+					"promscrape.job":      t.labels["job"],
+					"promscrape.instance": t.labels["instance"],
+					model.AttrCumulative:  "true", // scraped counters are lifetime totals
+				},
+				TSUnix: time.Now().Unix(),
+			}
+			select {
+			case out <- env:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrape fetches and parses one target. It never fails: errors are reported
+// through the synthetic up=0 series.
+func (r *Receiver) scrape(ctx context.Context, t target) *prompb.WriteRequest {
+	start := time.Now()
+	tsMs := start.UnixMilli()
+
+	series, err := r.fetchAndParse(ctx, t, tsMs)
+	if err != nil {
+		log.Printf("[promscrape] scrape failed target=%s: %v", t.url, err)
+		series = nil
+	}
+
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	series = append(series,
+		r.synthetic("up", t, tsMs, up),
+		r.synthetic("scrape_duration_seconds", t, tsMs, time.Since(start).Seconds()),
+		r.synthetic("scrape_samples_scraped", t, tsMs, float64(len(series))),
+	)
+	return &prompb.WriteRequest{Timeseries: series}
+}
+
+func (r *Receiver) fetchAndParse(ctx context.Context, t target, tsMs int64) ([]prompb.TimeSeries, error) {
+	sctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(sctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(r.timeout.Seconds(), 'f', -1, 64))
+	if r.bearerFile != "" {
+		tok, err := os.ReadFile(r.bearerFile)
+		if err != nil {
+			return nil, fmt.Errorf("read bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(tok)))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, r.maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > r.maxBodyBytes {
+		return nil, fmt.Errorf("body exceeds max_body_bytes=%d", r.maxBodyBytes)
+	}
+
+	p, err := textparse.New(body, resp.Header.Get("Content-Type"), false)
+	if err != nil {
+		// New always returns a usable parser; an unknown content type falls back to text.
+		log.Printf("[promscrape] content-type %q: %v (falling back to text format)", resp.Header.Get("Content-Type"), err)
+	}
+
+	var out []prompb.TimeSeries
+	for {
+		et, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse: %w", err)
+		}
+		if et != textparse.EntrySeries {
+			continue
+		}
+		_, ts, v := p.Series()
+		var lset labels.Labels
+		p.Metric(&lset)
+
+		sampleTs := tsMs
+		if ts != nil {
+			sampleTs = *ts
+		}
+		out = append(out, prompb.TimeSeries{
+			Labels:  r.mergeLabels(lset, t.labels),
+			Samples: []prompb.Sample{{Value: v, Timestamp: sampleTs}},
+		})
+	}
+	return out, nil
+}
+
+// mergeLabels attaches target labels to a scraped series. Conflicts are resolved
+// like Prometheus: honor_labels keeps the scraped value, otherwise the scraped
+// value is preserved as exported_<name>.
+func (r *Receiver) mergeLabels(scraped labels.Labels, targetLbls map[string]string) []prompb.Label {
+	m := map[string]string{}
+	scraped.Range(func(l labels.Label) { m[l.Name] = l.Value })
+	for k, v := range targetLbls {
+		if cur, ok := m[k]; ok && cur != "" {
+			if r.honorLabels {
+				continue
+			}
+			m["exported_"+k] = cur
+		}
+		m[k] = v
+	}
+	return toPromLabels(m)
+}
+
+func (r *Receiver) synthetic(name string, t target, tsMs int64, v float64) prompb.TimeSeries {
+	m := map[string]string{"__name__": name}
+	for k, val := range t.labels {
+		m[k] = val
+	}
+	return prompb.TimeSeries{
+		Labels:  toPromLabels(m),
+		Samples: []prompb.Sample{{Value: v, Timestamp: tsMs}},
+	}
+}
+
+// ----------------- discovery helpers -----------------
+
+func readTargetFile(path string) ([]targetGroup, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is a subset of YAML, so one decoder covers both file_sd formats.
+	var gs []targetGroup
+	if err := yaml.Unmarshal(b, &gs); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+func parseStaticConfigs(raw any) []targetGroup {
+	arr, ok := raw.([]any)
+	if !ok {
+		return nil
+	}
+	var out []targetGroup
+	for _, it := range arr {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		g := targetGroup{Targets: stringSlice(m["targets"]), Labels: map[string]string{}}
+		if lm, ok := m["labels"].(map[string]any); ok {
+			for k, v := range lm {
+				g.Labels[k] = fmt.Sprint(v)
+			}
+		}
+		if len(g.Targets) > 0 {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+func parseFileSDConfigs(raw any) []fileSDConfig {
+	arr, ok := raw.([]any)
+	if !ok {
+		return nil
+	}
+	var out []fileSDConfig
+	for _, it := range arr {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		fc := fileSDConfig{files: stringSlice(m["files"]), refresh: 30 * time.Second}
+		if v, ok := m["refresh_interval_ms"].(int); ok && v > 0 {
+			fc.refresh = time.Duration(v) * time.Millisecond
+		}
+		if len(fc.files) > 0 {
+			out = append(out, fc)
+		}
+	}
+	return out
+}
+
+func stringSlice(raw any) []string {
+	arr, ok := raw.([]any)
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(arr))
+	for _, it := range arr {
+		if s, ok := it.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func toPromLabels(m map[string]string) []prompb.Label {
+	out := make([]prompb.Label, 0, len(m))
+	for k, v := range m {
+		if v == "" {
+			continue
+		}
+		out = append(out, prompb.Label{Name: k, Value: v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func labelsKey(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(m[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
@@ -0,0 +1,247 @@
+package promscrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	prompb "github.com/prometheus/prometheus/prompb"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+func TestParseStaticConfigs(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  any
+		want []targetGroup
+	}{
+		{"missing", nil, nil},
+		{
+			name: "targets and labels",
+			raw: []any{map[string]any{
+				"targets": []any{"a:9100", "b:9100"},
+				"labels":  map[string]any{"env": "prod", "shard": 2},
+			}},
+			want: []targetGroup{{Targets: []string{"a:9100", "b:9100"}, Labels: map[string]string{"env": "prod", "shard": "2"}}},
+		},
+		{
+			name: "groups without targets are dropped",
+			raw:  []any{map[string]any{"labels": map[string]any{"env": "prod"}}, "junk"},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStaticConfigs(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// targetKeys lists discovered targets as "url job instance extra-labels".
+func targetKeys(ts map[string]target) []string {
+	var out []string
+	for _, t := range ts {
+		s := t.url
+		keys := make([]string, 0, len(t.labels))
+		for k := range t.labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s += " " + k + "=" + t.labels[k]
+		}
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestDiscoverFileSD(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, s string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.yaml", "- targets: ['db:9187']\n  labels: {env: prod}\n")
+	write("b.json", `[{"targets": ["cache:9121"], "labels": {"__metrics_path__": "/probe", "__scheme__": "https"}}]`)
+
+	r := New(config.ReceiverCfg{Name: "node", Extra: map[string]any{
+		"static_configs":  []any{map[string]any{"targets": []any{"host:9100"}}},
+		"file_sd_configs": []any{map[string]any{"files": []any{filepath.Join(dir, "*.yaml"), filepath.Join(dir, "*.json")}}},
+	}})
+	want := []string{
+		"http://db:9187/metrics env=prod instance=db:9187 job=node",
+		"http://host:9100/metrics instance=host:9100 job=node",
+		"https://cache:9121/probe instance=cache:9121 job=node",
+	}
+	if got := targetKeys(r.discover()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("targets:\n got %q\nwant %q", got, want)
+	}
+
+	// Reload: changed and removed files are picked up by the next discover.
+	write("a.yaml", "- targets: ['db:9187', 'db2:9187']\n  labels: {env: staging}\n")
+	if err := os.Remove(filepath.Join(dir, "b.json")); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"http://db2:9187/metrics env=staging instance=db2:9187 job=node",
+		"http://db:9187/metrics env=staging instance=db:9187 job=node",
+		"http://host:9100/metrics instance=host:9100 job=node",
+	}
+	if got := targetKeys(r.discover()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after reload:\n got %q\nwant %q", got, want)
+	}
+
+	// A broken file is skipped; the rest still resolves.
+	write("a.yaml", "- targets: [")
+	if got := targetKeys(r.discover()); !reflect.DeepEqual(got, want[2:]) {
+		t.Fatalf("broken file: got %q", got)
+	}
+}
+
+// seriesByName maps each series of a write request as "name{labels}" to its value,
+// leaving out scrape_duration_seconds (not deterministic).
+func seriesByName(wr *prompb.WriteRequest) map[string]float64 {
+	out := map[string]float64{}
+	for _, ts := range wr.Timeseries {
+		var name string
+		var lbls []string
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				name = l.Value
+				continue
+			}
+			lbls = append(lbls, l.Name+"="+l.Value)
+		}
+		if name == "scrape_duration_seconds" {
+			continue
+		}
+		out[name+"{"+strings.Join(lbls, ",")+"}"] = ts.Samples[0].Value
+	}
+	return out
+}
+
+func TestScrape(t *testing.T) {
+	const promText = `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",job="app"} 12
+http_requests_total{code="500",job="app"} 3
+# TYPE queue_depth gauge
+queue_depth 7
+`
+	const openMetrics = `# TYPE http_requests counter
+http_requests_total{code="200"} 12
+# TYPE queue_depth gauge
+queue_depth 7
+# EOF
+`
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		honor       bool
+		want        map[string]float64
+	}{
+		{
+			name:        "prometheus text, conflicting job kept as exported_job",
+			contentType: "text/plain; version=0.0.4",
+			body:        promText,
+			want: map[string]float64{
+				"http_requests_total{code=200,exported_job=app,instance=T,job=scrape}": 12,
+				"http_requests_total{code=500,exported_job=app,instance=T,job=scrape}": 3,
+				"queue_depth{instance=T,job=scrape}":                                   7,
+				"up{instance=T,job=scrape}":                                            1,
+				"scrape_samples_scraped{instance=T,job=scrape}":                        3,
+			},
+		},
+		{
+			name:        "honor_labels keeps the scraped job",
+			contentType: "text/plain; version=0.0.4",
+			body:        promText,
+			honor:       true,
+			want: map[string]float64{
+				"http_requests_total{code=200,instance=T,job=app}": 12,
+				"http_requests_total{code=500,instance=T,job=app}": 3,
+				"queue_depth{instance=T,job=scrape}":               7,
+				"up{instance=T,job=scrape}":                        1,
+				"scrape_samples_scraped{instance=T,job=scrape}":    3,
+			},
+		},
+		{
+			name:        "openmetrics",
+			contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			body:        openMetrics,
+			want: map[string]float64{
+				"http_requests_total{code=200,instance=T,job=scrape}": 12,
+				"queue_depth{instance=T,job=scrape}":                  7,
+				"up{instance=T,job=scrape}":                           1,
+				"scrape_samples_scraped{instance=T,job=scrape}":       2,
+			},
+		},
+		{
+			name:   "failed scrape reports up=0",
+			status: http.StatusInternalServerError,
+			want: map[string]float64{
+				"up{instance=T,job=scrape}":                     0,
+				"scrape_samples_scraped{instance=T,job=scrape}": 0,
+			},
+		},
+		{
+			name:        "parse error reports up=0",
+			contentType: "text/plain; version=0.0.4",
+			body:        "queue_depth{ 7\n",
+			want: map[string]float64{
+				"up{instance=T,job=scrape}":                     0,
+				"scrape_samples_scraped{instance=T,job=scrape}": 0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			r := New(config.ReceiverCfg{Name: "scrape", Extra: map[string]any{"honor_labels": tt.honor}})
+			r.client = srv.Client()
+			addr := strings.TrimPrefix(srv.URL, "http://")
+			tg := target{url: srv.URL + "/metrics", labels: map[string]string{"job": "scrape", "instance": addr}}
+
+			wr := r.scrape(context.Background(), tg)
+			got := map[string]float64{}
+			for k, v := range seriesByName(wr) {
+				got[strings.ReplaceAll(k, addr, "T")] = v
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("series:\n got %v\nwant %v", got, tt.want)
+			}
+			var haveDuration bool
+			for _, ts := range wr.Timeseries {
+				for _, l := range ts.Labels {
+					haveDuration = haveDuration || (l.Name == "__name__" && l.Value == "scrape_duration_seconds")
+				}
+			}
+			if !haveDuration {
+				t.Fatal("no scrape_duration_seconds series")
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package pulsar
+
+import (
+	"log"
+	"sync"
+
+	ps "github.com/apache/pulsar-client-go/pulsar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+)
+
+var (
+	ackedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_pulsar_acked_messages_total",
+		Help: "Messages acknowledged after the pipeline finished with them.",
+	}, []string{"topic", "subscription"})
+
+	nackedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_pulsar_nacked_messages_total",
+		Help: "Messages negatively acknowledged for redelivery (and eventually the dead-letter topic), by reason.",
+	}, []string{"topic", "subscription", "reason"})
+
+	malformedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mirador_pulsar_malformed_messages_total",
+		Help: "Messages that failed to decode and were acked (dropped) because no dead-letter policy is configured.",
+	}, []string{"topic", "subscription"})
+)
+
+// messageAcker resolves one Pulsar message once the pipeline is done with it.
+// Ack acknowledges it; Nack schedules redelivery after NackRedeliveryDelay,
+// and once MaxDeliveries is reached the client routes it to the DLQ topic.
+type messageAcker struct {
+	r        *Receiver
+	consumer ps.Consumer
+	msg      ps.Message
+	once     sync.Once
+}
+
+func (a *messageAcker) Ack() {
+	a.once.Do(func() {
+		if err := a.consumer.Ack(a.msg); err != nil {
+			log.Printf("[pulsar/%s] ack msg=%v: %v", a.r.kind, a.msg.ID(), err)
+			return
+		}
+		ackedMessages.WithLabelValues(a.r.topic, a.r.subName).Inc()
+	})
+}
+
+func (a *messageAcker) Nack(err error) {
+	a.once.Do(func() { a.r.nack(a.consumer, a.msg, err) })
+}
+
+// nack schedules redelivery. Malformed messages are only nacked when a
+// dead-letter policy will eventually take them; without one, redelivery would
+// never end, so they are acked and counted instead.
+func (r *Receiver) nack(consumer ps.Consumer, msg ps.Message, err error) {
+	reason := "export"
+	if ack.IsMalformed(err) {
+		reason = "malformed"
+		if r.maxRedeliveries == 0 {
+			log.Printf("[pulsar/%s] dropping malformed msg=%v (no dead-letter policy): %v", r.kind, msg.ID(), err)
+			if aerr := consumer.Ack(msg); aerr != nil {
+				log.Printf("[pulsar/%s] ack msg=%v: %v", r.kind, msg.ID(), aerr)
+			}
+			malformedMessages.WithLabelValues(r.topic, r.subName).Inc()
+			return
+		}
+	}
+	log.Printf("[pulsar/%s] nack msg=%v redelivery=%d reason=%s: %v", r.kind, msg.ID(), msg.RedeliveryCount(), reason, err)
+	nackedMessages.WithLabelValues(r.topic, r.subName, reason).Inc()
+	consumer.Nack(msg)
+}
@@ -0,0 +1,51 @@
+package pulsar
+
+import (
+	"errors"
+	"testing"
+
+	ps "github.com/apache/pulsar-client-go/pulsar"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+)
+
+// fakeConsumer records how messages were resolved; the embedded interface is
+// nil, so anything else panics.
+type fakeConsumer struct {
+	ps.Consumer
+	acks, nacks int
+}
+
+func (c *fakeConsumer) Ack(ps.Message) error { c.acks++; return nil }
+func (c *fakeConsumer) Nack(ps.Message)      { c.nacks++ }
+
+type fakeMessage struct{ ps.Message }
+
+func (fakeMessage) ID() ps.MessageID        { return ps.EarliestMessageID() }
+func (fakeMessage) RedeliveryCount() uint32 { return 0 }
+
+func TestNack(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxRedeliveries int
+		err             error
+		wantAcks        int
+		wantNacks       int
+	}{
+		{"export failure is redelivered", 0, errors.New("export failed"), 0, 1},
+		{"malformed without dlq is dropped", 0, ack.Malformed(errors.New("bad payload")), 1, 0},
+		{"malformed with dlq is dead-lettered", 5, ack.Malformed(errors.New("bad payload")), 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Receiver{kind: "traces", topic: "t", subName: "s", maxRedeliveries: tt.maxRedeliveries}
+			c := &fakeConsumer{}
+			a := &messageAcker{r: r, consumer: c, msg: fakeMessage{}}
+			a.Nack(tt.err)
+			a.Nack(tt.err) // resolved once
+			if c.acks != tt.wantAcks || c.nacks != tt.wantNacks {
+				t.Fatalf("acks=%d nacks=%d, want acks=%d nacks=%d", c.acks, c.nacks, tt.wantAcks, tt.wantNacks)
+			}
+		})
+	}
+}
@@ -1,8 +1,6 @@
 package pulsar
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"log"
@@ -11,6 +9,8 @@ import (
 
 	ps "github.com/apache/pulsar-client-go/pulsar"
 
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/ack"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/codec"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
 	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
 )
@@ -21,6 +21,7 @@ import (
 //   - "traces":    OTLP ExportTracesServiceRequest
 //   - "prom_rw":   Prometheus Remote Write (prompb.WriteRequest)
 //   - "json_logs": JSON payload per message (or NDJSON if extra.ndjson = true)
+//   - "auto":      per-message kind from a message property (see codec.Options)
 //
 // Config mapping (config.ReceiverCfg):
 //   - Endpoint OR Brokers[0]  => Pulsar serviceURL (e.g., pulsar://host:6650, pulsar+ssl://host:6651)
@@ -36,13 +37,27 @@ import (
 //     message_chan_buffer: int              // consumer buffer (default 32)
 //     receiver_queue_size: int              // prefetch queue per consumer (default 1000)
 //     kind: string                          // override of constructor kind
+//     encoding: string                      // raw | otlp_proto | otlp_json | jaeger_proto | zipkin_json (default raw)
+//     kind_header: string                   // property naming the kind when kind=auto (default "signal")
+//     default_kind: string                  // kind=auto fallback when the property is absent (default metrics)
+//     ack_mode: string                      // "export" (default) acks after export, nacks on failure; "receive" acks on read
+//     nack_redelivery_delay_ms: int         // delay before a nacked message is redelivered (default 60000)
+//     max_redeliveries: int                 // > 0 enables the dead-letter policy (shared/key_shared only)
+//     dead_letter_topic: string             // default "<topic>-<subscription>-DLQ"
+//     dead_letter_subscription: string      // initial subscription created on the DLQ topic
+//
+// Messages that fail to decode (here or in a processor) are nacked when
+// max_redeliveries is set, so bad producers end up in the dead-letter topic
+// instead of disappearing. Without a dead-letter policy they would be
+// redelivered forever, so they are acked and counted in
+// mirador_pulsar_malformed_messages_total instead.
 type Receiver struct {
 	serviceURL string
 	topic      string
 	subName    string
 	kind       string
 
-	ndjson bool
+	codec codec.Options
 
 	// Pulsar client/consumer options
 	subType           ps.SubscriptionType
@@ -52,6 +67,13 @@ type Receiver struct {
 	tlsTrustCertsPath string
 	msgChanBuffer     int
 	receiverQueueSize int
+
+	// Delivery
+	ackMode         string
+	nackDelay       time.Duration
+	maxRedeliveries int
+	dlqTopic        string
+	dlqSubscription string
 }
 
 // New builds a Pulsar receiver. 'kind' should be "metrics" | "traces" | "prom_rw" | "json_logs".
@@ -67,11 +89,8 @@ func New(rc config.ReceiverCfg, kind string) *Receiver {
 		kind = v
 	}
 
-	// NDJSON splitting for json_logs
-	ndjson := false
-	if v, ok := rc.Extra["ndjson"].(bool); ok {
-		ndjson = v
-	}
+	// Payload encoding, kind detection and NDJSON splitting for json_logs
+	opts := codec.FromExtra(rc.Extra, kind)
 
 	// Subscription type
 	subType := ps.Shared
@@ -115,12 +134,27 @@ func New(rc config.ReceiverCfg, kind string) *Receiver {
 		recvQ = v
 	}
 
+	ackMode := "export"
+	if s, ok := rc.Extra["ack_mode"].(string); ok && strings.EqualFold(strings.TrimSpace(s), "receive") {
+		ackMode = "receive"
+	}
+	nackDelay := time.Duration(0) // client default (1m)
+	if v, ok := rc.Extra["nack_redelivery_delay_ms"].(int); ok && v > 0 {
+		nackDelay = time.Duration(v) * time.Millisecond
+	}
+	maxRedeliveries := 0
+	if v, ok := rc.Extra["max_redeliveries"].(int); ok && v > 0 {
+		maxRedeliveries = v
+	}
+	dlqTopic, _ := rc.Extra["dead_letter_topic"].(string)
+	dlqSub, _ := rc.Extra["dead_letter_subscription"].(string)
+
 	return &Receiver{
 		serviceURL:        svc,
 		topic:             rc.Topic,
 		subName:           rc.Group, // mirrors Kafka group → Pulsar subscription name
-		kind:              normalizeKind(kind),
-		ndjson:            ndjson,
+		kind:              opts.Kind,
+		codec:             opts,
 		subType:           subType,
 		authToken:         authToken,
 		authTokenFile:     authTokenFile,
@@ -128,6 +162,11 @@ func New(rc config.ReceiverCfg, kind string) *Receiver {
 		tlsTrustCertsPath: tlsTrustPath,
 		msgChanBuffer:     msgBuf,
 		receiverQueueSize: recvQ,
+		ackMode:           ackMode,
+		nackDelay:         nackDelay,
+		maxRedeliveries:   maxRedeliveries,
+		dlqTopic:          dlqTopic,
+		dlqSubscription:   dlqSub,
 	}
 }
 
@@ -162,6 +201,18 @@ func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
 		// Buffer sizes
 		MessageChannel:    make(chan ps.ConsumerMessage, r.msgChanBuffer),
 		ReceiverQueueSize: r.receiverQueueSize,
+		// Redelivery / dead-lettering
+		NackRedeliveryDelay: r.nackDelay,
+	}
+	if r.maxRedeliveries > 0 {
+		if r.subType != ps.Shared && r.subType != ps.KeyShared {
+			log.Printf("[pulsar/%s] max_redeliveries requires a shared or key_shared subscription; dead-lettering may not apply", r.kind)
+		}
+		consOpts.DLQ = &ps.DLQPolicy{
+			MaxDeliveries:           uint32(r.maxRedeliveries),
+			DeadLetterTopic:         r.dlqTopic,
+			InitialSubscriptionName: r.dlqSubscription,
+		}
 	}
 
 	consumer, err := client.Subscribe(consOpts)
@@ -170,7 +221,7 @@ func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
 	}
 	defer consumer.Close()
 
-	log.Printf("[pulsar/%s] consuming topic=%s subscription=%s url=%s", r.kind, r.topic, r.subName, r.serviceURL)
+	log.Printf("[pulsar/%s] consuming topic=%s subscription=%s url=%s encoding=%s ack_mode=%s max_redeliveries=%d", r.kind, r.topic, r.subName, r.serviceURL, r.codec.Encoding, r.ackMode, r.maxRedeliveries)
 
 	// Receive loop using the consumer's MessageChannel to avoid blocking Receive calls.
 	msgCh := consumer.Chan()
@@ -189,79 +240,37 @@ func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
 			ts := time.Now().Unix()
 			attrs := propsToMap(msg.Properties())
 
-			switch r.kind {
-			case "json_logs":
-				if r.ndjson {
-					sc := bufio.NewScanner(bytes.NewReader(msg.Payload()))
-					// allow long lines (up to ~10MB)
-					buf := make([]byte, 0, 64*1024)
-					sc.Buffer(buf, 10*1024*1024)
-					for sc.Scan() {
-						line := strings.TrimSpace(sc.Text())
-						if line == "" {
-							continue
-						}
-						env := model.Envelope{
-							Kind:   model.KindJSONLogs,
-							Bytes:  []byte(line),
-							Attrs:  attrs,
-							TSUnix: ts,
-						}
-						select {
-						case out <- env:
-						default:
-							log.Printf("[pulsar/json_logs] dropping NDJSON line due to backpressure")
-						}
-					}
-					if err := sc.Err(); err != nil {
-						log.Printf("[pulsar/json_logs] scan error: %v", err)
-					}
-				} else {
-					env := model.Envelope{
-						Kind:   model.KindJSONLogs,
-						Bytes:  msg.Payload(),
-						Attrs:  attrs,
-						TSUnix: ts,
-					}
+			payloads, err := r.codec.Decode(msg.Payload(), attrs)
+			if err != nil {
+				r.nack(consumer, msg, ack.Malformed(err))
+				continue
+			}
+
+			var msgAck model.Acker
+			if r.ackMode == "export" {
+				msgAck = &messageAcker{r: r, consumer: consumer, msg: msg}
+			} else {
+				consumer.Ack(msg)
+			}
+			acks := ack.Fanout(msgAck, len(payloads))
+			for i, p := range payloads {
+				env := model.Envelope{Kind: p.Kind, Bytes: p.Bytes, Attrs: attrs, TSUnix: ts, Ack: acks[i]}
+				if msgAck != nil {
+					// Unacked messages are redelivered, so wait for the pipeline
+					// instead of dropping.
 					select {
 					case out <- env:
-					default:
-						log.Printf("[pulsar/json_logs] dropping message due to backpressure")
+					case <-ctx.Done():
+						return nil
 					}
+					continue
 				}
-
-			case "metrics":
 				select {
-				case out <- model.Envelope{Kind: model.KindMetrics, Bytes: msg.Payload(), Attrs: attrs, TSUnix: ts}:
+				case out <- env:
 				default:
-					log.Printf("[pulsar/metrics] dropping message due to backpressure")
-				}
-
-			case "traces":
-				select {
-				case out <- model.Envelope{Kind: model.KindTraces, Bytes: msg.Payload(), Attrs: attrs, TSUnix: ts}:
-				default:
-					log.Printf("[pulsar/traces] dropping message due to backpressure")
-				}
-
-			case "prom_rw":
-				select {
-				case out <- model.Envelope{Kind: model.KindPromRW, Bytes: msg.Payload(), Attrs: attrs, TSUnix: ts}:
-				default:
-					log.Printf("[pulsar/prom_rw] dropping message due to backpressure")
-				}
-
-			default:
-				// Fallback to metrics to match kafka receiver’s behavior
-				select {
-				case out <- model.Envelope{Kind: model.KindMetrics, Bytes: msg.Payload(), Attrs: attrs, TSUnix: ts}:
-				default:
-					log.Printf("[pulsar/%s] dropping message due to backpressure", r.kind)
+					log.Printf("[pulsar/%s] dropping message due to backpressure", p.Kind)
 				}
 			}
-
-			// Ack regardless of downstream backpressure to avoid redelivery loops.
-			consumer.Ack(msg)
 		}
 	}
 }
@@ -276,17 +285,3 @@ func propsToMap(p map[string]string) map[string]string {
 	}
 	return out
 }
-
-func normalizeKind(k string) string {
-	k = strings.ToLower(strings.TrimSpace(k))
-	switch k {
-	case "metrics", "traces", "prom_rw", "json_logs":
-		return k
-	case "promremotewrite", "prometheusremotewrite", "prom-remote-write":
-		return "prom_rw"
-	case "json", "jsonlogs", "logs":
-		return "json_logs"
-	default:
-		return "metrics"
-	}
-}
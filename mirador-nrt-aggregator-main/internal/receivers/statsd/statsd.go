@@ -0,0 +1,503 @@
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	collmet "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	com "go.opentelemetry.io/proto/otlp/common/v1"
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+	res "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+var linesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mirador_statsd_lines_total",
+	Help: "StatsD lines received, by metric type (c, g, ms, h, d, s) or \"invalid\".",
+}, []string{"type"})
+
+// Receiver accepts StatsD / DogStatsD lines over UDP and/or a Unix datagram
+// socket, aggregates them per flush interval and emits one OTLP
+// ExportMetricsServiceRequest (delta temporality) so the summarizer can build
+// RED aggregates:
+//
+//	name:value|c[|@rate][|#tags]     → Sum "<name>_total" (value / rate)
+//	name:value|ms                    → Histogram "<name>_duration_seconds" (ms → s)
+//	name:value|h, name:value|d       → Histogram "<name>"
+//	name:value|g, name:+/-value|g    → Gauge "<name>" (last value, kept across flushes
+//	                                   until idle for gauge_ttl_ms)
+//	name:value|s                     → Gauge "<name>" (distinct values per interval)
+//
+// Names are sanitized to [a-zA-Z0-9_]; counters are suffixed with _total, so
+// "checkout.requests" and "checkout.errors" land on the summarizer's
+// requests_total / errors_total. DogStatsD multi-value packets (name:1:2:3|d)
+// are supported; events (_e) and service checks (_sc) are ignored.
+//
+// Config (receivers.statsd):
+//
+//	endpoint: "0.0.0.0:8125"         # UDP; empty disables UDP when socket_path is set
+//	socket_path: /var/run/statsd.sock # optional Unix datagram socket
+//	flush_interval_ms: 10000
+//	service_tags: [service, service.name, app]   # first tag present → resource service.name
+//	service_from_prefix: false       # "myapp.requests" → service myapp, metric "requests"
+//	default_service: string          # when nothing matches (default "unknown")
+//	metric_name_map: {statsd.name: otlp_name}   # explicit renames (applied before suffixing)
+//	histogram_buckets: [0.005, ...]  # explicit bounds (default Prometheus defaults, seconds)
+//	gauge_ttl_ms: 3600000            # forget gauges not updated for this long; 0 = never
+//	max_packet_bytes: 65536
+type Receiver struct {
+	endpoint   string
+	socketPath string
+	interval   time.Duration
+
+	serviceTags       []string
+	serviceFromPrefix bool
+	defaultService    string
+	nameMap           map[string]string
+	buckets           []float64
+	maxPacket         int
+	gaugeTTL          time.Duration
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	service string
+	name    string
+	kind    byte // 'c' sum, 'h' histogram, 'g' gauge, 's' set
+	attrs   []*com.KeyValue
+
+	sum     float64
+	counts  []uint64
+	count   uint64
+	total   float64
+	gauge   float64
+	set     map[string]struct{}
+	updated bool
+	seen    time.Time // flush that last saw an update (gauges)
+}
+
+// New builds a StatsD receiver.
+func New(rc config.ReceiverCfg) *Receiver {
+	interval := 10 * time.Second
+	if v, ok := rc.Extra["flush_interval_ms"].(int); ok && v > 0 {
+		interval = time.Duration(v) * time.Millisecond
+	}
+	svcTags := []string{"service", "service.name", "app"}
+	if arr, ok := rc.Extra["service_tags"].([]any); ok {
+		svcTags = svcTags[:0]
+		for _, it := range arr {
+			if s, ok := it.(string); ok && s != "" {
+				svcTags = append(svcTags, s)
+			}
+		}
+	}
+	fromPrefix, _ := rc.Extra["service_from_prefix"].(bool)
+	defSvc := "unknown"
+	if s, ok := rc.Extra["default_service"].(string); ok && s != "" {
+		defSvc = s
+	}
+	nameMap := map[string]string{}
+	if m, ok := rc.Extra["metric_name_map"].(map[string]any); ok {
+		for k, v := range m {
+			if s, ok := v.(string); ok && s != "" {
+				nameMap[k] = s
+			}
+		}
+	}
+	buckets := []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	if arr, ok := rc.Extra["histogram_buckets"].([]any); ok && len(arr) > 0 {
+		buckets = buckets[:0]
+		for _, it := range arr {
+			switch t := it.(type) {
+			case float64:
+				buckets = append(buckets, t)
+			case int:
+				buckets = append(buckets, float64(t))
+			}
+		}
+		sort.Float64s(buckets)
+	}
+	maxPacket := 64 * 1024
+	if v, ok := rc.Extra["max_packet_bytes"].(int); ok && v > 0 {
+		maxPacket = v
+	}
+	gaugeTTL := time.Hour
+	if v, ok := rc.Extra["gauge_ttl_ms"].(int); ok && v >= 0 {
+		gaugeTTL = time.Duration(v) * time.Millisecond
+	}
+	socketPath, _ := rc.Extra["socket_path"].(string)
+	return &Receiver{
+		endpoint:          rc.Endpoint,
+		socketPath:        socketPath,
+		interval:          interval,
+		serviceTags:       svcTags,
+		serviceFromPrefix: fromPrefix,
+		defaultService:    defSvc,
+		nameMap:           nameMap,
+		buckets:           buckets,
+		maxPacket:         maxPacket,
+		gaugeTTL:          gaugeTTL,
+		series:            map[string]*series{},
+	}
+}
+
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	var conns []net.PacketConn
+
+	addr := strings.TrimSpace(r.endpoint)
+	if addr == "" && r.socketPath == "" {
+		addr = ":8125"
+	}
+	if addr != "" {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, pc)
+		log.Printf("[statsd] listening on udp://%s flush=%s", addr, r.interval)
+	}
+	if r.socketPath != "" {
+		_ = os.Remove(r.socketPath) // stale socket from a previous run
+		pc, err := net.ListenPacket("unixgram", r.socketPath)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return err
+		}
+		conns = append(conns, pc)
+		log.Printf("[statsd] listening on unixgram://%s flush=%s", r.socketPath, r.interval)
+	}
+
+	var wg sync.WaitGroup
+	for _, pc := range conns {
+		wg.Add(1)
+		go func(pc net.PacketConn) {
+			defer wg.Done()
+			r.readLoop(ctx, pc)
+		}(pc)
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, pc := range conns {
+				_ = pc.Close()
+			}
+			wg.Wait()
+			if r.socketPath != "" {
+				_ = os.Remove(r.socketPath)
+			}
+			return nil
+		case now := <-ticker.C:
+			r.flush(ctx, out, now)
+		}
+	}
+}
+
+func (r *Receiver) readLoop(ctx context.Context, pc net.PacketConn) {
+	buf := make([]byte, r.maxPacket)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[statsd] read error: %v", err)
+			continue
+		}
+		for _, line := range bytes.Split(buf[:n], []byte{'\n'}) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			if err := r.ingest(string(line)); err != nil {
+				linesTotal.WithLabelValues("invalid").Inc()
+			}
+		}
+	}
+}
+
+// ---------------- parsing ----------------
+
+// ingest parses one line: name:value[:value...]|type[|@rate][|#tags][|c:...][|T...].
+func (r *Receiver) ingest(line string) error {
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return nil // DogStatsD events / service checks
+	}
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return errors.New("missing ':'")
+	}
+	name := line[:colon]
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 {
+		return errors.New("missing type")
+	}
+	values := strings.Split(parts[0], ":")
+	typ := parts[1]
+
+	rate := 1.0
+	var tags []string
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			f, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || f <= 0 || f > 1 {
+				return fmt.Errorf("invalid sample rate %q", p)
+			}
+			rate = f
+		case strings.HasPrefix(p, "#"):
+			tags = strings.Split(p[1:], ",")
+		}
+	}
+
+	service, attrs := r.resolveService(&name, tags)
+	switch typ {
+	case "c":
+		for _, v := range values {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			s := r.get('c', service, r.metricName(name, "_total"), attrs)
+			s.sum += f / rate
+			s.updated = true
+			r.mu.Unlock()
+		}
+	case "ms", "h", "d":
+		suffix, scale := "", 1.0
+		if typ == "ms" {
+			suffix, scale = "_duration_seconds", 1.0/1000
+		}
+		weight := uint64(math.Max(1, math.Round(1/rate)))
+		for _, v := range values {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			f *= scale
+			s := r.get('h', service, r.metricName(name, suffix), attrs)
+			if s.counts == nil {
+				s.counts = make([]uint64, len(r.buckets)+1)
+			}
+			s.counts[sort.SearchFloat64s(r.buckets, f)] += weight
+			s.count += weight
+			s.total += f * float64(weight)
+			s.updated = true
+			r.mu.Unlock()
+		}
+	case "g":
+		for _, v := range values {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			s := r.get('g', service, r.metricName(name, ""), attrs)
+			if strings.HasPrefix(v, "+") || strings.HasPrefix(v, "-") {
+				s.gauge += f
+			} else {
+				s.gauge = f
+			}
+			s.updated = true
+			r.mu.Unlock()
+		}
+	case "s":
+		for _, v := range values {
+			s := r.get('s', service, r.metricName(name, ""), attrs)
+			if s.set == nil {
+				s.set = map[string]struct{}{}
+			}
+			s.set[v] = struct{}{}
+			s.updated = true
+			r.mu.Unlock()
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", typ)
+	}
+	linesTotal.WithLabelValues(typ).Inc()
+	return nil
+}
+
+// resolveService picks the service from tags (removing that tag) or the name
+// prefix, and returns the remaining tags as attributes.
+func (r *Receiver) resolveService(name *string, tags []string) (string, []*com.KeyValue) {
+	kv := make(map[string]string, len(tags))
+	for _, t := range tags {
+		if t == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(t, ":")
+		kv[k] = v
+	}
+	service := ""
+	for _, k := range r.serviceTags {
+		if v := kv[k]; v != "" {
+			service = v
+			delete(kv, k)
+			break
+		}
+	}
+	if service == "" && r.serviceFromPrefix {
+		if dot := strings.IndexByte(*name, '.'); dot > 0 {
+			service, *name = (*name)[:dot], (*name)[dot+1:]
+		}
+	}
+	if service == "" {
+		service = r.defaultService
+	}
+
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]*com.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, &com.KeyValue{Key: k, Value: &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: kv[k]}}})
+	}
+	return service, attrs
+}
+
+func (r *Receiver) metricName(name, suffix string) string {
+	if mapped, ok := r.nameMap[name]; ok {
+		name = mapped
+	}
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	out := string(b)
+	if suffix != "" && !strings.HasSuffix(out, suffix) {
+		out += suffix
+	}
+	return out
+}
+
+// get returns the series for (kind, service, name, attrs) with r.mu held;
+// the caller unlocks.
+func (r *Receiver) get(kind byte, service, name string, attrs []*com.KeyValue) *series {
+	var sb strings.Builder
+	sb.WriteByte(kind)
+	sb.WriteString("|" + service + "|" + name)
+	for _, a := range attrs {
+		sb.WriteString("|" + a.Key + "=" + a.GetValue().GetStringValue())
+	}
+	key := sb.String()
+
+	r.mu.Lock()
+	s, ok := r.series[key]
+	if !ok {
+		s = &series{service: service, name: name, kind: kind, attrs: attrs}
+		r.series[key] = s
+	}
+	return s
+}
+
+// ---------------- flush ----------------
+
+func (r *Receiver) flush(ctx context.Context, out chan<- model.Envelope, now time.Time) {
+	end := uint64(now.UnixNano())
+	start := uint64(now.Add(-r.interval).UnixNano())
+
+	r.mu.Lock()
+	byService := map[string][]*met.Metric{}
+	for key, s := range r.series {
+		if !s.updated {
+			// Gauges stay for ± deltas; idle ones (e.g. of a departed pod) go.
+			if s.kind != 'g' || (r.gaugeTTL > 0 && now.Sub(s.seen) >= r.gaugeTTL) {
+				delete(r.series, key)
+			}
+			continue
+		}
+		var m *met.Metric
+		switch s.kind {
+		case 'c':
+			m = &met.Metric{Name: s.name, Data: &met.Metric_Sum{Sum: &met.Sum{
+				AggregationTemporality: met.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				IsMonotonic:            true,
+				DataPoints: []*met.NumberDataPoint{{
+					Attributes: s.attrs, StartTimeUnixNano: start, TimeUnixNano: end,
+					Value: &met.NumberDataPoint_AsDouble{AsDouble: s.sum},
+				}},
+			}}}
+		case 'h':
+			sum := s.total
+			m = &met.Metric{Name: s.name, Data: &met.Metric_Histogram{Histogram: &met.Histogram{
+				AggregationTemporality: met.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				DataPoints: []*met.HistogramDataPoint{{
+					Attributes: s.attrs, StartTimeUnixNano: start, TimeUnixNano: end,
+					Count: s.count, Sum: &sum, BucketCounts: s.counts, ExplicitBounds: r.buckets,
+				}},
+			}}}
+		case 'g', 's':
+			v := s.gauge
+			if s.kind == 's' {
+				v = float64(len(s.set))
+			}
+			m = &met.Metric{Name: s.name, Data: &met.Metric_Gauge{Gauge: &met.Gauge{
+				DataPoints: []*met.NumberDataPoint{{
+					Attributes: s.attrs, TimeUnixNano: end,
+					Value: &met.NumberDataPoint_AsDouble{AsDouble: v},
+				}},
+			}}}
+		}
+		byService[s.service] = append(byService[s.service], m)
+
+		// Reset interval state; gauges keep their last value (StatsD semantics).
+		if s.kind == 'g' {
+			s.updated = false
+			s.seen = now
+		} else {
+			delete(r.series, key)
+		}
+	}
+	r.mu.Unlock()
+
+	if len(byService) == 0 {
+		return
+	}
+	req := &collmet.ExportMetricsServiceRequest{}
+	for svc, ms := range byService {
+		req.ResourceMetrics = append(req.ResourceMetrics, &met.ResourceMetrics{
+			Resource: &res.Resource{Attributes: []*com.KeyValue{
+				{Key: "service.name", Value: &com.AnyValue{Value: &com.AnyValue_StringValue{StringValue: svc}}},
+			}},
+			ScopeMetrics: []*met.ScopeMetrics{{
+				Scope:   &com.InstrumentationScope{Name: "mirador.statsd"},
+				Metrics: ms,
+			}},
+		})
+	}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		log.Printf("[statsd] marshal error: %v", err)
+		return
+	}
+	select {
+	case out <- model.Envelope{Kind: model.KindMetrics, Bytes: b, Attrs: map[string]string{"source": "statsd"}, TSUnix: now.Unix()}:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,197 @@
+package statsd
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	collmet "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	met "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+// flushed flushes r once and returns the emitted metrics keyed by
+// "service/name"; nil when nothing was emitted.
+func flushed(t *testing.T, r *Receiver, now time.Time) map[string]*met.Metric {
+	t.Helper()
+	out := make(chan model.Envelope, 1)
+	r.flush(context.Background(), out, now)
+	select {
+	case env := <-out:
+		req := &collmet.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(env.Bytes, req); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		got := map[string]*met.Metric{}
+		for _, rm := range req.ResourceMetrics {
+			svc := rm.GetResource().GetAttributes()[0].GetValue().GetStringValue()
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					got[svc+"/"+m.Name] = m
+				}
+			}
+		}
+		return got
+	default:
+		return nil
+	}
+}
+
+func TestIngest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		extra map[string]any
+		lines []string
+		check func(t *testing.T, got map[string]*met.Metric)
+	}{
+		{
+			name:  "counter with sample rate",
+			lines: []string{"api.hits:1|c|@0.1", "api.hits:2|c"},
+			check: func(t *testing.T, got map[string]*met.Metric) {
+				m := got["unknown/api_hits_total"]
+				if v := m.GetSum().GetDataPoints()[0].GetAsDouble(); v != 12 {
+					t.Errorf("sum = %v, want 12", v)
+				}
+				if !m.GetSum().GetIsMonotonic() {
+					t.Error("counter not monotonic")
+				}
+			},
+		},
+		{
+			name:  "gauge set then deltas",
+			lines: []string{"queue:10|g", "queue:+5|g", "queue:-3|g"},
+			check: func(t *testing.T, got map[string]*met.Metric) {
+				if v := got["unknown/queue"].GetGauge().GetDataPoints()[0].GetAsDouble(); v != 12 {
+					t.Errorf("gauge = %v, want 12", v)
+				}
+			},
+		},
+		{
+			name:  "dogstatsd tags pick the service",
+			lines: []string{"req:1|c|#service:checkout,env:prod"},
+			check: func(t *testing.T, got map[string]*met.Metric) {
+				m := got["checkout/req_total"]
+				if m == nil {
+					t.Fatalf("missing checkout/req_total in %v", got)
+				}
+				attrs := m.GetSum().GetDataPoints()[0].GetAttributes()
+				if len(attrs) != 1 || attrs[0].Key != "env" || attrs[0].GetValue().GetStringValue() != "prod" {
+					t.Errorf("attrs = %v, want env=prod only", attrs)
+				}
+			},
+		},
+		{
+			name:  "service from prefix",
+			extra: map[string]any{"service_from_prefix": true},
+			lines: []string{"cart.items:3|g"},
+			check: func(t *testing.T, got map[string]*met.Metric) {
+				if got["cart/items"] == nil {
+					t.Errorf("missing cart/items in %v", got)
+				}
+			},
+		},
+		{
+			name:  "set counts distinct values",
+			lines: []string{"users:alice|s", "users:bob|s", "users:alice|s"},
+			check: func(t *testing.T, got map[string]*met.Metric) {
+				if v := got["unknown/users"].GetGauge().GetDataPoints()[0].GetAsDouble(); v != 2 {
+					t.Errorf("set size = %v, want 2", v)
+				}
+			},
+		},
+		{
+			name:  "timer in seconds with multiple values",
+			lines: []string{"db.query:20:300|ms", "db.query:2000|ms|@0.5"},
+			check: func(t *testing.T, got map[string]*met.Metric) {
+				dp := got["unknown/db_query_duration_seconds"].GetHistogram().GetDataPoints()[0]
+				if dp.Count != 4 {
+					t.Errorf("count = %d, want 4", dp.Count)
+				}
+				if math.Abs(dp.GetSum()-4.32) > 1e-9 {
+					t.Errorf("sum = %v, want 4.32", dp.GetSum())
+				}
+			},
+		},
+		{
+			name:  "histogram keeps its name",
+			lines: []string{"payload:512|h"},
+			check: func(t *testing.T, got map[string]*met.Metric) {
+				if got["unknown/payload"].GetHistogram() == nil {
+					t.Errorf("missing histogram payload in %v", got)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(config.ReceiverCfg{Name: "statsd", Extra: tt.extra})
+			for _, l := range tt.lines {
+				if err := r.ingest(l); err != nil {
+					t.Fatalf("ingest(%q): %v", l, err)
+				}
+			}
+			tt.check(t, flushed(t, r, now))
+		})
+	}
+}
+
+func TestIngestInvalid(t *testing.T) {
+	r := New(config.ReceiverCfg{Name: "statsd"})
+	for _, l := range []string{"nocolon", "name:1", "name:x|c", "name:1|c|@0", "name:1|c|@2", "name:1|zz"} {
+		if err := r.ingest(l); err == nil {
+			t.Errorf("ingest(%q) succeeded, want error", l)
+		}
+	}
+	for _, l := range []string{"_e{5,4}:title|text", "_sc|check|0"} {
+		if err := r.ingest(l); err != nil {
+			t.Errorf("ingest(%q) = %v, want ignored", l, err)
+		}
+	}
+}
+
+func TestFlushGauges(t *testing.T) {
+	r := New(config.ReceiverCfg{Name: "statsd", Extra: map[string]any{"gauge_ttl_ms": 60000}})
+	now := time.Unix(1700000000, 0)
+	_ = r.ingest("queue:10|g")
+	_ = r.ingest("hits:1|c")
+	if got := flushed(t, r, now); len(got) != 2 {
+		t.Fatalf("first flush = %v, want queue and hits", got)
+	}
+
+	// Idle interval: nothing emitted, but the gauge keeps its value for deltas.
+	if got := flushed(t, r, now.Add(10*time.Second)); got != nil {
+		t.Fatalf("idle flush emitted %v", got)
+	}
+	_ = r.ingest("queue:+1|g")
+	got := flushed(t, r, now.Add(20*time.Second))
+	if v := got["unknown/queue"].GetGauge().GetDataPoints()[0].GetAsDouble(); v != 11 {
+		t.Errorf("gauge after delta = %v, want 11", v)
+	}
+
+	// Idle past the TTL: the gauge is forgotten and a delta starts from zero.
+	flushed(t, r, now.Add(90*time.Second))
+	if n := len(r.series); n != 0 {
+		t.Fatalf("series after TTL = %d, want 0", n)
+	}
+	_ = r.ingest("queue:+1|g")
+	got = flushed(t, r, now.Add(100*time.Second))
+	if v := got["unknown/queue"].GetGauge().GetDataPoints()[0].GetAsDouble(); v != 1 {
+		t.Errorf("gauge after eviction = %v, want 1", v)
+	}
+}
+
+func TestFlushGaugeTTLDisabled(t *testing.T) {
+	r := New(config.ReceiverCfg{Name: "statsd", Extra: map[string]any{"gauge_ttl_ms": 0}})
+	now := time.Unix(1700000000, 0)
+	_ = r.ingest("queue:10|g")
+	flushed(t, r, now)
+	flushed(t, r, now.Add(24*time.Hour))
+	if n := len(r.series); n != 1 {
+		t.Fatalf("series = %d, want the gauge kept", n)
+	}
+}
@@ -0,0 +1,544 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/common"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/model"
+)
+
+var messagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mirador_syslog_messages_total",
+	Help: "Syslog messages received, by transport and detected format (rfc5424, rfc3164, unparsed).",
+}, []string{"transport", "format"})
+
+// Receiver accepts syslog messages and forwards each one as a KindJSONLogs
+// envelope holding a flat JSON record, so logsum and the logs filter consume
+// them like any other JSON log:
+//
+//	{"ts": RFC3339Nano, "service": app-name|hostname, "level": "error", "message": "...",
+//	 "hostname", "app_name", "proc_id", "msg_id", "facility", "severity",
+//	 "facility_code", "severity_code", "structured_data": {"id": {"k": "v"}}, "syslog.format"}
+//
+// Config (receivers.syslog):
+//
+//	endpoint: "0.0.0.0:5514"
+//	protocol: udp | tcp | tls          # default udp
+//	format: auto | rfc5424 | rfc3164   # default auto (per message)
+//	framing: auto | octet_counting | non_transparent   # tcp/tls; default auto (per message)
+//	max_message_bytes: int             # default 65536
+//	timezone: string                   # RFC 3164 timestamps carry no zone (default "Local")
+//	service_field: app_name | hostname # which header field becomes "service" (default app_name, falls back)
+//	tls.cert_file / tls.key_file / tls.client_ca_file / tls.require_client_cert
+type Receiver struct {
+	endpoint     string
+	protocol     string
+	format       string
+	framing      string
+	maxMsgBytes  int
+	loc          *time.Location
+	serviceField string
+
+	tlsCertFile       string
+	tlsKeyFile        string
+	tlsClientCAFile   string
+	requireClientCert bool
+}
+
+// New builds a syslog receiver.
+func New(rc config.ReceiverCfg) *Receiver {
+	protocol := "udp"
+	if s, ok := rc.Extra["protocol"].(string); ok && s != "" {
+		protocol = strings.ToLower(strings.TrimSpace(s))
+	}
+	format := "auto"
+	if s, ok := rc.Extra["format"].(string); ok && s != "" {
+		format = strings.ToLower(strings.TrimSpace(s))
+	}
+	framing := "auto"
+	if s, ok := rc.Extra["framing"].(string); ok && s != "" {
+		framing = strings.ToLower(strings.TrimSpace(s))
+	}
+	maxMsg := 64 * 1024
+	if v, ok := rc.Extra["max_message_bytes"].(int); ok && v > 0 {
+		maxMsg = v
+	}
+	loc := time.Local
+	if s, ok := rc.Extra["timezone"].(string); ok && s != "" {
+		if l, err := time.LoadLocation(s); err == nil {
+			loc = l
+		} else {
+			log.Printf("[syslog] unknown timezone %q, using Local: %v", s, err)
+		}
+	}
+	svcField := "app_name"
+	if s, ok := rc.Extra["service_field"].(string); ok && s != "" {
+		svcField = s
+	}
+	requireClientCert := false
+	if b, ok := common.NestedBool(rc.Extra, "tls", "require_client_cert"); ok {
+		requireClientCert = b
+	}
+	return &Receiver{
+		endpoint:          rc.Endpoint,
+		protocol:          protocol,
+		format:            format,
+		framing:           framing,
+		maxMsgBytes:       maxMsg,
+		loc:               loc,
+		serviceField:      svcField,
+		tlsCertFile:       common.NestedString(rc.Extra, "tls", "cert_file"),
+		tlsKeyFile:        common.NestedString(rc.Extra, "tls", "key_file"),
+		tlsClientCAFile:   common.NestedString(rc.Extra, "tls", "client_ca_file"),
+		requireClientCert: requireClientCert,
+	}
+}
+
+func (r *Receiver) Start(ctx context.Context, out chan<- model.Envelope) error {
+	addr := r.endpoint
+	if strings.TrimSpace(addr) == "" {
+		addr = ":5514"
+	}
+	switch r.protocol {
+	case "udp":
+		return r.serveUDP(ctx, addr, out)
+	case "tcp", "tls":
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		if r.protocol == "tls" {
+			tlsCfg, err := common.ServerTLS(r.tlsCertFile, r.tlsKeyFile, r.tlsClientCAFile, r.requireClientCert)
+			if err != nil {
+				_ = ln.Close()
+				return fmt.Errorf("syslog tls: %w", err)
+			}
+			ln = tls.NewListener(ln, tlsCfg)
+		}
+		return r.serveStream(ctx, ln, out)
+	default:
+		return fmt.Errorf("syslog receiver: unsupported protocol %q (want udp|tcp|tls)", r.protocol)
+	}
+}
+
+// ---------------- transports ----------------
+
+func (r *Receiver) serveUDP(ctx context.Context, addr string, out chan<- model.Envelope) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	go func() { <-ctx.Done(); _ = pc.Close() }()
+	log.Printf("[syslog] listening on udp://%s format=%s", addr, r.format)
+
+	buf := make([]byte, r.maxMsgBytes)
+	for {
+		n, peer, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("[syslog] udp read error: %v", err)
+			continue
+		}
+		// A datagram is exactly one message (some senders append a newline).
+		msg := bytes.TrimRight(buf[:n], "\r\n\x00")
+		if len(msg) == 0 {
+			continue
+		}
+		if !r.emit(ctx, out, msg, peer.String()) {
+			return nil
+		}
+	}
+}
+
+func (r *Receiver) serveStream(ctx context.Context, ln net.Listener, out chan<- model.Envelope) error {
+	go func() { <-ctx.Done(); _ = ln.Close() }()
+	log.Printf("[syslog] listening on %s://%s format=%s framing=%s", r.protocol, ln.Addr(), r.format, r.framing)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("[syslog] accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go r.handleConn(ctx, conn, out)
+	}
+}
+
+func (r *Receiver) handleConn(ctx context.Context, conn net.Conn, out chan<- model.Envelope) {
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() { <-cctx.Done(); _ = conn.Close() }()
+
+	peer := conn.RemoteAddr().String()
+	br := bufio.NewReaderSize(conn, 64*1024)
+	for {
+		msg, err := r.readFrame(br)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && cctx.Err() == nil {
+				log.Printf("[syslog] %s peer=%s: %v", r.protocol, peer, err)
+			}
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		if !r.emit(cctx, out, msg, peer) {
+			return
+		}
+	}
+}
+
+// readFrame reads one message using octet counting (RFC 6587 3.4.1:
+// "LEN SP MSG") or non-transparent LF framing (3.4.2). In auto mode a leading
+// digit selects octet counting, since a syslog message itself starts with '<'.
+func (r *Receiver) readFrame(br *bufio.Reader) ([]byte, error) {
+	octet := r.framing == "octet_counting"
+	if r.framing == "auto" {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		octet = b[0] >= '0' && b[0] <= '9'
+	}
+
+	if octet {
+		n, err := r.readOctetCount(br)
+		if err != nil {
+			return nil, err
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(msg, "\r\n"), nil
+	}
+
+	var line []byte
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err != nil {
+			if len(line) > 0 && errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if len(line)+len(chunk) <= r.maxMsgBytes {
+			line = append(line, chunk...)
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	return bytes.TrimRight(line, "\r\x00"), nil
+}
+
+// maxOctetDigits bounds the MSG-LEN prefix; 10 digits exceed any sane
+// max_message_bytes.
+const maxOctetDigits = 10
+
+// readOctetCount reads the "LEN SP" prefix of an octet-counted frame: digits
+// only, at most maxOctetDigits of them, and no more than max_message_bytes,
+// all checked before anything is allocated.
+func (r *Receiver) readOctetCount(br *bufio.Reader) (int, error) {
+	n := 0
+	for digits := 0; ; digits++ {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == ' ' && digits > 0 {
+			break
+		}
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid octet count: unexpected byte %q", c)
+		}
+		if digits == maxOctetDigits {
+			return 0, fmt.Errorf("invalid octet count: more than %d digits", maxOctetDigits)
+		}
+		n = n*10 + int(c-'0')
+	}
+	if n > r.maxMsgBytes {
+		return 0, fmt.Errorf("message of %d bytes exceeds max_message_bytes=%d", n, r.maxMsgBytes)
+	}
+	return n, nil
+}
+
+func (r *Receiver) emit(ctx context.Context, out chan<- model.Envelope, raw []byte, peer string) bool {
+	rec, format := r.parse(raw)
+	messagesTotal.WithLabelValues(r.protocol, format).Inc()
+	rec["peer"] = peer
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("[syslog] json marshal error: %v", err)
+		return true
+	}
+	select {
+	case out <- model.Envelope{
+		Kind:   model.KindJSONLogs,
+		Bytes:  b,
+		Attrs:  map[string]string{"syslog.peer": peer, "syslog.transport": r.protocol},
+		TSUnix: time.Now().Unix(),
+	}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ---------------- parsing ----------------
+
+var facilities = [...]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severities = [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// levels maps syslog severities onto the level names used by logsum/filter
+// (error_levels defaults to error+fatal).
+var levels = [...]string{"fatal", "fatal", "fatal", "error", "warn", "info", "info", "debug"}
+
+// parse turns one message into a JSON record. Messages that match neither RFC
+// are still forwarded, with the raw text as message.
+func (r *Receiver) parse(raw []byte) (map[string]any, string) {
+	s := strings.TrimPrefix(string(raw), "\ufeff")
+	pri, rest, hasPri := parsePRI(s)
+	if !hasPri {
+		pri = 13 // RFC 3164 4.3.3: user.notice
+	}
+
+	var (
+		rec    map[string]any
+		format string
+	)
+	is5424 := hasPri && len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' '
+	switch {
+	case r.format == "rfc5424" || (r.format == "auto" && is5424):
+		var err error
+		if rec, err = parse5424(rest); err == nil {
+			format = "rfc5424"
+			break
+		}
+		fallthrough
+	case hasPri:
+		rec = r.parse3164(rest)
+		format = "rfc3164"
+	default:
+		rec = map[string]any{"message": s}
+		format = "unparsed"
+	}
+
+	fac, sev := pri/8, pri%8
+	rec["facility_code"] = fac
+	rec["severity_code"] = sev
+	if fac < len(facilities) {
+		rec["facility"] = facilities[fac]
+	}
+	rec["severity"] = severities[sev]
+	rec["level"] = levels[sev]
+	rec["syslog.format"] = format
+
+	if _, ok := rec["ts"]; !ok {
+		rec["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	svc, _ := rec[r.serviceField].(string)
+	if svc == "" {
+		svc, _ = rec["app_name"].(string)
+	}
+	if svc == "" {
+		svc, _ = rec["hostname"].(string)
+	}
+	if svc != "" {
+		rec["service"] = svc
+	}
+	return rec, format
+}
+
+// parsePRI consumes "<PRI>" (1-3 digits, 0..191).
+func parsePRI(s string) (int, string, bool) {
+	if len(s) < 3 || s[0] != '<' {
+		return 0, s, false
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return 0, s, false
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, s, false
+	}
+	return pri, s[end+1:], true
+}
+
+// parse5424 parses "VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP
+// MSGID SP STRUCTURED-DATA [SP MSG]" (the part after PRI).
+func parse5424(s string) (map[string]any, error) {
+	rec := map[string]any{}
+	var fields [6]string
+	for i := range fields {
+		sp := strings.IndexByte(s, ' ')
+		if sp < 0 {
+			return nil, errors.New("rfc5424: truncated header")
+		}
+		fields[i], s = s[:sp], s[sp+1:]
+	}
+	if fields[0] != "1" {
+		return nil, fmt.Errorf("rfc5424: unsupported version %q", fields[0])
+	}
+	if fields[1] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("rfc5424: timestamp: %w", err)
+		}
+		rec["ts"] = ts.UTC().Format(time.RFC3339Nano)
+	}
+	for i, k := range []string{"", "", "hostname", "app_name", "proc_id", "msg_id"} {
+		if k != "" && fields[i] != "-" {
+			rec[k] = fields[i]
+		}
+	}
+
+	sd, rest, err := parseSD(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd) > 0 {
+		rec["structured_data"] = sd
+	}
+	rest = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	rec["message"] = strings.TrimRight(rest, "\r\n")
+	return rec, nil
+}
+
+// parseSD parses STRUCTURED-DATA: "-" or one or more [SD-ID PARAM="VALUE"...].
+func parseSD(s string) (map[string]map[string]string, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, s[1:], nil
+	}
+	out := map[string]map[string]string{}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end < 0 {
+			return nil, "", errors.New("rfc5424: unterminated SD-ELEMENT")
+		}
+		id := s[:end]
+		s = s[end:]
+		params := map[string]string{}
+		for {
+			s = strings.TrimLeft(s, " ")
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+			eq := strings.Index(s, "=\"")
+			if eq <= 0 {
+				return nil, "", errors.New("rfc5424: malformed SD-PARAM")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var val strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+					val.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				val.WriteByte(c)
+			}
+			if !closed {
+				return nil, "", errors.New("rfc5424: unterminated PARAM-VALUE")
+			}
+			params[name] = val.String()
+		}
+		out[id] = params
+	}
+	if len(out) == 0 {
+		return nil, "", errors.New("rfc5424: missing STRUCTURED-DATA")
+	}
+	return out, s, nil
+}
+
+// parse3164 parses the BSD format leniently: "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG".
+// The timestamp may also be RFC 3339 (rsyslog), and the hostname may be missing.
+func (r *Receiver) parse3164(s string) map[string]any {
+	rec := map[string]any{}
+	if len(s) >= 15 {
+		if ts, err := time.ParseInLocation(time.Stamp, s[:15], r.loc); err == nil {
+			now := time.Now().In(r.loc)
+			ts = ts.AddDate(now.Year(), 0, 0)
+			// No year on the wire: a December message read in January belongs to last year.
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			rec["ts"] = ts.UTC().Format(time.RFC3339Nano)
+			s = strings.TrimLeft(s[15:], " ")
+		}
+	}
+	if _, ok := rec["ts"]; !ok {
+		if sp := strings.IndexByte(s, ' '); sp > 0 {
+			if ts, err := time.Parse(time.RFC3339Nano, s[:sp]); err == nil {
+				rec["ts"] = ts.UTC().Format(time.RFC3339Nano)
+				s = s[sp+1:]
+			}
+		}
+	}
+
+	// HOSTNAME is present unless the first token already looks like a TAG.
+	if sp := strings.IndexByte(s, ' '); sp > 0 {
+		tok := s[:sp]
+		if !strings.HasSuffix(tok, ":") && !strings.Contains(tok, "[") {
+			rec["hostname"] = tok
+			s = s[sp+1:]
+		}
+	}
+
+	// TAG: up to 32 alphanumerics, optionally "[pid]", terminated by ':'.
+	if end := strings.IndexAny(s, ":[ "); end > 0 && end <= 48 {
+		tag := s[:end]
+		rest := s[end:]
+		if strings.HasPrefix(rest, "[") {
+			if cl := strings.IndexByte(rest, ']'); cl > 0 {
+				rec["proc_id"] = rest[1:cl]
+				rest = rest[cl+1:]
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			rec["app_name"] = tag
+			s = strings.TrimPrefix(rest[1:], " ")
+		}
+	}
+	rec["message"] = strings.TrimRight(s, "\r\n")
+	return rec
+}
@@ -0,0 +1,125 @@
+package syslog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/platformbuilds/mirador-nrt-aggregator/internal/config"
+)
+
+// readFrames reads frames until the stream ends or a frame fails.
+func readFrames(r *Receiver, in string) ([]string, error) {
+	br := bufio.NewReader(strings.NewReader(in))
+	var out []string
+	for {
+		msg, err := r.readFrame(br)
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, string(msg))
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		framing string
+		max     int
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "auto: octet counted",
+			in:   "11 <34>1 hello5 <1>hi",
+			want: []string{"<34>1 hello", "<1>hi"},
+		},
+		{
+			name: "auto: newline delimited",
+			in:   "<34>1 hello\n<1>hi\r\n<2>last",
+			want: []string{"<34>1 hello", "<1>hi", "<2>last"},
+		},
+		{
+			name: "auto: mixed per message",
+			in:   "5 <1>hi<2>lf\n",
+			want: []string{"<1>hi", "<2>lf"},
+		},
+		{
+			name: "octet count spans newlines",
+			in:   "7 <1>a\nb\n",
+			want: []string{"<1>a\nb"},
+		},
+		{
+			name:    "non_transparent ignores leading digits",
+			framing: "non_transparent",
+			in:      "12 not counted\n",
+			want:    []string{"12 not counted"},
+		},
+		{
+			name:    "newline message truncated to max",
+			framing: "non_transparent",
+			max:     8,
+			in:      "<1>short\n<1>much too long\n<2>ok\n",
+			want:    []string{"<1>short", "", "<2>ok"},
+		},
+		{
+			name:    "octet count over max",
+			max:     100,
+			in:      "101 " + strings.Repeat("x", 101),
+			wantErr: "exceeds max_message_bytes",
+		},
+		{
+			name:    "octet count with too many digits",
+			in:      "99999999999999999999 x",
+			wantErr: "more than 10 digits",
+		},
+		{
+			name:    "octet count followed by garbage",
+			framing: "octet_counting",
+			in:      "12abc <1>x",
+			wantErr: "unexpected byte",
+		},
+		{
+			name:    "octet counting requires a length",
+			framing: "octet_counting",
+			in:      " <1>x",
+			wantErr: "unexpected byte",
+		},
+		{
+			name:    "truncated octet counted frame",
+			in:      "20 <1>short",
+			wantErr: "unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extra := map[string]any{"protocol": "tcp"}
+			if tt.framing != "" {
+				extra["framing"] = tt.framing
+			}
+			if tt.max > 0 {
+				extra["max_message_bytes"] = tt.max
+			}
+			got, err := readFrames(New(config.ReceiverCfg{Extra: extra}), tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
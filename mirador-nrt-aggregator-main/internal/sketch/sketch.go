@@ -0,0 +1,233 @@
+// Package sketch holds the mergeable window state carried in
+// model.Aggregate.Sketch: t-digests for quantiles and HyperLogLog registers
+// for distinct counts. Both merge losslessly with respect to their own error
+// bounds, so windows from several replicas, or many short windows, combine
+// into the same result a single long window would have produced.
+package sketch
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+// Compression is the t-digest compression used across the aggregator.
+const Compression = 100
+
+// NewDigest returns an empty t-digest with the shared compression.
+func NewDigest() *tdigest.TDigest {
+	td, _ := tdigest.New(tdigest.Compression(Compression)) // only fails on invalid compression
+	return td
+}
+
+// EncodeDigest serializes td; nil or empty digests encode to nil.
+func EncodeDigest(td *tdigest.TDigest) []byte {
+	if td == nil || td.Count() == 0 {
+		return nil
+	}
+	b, err := td.AsBytes()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// DecodeDigest parses a digest written by EncodeDigest.
+func DecodeDigest(b []byte) (*tdigest.TDigest, error) {
+	td := NewDigest()
+	if len(b) == 0 {
+		return td, nil
+	}
+	if err := td.FromBytes(b); err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+// hllPrecision gives 2^12 registers: 4 KiB per dense sketch, ~1.6% standard
+// error.
+const hllPrecision = 12
+
+const hllRegisters = 1 << hllPrecision
+
+// hllSparseMax is how many registers the sparse form holds before switching
+// to the dense array; below it a sketch costs a few bytes per distinct value.
+const hllSparseMax = hllRegisters / 16
+
+// HLL is a HyperLogLog++ style distinct counter: 64-bit hashes and a sparse
+// register map for small cardinalities. The zero value is not usable; use NewHLL or HLLFromBytes.
+type HLL struct {
+	sparse map[uint16]uint8 // nil once dense
+	reg    []uint8
+}
+
+// NewHLL returns an empty counter.
+func NewHLL() *HLL { return &HLL{sparse: map[uint16]uint8{}} }
+
+// HLLFromBytes restores registers written by Bytes.
+func HLLFromBytes(b []byte) (*HLL, error) {
+	if len(b) != hllRegisters {
+		return nil, errors.New("sketch: bad hll length")
+	}
+	h := &HLL{reg: make([]uint8, hllRegisters)}
+	copy(h.reg, b)
+	return h, nil
+}
+
+// Add records one value.
+func (h *HLL) Add(v string) {
+	x := hash64(v)
+	idx := uint16(x >> (64 - hllPrecision))
+	// The remaining bits, with a sentinel so rho is bounded.
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	h.set(idx, uint8(bits.LeadingZeros64(w)+1))
+}
+
+func (h *HLL) set(idx uint16, rho uint8) {
+	if h.sparse == nil {
+		if rho > h.reg[idx] {
+			h.reg[idx] = rho
+		}
+		return
+	}
+	if rho > h.sparse[idx] {
+		h.sparse[idx] = rho
+		if len(h.sparse) > hllSparseMax {
+			h.densify()
+		}
+	}
+}
+
+func (h *HLL) densify() {
+	h.reg = make([]uint8, hllRegisters)
+	for i, r := range h.sparse {
+		h.reg[i] = r
+	}
+	h.sparse = nil
+}
+
+// Merge folds o into h (register-wise max).
+func (h *HLL) Merge(o *HLL) {
+	if o.sparse != nil {
+		for i, r := range o.sparse {
+			h.set(i, r)
+		}
+		return
+	}
+	if h.sparse != nil {
+		h.densify()
+	}
+	for i, r := range o.reg {
+		if r > h.reg[i] {
+			h.reg[i] = r
+		}
+	}
+}
+
+// Estimate returns the approximate number of distinct values added, using
+// Ertl's improved estimator ("New cardinality estimation algorithms for
+// HyperLogLog sketches", 2017): accurate across the whole range without the
+// empirical bias tables of HLL++.
+func (h *HLL) Estimate() uint64 {
+	const q = 64 - hllPrecision
+	var c [q + 2]int // register value histogram
+	if h.sparse != nil {
+		c[0] = hllRegisters - len(h.sparse)
+		for _, r := range h.sparse {
+			c[r]++
+		}
+	} else {
+		for _, r := range h.reg {
+			c[r]++
+		}
+	}
+	m := float64(hllRegisters)
+	z := m * tau(1-float64(c[q+1])/m)
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + float64(c[k]))
+	}
+	z += m * sigma(float64(c[0])/m)
+	return uint64(math.Round(m * m / (2 * math.Ln2) / z))
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// Bytes returns the dense registers for Aggregate.Sketch.
+func (h *HLL) Bytes() []byte {
+	out := make([]byte, hllRegisters)
+	if h.sparse != nil {
+		for i, r := range h.sparse {
+			out[i] = r
+		}
+		return out
+	}
+	copy(out, h.reg)
+	return out
+}
+
+// SizeBytes approximates the sketch's memory footprint.
+func (h *HLL) SizeBytes() int {
+	if h.sparse != nil {
+		return 8 * len(h.sparse) // key, value and map overhead
+	}
+	return len(h.reg)
+}
+
+// hash64 is FNV-1a followed by the splitmix64 finalizer; FNV alone does not
+// spread short keys well enough over the high bits HLL indexes by. It must
+// stay stable: replicas and rollups merge registers from different processes.
+func hash64(s string) uint64 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(s))
+	x := f.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// DigestSizeBytes approximates a digest's memory footprint (16 bytes per
+// centroid); compression bounds the centroid count.
+func DigestSizeBytes(td *tdigest.TDigest) int {
+	if td == nil {
+		return 0
+	}
+	n := 0
+	td.ForEachCentroid(func(float64, uint64) bool { n++; return true })
+	return 16 * n
+}
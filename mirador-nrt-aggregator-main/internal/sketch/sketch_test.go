@@ -0,0 +1,145 @@
+package sketch
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func hllOf(from, to int) *HLL {
+	h := NewHLL()
+	for i := from; i < to; i++ {
+		h.Add("v" + strconv.Itoa(i))
+	}
+	return h
+}
+
+func TestHLLEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 100000} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			got := float64(hllOf(0, n).Estimate())
+			// ~1.6% standard error; allow 4 sigma, and a little slack for tiny n
+			if tol := math.Max(1, 0.065*float64(n)); math.Abs(got-float64(n)) > tol {
+				t.Fatalf("estimate = %v, want %d ± %v", got, n, tol)
+			}
+		})
+	}
+}
+
+// Merging must give exactly the registers of a sketch fed the union, in
+// every combination of sparse and dense sketches.
+func TestHLLMerge(t *testing.T) {
+	tests := []struct {
+		name         string
+		aFrom, aTo   int
+		bFrom, bTo   int
+		wantDistinct int
+	}{
+		{"sparse into sparse", 0, 50, 50, 100, 100},
+		{"sparse into sparse, overlapping", 0, 80, 40, 120, 120},
+		{"dense into sparse", 0, 50, 50, 5050, 5050},
+		{"sparse into dense", 0, 5000, 5000, 5050, 5050},
+		{"dense into dense", 0, 5000, 2500, 10000, 10000},
+		{"empty", 0, 0, 0, 100, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := hllOf(tt.aFrom, tt.aTo), hllOf(tt.bFrom, tt.bTo)
+			union := hllOf(min(tt.aFrom, tt.bFrom), max(tt.aTo, tt.bTo))
+
+			a.Merge(b)
+			if !bytes.Equal(a.Bytes(), union.Bytes()) {
+				t.Fatal("merged registers differ from the union's")
+			}
+			if got := a.Estimate(); got != union.Estimate() {
+				t.Fatalf("estimate = %d, union estimate = %d", got, union.Estimate())
+			}
+			if got, n := float64(a.Estimate()), float64(tt.wantDistinct); math.Abs(got-n) > math.Max(1, 0.065*n) {
+				t.Fatalf("estimate = %v, want about %v", got, n)
+			}
+		})
+	}
+}
+
+func TestHLLBytes(t *testing.T) {
+	for _, n := range []int{10, 5000} { // sparse, dense
+		h := hllOf(0, n)
+		r, err := HLLFromBytes(h.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Estimate() != h.Estimate() {
+			t.Fatalf("n=%d: restored estimate = %d, want %d", n, r.Estimate(), h.Estimate())
+		}
+	}
+	if _, err := HLLFromBytes(make([]byte, 10)); err == nil {
+		t.Fatal("short registers accepted")
+	}
+}
+
+// Digests of a split stream, encoded, decoded and merged, answer quantiles
+// like a digest of the whole stream.
+func TestDigestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts int
+		value func(i int) float64
+	}{
+		{"uniform in two parts", 2, func(i int) float64 { return float64(i % 1000) }},
+		{"uniform in twelve parts", 12, func(i int) float64 { return float64(i % 1000) }},
+		{"long tail", 5, func(i int) float64 { return math.Exp(float64(i%100) / 20) }},
+	}
+	const n = 12000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whole := NewDigest()
+			parts := make([]*tdigest.TDigest, tt.parts)
+			for i := range parts {
+				parts[i] = NewDigest()
+			}
+			for i := 0; i < n; i++ {
+				v := tt.value(i)
+				_ = whole.Add(v)
+				_ = parts[i%tt.parts].Add(v)
+			}
+			merged := NewDigest()
+			for _, p := range parts {
+				td, err := DecodeDigest(EncodeDigest(p))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := merged.Merge(td); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if merged.Count() != whole.Count() {
+				t.Fatalf("count = %d, want %d", merged.Count(), whole.Count())
+			}
+			for _, q := range []float64{0.5, 0.9, 0.99} {
+				got, want := merged.Quantile(q), whole.Quantile(q)
+				if math.Abs(got-want) > 0.02*math.Abs(want)+1 {
+					t.Fatalf("q%v = %v, want %v", q, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDigestEncoding(t *testing.T) {
+	if b := EncodeDigest(nil); b != nil {
+		t.Fatalf("nil digest encoded to %d bytes", len(b))
+	}
+	if b := EncodeDigest(NewDigest()); b != nil {
+		t.Fatalf("empty digest encoded to %d bytes", len(b))
+	}
+	td, err := DecodeDigest(nil)
+	if err != nil || td.Count() != 0 {
+		t.Fatalf("DecodeDigest(nil) = %v, %v", td, err)
+	}
+	if _, err := DecodeDigest([]byte{1, 2, 3}); err == nil {
+		t.Fatal("garbage decoded")
+	}
+}
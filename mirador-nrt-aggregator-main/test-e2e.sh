@@ -77,12 +77,15 @@ SCHEMA_PAYLOAD='{
     {"name": "p50",           "dataType": ["number"]},
     {"name": "p95",           "dataType": ["number"]},
     {"name": "p99",           "dataType": ["number"]},
+    {"name": "quantiles",     "dataType": ["text"]},
     {"name": "rps",           "dataType": ["number"]},
     {"name": "error_rate",    "dataType": ["number"]},
     {"name": "anomaly_score", "dataType": ["number"]},
     {"name": "count",         "dataType": ["int"]},
     {"name": "labels",        "dataType": ["text"]},
-    {"name": "locator",       "dataType": ["text"]}
+    {"name": "locator",       "dataType": ["text"]},
+    {"name": "group",         "dataType": ["text"]},
+    {"name": "sketch",        "dataType": ["text"]}
   ]
 }'
 